@@ -73,36 +73,35 @@ func (gw *Gateway) soa(state request.Request) *dns.SOA {
 		Refresh: 7200,
 		Retry:   1800,
 		Expire:  86400,
-		Minttl:  gw.ttlSOA,
+		Minttl:  gw.soaMinTTL,
 	}
 	return soa
 }
 
 func (gw *Gateway) nameservers(state request.Request) (result []dns.RR) {
-	primaryNS := gw.ns1(state)
-	result = append(result, primaryNS)
-
-	secondaryNS := gw.ns2(state)
-	if secondaryNS != nil {
-		result = append(result, secondaryNS)
+	if gw.disableNameservers {
+		return nil
+	}
+	if len(gw.nameserverNames) > 0 {
+		for _, name := range gw.nameserverNames {
+			header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeNS, Ttl: gw.ttlSOA, Class: dns.ClassINET}
+			result = append(result, &dns.NS{Hdr: header, Ns: name})
+		}
+		return result
 	}
 
-	return result
-}
+	result = append(result, gw.ns(gw.apex, state))
 
-func (gw *Gateway) ns1(state request.Request) *dns.NS {
-	header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeNS, Ttl: gw.ttlSOA, Class: dns.ClassINET}
-	ns := &dns.NS{Hdr: header, Ns: dnsutil.Join(gw.apex, state.Zone)}
+	for _, secondNS := range gw.secondNS {
+		result = append(result, gw.ns(secondNS, state))
+	}
 
-	return ns
+	return result
 }
 
-func (gw *Gateway) ns2(state request.Request) *dns.NS {
-	if gw.secondNS == "" { // If second NS is undefined, return nothing
-		return nil
-	}
+func (gw *Gateway) ns(name string, state request.Request) *dns.NS {
 	header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeNS, Ttl: gw.ttlSOA, Class: dns.ClassINET}
-	ns := &dns.NS{Hdr: header, Ns: dnsutil.Join(gw.secondNS, state.Zone)}
+	ns := &dns.NS{Hdr: header, Ns: dnsutil.Join(name, state.Zone)}
 
 	return ns
 }
@@ -5,6 +5,7 @@ import (
 	"net/netip"
 	"testing"
 
+	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
@@ -14,30 +15,30 @@ import (
 
 func setupEmptyLookupFuncs(gw *Gateway) {
 	if resource := gw.lookupResource("HTTPRoute"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(context.Context, []string) lookupResult { return lookupResult{} }
 	}
 	if resource := gw.lookupResource("TLSRoute"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(context.Context, []string) lookupResult { return lookupResult{} }
 	}
 	if resource := gw.lookupResource("GRPCRoute"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(context.Context, []string) lookupResult { return lookupResult{} }
 	}
 	if resource := gw.lookupResource("Ingress"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(context.Context, []string) lookupResult { return lookupResult{} }
 	}
 	if resource := gw.lookupResource("Service"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(context.Context, []string) lookupResult { return lookupResult{} }
 	}
 }
 
 func TestDualNS(t *testing.T) {
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := syncedController()
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
 	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
 	gw.Controller = ctrl
 	gw.ExternalAddrFunc = selfDualAddressTest
-	gw.secondNS = "dns2.kube-system"
+	gw.secondNS = []string{"dns2.kube-system"}
 	setupEmptyLookupFuncs(gw)
 
 	ctx := context.TODO()
@@ -64,6 +65,151 @@ func TestDualNS(t *testing.T) {
 	}
 }
 
+func TestNameserversFromCorefile(t *testing.T) {
+	c := caddy.NewTestController("dns", `k8s_gateway example.com {
+		apex ns1.dns
+		hostmaster admin
+		secondary ns2.dns
+	}`)
+	gw, err := parse(c)
+	if err != nil {
+		t.Fatalf("Failed to parse Corefile: %v", err)
+	}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = syncedController()
+	gw.ExternalAddrFunc = func(request.Request) []dns.RR { return nil }
+	setupEmptyLookupFuncs(gw)
+
+	ctx := context.TODO()
+	for i, tc := range testsCorefileNS {
+		r := tc.Msg()
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		if _, err := gw.ServeDNS(ctx, w, r); err != nil {
+			t.Errorf("Test %d expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test number #%d: %+v", i, err)
+		}
+	}
+}
+
+var testsCorefileNS = []test.Case{
+	{
+		Qname: "example.com.", Qtype: dns.TypeNS,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.NS("example.com.   60  IN  NS  ns1.dns.example.com."),
+			test.NS("example.com.   60  IN  NS  ns2.dns.example.com."),
+		},
+	},
+	{
+		Qname: "example.com.", Qtype: dns.TypeSOA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA ns1.dns.example.com. admin.ns1.dns.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+}
+
+func TestMultipleNS(t *testing.T) {
+	ctrl := syncedController()
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = ctrl
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.secondNS = []string{"dns2.kube-system", "dns3.kube-system"}
+	setupEmptyLookupFuncs(gw)
+	if resource := gw.lookupResource("Service"); resource != nil {
+		resource.lookup = func(_ context.Context, keys []string) (results lookupResult) {
+			for _, key := range keys {
+				results.addrs = append(results.addrs, testNameserverIndexes[key]...)
+			}
+			return results
+		}
+	}
+
+	tc := test.Case{
+		Qname: "example.com.", Qtype: dns.TypeNS,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.NS("example.com.   60  IN  NS  dns1.kube-system.example.com."),
+			test.NS("example.com.   60  IN  NS  dns2.kube-system.example.com."),
+			test.NS("example.com.   60  IN  NS  dns3.kube-system.example.com."),
+		},
+		Extra: []dns.RR{
+			test.A("dns1.kube-system.example.com.   60  IN  A   192.0.1.53"),
+			test.A("dns2.kube-system.example.com.   60  IN  A   192.0.2.53"),
+			test.A("dns3.kube-system.example.com.   60  IN  A   192.0.3.53"),
+		},
+	}
+
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := test.SortAndCheck(w.Msg, tc); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNameserverAAAAGlue(t *testing.T) {
+	gw := newTestGateway()
+	gw.secondNS = []string{"dns2.kube-system"}
+	setupEmptyLookupFuncs(gw)
+	if resource := gw.lookupResource("Service"); resource != nil {
+		resource.lookup = func(_ context.Context, keys []string) (results lookupResult) {
+			for _, key := range keys {
+				results.addrs = append(results.addrs, testDualNameserverIndexes[key]...)
+			}
+			return results
+		}
+	}
+
+	tests := []test.Case{
+		{
+			Qname: "example.com.", Qtype: dns.TypeNS,
+			Answer: []dns.RR{
+				test.NS("example.com.   60  IN  NS  dns1.kube-system.example.com."),
+				test.NS("example.com.   60  IN  NS  dns2.kube-system.example.com."),
+			},
+			Extra: []dns.RR{
+				test.A("dns1.kube-system.example.com.   60  IN  A   192.0.1.53"),
+				test.AAAA("dns1.kube-system.example.com.   60  IN  AAAA   2001:db8:1::53"),
+				test.AAAA("dns2.kube-system.example.com.   60  IN  AAAA   2001:db8:2::53"),
+			},
+		},
+		{
+			Qname: "dns1.kube-system.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("dns1.kube-system.example.com.   60  IN  AAAA   2001:db8:1::53")},
+		},
+	}
+
+	ctx := context.TODO()
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+var testDualNameserverIndexes = map[string][]netip.Addr{
+	"dns1.kube-system": {netip.MustParseAddr("192.0.1.53"), netip.MustParseAddr("2001:db8:1::53")},
+	"dns2.kube-system": {netip.MustParseAddr("2001:db8:2::53")},
+}
+
+var testNameserverIndexes = map[string][]netip.Addr{
+	"dns1.kube-system": {netip.MustParseAddr("192.0.1.53")},
+	"dns2.kube-system": {netip.MustParseAddr("192.0.2.53")},
+	"dns3.kube-system": {netip.MustParseAddr("192.0.3.53")},
+}
+
 var testsDualNS = []test.Case{
 	{
 		Qname: "example.com.", Qtype: dns.TypeSOA,
@@ -13,7 +13,7 @@ import (
 
 func TestApex(t *testing.T) {
 
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := syncedController()
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
 	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
@@ -125,3 +125,49 @@ func selfAddressTest(state request.Request) []dns.RR {
 	a := test.A("dns1.kube-system.example.com. IN A 127.0.0.1")
 	return []dns.RR{a}
 }
+
+func TestApexNameservers(t *testing.T) {
+	soa := test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")
+	tests := []struct {
+		nameserverNames    []string
+		disableNameservers bool
+		tc                 test.Case
+	}{
+		{
+			disableNameservers: true,
+			tc: test.Case{
+				Qname: "example.com.", Qtype: dns.TypeNS,
+				Rcode: dns.RcodeSuccess,
+				Ns:    []dns.RR{soa},
+			},
+		},
+		{
+			nameserverNames: []string{"ns1.example.net.", "ns2.example.net."},
+			tc: test.Case{
+				Qname: "example.com.", Qtype: dns.TypeNS,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.NS("example.com.   60  IN  NS  ns1.example.net."),
+					test.NS("example.com.   60  IN  NS  ns2.example.net."),
+				},
+			},
+		},
+	}
+
+	ctx := context.TODO()
+	for i, tt := range tests {
+		gw := newTestGateway()
+		gw.ExternalAddrFunc = selfAddressTest
+		gw.nameserverNames = tt.nameserverNames
+		gw.disableNameservers = tt.disableNameservers
+		setupEmptyLookupFuncs(gw)
+
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, tt.tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tt.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
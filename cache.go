@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+type answerKey struct {
+	qname string
+	qtype uint16
+}
+
+type answerEntry struct {
+	key      answerKey
+	results  lookupResult
+	ptrNames []string
+	expires  time.Time
+}
+
+// answerCache keeps the lookup results of recent queries until any watched
+// object changes, evicting the least recently used entries beyond its size.
+// Entries also expire after the TTL of their answer, as resolved load
+// balancer hostnames can change without an informer event.
+type answerCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[answerKey]*list.Element
+	order   *list.List
+	// purges counts the purges, so results computed before one aren't added after it
+	purges uint64
+}
+
+func newAnswerCache(size int) *answerCache {
+	return &answerCache{
+		size:    size,
+		entries: make(map[answerKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached results of a query, it's safe to call on a nil cache
+func (c *answerCache) get(qname string, qtype uint16) (lookupResult, []string, bool) {
+	if c == nil {
+		return lookupResult{}, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[answerKey{strings.ToLower(qname), qtype}]
+	if ok && time.Now().After(elem.Value.(*answerEntry).expires) {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		answerCacheMisses.Inc()
+		return lookupResult{}, nil, false
+	}
+	answerCacheHits.Inc()
+	c.order.MoveToFront(elem)
+	entry := elem.Value.(*answerEntry)
+	return entry.results, entry.ptrNames, true
+}
+
+// generation returns the number of purges so far, to be passed to add along
+// with the results looked up after calling it
+func (c *answerCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.purges
+}
+
+// add caches the results of a query for at most ttl, unless the cache was
+// purged since generation was returned. It's safe to call on a nil cache
+func (c *answerCache) add(generation uint64, qname string, qtype uint16, results lookupResult, ptrNames []string, ttl time.Duration) {
+	if c == nil || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.purges {
+		return
+	}
+
+	key := answerKey{strings.ToLower(qname), qtype}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.order.Len() >= c.size {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&answerEntry{
+		key:      key,
+		results:  results,
+		ptrNames: ptrNames,
+		expires:  time.Now().Add(ttl),
+	})
+	answerCacheEntries.Set(float64(c.order.Len()))
+}
+
+func (c *answerCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*answerEntry).key)
+	answerCacheEntries.Set(float64(c.order.Len()))
+}
+
+// purge drops all cached results
+func (c *answerCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purges++
+	clear(c.entries)
+	c.order.Init()
+	answerCacheEntries.Set(0)
+}
+
+// eventHandler purges the cache whenever a watched object changes, as any
+// object can affect the answers for other names, e.g. a Gateway for its routes
+func (c *answerCache) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.purge() },
+		UpdateFunc: func(interface{}, interface{}) { c.purge() },
+		DeleteFunc: func(interface{}) { c.purge() },
+	}
+}
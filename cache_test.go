@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPluginAnswerCache(t *testing.T) {
+	addr := netip.MustParseAddr("192.0.1.1")
+	lookups := 0
+	lookup := func(_ context.Context, keys []string) (result lookupResult) {
+		lookups++
+		for _, key := range keys {
+			if key == "svc1.ns1" {
+				result.addrs = append(result.addrs, addr)
+			}
+		}
+		return
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: lookup, reverse: noopReverse})
+	gw.answerCache = newAnswerCache(10)
+
+	query := func(expected string) {
+		t.Helper()
+		tc := test.Case{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc1.ns1.example.com.	60	IN	A	" + expected)},
+		}
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Error(err)
+		}
+	}
+
+	query("192.0.1.1")
+	addr = netip.MustParseAddr("192.0.1.2")
+	query("192.0.1.1")
+	if lookups != 1 {
+		t.Errorf("Expected the second query to be answered from the cache, got %d lookups", lookups)
+	}
+
+	// a changed object invalidates the cached answers
+	old := &core.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc1", Namespace: "ns1", ResourceVersion: "1"}}
+	updated := old.DeepCopy()
+	updated.ResourceVersion = "2"
+	gw.answerCache.eventHandler().OnUpdate(old, updated)
+	query("192.0.1.2")
+	if lookups != 2 {
+		t.Errorf("Expected the query to be looked up again after the update, got %d lookups", lookups)
+	}
+}
+
+func TestAnswerCacheEviction(t *testing.T) {
+	c := newAnswerCache(2)
+	result := lookupResult{addrs: []netip.Addr{netip.MustParseAddr("192.0.1.1")}}
+	c.add(0, "a.example.com.", dns.TypeA, result, nil, time.Minute)
+	c.add(0, "b.example.com.", dns.TypeA, result, nil, time.Minute)
+
+	// a is used more recently than b
+	if _, _, ok := c.get("A.example.com.", dns.TypeA); !ok {
+		t.Errorf("Expected a cached answer regardless of the query case")
+	}
+	c.add(0, "c.example.com.", dns.TypeA, result, nil, time.Minute)
+	if _, _, ok := c.get("b.example.com.", dns.TypeA); ok {
+		t.Errorf("Expected the least recently used answer to be evicted")
+	}
+	for _, qname := range []string{"a.example.com.", "c.example.com."} {
+		if _, _, ok := c.get(qname, dns.TypeA); !ok {
+			t.Errorf("Expected a cached answer for %s", qname)
+		}
+	}
+	if _, _, ok := c.get("a.example.com.", dns.TypeAAAA); ok {
+		t.Errorf("Expected no cached answer for another query type")
+	}
+
+	// expired answers and ones without a TTL aren't returned
+	c.add(0, "d.example.com.", dns.TypeA, result, nil, -time.Second)
+	c.add(0, "e.example.com.", dns.TypeA, result, nil, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	for _, qname := range []string{"d.example.com.", "e.example.com."} {
+		if _, _, ok := c.get(qname, dns.TypeA); ok {
+			t.Errorf("Expected no cached answer for %s", qname)
+		}
+	}
+
+	// a nil cache never has answers
+	var disabled *answerCache
+	disabled.add(0, "a.example.com.", dns.TypeA, result, nil, time.Minute)
+	if _, _, ok := disabled.get("a.example.com.", dns.TypeA); ok {
+		t.Errorf("Expected no cached answer from a disabled cache")
+	}
+}
+
+func TestAnswerCachePurgeDuringLookup(t *testing.T) {
+	c := newAnswerCache(2)
+	result := lookupResult{addrs: []netip.Addr{netip.MustParseAddr("192.0.1.1")}}
+
+	// an object changes while the answer is looked up
+	generation := c.generation()
+	c.purge()
+	c.add(generation, "a.example.com.", dns.TypeA, result, nil, time.Minute)
+	if _, _, ok := c.get("a.example.com.", dns.TypeA); ok {
+		t.Errorf("Expected no cached answer looked up before the purge")
+	}
+
+	c.add(c.generation(), "a.example.com.", dns.TypeA, result, nil, time.Minute)
+	if _, _, ok := c.get("a.example.com.", dns.TypeA); !ok {
+		t.Errorf("Expected a cached answer looked up after the purge")
+	}
+}
@@ -179,6 +179,55 @@ false
   {{- end -}}
 {{- end }}
 
+{{- define "k8s-gateway.endpoints" -}}
+  {{- if .Values.watchedResources -}}
+    {{- $found := false -}}
+    {{- range .Values.watchedResources -}}
+      {{- if eq . "Endpoints" -}}
+        {{- $found = true -}}
+      {{- end -}}
+    {{- end -}}
+    {{- if $found -}}
+true
+    {{- else -}}
+false
+    {{- end -}}
+  {{- else -}}
+false
+  {{- end -}}
+{{- end }}
+
+{{/*
+  k8s-gateway.nodes:
+  Returns "true" if services resolve to the addresses of their nodes, which
+  watches Nodes and EndpointSlices. Otherwise returns "false".
+*/}}
+{{- define "k8s-gateway.nodes" -}}
+  {{- if or .Values.nodeAddresses.nodePort .Values.nodeAddresses.localTrafficPolicy -}}
+true
+  {{- else -}}
+false
+  {{- end -}}
+{{- end }}
+
+{{- define "k8s-gateway.virtualService" -}}
+  {{- if .Values.watchedResources -}}
+    {{- $found := false -}}
+    {{- range .Values.watchedResources -}}
+      {{- if eq . "VirtualService" -}}
+        {{- $found = true -}}
+      {{- end -}}
+    {{- end -}}
+    {{- if $found -}}
+true
+    {{- else -}}
+false
+    {{- end -}}
+  {{- else -}}
+false
+  {{- end -}}
+{{- end }}
+
 {{- define "k8s-gateway.securityContext" -}}
   {{- $securityContext := .Values.securityContext -}}
   {{- if .Values.secure -}}
@@ -1,43 +1,290 @@
 package gateway
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"net"
 	"net/netip"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/coredns/coredns/plugin/pkg/fall"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 )
 
-type lookupFunc func(indexKeys []string) []netip.Addr
+type lookupFunc func(ctx context.Context, indexKeys []string) lookupResult
+
+// lookupResult holds the records a resource lookup found for a set of index keys
+type lookupResult struct {
+	// addresses backing A and AAAA answers
+	addrs []netip.Addr
+	// raw data of all other records keyed by record type, e.g. "MX"
+	records map[string][]string
+	// load balancer hostnames the addresses were resolved from, only answered
+	// as CNAME targets in zones with cnameGatewayHostnames
+	hostnames []string
+	// lowest TTL requested by any of the matched objects
+	ttl *uint32
+	// lowest TTL of the upstream records load balancer hostnames resolved to
+	upstreamTTL *uint32
+	// some matched objects were skipped because of a class filter
+	filtered bool
+	// relative weights of addresses biasing their order in answers
+	weights map[netip.Addr]uint32
+	// protocols advertised in HTTPS answers
+	alpn []string
+	// regions of addresses, preferred in answers to clients of the same region
+	regions map[netip.Addr]string
+	// priorities of addresses, lower ones always come first in answers
+	priorities map[netip.Addr]uint32
+}
+
+func (r *lookupResult) addRecords(recordType string, data ...string) {
+	if len(data) == 0 {
+		return
+	}
+	if r.records == nil {
+		r.records = make(map[string][]string)
+	}
+	r.records[recordType] = append(r.records[recordType], data...)
+}
+
+// setTTL keeps the lowest TTL requested across all matched objects
+func (r *lookupResult) setTTL(ttl uint32) {
+	if r.ttl == nil || ttl < *r.ttl {
+		r.ttl = &ttl
+	}
+}
+
+// setUpstreamTTL keeps the lowest TTL of all resolved hostnames
+func (r *lookupResult) setUpstreamTTL(ttl uint32) {
+	if r.upstreamTTL == nil || ttl < *r.upstreamTTL {
+		r.upstreamTTL = &ttl
+	}
+}
+
+// merge adds the records and TTLs of another result
+func (r *lookupResult) merge(other lookupResult) {
+	r.addrs = append(r.addrs, other.addrs...)
+	for recordType, data := range other.records {
+		r.addRecords(recordType, data...)
+	}
+	if other.ttl != nil {
+		r.setTTL(*other.ttl)
+	}
+	if other.upstreamTTL != nil {
+		r.setUpstreamTTL(*other.upstreamTTL)
+	}
+	r.hostnames = append(r.hostnames, other.hostnames...)
+	r.filtered = r.filtered || other.filtered
+	for addr, weight := range other.weights {
+		r.addWeight(addr, weight)
+	}
+	for _, protocol := range other.alpn {
+		if !slices.Contains(r.alpn, protocol) {
+			r.alpn = append(r.alpn, protocol)
+		}
+	}
+	for addr, region := range other.regions {
+		r.addRegion(addr, region)
+	}
+	for addr, priority := range other.priorities {
+		r.addPriority(addr, priority)
+	}
+}
+
+// setWeight assigns a weight to all addresses of the result, keeping the
+// highest one for addresses shared by several objects
+func (r *lookupResult) setWeight(weight uint32) {
+	for _, addr := range r.addrs {
+		r.addWeight(addr, weight)
+	}
+}
+
+func (r *lookupResult) addWeight(addr netip.Addr, weight uint32) {
+	if r.weights == nil {
+		r.weights = make(map[netip.Addr]uint32)
+	}
+	// a weight of 0 is kept as well, unlike a missing weight it puts the address last
+	if current, ok := r.weights[addr]; !ok || weight > current {
+		r.weights[addr] = weight
+	}
+}
+
+// setRegion assigns a region to all addresses of the result, keeping the
+// first one for addresses shared by several objects
+func (r *lookupResult) setRegion(region string) {
+	for _, addr := range r.addrs {
+		r.addRegion(addr, region)
+	}
+}
+
+func (r *lookupResult) addRegion(addr netip.Addr, region string) {
+	if r.regions == nil {
+		r.regions = make(map[netip.Addr]string)
+	}
+	if _, ok := r.regions[addr]; !ok {
+		r.regions[addr] = region
+	}
+}
+
+// setPriorities assigns priorities to the addresses of the result they're
+// given for, keeping the lowest one for addresses shared by several objects
+func (r *lookupResult) setPriorities(priorities map[netip.Addr]uint32) {
+	for _, addr := range r.addrs {
+		if priority, ok := priorities[addr]; ok {
+			r.addPriority(addr, priority)
+		}
+	}
+}
+
+func (r *lookupResult) addPriority(addr netip.Addr, priority uint32) {
+	if r.priorities == nil {
+		r.priorities = make(map[netip.Addr]uint32)
+	}
+	if current, ok := r.priorities[addr]; !ok || priority < current {
+		r.priorities[addr] = priority
+	}
+}
+
+func (r *lookupResult) ttlOr(ttl uint32) uint32 {
+	if r.ttl != nil {
+		return *r.ttl
+	}
+	return ttl
+}
+
+// aliasHostnames returns the result with its load balancer hostnames added to
+// the CNAME targets, the records of the original result are left untouched
+func (r lookupResult) aliasHostnames() lookupResult {
+	if len(r.hostnames) == 0 {
+		return r
+	}
+	records := make(map[string][]string, len(r.records)+1)
+	maps.Copy(records, r.records)
+	records["CNAME"] = slices.Concat(r.records["CNAME"], r.hostnames)
+	r.records = records
+	return r
+}
+
+func (r *lookupResult) isEmpty() bool {
+	return len(r.addrs) == 0 && len(r.records) == 0
+}
+
+// hasType reports whether a result answers queries of a type, addresses and
+// hostnames answer A, AAAA and HTTPS queries while types without records of their
+// own, e.g. ANY, are answered by any record
+func (r *lookupResult) hasType(qtype uint16) bool {
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeHTTPS:
+		return len(r.addrs) > 0 || len(r.records["CNAME"]) > 0
+	}
+	if _, ok := recordBuilders[qtype]; ok {
+		return len(r.records[dns.TypeToString[qtype]]) > 0
+	}
+	return !r.isEmpty()
+}
+
+// reverseLookupFunc returns the hostnames of all live objects backed by an address
+type reverseLookupFunc func(addr netip.Addr) []string
 
 type resourceWithIndex struct {
-	name   string
-	lookup lookupFunc
+	name    string
+	lookup  lookupFunc
+	reverse reverseLookupFunc
+	// guards lookup and reverse, which controllers replace when a resource is
+	// activated while queries are served
+	mu sync.RWMutex
+}
+
+// lookups returns the current lookup functions of the resource
+func (r *resourceWithIndex) lookups() (lookupFunc, reverseLookupFunc) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lookup, r.reverse
+}
+
+// setLookups replaces the lookup functions of the resource, a nil function
+// keeps the current one
+func (r *resourceWithIndex) setLookups(lookup lookupFunc, reverse reverseLookupFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lookup != nil {
+		r.lookup = lookup
+	}
+	if reverse != nil {
+		r.reverse = reverse
+	}
 }
 
 // Static resources with their default noop function
 var staticResources = []*resourceWithIndex{
-	{name: "HTTPRoute", lookup: noop},
-	{name: "TLSRoute", lookup: noop},
-	{name: "GRPCRoute", lookup: noop},
-	{name: "Ingress", lookup: noop},
-	{name: "Service", lookup: noop},
-	{name: "DNSEndpoint", lookup: noop},
+	{name: "HTTPRoute", lookup: noop, reverse: noopReverse},
+	{name: "TLSRoute", lookup: noop, reverse: noopReverse},
+	{name: "GRPCRoute", lookup: noop, reverse: noopReverse},
+	{name: "Ingress", lookup: noop, reverse: noopReverse},
+	{name: "Service", lookup: noop, reverse: noopReverse},
+	{name: "DNSEndpoint", lookup: noop, reverse: noopReverse},
+	{name: "Endpoints", lookup: noop, reverse: noopReverse},
+	{name: "VirtualService", lookup: noop, reverse: noopReverse},
 }
 
-var noop lookupFunc = func([]string) (result []netip.Addr) { return }
+// staticResourceNames returns the names of all supported resources
+func staticResourceNames() []string {
+	names := make([]string, len(staticResources))
+	for i, resource := range staticResources {
+		names[i] = resource.name
+	}
+	return names
+}
+
+var noop lookupFunc = func(context.Context, []string) (result lookupResult) { return }
+
+var noopReverse reverseLookupFunc = func(netip.Addr) (result []string) { return }
+
+// address families that can be emitted by a plugin instance
+const (
+	familyAll  = "all"
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// policies for a hostname claimed by objects in several namespaces
+const (
+	conflictUnion  = "union"
+	conflictFirst  = "first"
+	conflictReject = "reject"
+)
 
 var (
 	ttlDefault        = uint32(60)
 	ttlSOA            = uint32(60)
 	defaultApex       = "dns1.kube-system"
 	defaultHostmaster = "hostmaster"
-	defaultSecondNS   = ""
+	defaultSecondNS   = []string{}
+	// reserved label answering TXT queries with the index summary
+	debugIndexLabel = "_index"
+	// only LoadBalancer services are published unless configured otherwise
+	defaultServiceTypes = []string{"LoadBalancer"}
+	defaultFamily       = familyAll
+	// objects of all namespaces claiming a hostname contribute to its answers
+	defaultHostnameConflicts = conflictUnion
+	// answers derived from resolved hostnames are cached at least this long
+	defaultUpstreamTTLFloor = uint32(5)
 )
 
 // Gateway stores all runtime configuration of a plugin
@@ -51,11 +298,82 @@ type Gateway struct {
 	Controller          *KubeController
 	apex                string
 	hostmaster          string
-	secondNS            string
+	secondNS            []string
 	configFile          string
-	configContext       string
+	configContexts      []string
 	ExternalAddrFunc    func(request.Request) []dns.RR
 	resourceFilters     ResourceFilters
+	debugIndex          bool
+	// controllers of the clusters of further kubeconfig contexts, whose lookup
+	// results are merged with the ones of Controller
+	clusters []*KubeController
+	// query types that fall through, all of them when empty
+	fallthroughTypes []uint16
+	// fallthrough of zones that don't follow Fall and fallthroughTypes, keyed by zone
+	zoneFallthrough map[string]zoneFall
+	// address families emitted in A and AAAA answers, overridden for the
+	// zones with an entry in zoneFamily
+	family     string
+	zoneFamily map[string]string
+	// lowest TTL used for answers derived from resolved hostnames
+	upstreamTTLFloor uint32
+	// minimum field of the SOA, how long resolvers cache negative answers
+	soaMinTTL uint32
+	// answer with a CNAME to load balancer hostnames instead of their addresses,
+	// overridden for the zones with an entry in zoneCNAMEGatewayHostnames
+	cnameGatewayHostnames     bool
+	zoneCNAMEGatewayHostnames map[string]bool
+	// answer ANY queries with a single HINFO record instead of all records
+	minimalAny bool
+	// answer names excluded by a filter with REFUSED instead of NXDOMAIN
+	refuseFiltered bool
+	// address family listed first in ANY answers, empty for no preference
+	preferFamily string
+	// hide the addresses of the other family for names with addresses of the preferred one
+	preferFamilyOnly bool
+	// family preference of zones that don't follow preferFamily, keyed by zone
+	zonePreferFamily map[string]familyPreference
+	// pass queries to the next plugin instead of failing them until synced
+	fallthroughUnsynced bool
+	// query names that are always traced, and the share of other queries traced
+	traceNames      []string
+	traceSampleRate float64
+	// TTL of answers for names whose object was deleted within the grace period
+	deleteGracePeriod time.Duration
+	deleteGraceTTL    uint32
+	// period the last load balancer addresses of a Service are served after its status became empty
+	statusGracePeriod time.Duration
+	// TTL of answers served from the last known state while the API server is unreachable
+	serveStale    bool
+	serveStaleTTL uint32
+	// replace the synthesized apex NS records, none at all if disableNameservers is set
+	nameserverNames    []string
+	disableNameservers bool
+	// glob patterns of names that are published, all unless set, and never published
+	allowNames []string
+	denyNames  []string
+	// period of informer resyncs, 0 relies on watch events only
+	resyncPeriod time.Duration
+	// resources looked up before all others when several provide a name, in this order
+	resourcePrecedence []string
+	// regions of client subnets, matched against the EDNS Client Subnet of queries
+	clientRegions []clientRegion
+	// nameservers (host:port) resolving load balancer hostnames, resolv.conf unless set
+	upstreamResolvers []string
+	// resources looked up for names in a zone, all Resources for zones without an entry
+	zoneResources map[string][]*resourceWithIndex
+	// resource filters of the zones overriding the ones of the block, keyed by zone
+	zoneFilters map[string]*ResourceFilters
+	// zones whose names are looked up as the same names in another served zone, keyed by alias
+	zoneAliases map[string]string
+	// labels right below the zones whose names are also looked up without them, e.g. svc
+	stripSubdomain string
+	// lookup results of recent queries, nil unless enabled
+	answerCache *answerCache
+	// addresses configured for names in the Corefile, keyed by name without the closing dot
+	staticRecords map[string][]netip.Addr
+	// DNAME targets configured for subtrees in the Corefile, keyed by lower case owner name
+	dnameTargets map[string]string
 
 	Fall fall.F
 }
@@ -63,6 +381,39 @@ type Gateway struct {
 type ResourceFilters struct {
 	ingressClasses []string
 	gatewayClasses []string
+	serviceTypes   []string
+	// only resolve routes whose attachment was accepted by the parent Gateway
+	acceptedRoutesOnly bool
+	// only resolve Gateways whose status is Accepted=True and Programmed=True
+	programmedGatewaysOnly bool
+	// only resolve routes attached to Gateways in other namespaces if a ReferenceGrant allows it
+	requireReferenceGrants bool
+	// also publish routes under the "name.namespace" of their Service backends
+	backendRefHostnames bool
+	// resolve Services of every type to their cluster IPs
+	serviceClusterIPs bool
+	// use the IP of load balancer status entries that also carry a hostname
+	preferLoadBalancerIPs bool
+	// resolve Services with external IPs to their load balancer status addresses as well
+	mergeExternalIPs bool
+	// only publish Services with a hostname annotation, not as name.namespace
+	requireHostnameAnnotation bool
+	// also publish Services under the hostnames in their load balancer status
+	indexLoadBalancerHostnames bool
+	// resolve NodePort Services to the addresses of this type of the nodes hosting their endpoints
+	nodeAddressType string
+	// resolve Services with externalTrafficPolicy Local to the addresses of this
+	// type of the nodes hosting their ready endpoints
+	localPolicyAddressType string
+	// how Services and Ingresses in different namespaces claiming the same hostname are resolved
+	hostnameConflicts string
+	// annotation on Gateways listing their addresses, used when the status has none
+	gatewayAddressAnnotation string
+	// only index Ingresses with a load balancer address, and the ready annotation if set
+	readyIngressesOnly     bool
+	ingressReadyAnnotation string
+	// resolves load balancer hostnames with the nameservers of this instance
+	resolver hostnameResolver
 }
 
 // Create a new Gateway instance
@@ -72,9 +423,18 @@ func newGateway() *Gateway {
 		ConfiguredResources: []*string{},
 		ttlLow:              ttlDefault,
 		ttlSOA:              ttlSOA,
+		soaMinTTL:           ttlSOA,
 		apex:                defaultApex,
 		secondNS:            defaultSecondNS,
 		hostmaster:          defaultHostmaster,
+		family:              defaultFamily,
+		upstreamTTLFloor:    defaultUpstreamTTLFloor,
+		resyncPeriod:        defaultResyncPeriod,
+		resourceFilters: ResourceFilters{
+			serviceTypes:      defaultServiceTypes,
+			hostnameConflicts: defaultHostnameConflicts,
+			resolver:          newUpstreamResolver(nil),
+		},
 	}
 }
 
@@ -110,9 +470,82 @@ func (gw *Gateway) updateResources(newResources []string) {
 		}
 	}
 
+	// resources listed in the precedence order are looked up first
+	if len(gw.resourcePrecedence) > 0 {
+		slices.SortStableFunc(gw.Resources, func(a, b *resourceWithIndex) int {
+			return cmp.Compare(gw.precedenceOf(a.name), gw.precedenceOf(b.name))
+		})
+	}
+
 	log.Debugf("final resources: %v", gw.Resources)
 }
 
+// precedenceOf returns the position of a resource in the precedence order,
+// resources that aren't listed come after all listed ones
+func (gw *Gateway) precedenceOf(name string) int {
+	if i := slices.Index(gw.resourcePrecedence, name); i >= 0 {
+		return i
+	}
+	return len(gw.resourcePrecedence)
+}
+
+// resourcesFor returns the resources names in a zone are looked up in
+func (gw *Gateway) resourcesFor(zone string) []*resourceWithIndex {
+	if resources, ok := gw.zoneResources[strings.ToLower(zone)]; ok {
+		return resources
+	}
+	return gw.Resources
+}
+
+// filtersOf returns the resource filters applied to names in a zone
+func (gw *Gateway) filtersOf(zone string) ResourceFilters {
+	if filters, ok := gw.zoneFilters[strings.ToLower(zone)]; ok {
+		return *filters
+	}
+	return gw.resourceFilters
+}
+
+// indexFilters returns the resource filters objects are indexed with, which
+// have to index everything one of the zones publishes
+func (gw *Gateway) indexFilters() ResourceFilters {
+	filters := gw.resourceFilters
+	for _, zoneFilters := range gw.zoneFilters {
+		filters.serviceClusterIPs = filters.serviceClusterIPs || zoneFilters.serviceClusterIPs
+		filters.serviceTypes = unionOf(filters.serviceTypes, zoneFilters.serviceTypes)
+		if len(filters.ingressClasses) > 0 && len(zoneFilters.ingressClasses) > 0 {
+			filters.ingressClasses = unionOf(filters.ingressClasses, zoneFilters.ingressClasses)
+		} else {
+			// no classes allow all of them
+			filters.ingressClasses = nil
+		}
+	}
+	return filters
+}
+
+// unionOf returns the values of a followed by the ones of b that aren't in a
+func unionOf(a, b []string) []string {
+	union := slices.Clone(a)
+	for _, value := range b {
+		if !slices.Contains(union, value) {
+			union = append(union, value)
+		}
+	}
+	return union
+}
+
+// zoneFiltersKey is the context key of the resource filters of the zone a
+// lookup is done for
+type zoneFiltersKey struct{}
+
+// queryFilters returns the resource filters of the zone of a lookup, or the
+// given filters of the block if the zone doesn't override them
+func queryFilters(ctx context.Context, filters ResourceFilters) ResourceFilters {
+	if zoneFilters, ok := ctx.Value(zoneFiltersKey{}).(*ResourceFilters); ok {
+		return *zoneFilters
+	}
+	return filters
+}
+
 func (gw *Gateway) SetConfiguredResources(newResources []string) {
 	gw.ConfiguredResources = make([]*string, len(newResources))
 	for i, resource := range newResources {
@@ -134,12 +567,49 @@ func (gw *Gateway) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 	zone = qname[len(qname)-len(zone):] // maintain case of original query
 	state.Zone = zone
 
-	indexKeySets := gw.getQueryIndexKeySets(qname, zone)
-	log.Debugf("computed Index Keys sets %v", indexKeySets)
+	if gw.debugIndex && state.QType() == dns.TypeTXT && strings.EqualFold(state.Name(), debugIndexLabel+"."+zone) {
+		return gw.serveIndexSummary(state)
+	}
+
+	// names below a DNAME owner are redirected rather than looked up (RFC 6672)
+	if owner, ok := gw.dnameOwner(qname); ok && len(owner) < len(qname) {
+		return gw.serveDNAME(state, owner)
+	}
 
-	if !gw.Controller.HasSynced() {
-		// TODO maybe there's a better way to do this? e.g. return an error back to the client?
-		return dns.RcodeServerFailure, plugin.Error(thisPlugin, fmt.Errorf("could not sync required resources"))
+	// names in an alias zone mirror the same names in the zone it points to
+	lookupName, lookupZone := qname, zone
+	if target, ok := gw.zoneAliases[strings.ToLower(zone)]; ok {
+		lookupName = qname[:len(qname)-len(zone)] + target
+		lookupZone = target
+	}
+
+	indexKeySets := gw.getQueryIndexKeySets(lookupName, lookupZone)
+	// arguments of disabled logs are still allocated, so they are skipped on the hot path
+	if clog.D.Value() {
+		log.Debugf("computed Index Keys sets %v", indexKeySets)
+	}
+
+	trace := gw.newQueryTrace(qname)
+	if trace != nil {
+		trace.logf("query %s %s computed index key sets %v", qname, dns.TypeToString[state.QType()], indexKeySets)
+	}
+
+	// static records are served even before the resources are synced. Once
+	// synced, queries are answered from the informer caches even while the API
+	// server is unreachable, serveStale only lowers the TTL meanwhile
+	synced := gw.hasSynced()
+	if !synced && len(gw.getStaticAddresses(indexKeySets, nil).addrs) == 0 {
+		if gw.fallthroughUnsynced {
+			return plugin.NextOrFailure(gw.Name(), gw.Next, ctx, w, r)
+		}
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		setExtendedError(m, r, dns.ExtendedErrorCodeNotReady, "k8s_gateway resources are not synced yet")
+		if err := w.WriteMsg(m); err != nil {
+			log.Errorf("failed to send a response: %s", err)
+		}
+		// the response is written already, the error is only logged
+		return dns.RcodeSuccess, plugin.Error(thisPlugin, fmt.Errorf("could not sync required resources"))
 	}
 
 	var isRootZoneQuery bool
@@ -148,92 +618,213 @@ func (gw *Gateway) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 			isRootZoneQuery = true
 			break
 		}
-		if dns.IsSubDomain(gw.apex+"."+z, state.Name()) {
+		if isSubdomain(gw.apex+"."+z, state.Name()) {
 			// dns subdomain test for ns. and dns. queries
 			ret, err := gw.serveSubApex(state)
 			return ret, err
 		}
 	}
 
-	addrs := gw.getMatchingAddresses(indexKeySets)
-	log.Debugf("computed response addresses %v", addrs)
+	// the name is excluded by allowNames or denyNames
+	var denied bool
+	var results lookupResult
+	var ptrNames []string
+	if !gw.published(qname) || !gw.published(lookupName) {
+		trace.logf("name %s is not published by the allowNames or denyNames patterns", lookupName)
+		denied = true
+	} else if !synced {
+		results = gw.getStaticAddresses(indexKeySets, trace)
+	} else if cached, cachedNames, ok := gw.answerCache.get(qname, state.QType()); ok {
+		trace.logf("found addresses %v and records %v in the answer cache", cached.addrs, cached.records)
+		results, ptrNames = cached, cachedNames
+	} else {
+		// results computed while an object changed must not outlive the purge
+		generation := gw.answerCache.generation()
+		results = gw.getMatchingAddresses(ctx, lookupZone, indexKeySets, state.QType(), trace)
+		if clog.D.Value() {
+			log.Debugf("computed response addresses %v and records %v", results.addrs, results.records)
+		}
+
+		if state.QType() == dns.TypePTR {
+			ptrNames = gw.getMatchingHostnames(zone, qname)
+			log.Debugf("computed response hostnames %v", ptrNames)
+		}
+
+		// resolved hostnames may change without any object changing
+		cacheTTL := results.ttlOr(gw.ttlLow)
+		if results.upstreamTTL != nil {
+			cacheTTL = min(cacheTTL, *results.upstreamTTL)
+		}
+		gw.answerCache.add(generation, qname, state.QType(), results, ptrNames, time.Duration(cacheTTL)*time.Second)
+	}
+	addrs := results.addrs
 
 	// Fall through if no host matches
-	if len(addrs) == 0 && gw.Fall.Through(qname) {
+	if results.isEmpty() && len(ptrNames) == 0 && gw.fallsThrough(qname, zone, state.QType()) {
+		return plugin.NextOrFailure(gw.Name(), gw.Next, ctx, w, r)
+	}
+
+	// TXT records of a name served here may be managed elsewhere, e.g. ACME DNS-01
+	// challenges, so a missing TXT set falls through even if the name has addresses
+	if state.QType() == dns.TypeTXT && len(results.records["TXT"]) == 0 &&
+		len(results.records["CNAME"]) == 0 && gw.fallsThrough(qname, zone, state.QType()) {
+		trace.logf("no TXT records, falling through")
 		return plugin.NextOrFailure(gw.Name(), gw.Next, ctx, w, r)
 	}
 
 	m := new(dns.Msg)
 	m.SetReply(state.Req)
 
+	ttl := results.ttlOr(gw.ttlLow)
+	if results.upstreamTTL != nil {
+		// don't outlive the records of resolved hostnames, down to the configured floor
+		ttl = min(ttl, max(*results.upstreamTTL, gw.upstreamTTLFloor))
+	}
+	if gw.deleteGracePeriod > 0 && gw.recentlyDeleted(slices.Concat(indexKeySets...)) {
+		// another object may still back the name, don't let resolvers keep it for long
+		ttl = min(ttl, gw.deleteGraceTTL)
+	}
+	if gw.serveStale && gw.isDisconnected() {
+		// the informer caches may be outdated until the API server is reachable again
+		ttl = min(ttl, gw.serveStaleTTL)
+	}
+	if trace != nil {
+		trace.logf("computed addresses %v and records %v with TTL %d", addrs, results.records, ttl)
+	}
+
 	var ipv4Addrs []netip.Addr
 	var ipv6Addrs []netip.Addr
 
+	family := gw.familyOf(zone)
 	for _, addr := range addrs {
-		if addr.Is4() {
+		if addr.Is4() && family != familyIPv6 {
 			ipv4Addrs = append(ipv4Addrs, addr)
 		}
-		if addr.Is6() {
+		if addr.Is6() && family != familyIPv4 {
 			ipv6Addrs = append(ipv6Addrs, addr)
 		}
 	}
 
-	switch state.QType() {
-	case dns.TypeA:
-
-		if len(ipv4Addrs) == 0 {
-
-			if !isRootZoneQuery {
-				// No match, return NXDOMAIN
-				m.Rcode = dns.RcodeNameError
-			}
+	// names with addresses of both families only get the preferred one, so
+	// e.g. AAAA queries are answered with NODATA if IPv4 is preferred
+	preference := gw.preferenceOf(zone)
+	if preference.only && len(ipv4Addrs) > 0 && len(ipv6Addrs) > 0 {
+		switch preference.family {
+		case familyIPv4:
+			ipv6Addrs = nil
+		case familyIPv6:
+			ipv4Addrs = nil
+		}
+	}
 
-			m.Ns = []dns.RR{gw.soa(state)}
+	// a name exists if it has records of any type, queries for a type it
+	// doesn't have are answered with NODATA rather than NXDOMAIN
+	dnameTarget, hasDNAME := gw.dnameTargets[strings.ToLower(qname)]
+	nameExists := isRootZoneQuery || !results.isEmpty() || len(ptrNames) > 0 || hasDNAME
+
+	// addresses in the region of the client come first, in weighted order
+	weights := results.weights
+	ecs, region := gw.clientRegionOf(r)
+	var regional bool
+	if region != "" && len(results.regions) > 0 {
+		regional = inRegionSplit(ipv4Addrs, results.regions, region) || inRegionSplit(ipv6Addrs, results.regions, region)
+		ipv4Addrs = preferRegion(weightedShuffle(ipv4Addrs, weights), results.regions, region)
+		ipv6Addrs = preferRegion(weightedShuffle(ipv6Addrs, weights), results.regions, region)
+		weights = nil
+		trace.logf("preferred addresses of region %s", region)
+	}
 
-		} else {
+	// prioritized addresses come first regardless of weights and regions
+	if len(results.priorities) > 0 {
+		ipv4Addrs = preferPriority(weightedShuffle(ipv4Addrs, weights), results.priorities)
+		ipv6Addrs = preferPriority(weightedShuffle(ipv6Addrs, weights), results.priorities)
+		weights = nil
+	}
 
-			m.Answer = gw.A(state.Name(), ipv4Addrs)
-		}
-	case dns.TypeAAAA:
+	cnames := gw.CNAME(state.Name(), ttl, results.records["CNAME"])
 
-		if len(ipv6Addrs) == 0 {
+	switch qtype := state.QType(); {
+	case len(cnames) > 0 && !isRootZoneQuery && qtype != dns.TypeSOA:
+		// a name with a CNAME can't have any other data
+		m.Answer = cnames
 
-			if !isRootZoneQuery {
-				// No match, return NXDOMAIN
-				m.Rcode = dns.RcodeNameError
-			}
+	case qtype == dns.TypeA:
+		m.Answer = gw.A(state.Name(), ttl, ipv4Addrs, weights)
 
-			// as per rfc4074 #3
-			if len(ipv4Addrs) > 0 {
-				m.Rcode = dns.RcodeSuccess
-			}
+	case qtype == dns.TypeAAAA:
+		m.Answer = gw.AAAA(state.Name(), ttl, ipv6Addrs, weights)
 
-			m.Ns = []dns.RR{gw.soa(state)}
+	case qtype == dns.TypeHTTPS:
+		m.Answer = gw.HTTPS(state.Name(), ttl, ipv4Addrs, ipv6Addrs, results.alpn)
 
-		} else {
+	case qtype == dns.TypeDNAME:
+		if hasDNAME {
+			m.Answer = []dns.RR{gw.DNAME(state.Name(), gw.ttlLow, dnameTarget)}
+		}
 
-			m.Answer = gw.AAAA(state.Name(), ipv6Addrs)
+	case qtype == dns.TypeANY && gw.minimalAny:
+		// RFC 8482 section 4.2
+		if nameExists {
+			m.Answer = []dns.RR{&dns.HINFO{Hdr: dns.RR_Header{Name: state.Name(), Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: ttl}, Cpu: "RFC8482"}}
 		}
 
-	case dns.TypeSOA:
+	case qtype == dns.TypeANY:
+		addrRecords := [][]dns.RR{gw.A(state.Name(), ttl, ipv4Addrs, weights), gw.AAAA(state.Name(), ttl, ipv6Addrs, weights)}
+		if preference.family == familyIPv6 {
+			slices.Reverse(addrRecords)
+		}
+		m.Answer = slices.Concat(addrRecords[0], addrRecords[1])
+		for _, recordType := range slices.Sorted(maps.Keys(recordBuilders)) {
+			m.Answer = append(m.Answer, recordBuilders[recordType](gw, state.Name(), ttl, results.records[dns.TypeToString[recordType]])...)
+		}
+		if hasDNAME {
+			m.Answer = append(m.Answer, gw.DNAME(state.Name(), gw.ttlLow, dnameTarget))
+		}
+		if isRootZoneQuery {
+			m.Answer = append(m.Answer, gw.soa(state))
+		}
 
+	case qtype == dns.TypeSOA:
 		m.Answer = []dns.RR{gw.soa(state)}
 
-	case dns.TypeNS:
+	case qtype == dns.TypePTR:
+		m.Answer = gw.PTR(state.Name(), gw.ttlLow, ptrNames)
 
-		if isRootZoneQuery {
-			m.Answer = gw.nameservers(state)
+	case qtype == dns.TypeNS && isRootZoneQuery:
+		m.Answer = gw.nameservers(state)
 
+		// glue is only known for the synthesized nameservers
+		if len(m.Answer) > 0 && len(gw.nameserverNames) == 0 {
 			addr := gw.ExternalAddrFunc(state)
 			for _, rr := range addr {
 				rr.Header().Ttl = gw.ttlSOA
 				m.Extra = append(m.Extra, rr)
 			}
-		} else {
-			m.Ns = []dns.RR{gw.soa(state)}
 		}
 
+	case recordBuilders[qtype] != nil:
+		// records kept as raw data, e.g. NS records of a delegated subdomain
+		m.Answer = recordBuilders[qtype](gw, state.Name(), ttl, results.records[dns.TypeToString[qtype]])
+	}
+
+	switch {
+	case len(m.Answer) > 0:
+	case !nameExists && gw.refuseFiltered && (results.filtered || denied):
+		// filtered names are told apart from missing ones, e.g. for monitoring
+		m.Rcode = dns.RcodeRefused
+		if results.filtered {
+			setExtendedError(m, r, dns.ExtendedErrorCodeFiltered, "matching objects are excluded by a class filter")
+		} else {
+			setExtendedError(m, r, dns.ExtendedErrorCodeFiltered, "the name is excluded by allowNames or denyNames")
+		}
 	default:
+		if !nameExists {
+			// No match, return NXDOMAIN
+			m.Rcode = dns.RcodeNameError
+			if results.filtered {
+				setExtendedError(m, r, dns.ExtendedErrorCodeFiltered, "matching objects are excluded by a class filter")
+			}
+		}
 		m.Ns = []dns.RR{gw.soa(state)}
 	}
 
@@ -241,6 +832,27 @@ func (gw *Gateway) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 	// See https://github.com/coredns/coredns/pull/3573
 	m.Authoritative = true
 
+	if ecs != nil {
+		// an answer ordered for the region is valid for the whole client subnet,
+		// any other for all clients (RFC 7871, section 7.2.1)
+		if m.IsEdns0() == nil {
+			opt := r.IsEdns0()
+			m.SetEdns0(opt.UDPSize(), opt.Do())
+		}
+		ecs.SourceScope = 0
+		if regional {
+			ecs.SourceScope = ecs.SourceNetmask
+		}
+		m.IsEdns0().Option = append(m.IsEdns0().Option, ecs)
+	}
+
+	// large answers are trimmed to the buffer size advertised by the client,
+	// setting the TC bit over UDP so it retries over TCP
+	m = state.Scrub(m)
+	if m.Truncated {
+		trace.logf("truncated the answer to %d records for a %d bytes buffer", len(m.Answer), state.Size())
+	}
+
 	if err := w.WriteMsg(m); err != nil {
 		log.Errorf("failed to send a response: %s", err)
 	}
@@ -248,30 +860,119 @@ func (gw *Gateway) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 	return dns.RcodeSuccess, nil
 }
 
-// Computes keys to look up in cache
+// setExtendedError attaches an Extended DNS Error (RFC 8914) to the response
+// of a query using EDNS
+func setExtendedError(m, req *dns.Msg, code uint16, text string) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return
+	}
+	m.SetEdns0(opt.UDPSize(), opt.Do())
+	edns := m.IsEdns0()
+	edns.Option = append(edns.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: text})
+}
+
+// zoneFall configures fallthrough for a single zone
+type zoneFall struct {
+	off bool
+	// query types that fall through, all of them when empty
+	types []uint16
+}
+
+// fallsThrough reports whether an unmatched query is passed on to the next plugin
+func (gw *Gateway) fallsThrough(qname, zone string, qtype uint16) bool {
+	if zf, ok := gw.zoneFallthrough[strings.ToLower(zone)]; ok {
+		return !zf.off && (len(zf.types) == 0 || slices.Contains(zf.types, qtype))
+	}
+	if len(gw.fallthroughTypes) > 0 && !slices.Contains(gw.fallthroughTypes, qtype) {
+		return false
+	}
+	return gw.Fall.Through(qname)
+}
+
+// Computes keys to look up in cache: the query name without the closing dot,
+// followed by the name relative to the zone if the query is below the zone
+// apex. Names are compared as FQDNs, either may omit the closing dot. The apex
+// itself has no relative name and is only looked up by its full name.
 func (gw *Gateway) getQueryIndexKeys(qName, zone string) []string {
-	zonelessQuery := stripDomain(qName, zone)
+	qName, zone = dns.Fqdn(qName), dns.Fqdn(zone)
+	strippedQName := normalizeHostname(stripClosingDot(qName))
 
-	var indexKeys []string
-	strippedQName := stripClosingDot(qName)
-	if len(zonelessQuery) != 0 && zonelessQuery != strippedQName {
-		indexKeys = []string{strippedQName, zonelessQuery}
-	} else {
-		indexKeys = []string{strippedQName}
+	if strings.EqualFold(qName, zone) || !isSubdomain(zone, qName) {
+		return []string{strippedQName}
 	}
 
-	return indexKeys
+	zonelessQuery := normalizeHostname(stripDomain(qName, zone))
+	if len(zonelessQuery) == 0 || zonelessQuery == strippedQName {
+		return []string{strippedQName}
+	}
+	return []string{strippedQName, zonelessQuery}
+}
+
+// isSubdomain reports whether a name is the zone or below it, both being FQDNs.
+// Unlike dns.IsSubDomain, it doesn't allocate.
+func isSubdomain(zone, name string) bool {
+	if len(name) < len(zone) || !strings.EqualFold(name[len(name)-len(zone):], zone) {
+		return false
+	}
+	if len(name) == len(zone) || zone == "." {
+		return true
+	}
+	// the zone has to start at a label boundary, i.e. after an unescaped dot
+	i := len(name) - len(zone) - 1
+	if name[i] != '.' {
+		return false
+	}
+	escapes := 0
+	for j := i - 1; j >= 0 && name[j] == '\\'; j-- {
+		escapes++
+	}
+	return escapes%2 == 0
 }
 
 // Returns all sets of index keys that should be checked, in order, for a given
 // query name and zone. The first set of keys is the most specific, and the last
 // set is the most general. The first set of keys that is in the indexer should
-// be used to look up the query.
+// be used to look up the query. In zone `example.com.`:
+//
+//	example.com.          [[example.com]]
+//	www.example.com.      [[www.example.com www] [*.example.com *]]
+//	a.b.example.com.      [[a.b.example.com a.b] [*.b.example.com *.b]]
+//	*.example.com.        [[*.example.com *]]
+//
+// With stripSubdomain set to `svc`, the keys of a name below it are followed by
+// those of the name without it, so a name of the subdomain itself takes precedence:
+//
+//	a.b.svc.example.com.  [[a.b.svc.example.com a.b.svc] [a.b.example.com a.b] [*.b.svc.example.com *.b.svc] [*.b.example.com *.b]]
 func (gw *Gateway) getQueryIndexKeySets(qName, zone string) [][]string {
+	keySets := gw.nameIndexKeySets(qName, zone)
+	if stripped := gw.stripSubdomainName(qName, zone); stripped != "" {
+		strippedKeySets := gw.nameIndexKeySets(stripped, zone)
+		keySets = slices.Concat(keySets[:1], strippedKeySets[:1], keySets[1:], strippedKeySets[1:])
+	}
+	return keySets
+}
+
+// stripSubdomainName returns a name below the stripSubdomain labels of a zone
+// without them, or an empty string for other names
+func (gw *Gateway) stripSubdomainName(qName, zone string) string {
+	if gw.stripSubdomain == "" {
+		return ""
+	}
+	qName, zone = dns.Fqdn(qName), dns.Fqdn(zone)
+	subdomain := dnsutil.Join(gw.stripSubdomain, zone)
+	if len(qName) == len(subdomain) || !isSubdomain(subdomain, qName) {
+		return ""
+	}
+	return dnsutil.Join(qName[:len(qName)-len(subdomain)-1], zone)
+}
+
+// nameIndexKeySets returns the specific and wildcard index keys of a name
+func (gw *Gateway) nameIndexKeySets(qName, zone string) [][]string {
 	specificIndexKeys := gw.getQueryIndexKeys(qName, zone)
 
 	wildcardQName := gw.toWildcardQName(qName, zone)
-	if wildcardQName == "" {
+	if wildcardQName == "" || strings.EqualFold(wildcardQName, dns.Fqdn(qName)) {
 		return [][]string{specificIndexKeys}
 	}
 
@@ -281,87 +982,890 @@ func (gw *Gateway) getQueryIndexKeySets(qName, zone string) [][]string {
 
 // Converts a query name to a wildcard query name by replacing the first
 // label with a wildcard. The wildcard query name is used to look up
-// wildcard records in the indexer. If the query name is empty or
-// contains no labels, an empty string is returned.
+// wildcard records in the indexer. Wildcards of a zone don't cover its
+// apex nor names outside of it, for which an empty string is returned.
 func (gw *Gateway) toWildcardQName(qName, zone string) string {
-	// Indexer cache can be built from `name.namespace` without zone
-	zonelessQuery := stripDomain(qName, zone)
-	parts := strings.Split(zonelessQuery, ".")
-	if len(parts) == 0 {
+	qName, zone = dns.Fqdn(qName), dns.Fqdn(zone)
+	if strings.EqualFold(qName, zone) || !isSubdomain(zone, qName) {
 		return ""
 	}
 
-	parts[0] = "*"
-	parts = append(parts, zone)
-	return strings.Join(parts, ".")
+	// the name is at least one label below the zone, so it has a first label
+	if next, end := dns.NextLabel(qName, 0); !end {
+		return "*." + qName[next:]
+	}
+	return "*."
 }
 
 // Gets the set of addresses associated with the first set of index keys
-// that is in the indexer.
-func (gw *Gateway) getMatchingAddresses(indexKeySets [][]string) []netip.Addr {
+// that is in the indexer. Resources that have records of the queried type
+// take precedence over earlier ones only having other records of the name.
+func (gw *Gateway) getMatchingAddresses(ctx context.Context, zone string, indexKeySets [][]string, qtype uint16, trace *queryTrace) lookupResult {
 	// Iterate over supported resources and lookup DNS queries
 	// Stop once we've found at least one match
-	for _, indexKeys := range indexKeySets {
-		for _, resource := range gw.Resources {
-			addrs := resource.lookup(indexKeys)
-			if len(addrs) > 0 {
-				return addrs
+	var filtered bool
+	answersHostnames := gw.answersHostnames(zone)
+	if filters, ok := gw.zoneFilters[strings.ToLower(zone)]; ok {
+		ctx = context.WithValue(ctx, zoneFiltersKey{}, filters)
+	}
+	for _, indexKeySet := range indexKeySets {
+		// kept by value, a pointer to the loop variable would move every result to the heap
+		var first lookupResult
+		var firstResource string
+		for _, resource := range gw.resourcesFor(zone) {
+			results := gw.lookup(ctx, resource, indexKeySet)
+			if answersHostnames {
+				// names whose hostname failed to resolve still have the CNAME
+				results = results.aliasHostnames()
 			}
+			if results.hasType(qtype) {
+				trace.logf("resource %s matched index keys %v", resource.name, indexKeySet)
+				return results
+			}
+			if !results.isEmpty() && firstResource == "" {
+				first, firstResource = results, resource.name
+			}
+			filtered = filtered || results.filtered
+		}
+		if firstResource != "" {
+			// the name exists, but has no records of the queried type
+			trace.logf("resource %s matched index keys %v without %s records", firstResource, indexKeySet, dns.TypeToString[qtype])
+			return first
+		}
+	}
+
+	// static records have the lowest precedence
+	if results := gw.getStaticAddresses(indexKeySets, trace); !results.isEmpty() {
+		return results
+	}
+
+	trace.logf("no resource matched")
+	return lookupResult{filtered: filtered}
+}
+
+// getStaticAddresses returns the static records of the most specific index keys
+func (gw *Gateway) getStaticAddresses(indexKeySets [][]string, trace *queryTrace) (result lookupResult) {
+	for _, indexKeySet := range indexKeySets {
+		for _, key := range indexKeySet {
+			if addrs, ok := gw.staticRecords[strings.ToLower(key)]; ok {
+				trace.logf("static records matched index key %s", key)
+				result.addrs = addrs
+				return
+			}
+		}
+	}
+	return
+}
+
+// queryTrace logs how a single query is resolved, tagged with a correlation
+// id. A nil trace logs nothing, so disabled tracing only costs a nil check.
+type queryTrace struct {
+	id string
+}
+
+// newQueryTrace starts a trace for the configured query names and for the
+// sampled share of the other queries, it returns nil for all other queries
+func (gw *Gateway) newQueryTrace(qname string) *queryTrace {
+	if len(gw.traceNames) == 0 && gw.traceSampleRate == 0 {
+		return nil
+	}
+	if !slices.Contains(gw.traceNames, strings.ToLower(qname)) && rand.Float64() >= gw.traceSampleRate {
+		return nil
+	}
+	return &queryTrace{id: fmt.Sprintf("%08x", rand.Uint32())}
+}
+
+func (t *queryTrace) logf(format string, args ...any) {
+	if t == nil {
+		return
+	}
+	log.Infof("trace %s: "+format, append([]any{t.id}, args...)...)
+}
+
+// Gets the hostnames of the objects currently backed by the address encoded
+// in a reverse query name. The reverse indexes are maintained by the same
+// informers as the forward ones, so deleted objects stop resolving right away.
+func (gw *Gateway) getMatchingHostnames(zone, qName string) []string {
+	ip := dnsutil.ExtractAddressFromReverse(qName)
+	if ip == "" {
+		return nil
+	}
+	addr, err := parseAddr(ip)
+	if err != nil {
+		return nil
+	}
+
+	for _, resource := range gw.resourcesFor(zone) {
+		var fqdns []string
+		for _, hostname := range gw.reverseLookup(resource, addr) {
+			if fqdn := gw.toFQDN(hostname); fqdn != "" && gw.published(fqdn) {
+				fqdns = append(fqdns, fqdn)
+			}
+		}
+		if len(fqdns) > 0 {
+			return fqdns
 		}
 	}
 
 	return nil
 }
 
+// published reports whether a name may be answered, it must not match any
+// denyNames pattern and, if allowNames are set, has to match one of them
+func (gw *Gateway) published(name string) bool {
+	name = strings.ToLower(stripClosingDot(name))
+	matches := func(pattern string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	if slices.ContainsFunc(gw.denyNames, matches) {
+		return false
+	}
+	return len(gw.allowNames) == 0 || slices.ContainsFunc(gw.allowNames, matches)
+}
+
+// Converts an indexed hostname into a FQDN. Hostnames that are not within
+// any of the configured zones (e.g. `name.namespace`) get the first forward
+// zone appended. Wildcard hostnames can't be used as PTR targets.
+func (gw *Gateway) toFQDN(hostname string) string {
+	if hostname == "" || strings.HasPrefix(hostname, "*") {
+		return ""
+	}
+	fqdn := dns.Fqdn(hostname)
+	if plugin.Zones(gw.Zones).Matches(fqdn) != "" {
+		return fqdn
+	}
+	for _, zone := range gw.Zones {
+		if dnsutil.IsReverse(zone) == 0 {
+			return dnsutil.Join(hostname, zone)
+		}
+	}
+	return ""
+}
+
+// serveIndexSummary answers the reserved debug name with one TXT record per
+// controller, reporting the number of cached objects and its sync state.
+func (gw *Gateway) serveIndexSummary(state request.Request) (int, error) {
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Authoritative = true
+
+	var summary []string
+	for i, ctrl := range gw.controllers() {
+		for _, entry := range ctrl.indexSummary() {
+			if len(gw.clusters) > 0 {
+				// the controllers follow the order of the kubeconfig contexts
+				entry = gw.configContexts[i] + " " + entry
+			}
+			summary = append(summary, entry)
+		}
+	}
+	m.Answer = gw.TXT(state.QName(), 0, summary)
+
+	if err := state.W.WriteMsg(m); err != nil {
+		log.Errorf("Failed to send a response: %s", err)
+	}
+	return dns.RcodeSuccess, nil
+}
+
 // Name implements the Handler interface.
 func (gw *Gateway) Name() string { return thisPlugin }
 
+// Status is a read-only snapshot of the effective configuration of a Gateway
+type Status struct {
+	Zones []string
+	// Resources are the resources names are looked up in
+	Resources []string
+	// InactiveResources are configured but not watched since their CRD is unavailable
+	InactiveResources []string
+	IngressClasses    []string
+	GatewayClasses    []string
+	ServiceTypes      []string
+	// Synced is true once all watched resources have been synced
+	Synced bool
+}
+
+// Status returns the effective configuration of the Gateway and whether its
+// resources have been synced
+func (gw *Gateway) Status() Status {
+	status := Status{
+		Zones:          slices.Clone(gw.Zones),
+		IngressClasses: slices.Clone(gw.resourceFilters.ingressClasses),
+		GatewayClasses: slices.Clone(gw.resourceFilters.gatewayClasses),
+		ServiceTypes:   slices.Clone(gw.resourceFilters.serviceTypes),
+	}
+	for _, resource := range gw.Resources {
+		status.Resources = append(status.Resources, resource.name)
+	}
+	if gw.Controller != nil {
+		for _, ctrl := range gw.controllers() {
+			ctrl.mu.RLock()
+			for _, resource := range ctrl.inactiveResources {
+				if !slices.Contains(status.InactiveResources, resource) {
+					status.InactiveResources = append(status.InactiveResources, resource)
+				}
+			}
+			ctrl.mu.RUnlock()
+		}
+		status.Synced = gw.hasSynced()
+	}
+	return status
+}
+
+// controllers returns the controllers of all clusters names are resolved from
+func (gw *Gateway) controllers() []*KubeController {
+	return append([]*KubeController{gw.Controller}, gw.clusters...)
+}
+
+// clusterResources returns copies of the resources for the controller of a
+// further cluster to back, without any lookups of their own yet
+func (gw *Gateway) clusterResources() []*resourceWithIndex {
+	resources := make([]*resourceWithIndex, len(gw.Resources))
+	for i, resource := range gw.Resources {
+		resources[i] = &resourceWithIndex{name: resource.name, lookup: noop, reverse: noopReverse}
+	}
+	return resources
+}
+
+// hasSynced reports whether the controllers of all clusters have synced
+func (gw *Gateway) hasSynced() bool {
+	for _, ctrl := range gw.controllers() {
+		if !ctrl.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// isDisconnected reports whether the API server of any cluster is unreachable
+func (gw *Gateway) isDisconnected() bool {
+	return slices.ContainsFunc(gw.controllers(), (*KubeController).isDisconnected)
+}
+
+// recentlyDeleted reports whether an object with one of the index keys was
+// deleted in any cluster within the deleteGrace period
+func (gw *Gateway) recentlyDeleted(indexKeys []string) bool {
+	return slices.ContainsFunc(gw.controllers(), func(ctrl *KubeController) bool {
+		return ctrl.recentlyDeleted(indexKeys, gw.deleteGracePeriod)
+	})
+}
+
+// lookup looks up the index keys in a resource, merging the results of the
+// same resource in all further clusters
+func (gw *Gateway) lookup(ctx context.Context, resource *resourceWithIndex, indexKeys []string) lookupResult {
+	lookup, _ := resource.lookups()
+	results := lookup(ctx, indexKeys)
+	for _, ctrl := range gw.clusters {
+		if clusterResource := ctrl.lookupResource(resource.name); clusterResource != nil {
+			clusterLookup, _ := clusterResource.lookups()
+			results.merge(clusterLookup(ctx, indexKeys))
+		}
+	}
+	return results
+}
+
+// reverseLookup returns the hostnames of an address in a resource of all clusters
+func (gw *Gateway) reverseLookup(resource *resourceWithIndex, addr netip.Addr) []string {
+	_, reverse := resource.lookups()
+	hostnames := reverse(addr)
+	for _, ctrl := range gw.clusters {
+		if clusterResource := ctrl.lookupResource(resource.name); clusterResource != nil {
+			_, clusterReverse := clusterResource.lookups()
+			for _, hostname := range clusterReverse(addr) {
+				if !slices.Contains(hostnames, hostname) {
+					hostnames = append(hostnames, hostname)
+				}
+			}
+		}
+	}
+	return hostnames
+}
+
 // A does the A-record lookup in ingress indexer
-func (gw *Gateway) A(name string, results []netip.Addr) (records []dns.RR) {
+func (gw *Gateway) A(name string, ttl uint32, results []netip.Addr, weights map[netip.Addr]uint32) (records []dns.RR) {
+	dup := make(map[netip.Addr]struct{}, len(results))
+	for _, result := range weightedShuffle(results, weights) {
+		if _, ok := dup[result]; !ok {
+			dup[result] = struct{}{}
+			records = append(records, &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: result.AsSlice()})
+		}
+	}
+	return records
+}
+
+func (gw *Gateway) AAAA(name string, ttl uint32, results []netip.Addr, weights map[netip.Addr]uint32) (records []dns.RR) {
+	dup := make(map[netip.Addr]struct{}, len(results))
+	for _, result := range weightedShuffle(results, weights) {
+		if _, ok := dup[result]; !ok {
+			dup[result] = struct{}{}
+			records = append(records, &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}, AAAA: result.AsSlice()})
+		}
+	}
+	return records
+}
+
+// HTTPS builds a single HTTPS record of the name itself (target "."), with
+// the addresses of the name as hints and the protocols it advertises
+func (gw *Gateway) HTTPS(name string, ttl uint32, ipv4Addrs, ipv6Addrs []netip.Addr, alpn []string) (records []dns.RR) {
+	if len(ipv4Addrs) == 0 && len(ipv6Addrs) == 0 {
+		return nil
+	}
+	// parameters have to be in ascending key order (RFC 9460, section 2.2)
+	var params []dns.SVCBKeyValue
+	if len(alpn) > 0 {
+		params = append(params, &dns.SVCBAlpn{Alpn: alpn})
+	}
+	if hints := addrHints(ipv4Addrs); len(hints) > 0 {
+		params = append(params, &dns.SVCBIPv4Hint{Hint: hints})
+	}
+	if hints := addrHints(ipv6Addrs); len(hints) > 0 {
+		params = append(params, &dns.SVCBIPv6Hint{Hint: hints})
+	}
+	return []dns.RR{&dns.HTTPS{SVCB: dns.SVCB{
+		Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeHTTPS, Class: dns.ClassINET, Ttl: ttl},
+		Priority: 1,
+		Target:   ".",
+		Value:    params,
+	}}}
+}
+
+// addrHints returns the unique addresses in their original order
+func addrHints(addrs []netip.Addr) (hints []net.IP) {
+	dup := make(map[netip.Addr]struct{})
+	for _, addr := range addrs {
+		if _, ok := dup[addr]; !ok {
+			dup[addr] = struct{}{}
+			hints = append(hints, net.IP(addr.AsSlice()))
+		}
+	}
+	return hints
+}
+
+// clientRegion maps client subnets to the region they are closest to
+type clientRegion struct {
+	prefix netip.Prefix
+	region string
+}
+
+// clientRegionOf returns the EDNS Client Subnet option of a query and the
+// region of the most specific client subnet containing it, if any
+func (gw *Gateway) clientRegionOf(r *dns.Msg) (*dns.EDNS0_SUBNET, string) {
+	if len(gw.clientRegions) == 0 {
+		return nil, ""
+	}
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil, ""
+	}
+	for _, option := range opt.Option {
+		ecs, ok := option.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+		// a source prefix length of 0 opts out of tailored answers
+		if ecs.SourceNetmask == 0 {
+			return nil, ""
+		}
+		addr, ok := netip.AddrFromSlice(ecs.Address)
+		if !ok {
+			return nil, ""
+		}
+		addr = addr.Unmap()
+
+		var match clientRegion
+		for _, clientRegion := range gw.clientRegions {
+			if clientRegion.prefix.Contains(addr) && clientRegion.prefix.Bits() >= match.prefix.Bits() {
+				match = clientRegion
+			}
+		}
+		if match.region == "" {
+			return nil, ""
+		}
+		reply := *ecs
+		return &reply, match.region
+	}
+	return nil, ""
+}
+
+// preferRegion moves the addresses of a region to the front, keeping the
+// order of the addresses otherwise
+func preferRegion(addrs []netip.Addr, regions map[netip.Addr]string, region string) []netip.Addr {
+	sorted := slices.Clone(addrs)
+	slices.SortStableFunc(sorted, func(a, b netip.Addr) int {
+		aLocal, bLocal := regions[a] == region, regions[b] == region
+		switch {
+		case aLocal && !bLocal:
+			return -1
+		case bLocal && !aLocal:
+			return 1
+		}
+		return 0
+	})
+	return sorted
+}
+
+// inRegionSplit reports whether only some of the addresses are in a region,
+// so preferRegion orders them differently for clients of that region
+func inRegionSplit(addrs []netip.Addr, regions map[netip.Addr]string, region string) bool {
+	local := slices.ContainsFunc(addrs, func(addr netip.Addr) bool { return regions[addr] == region })
+	remote := slices.ContainsFunc(addrs, func(addr netip.Addr) bool { return regions[addr] != region })
+	return local && remote
+}
+
+// preferPriority orders addresses by ascending priority, followed by those
+// without one, keeping the order of addresses of the same priority
+func preferPriority(addrs []netip.Addr, priorities map[netip.Addr]uint32) []netip.Addr {
+	sorted := slices.Clone(addrs)
+	slices.SortStableFunc(sorted, func(a, b netip.Addr) int {
+		aPriority, aOk := priorities[a]
+		bPriority, bOk := priorities[b]
+		switch {
+		case aOk && !bOk:
+			return -1
+		case bOk && !aOk:
+			return 1
+		}
+		return cmp.Compare(aPriority, bPriority)
+	})
+	return sorted
+}
+
+// weightedShuffle orders addresses randomly so that higher weighted ones are
+// more likely to come first, addresses without a weight count as 1 and ones
+// with a weight of 0 always come last. Without any weights the order is left
+// untouched.
+func weightedShuffle(addrs []netip.Addr, weights map[netip.Addr]uint32) []netip.Addr {
+	if len(weights) == 0 || len(addrs) < 2 {
+		return addrs
+	}
+	// weighted random sampling without replacement (Efraimidis-Spirakis)
+	keys := make(map[netip.Addr]float64, len(addrs))
+	for _, addr := range addrs {
+		weight, ok := weights[addr]
+		if !ok {
+			weight = 1
+		}
+		if weight == 0 {
+			// below the keys of all weighted addresses, which are at least 0
+			keys[addr] = -1
+			continue
+		}
+		keys[addr] = math.Pow(rand.Float64(), 1/float64(weight))
+	}
+	shuffled := slices.Clone(addrs)
+	slices.SortStableFunc(shuffled, func(a, b netip.Addr) int {
+		return cmp.Compare(keys[b], keys[a])
+	})
+	return shuffled
+}
+
+// recordBuilder builds the records of a name from the raw data of their type
+type recordBuilder func(gw *Gateway, name string, ttl uint32, targets []string) []dns.RR
+
+// recordBuilders answer the record types kept as raw data in lookup results,
+// serving another type only takes adding its builder here
+var recordBuilders = map[uint16]recordBuilder{
+	dns.TypeNS:     (*Gateway).NS,
+	dns.TypeMX:     (*Gateway).MX,
+	dns.TypeTXT:    (*Gateway).TXT,
+	dns.TypeSRV:    (*Gateway).SRV,
+	dns.TypeDS:     (*Gateway).DS,
+	dns.TypeDNSKEY: (*Gateway).DNSKEY,
+	dns.TypeCAA:    (*Gateway).CAA,
+}
+
+// answersHostnames reports whether the load balancer hostnames of names in a
+// zone are answered as CNAME records instead of their addresses
+func (gw *Gateway) answersHostnames(zone string) bool {
+	if enabled, ok := gw.zoneCNAMEGatewayHostnames[strings.ToLower(zone)]; ok {
+		return enabled
+	}
+	return gw.cnameGatewayHostnames
+}
+
+// familyOf returns the address families answered in a zone
+func (gw *Gateway) familyOf(zone string) string {
+	if family, ok := gw.zoneFamily[strings.ToLower(zone)]; ok {
+		return family
+	}
+	return gw.family
+}
+
+// familyPreference configures the preferred address family of a zone
+type familyPreference struct {
+	// listed first in ANY answers, empty for no preference
+	family string
+	// hide the addresses of the other family for names with addresses of this one
+	only bool
+}
+
+// preferenceOf returns the preferred address family of a zone
+func (gw *Gateway) preferenceOf(zone string) familyPreference {
+	if preference, ok := gw.zonePreferFamily[strings.ToLower(zone)]; ok {
+		return preference
+	}
+	return familyPreference{family: gw.preferFamily, only: gw.preferFamilyOnly}
+}
+
+// MX builds the MX records from "preference host" formatted targets,
+// malformed targets are skipped
+func (gw *Gateway) MX(name string, ttl uint32, targets []string) (records []dns.RR) {
 	dup := make(map[string]struct{})
-	for _, result := range results {
-		if _, ok := dup[result.String()]; !ok {
-			dup[result.String()] = struct{}{}
-			records = append(records, &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: gw.ttlLow}, A: net.ParseIP(result.String())})
+	for _, target := range targets {
+		fields := strings.Fields(target)
+		if len(fields) != 2 {
+			log.Warningf("skipping malformed MX target %q for %s", target, name)
+			continue
+		}
+		preference, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			log.Warningf("skipping MX target %q for %s with invalid preference: %s", target, name, err)
+			continue
+		}
+		if _, ok := dns.IsDomainName(fields[1]); !ok {
+			log.Warningf("skipping MX target %q for %s with invalid host", target, name)
+			continue
+		}
+		host := dns.Fqdn(fields[1])
+		if _, ok := dup[fields[0]+" "+host]; !ok {
+			dup[fields[0]+" "+host] = struct{}{}
+			records = append(records, &dns.MX{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: ttl}, Preference: uint16(preference), Mx: host})
 		}
 	}
 	return records
 }
 
-func (gw *Gateway) AAAA(name string, results []netip.Addr) (records []dns.RR) {
+// SRV builds the SRV records from "priority weight port target" formatted
+// targets, malformed targets are skipped
+func (gw *Gateway) SRV(name string, ttl uint32, targets []string) (records []dns.RR) {
 	dup := make(map[string]struct{})
-	for _, result := range results {
-		if _, ok := dup[result.String()]; !ok {
-			dup[result.String()] = struct{}{}
-			records = append(records, &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: gw.ttlLow}, AAAA: net.ParseIP(result.String())})
+	for _, target := range targets {
+		fields := strings.Fields(target)
+		if len(fields) != 4 {
+			log.Warningf("skipping malformed SRV target %q for %s", target, name)
+			continue
+		}
+		var values [3]uint16
+		var err error
+		for i, field := range fields[:3] {
+			var value uint64
+			if value, err = strconv.ParseUint(field, 10, 16); err != nil {
+				break
+			}
+			values[i] = uint16(value)
+		}
+		if err != nil {
+			log.Warningf("skipping SRV target %q for %s with invalid priority, weight or port: %s", target, name, err)
+			continue
+		}
+		if _, ok := dns.IsDomainName(fields[3]); !ok {
+			log.Warningf("skipping SRV target %q for %s with invalid host", target, name)
+			continue
+		}
+		host := dns.Fqdn(fields[3])
+		key := strings.Join(append(fields[:3:3], host), " ")
+		if _, ok := dup[key]; !ok {
+			dup[key] = struct{}{}
+			records = append(records, &dns.SRV{
+				Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+				Priority: values[0],
+				Weight:   values[1],
+				Port:     values[2],
+				Target:   host,
+			})
 		}
 	}
 	return records
 }
 
-// SelfAddress returns the address of the local k8s_gateway service
-func (gw *Gateway) SelfAddress(state request.Request) (records []dns.RR) {
+// DS builds the DS records of a signed delegation from targets in the
+// "key-tag algorithm digest-type digest" format, the digest in hex
+func (gw *Gateway) DS(name string, ttl uint32, targets []string) (records []dns.RR) {
+	dup := make(map[string]struct{})
+	for _, target := range targets {
+		fields := strings.Fields(target)
+		if len(fields) < 4 {
+			log.Warningf("skipping malformed DS target %q for %s", target, name)
+			continue
+		}
+		keyTag, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			log.Warningf("skipping DS target %q for %s with invalid key tag: %s", target, name, err)
+			continue
+		}
+		algorithm, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			log.Warningf("skipping DS target %q for %s with invalid algorithm: %s", target, name, err)
+			continue
+		}
+		digestType, err := strconv.ParseUint(fields[2], 10, 8)
+		if err != nil {
+			log.Warningf("skipping DS target %q for %s with invalid digest type: %s", target, name, err)
+			continue
+		}
+		// the digest may be split into several fields like in zone files
+		digest := strings.ToUpper(strings.Join(fields[3:], ""))
+		if _, err := hex.DecodeString(digest); err != nil {
+			log.Warningf("skipping DS target %q for %s with invalid digest: %s", target, name, err)
+			continue
+		}
+		key := strings.Join([]string{fields[0], fields[1], fields[2], digest}, " ")
+		if _, ok := dup[key]; !ok {
+			dup[key] = struct{}{}
+			records = append(records, &dns.DS{
+				Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: ttl},
+				KeyTag:     uint16(keyTag),
+				Algorithm:  uint8(algorithm),
+				DigestType: uint8(digestType),
+				Digest:     digest,
+			})
+		}
+	}
+	return records
+}
 
-	var addrs1, addrs2 []netip.Addr
-	for _, resource := range gw.Resources {
-		results := resource.lookup([]string{gw.apex})
-		if len(results) > 0 {
-			addrs1 = append(addrs1, results...)
+// DNSKEY builds DNSKEY records from targets in the "flags protocol algorithm
+// public-key" format, the public key in base64
+func (gw *Gateway) DNSKEY(name string, ttl uint32, targets []string) (records []dns.RR) {
+	dup := make(map[string]struct{})
+	for _, target := range targets {
+		fields := strings.Fields(target)
+		if len(fields) < 4 {
+			log.Warningf("skipping malformed DNSKEY target %q for %s", target, name)
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			log.Warningf("skipping DNSKEY target %q for %s with invalid flags: %s", target, name, err)
+			continue
+		}
+		// the protocol field must be 3 (RFC 4034, section 2.1.2)
+		if fields[1] != "3" {
+			log.Warningf("skipping DNSKEY target %q for %s with invalid protocol", target, name)
+			continue
+		}
+		algorithm, err := strconv.ParseUint(fields[2], 10, 8)
+		if err != nil {
+			log.Warningf("skipping DNSKEY target %q for %s with invalid algorithm: %s", target, name, err)
+			continue
+		}
+		publicKey := strings.Join(fields[3:], "")
+		if _, err := base64.StdEncoding.DecodeString(publicKey); err != nil {
+			log.Warningf("skipping DNSKEY target %q for %s with invalid public key: %s", target, name, err)
+			continue
+		}
+		key := strings.Join([]string{fields[0], fields[2], publicKey}, " ")
+		if _, ok := dup[key]; !ok {
+			dup[key] = struct{}{}
+			records = append(records, &dns.DNSKEY{
+				Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: ttl},
+				Flags:     uint16(flags),
+				Protocol:  3,
+				Algorithm: uint8(algorithm),
+				PublicKey: publicKey,
+			})
+		}
+	}
+	return records
+}
+
+// CAA builds the certificate issuance policy of a name from targets in the
+// "flags tag value" format, e.g. `0 issue "letsencrypt.org"`
+func (gw *Gateway) CAA(name string, ttl uint32, targets []string) (records []dns.RR) {
+	dup := make(map[string]struct{})
+	for _, target := range targets {
+		fields := strings.Fields(target)
+		if len(fields) < 3 {
+			log.Warningf("skipping malformed CAA target %q for %s", target, name)
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			log.Warningf("skipping CAA target %q for %s with invalid flags: %s", target, name, err)
+			continue
+		}
+		// tags are up to 15 letters and digits (RFC 8659, section 4.1)
+		tag := strings.ToLower(fields[1])
+		if len(tag) > 15 || !isAlphanumeric(tag) {
+			log.Warningf("skipping CAA target %q for %s with invalid tag", target, name)
+			continue
+		}
+		value := strings.Join(fields[2:], " ")
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		key := strings.Join([]string{fields[0], tag, value}, " ")
+		if _, ok := dup[key]; !ok {
+			dup[key] = struct{}{}
+			records = append(records, &dns.CAA{
+				Hdr:   dns.RR_Header{Name: name, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: ttl},
+				Flag:  uint8(flags),
+				Tag:   tag,
+				Value: value,
+			})
+		}
+	}
+	return records
+}
+
+// isAlphanumeric reports whether s is a non-empty string of ASCII letters and digits
+func isAlphanumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// TXT builds one TXT record per target, splitting long values into
+// multiple character-strings
+func (gw *Gateway) TXT(name string, ttl uint32, targets []string) (records []dns.RR) {
+	dup := make(map[string]struct{})
+	for _, target := range targets {
+		if _, ok := dup[target]; !ok {
+			dup[target] = struct{}{}
+			records = append(records, &dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl}, Txt: split255(target)})
+		}
+	}
+	return records
+}
+
+// split255 splits a string into chunks of at most 255 bytes, the maximum
+// length of a single character-string (RFC 1035, section 3.3)
+func split255(s string) []string {
+	chunks := []string{}
+	for len(s) > 255 {
+		chunks = append(chunks, s[:255])
+		s = s[255:]
+	}
+	if len(s) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, s)
+	}
+	return chunks
+}
+
+// NS builds the NS records of a delegated subdomain
+func (gw *Gateway) NS(name string, ttl uint32, targets []string) (records []dns.RR) {
+	dup := make(map[string]struct{})
+	for _, target := range targets {
+		if _, ok := dns.IsDomainName(target); !ok {
+			log.Warningf("skipping malformed NS target %q for %s", target, name)
+			continue
 		}
-		results = resource.lookup([]string{gw.secondNS})
-		if len(results) > 0 {
-			addrs2 = append(addrs2, results...)
+		host := dns.Fqdn(target)
+		if _, ok := dup[host]; !ok {
+			dup[host] = struct{}{}
+			records = append(records, &dns.NS{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl}, Ns: host})
 		}
 	}
+	return records
+}
 
-	records = append(records, gw.A(gw.apex+"."+state.Zone, addrs1)...)
+// CNAME builds the CNAME record of a name that is backed by hostnames, only
+// a single one is allowed so the first hostname in sort order is used. A name
+// published under its own load balancer hostname never points to itself.
+func (gw *Gateway) CNAME(name string, ttl uint32, hostnames []string) (records []dns.RR) {
+	hostnames = slices.DeleteFunc(slices.Clone(hostnames), func(hostname string) bool {
+		return strings.EqualFold(dns.Fqdn(hostname), name)
+	})
+	if len(hostnames) == 0 {
+		return nil
+	}
+	target := slices.Min(hostnames)
+	return []dns.RR{&dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl}, Target: dns.Fqdn(target)}}
+}
+
+// DNAME builds the DNAME record redirecting the names below an owner to a target
+func (gw *Gateway) DNAME(owner string, ttl uint32, target string) dns.RR {
+	return &dns.DNAME{Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeDNAME, Class: dns.ClassINET, Ttl: ttl}, Target: target}
+}
+
+// dnameOwner returns the closest owner of a configured DNAME at or above a name
+func (gw *Gateway) dnameOwner(name string) (string, bool) {
+	if len(gw.dnameTargets) == 0 {
+		return "", false
+	}
+	name = strings.ToLower(name)
+	for i, end := 0, false; !end; i, end = dns.NextLabel(name, i) {
+		if _, ok := gw.dnameTargets[name[i:]]; ok {
+			return name[i:], true
+		}
+	}
+	return "", false
+}
+
+// serveDNAME answers a query for a name below a DNAME owner with the DNAME and
+// the CNAME synthesized from it, which resolvers follow to the target subtree
+func (gw *Gateway) serveDNAME(state request.Request, owner string) (int, error) {
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Authoritative = true
+
+	qname := state.Name()
+	ownerName := qname[len(qname)-len(owner):] // maintain case of original query
+	target := gw.dnameTargets[owner]
+	m.Answer = []dns.RR{gw.DNAME(ownerName, gw.ttlLow, target)}
+
+	synthesized := qname[:len(qname)-len(owner)] + target
+	if _, ok := dns.IsDomainName(synthesized); ok {
+		m.Answer = append(m.Answer, &dns.CNAME{Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: gw.ttlLow}, Target: synthesized})
+	} else {
+		// the substituted name is longer than a domain name may be (RFC 6672, section 2.2)
+		m.Rcode = dns.RcodeYXDomain
+	}
+
+	m = state.Scrub(m)
+	if err := state.W.WriteMsg(m); err != nil {
+		log.Errorf("failed to send a response: %s", err)
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// PTR builds the PTR records pointing at the given hostnames
+func (gw *Gateway) PTR(name string, ttl uint32, hostnames []string) (records []dns.RR) {
+	dup := make(map[string]struct{})
+	for _, hostname := range hostnames {
+		if _, ok := dup[hostname]; !ok {
+			dup[hostname] = struct{}{}
+			records = append(records, &dns.PTR{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}, Ptr: hostname})
+		}
+	}
+	return records
+}
+
+// SelfAddress returns the address of the local k8s_gateway service and,
+// for NS queries, the addresses of all secondary nameservers
+func (gw *Gateway) SelfAddress(state request.Request) (records []dns.RR) {
+	records = append(records, gw.glue(gw.apex, state.Zone)...)
 
 	if state.QType() == dns.TypeNS {
-		records = append(records, gw.A(gw.secondNS+"."+state.Zone, addrs2)...)
+		for _, ns := range gw.secondNS {
+			records = append(records, gw.glue(ns, state.Zone)...)
+		}
 	}
 
 	return records
-	//return records
+}
+
+// glue returns the A and AAAA records of a nameserver living under the zone
+func (gw *Gateway) glue(ns, zone string) []dns.RR {
+	var ipv4Addrs, ipv6Addrs []netip.Addr
+	for _, resource := range gw.Resources {
+		// ExternalAddrFunc isn't given the context of the query
+		for _, addr := range gw.lookup(context.Background(), resource, []string{ns}).addrs {
+			if addr.Is4() {
+				ipv4Addrs = append(ipv4Addrs, addr)
+			} else {
+				ipv6Addrs = append(ipv6Addrs, addr)
+			}
+		}
+	}
+
+	return slices.Concat(gw.A(ns+"."+zone, gw.ttlLow, ipv4Addrs, nil), gw.AAAA(ns+"."+zone, gw.ttlLow, ipv6Addrs, nil))
 }
 
 // Strips the zone from FQDN and return a hostname
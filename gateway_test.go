@@ -1,11 +1,16 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	golog "log"
 	"net/netip"
+	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coredns/coredns/plugin/pkg/fall"
 
@@ -13,11 +18,15 @@ import (
 	"github.com/coredns/coredns/plugin/test"
 
 	"github.com/miekg/dns"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 type FallthroughCase struct {
 	test.Case
 	FallthroughZones    []string
+	FallthroughTypes    []uint16
 	FallthroughExpected bool
 }
 
@@ -26,14 +35,14 @@ type Fallen struct {
 }
 
 func TestLookup(t *testing.T) {
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := syncedController()
 
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
 	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
 	gw.ExternalAddrFunc = gw.SelfAddress
 	gw.Controller = ctrl
-	real := []string{"Ingress", "Service", "HTTPRoute", "TLSRoute", "GRPCRoute", "DNSEndpoint"}
+	real := []string{"Ingress", "Service", "HTTPRoute", "TLSRoute", "GRPCRoute", "DNSEndpoint", "Endpoints", "VirtualService"}
 	fake := []string{"Pod", "Gateway"}
 
 	for _, resource := range real {
@@ -50,7 +59,7 @@ func TestLookup(t *testing.T) {
 }
 
 func TestPlugin(t *testing.T) {
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := syncedController()
 
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
@@ -84,32 +93,1775 @@ func TestPlugin(t *testing.T) {
 	}
 }
 
+func BenchmarkServeDNS(b *testing.B) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = syncedController()
+	setupLookupFuncs(gw)
+
+	ctx := context.TODO()
+	for _, qname := range []string{"domain.example.com.", "svc1.ns1.example.com.", "missing.example.com."} {
+		r := new(dns.Msg)
+		r.SetQuestion(qname, dns.TypeA)
+		b.Run(strings.TrimSuffix(qname, ".example.com."), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				w := &test.ResponseWriter{}
+				if _, err := gw.ServeDNS(ctx, w, r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestPluginFallthrough(t *testing.T) {
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := syncedController()
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, Fallen{})
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = ctrl
+	setupLookupFuncs(gw)
+
+	ctx := context.TODO()
+	for i, tc := range testsFallthrough {
+		r := tc.Msg()
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		gw.Fall = fall.F{Zones: tc.FallthroughZones}
+		gw.fallthroughTypes = tc.FallthroughTypes
+		_, err := gw.ServeDNS(ctx, w, r)
+
+		if errors.As(err, &Fallen{}) && !tc.FallthroughExpected {
+			t.Fatalf("Test %d query resulted unexpectedly in a fall through instead of a response", i)
+		}
+		if err == nil && tc.FallthroughExpected {
+			t.Fatalf("Test %d query resulted unexpectedly in a response instead of a fall through", i)
+		}
+	}
+}
+
+func TestPluginPTR(t *testing.T) {
+	ctrl := syncedController()
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com.", "0.192.in-addr.arpa."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = ctrl
+	setupLookupFuncs(gw)
+
+	ctx := context.TODO()
+	for i, tc := range testsPTR {
+		r := tc.Msg()
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		_, err := gw.ServeDNS(ctx, w, r)
+		if err != tc.Error {
+			t.Errorf("Test %d expected no error, got %v", i, err)
+			return
+		}
+
+		if err = test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d failed with error: %v", i, err)
+		}
+	}
+
+	// once the backing object is gone the PTR stops resolving
+	delete(testServiceReverseIndexes, "192.0.1.1")
+	defer func() { testServiceReverseIndexes["192.0.1.1"] = []string{"svc1.ns1"} }()
+
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(ctx, w, testsPTR[0].Msg()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if w.Msg.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN for deleted object, got %s", dns.RcodeToString[w.Msg.Rcode])
+	}
+}
+
+func TestPluginIndexSummary(t *testing.T) {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &core.Service{}, defaultResyncPeriod, cache.Indexers{})
+	for _, name := range []string{"svc1", "svc2"} {
+		if err := informer.GetIndexer().Add(&core.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1"}}); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+	ctrl := &KubeController{controllers: map[string]cache.SharedIndexInformer{"Service": informer}}
+	ctrl.hasSynced.Store(true)
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = ctrl
+	setupLookupFuncs(gw)
+
+	ctx := context.TODO()
+	tc := test.Case{
+		Qname: "_index.example.com.", Qtype: dns.TypeTXT, Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	}
+
+	// disabled by default
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := test.SortAndCheck(w.Msg, tc); err != nil {
+		t.Errorf("Expected no index summary by default: %v", err)
+	}
+
+	gw.debugIndex = true
+	tc.Rcode = dns.RcodeSuccess
+	tc.Ns = nil
+	tc.Answer = []dns.RR{test.TXT(`_index.example.com.	0	IN	TXT	"Service objects=2 synced=false"`)}
+	w = dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := test.SortAndCheck(w.Msg, tc); err != nil {
+		t.Errorf("Unexpected index summary: %v", err)
+	}
+}
+
+func TestGetQueryIndexKeys(t *testing.T) {
+	tests := []struct {
+		qname, zone string
+		expected    []string
+	}{
+		// the apex is only indexed by its full name
+		{"example.com.", "example.com.", []string{"example.com"}},
+		{"Example.COM.", "example.com.", []string{"example.com"}},
+		{"example.com", "example.com.", []string{"example.com"}},
+		{"example.com.", "example.com", []string{"example.com"}},
+		// one label below the apex
+		{"www.example.com.", "example.com.", []string{"www.example.com", "www"}},
+		{"WWW.example.com", "example.com.", []string{"www.example.com", "www"}},
+		{"svc.ns.example.com.", "example.com.", []string{"svc.ns.example.com", "svc.ns"}},
+		// names relative to the root zone are the names themselves
+		{"www.", ".", []string{"www"}},
+		// names outside the zone aren't shortened
+		{"www.example.org.", "example.com.", []string{"www.example.org"}},
+	}
+
+	gw := newGateway()
+	for i, tc := range tests {
+		if keys := gw.getQueryIndexKeys(tc.qname, tc.zone); !slices.Equal(keys, tc.expected) {
+			t.Errorf("Test %d: Expected index keys %v for %s in %s, got %v", i, tc.expected, tc.qname, tc.zone, keys)
+		}
+	}
+}
+
+func TestIsSubdomain(t *testing.T) {
+	tests := []struct {
+		zone, name string
+		expected   bool
+	}{
+		{"example.com.", "example.com.", true},
+		{"example.com.", "www.example.com.", true},
+		{"example.com.", "a.b.EXAMPLE.com.", true},
+		{"Example.com.", "www.example.COM.", true},
+		{".", "www.example.com.", true},
+		{"example.com.", "wwwexample.com.", false},
+		{"example.com.", "example.org.", false},
+		{"www.example.com.", "example.com.", false},
+		// an escaped dot doesn't separate labels
+		{"example.com.", `www\.example.com.`, false},
+	}
+
+	for i, tc := range tests {
+		if result := isSubdomain(tc.zone, tc.name); result != tc.expected {
+			t.Errorf("Test %d: Expected isSubdomain(%s, %s) to be %t", i, tc.zone, tc.name, tc.expected)
+		}
+		// the same as dns.IsSubDomain, without allocating
+		if result := dns.IsSubDomain(tc.zone, tc.name); result != tc.expected {
+			t.Errorf("Test %d: Expected dns.IsSubDomain(%s, %s) to be %t", i, tc.zone, tc.name, tc.expected)
+		}
+	}
+}
+
+func TestAddressRecords(t *testing.T) {
+	gw := newGateway()
+	addrs := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("192.0.2.1")}
+	expected := []dns.RR{
+		test.A("app.example.com.	60	IN	A	192.0.2.1"),
+		test.A("app.example.com.	60	IN	A	192.0.2.2"),
+	}
+	if records := gw.A("app.example.com.", 60, addrs, nil); !slices.EqualFunc(records, expected, dns.IsDuplicate) {
+		t.Errorf("Expected %v, got %v", expected, records)
+	}
+
+	addrs = []netip.Addr{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::1")}
+	expected = []dns.RR{test.AAAA("app.example.com.	60	IN	AAAA	2001:db8::1")}
+	if records := gw.AAAA("app.example.com.", 60, addrs, nil); !slices.EqualFunc(records, expected, dns.IsDuplicate) {
+		t.Errorf("Expected %v, got %v", expected, records)
+	}
+}
+
+func TestToWildcardQName(t *testing.T) {
+	tests := []struct {
+		qname, zone string
+		expected    string
+	}{
+		{"www.example.com.", "example.com.", "*.example.com."},
+		{"a.b.example.com.", "example.com.", "*.b.example.com."},
+		{"a.b.c.example.com", "example.com.", "*.b.c.example.com."},
+		{"*.example.com.", "example.com.", "*.example.com."},
+		{"www.", ".", "*."},
+		{"www.example.com.", ".", "*.example.com."},
+		// the apex isn't covered by wildcards
+		{"example.com.", "example.com.", ""},
+		{"EXAMPLE.com", "example.com.", ""},
+		// neither are names outside of the zone
+		{"www.example.org.", "example.com.", ""},
+		{"com.", "example.com.", ""},
+	}
+
+	gw := newGateway()
+	for i, tc := range tests {
+		if wildcard := gw.toWildcardQName(tc.qname, tc.zone); wildcard != tc.expected {
+			t.Errorf("Test %d: Expected wildcard %q for %s in %s, got %q", i, tc.expected, tc.qname, tc.zone, wildcard)
+		}
+	}
+}
+
+func TestGetQueryIndexKeySets(t *testing.T) {
+	tests := []struct {
+		qname, zone string
+		expected    [][]string
+	}{
+		{"example.com.", "example.com.", [][]string{{"example.com"}}},
+		{"Example.com", "example.com.", [][]string{{"example.com"}}},
+		{"www.example.com.", "example.com.", [][]string{{"www.example.com", "www"}, {"*.example.com", "*"}}},
+		{"WWW.example.com", "example.com", [][]string{{"www.example.com", "www"}, {"*.example.com", "*"}}},
+		{"svc.ns.example.com.", "example.com.", [][]string{{"svc.ns.example.com", "svc.ns"}, {"*.ns.example.com", "*.ns"}}},
+		{"a.b.c.example.com.", "example.com.", [][]string{{"a.b.c.example.com", "a.b.c"}, {"*.b.c.example.com", "*.b.c"}}},
+		// a wildcard query is its own wildcard
+		{"*.example.com.", "example.com.", [][]string{{"*.example.com", "*"}}},
+		// single label names in the root zone
+		{"www.", ".", [][]string{{"www"}, {"*"}}},
+		// names outside of the zone are only looked up by their full name
+		{"www.example.org.", "example.com.", [][]string{{"www.example.org"}}},
+	}
+
+	gw := newGateway()
+	for i, tc := range tests {
+		keySets := gw.getQueryIndexKeySets(tc.qname, tc.zone)
+		if !slices.EqualFunc(keySets, tc.expected, slices.Equal) {
+			t.Errorf("Test %d: Expected index key sets %v for %s in %s, got %v", i, tc.expected, tc.qname, tc.zone, keySets)
+		}
+	}
+}
+
+func TestGetQueryIndexKeySetsStripSubdomain(t *testing.T) {
+	tests := []struct {
+		qname, zone string
+		expected    [][]string
+	}{
+		{"svc1.ns1.svc.example.com.", "example.com.", [][]string{
+			{"svc1.ns1.svc.example.com", "svc1.ns1.svc"},
+			{"svc1.ns1.example.com", "svc1.ns1"},
+			{"*.ns1.svc.example.com", "*.ns1.svc"},
+			{"*.ns1.example.com", "*.ns1"},
+		}},
+		{"www.SVC.example.com.", "example.com.", [][]string{{"www.svc.example.com", "www.svc"}, {"www.example.com", "www"}, {"*.svc.example.com", "*.svc"}, {"*.example.com", "*"}}},
+		// the subdomain itself and names outside of it aren't stripped
+		{"svc.example.com.", "example.com.", [][]string{{"svc.example.com", "svc"}, {"*.example.com", "*"}}},
+		{"www.example.com.", "example.com.", [][]string{{"www.example.com", "www"}, {"*.example.com", "*"}}},
+		{"www.svc.ns1.example.com.", "example.com.", [][]string{{"www.svc.ns1.example.com", "www.svc.ns1"}, {"*.svc.ns1.example.com", "*.svc.ns1"}}},
+		{"www.svc.", ".", [][]string{{"www.svc"}, {"www"}, {"*.svc"}, {"*"}}},
+	}
+
+	gw := newGateway()
+	gw.stripSubdomain = "svc"
+	for i, tc := range tests {
+		keySets := gw.getQueryIndexKeySets(tc.qname, tc.zone)
+		if !slices.EqualFunc(keySets, tc.expected, slices.Equal) {
+			t.Errorf("Test %d: Expected index key sets %v for %s in %s, got %v", i, tc.expected, tc.qname, tc.zone, keySets)
+		}
+	}
+}
+
+func TestSplit255(t *testing.T) {
+	for _, length := range []int{0, 254, 255, 256, 510, 511} {
+		s := strings.Repeat("a", length)
+		chunks := split255(s)
+
+		expected := max((length+254)/255, 1)
+		if len(chunks) != expected {
+			t.Errorf("Length %d: expected %d chunks, got %d", length, expected, len(chunks))
+		}
+		for i, chunk := range chunks {
+			if len(chunk) > 255 || (len(chunk) == 0 && length > 0) {
+				t.Errorf("Length %d: chunk %d has invalid length %d", length, i, len(chunk))
+			}
+		}
+		if joined := strings.Join(chunks, ""); joined != s {
+			t.Errorf("Length %d: chunks don't reassemble into the original string", length)
+		}
+	}
+}
+
+func TestPluginUnsynced(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, Fallen{})
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = &KubeController{}
+	setupLookupFuncs(gw)
+
+	ctx := context.TODO()
+	r := new(dns.Msg)
+	r.SetQuestion("domain.example.com.", dns.TypeA)
+	r.SetEdns0(4096, false)
+
+	// SERVFAIL by default, with an extended error telling why
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(ctx, w, r); err == nil || errors.As(err, &Fallen{}) {
+		t.Errorf("Expected an error while unsynced, got %v", err)
+	}
+	if w.Msg == nil || w.Msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL while unsynced, got %v", w.Msg)
+	}
+	if ede := extendedError(w.Msg); ede == nil || ede.InfoCode != dns.ExtendedErrorCodeNotReady {
+		t.Errorf("Expected a Not Ready extended error, got %v", ede)
+	}
+
+	// no extended error without EDNS
+	r.Extra = nil
+	w = dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(ctx, w, r); err == nil {
+		t.Errorf("Expected an error while unsynced")
+	}
+	if w.Msg.Rcode != dns.RcodeServerFailure || w.Msg.IsEdns0() != nil {
+		t.Errorf("Expected a plain SERVFAIL without EDNS, got %v", w.Msg)
+	}
+
+	gw.fallthroughUnsynced = true
+	w = dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(ctx, w, r); !errors.As(err, &Fallen{}) {
+		t.Errorf("Expected fallthrough while unsynced, got error %v", err)
+	}
+}
+
+func TestPluginStaticRecords(t *testing.T) {
+	gw := newTestGateway()
+	gw.staticRecords = map[string][]netip.Addr{
+		"vanity.example.com":   {netip.MustParseAddr("203.0.113.1"), netip.MustParseAddr("2001:db8::1")},
+		"svc1.ns1.example.com": {netip.MustParseAddr("203.0.113.2")},
+	}
+	setupLookupFuncs(gw)
+
+	soa := test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")
+	serve := func(tests []test.Case) {
+		t.Helper()
+		for i, tc := range tests {
+			w := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+				t.Fatalf("Test %d: Expected no error, got %v", i, err)
+			}
+			if err := test.SortAndCheck(w.Msg, tc); err != nil {
+				t.Errorf("Test %d: %v", i, err)
+			}
+		}
+	}
+
+	serve([]test.Case{
+		{
+			Qname: "vanity.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("vanity.example.com.	60	IN	A	203.0.113.1")},
+		},
+		{
+			Qname: "Vanity.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("vanity.example.com.	60	IN	AAAA	2001:db8::1")},
+		},
+		// dynamic resources take precedence
+		{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc1.ns1.example.com.	60	IN	A	192.0.1.1")},
+		},
+		{
+			Qname: "other.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{soa},
+		},
+	})
+
+	// only static records are answered before the resources are synced
+	gw.Controller = &KubeController{}
+	serve([]test.Case{
+		{
+			Qname: "vanity.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("vanity.example.com.	60	IN	A	203.0.113.1")},
+		},
+		{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc1.ns1.example.com.	60	IN	A	203.0.113.2")},
+		},
+	})
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(context.TODO(), w, new(dns.Msg).SetQuestion("domain.example.com.", dns.TypeA)); err == nil || w.Msg.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL for names without static records while unsynced, got %v", w.Msg)
+	}
+}
+
+func TestPluginStripSubdomain(t *testing.T) {
+	gw := newTestGateway()
+	gw.stripSubdomain = "svc"
+
+	// a name published below the subdomain itself isn't stripped
+	ingress := gw.lookupResource("Ingress")
+	lookup := ingress.lookup
+	defer func() { ingress.lookup = lookup }()
+	ingress.lookup = func(_ context.Context, indexKeys []string) lookupResult {
+		if slices.Contains(indexKeys, "svc2.ns1.svc.example.com") {
+			return lookupResult{addrs: []netip.Addr{netip.MustParseAddr("192.0.0.20")}}
+		}
+		return lookup(context.TODO(), indexKeys)
+	}
+
+	tests := []test.Case{
+		{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc1.ns1.example.com.	60	IN	A	192.0.1.1")},
+		},
+		{
+			Qname: "svc1.ns1.svc.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc1.ns1.svc.example.com.	60	IN	A	192.0.1.1")},
+		},
+		{
+			Qname: "svc2.ns1.svc.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc2.ns1.svc.example.com.	60	IN	A	192.0.0.20")},
+		},
+		{
+			Qname: "svcX.ns1.svc.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")},
+		},
+	}
+
+	ctx := context.TODO()
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestPluginDNAME(t *testing.T) {
+	gw := newTestGateway()
+	gw.dnameTargets = map[string]string{"old.example.com.": "new.example.com."}
+
+	dname := test.DNAME("old.example.com.	60	IN	DNAME	new.example.com.")
+	tests := []test.Case{
+		{
+			Qname: "old.example.com.", Qtype: dns.TypeDNAME,
+			Answer: []dns.RR{dname},
+		},
+		// the owner itself isn't redirected
+		{
+			Qname: "old.example.com.", Qtype: dns.TypeA,
+			Ns: []dns.RR{test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")},
+		},
+		{
+			Qname: "www.old.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{dname, test.CNAME("www.old.example.com.	60	IN	CNAME	www.new.example.com.")},
+		},
+		{
+			Qname: "x.y.old.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{dname, test.CNAME("x.y.old.example.com.	60	IN	CNAME	x.y.new.example.com.")},
+		},
+		{
+			Qname: "www.old.example.com.", Qtype: dns.TypeDNAME,
+			Answer: []dns.RR{dname, test.CNAME("www.old.example.com.	60	IN	CNAME	www.new.example.com.")},
+		},
+	}
+
+	ctx := context.TODO()
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+
+	// the substituted name can't exceed the maximum name length
+	gw.dnameTargets["old.example.com."] = strings.Repeat("x", 63) + "." + strings.Repeat("y", 63) + "." + strings.Repeat("z", 63) + ".example.com."
+	r := new(dns.Msg)
+	r.SetQuestion(strings.Repeat("a", 63)+".old.example.com.", dns.TypeA)
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(ctx, w, r); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if w.Msg.Rcode != dns.RcodeYXDomain || len(w.Msg.Answer) != 1 {
+		t.Errorf("Expected YXDOMAIN with only the DNAME, got %v", w.Msg)
+	}
+}
+
+func TestPluginAny(t *testing.T) {
+	gw := newTestGateway()
+
+	soa := test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")
+	for _, tc := range []struct {
+		minimalAny bool
+		test.Case
+	}{
+		{false, test.Case{
+			Qname: "mixed.endpoint.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{
+				test.A("mixed.endpoint.example.com.	60	IN	A	192.0.4.5"),
+				test.AAAA("mixed.endpoint.example.com.	60	IN	AAAA	fd12:3456:789a:4::5"),
+				test.TXT(`mixed.endpoint.example.com.	60	IN	TXT	"v=spf1 -all"`),
+			},
+		}},
+		{false, test.Case{
+			Qname: "svcX.ns1.example.com.", Qtype: dns.TypeANY, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{soa},
+		}},
+		{true, test.Case{
+			Qname: "mixed.endpoint.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{test.HINFO("mixed.endpoint.example.com.	60	IN	HINFO	RFC8482 \"\"")},
+		}},
+		{true, test.Case{
+			Qname: "svcX.ns1.example.com.", Qtype: dns.TypeANY, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{soa},
+		}},
+	} {
+		gw.minimalAny = tc.minimalAny
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc.Case); err != nil {
+			t.Errorf("%s (minimalAny %t): %v", tc.Qname, tc.minimalAny, err)
+		}
+	}
+}
+
+func TestPluginTruncation(t *testing.T) {
+	var addrs []netip.Addr
+	for i := range 100 {
+		addrs = append(addrs, netip.AddrFrom4([4]byte{192, 0, 2, byte(i)}))
+	}
+	gw := newTestGateway()
+	gw.Resources = []*resourceWithIndex{{
+		name:    "Service",
+		lookup:  func(context.Context, []string) lookupResult { return lookupResult{addrs: addrs} },
+		reverse: noopReverse,
+	}}
+
+	for _, tc := range []struct {
+		tcp       bool
+		bufsize   uint16
+		truncated bool
+	}{
+		{false, 0, true},
+		{false, 1232, true},
+		{false, 4096, false},
+		{true, 0, false},
+	} {
+		r := new(dns.Msg)
+		r.SetQuestion("large.example.com.", dns.TypeA)
+		if tc.bufsize > 0 {
+			r.SetEdns0(tc.bufsize, false)
+		}
+		w := dnstest.NewRecorder(&test.ResponseWriter{TCP: tc.tcp})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if w.Msg.Truncated != tc.truncated {
+			t.Errorf("TCP %t, buffer %d: expected truncated %t, got %t", tc.tcp, tc.bufsize, tc.truncated, w.Msg.Truncated)
+		}
+		if tc.truncated && (len(w.Msg.Answer) >= len(addrs) || w.Msg.Len() > int(max(tc.bufsize, dns.MinMsgSize))) {
+			t.Errorf("TCP %t, buffer %d: expected the answer to fit, got %d records in %d bytes", tc.tcp, tc.bufsize, len(w.Msg.Answer), w.Msg.Len())
+		}
+		if !tc.truncated && len(w.Msg.Answer) != len(addrs) {
+			t.Errorf("TCP %t, buffer %d: expected all %d records, got %d", tc.tcp, tc.bufsize, len(addrs), len(w.Msg.Answer))
+		}
+	}
+}
+
+func TestPluginTrace(t *testing.T) {
+	var buf bytes.Buffer
+	golog.SetOutput(&buf)
+	defer golog.SetOutput(os.Stderr)
+
+	gw := newTestGateway()
+	gw.traceNames = []string{"svc1.ns1.example.com."}
+
+	ctx := context.TODO()
+	for _, qname := range []string{"svc2.ns1.example.com.", "SVC1.ns1.example.com."} {
+		r := new(dns.Msg)
+		r.SetQuestion(qname, dns.TypeA)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, r); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	output := buf.String()
+	if strings.Contains(output, "svc2.ns1") {
+		t.Errorf("Expected no trace for svc2.ns1.example.com., got %s", output)
+	}
+	if !strings.Contains(output, "query SVC1.ns1.example.com. A computed index key sets") {
+		t.Errorf("Expected a trace of the query for svc1.ns1.example.com., got %s", output)
+	}
+	if !strings.Contains(output, "resource Service matched index keys") {
+		t.Errorf("Expected a trace of the matching resource, got %s", output)
+	}
+}
+
+func TestPluginDeleteGrace(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.deleteGracePeriod = time.Minute
+	gw.deleteGraceTTL = 5
+	ctrl := &KubeController{gateway: gw}
+	ctrl.hasSynced.Store(true)
+	gw.Controller = ctrl
+	setupLookupFuncs(gw)
+
+	// the name is still backed by another object after the deletion
+	deleted := &core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc1", Namespace: "ns1"},
+		Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+	}
+	ctrl.deletionHandler(serviceHostnameIndexFunc(gw.resourceFilters)).OnDelete(cache.DeletedFinalStateUnknown{Obj: deleted})
+
+	ctx := context.TODO()
+	tests := []test.Case{
+		{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc1.ns1.example.com.   5  IN  A   192.0.1.1")},
+		},
+		{
+			Qname: "svc2.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc2.ns1.example.com.   60  IN  A   192.0.0.2")},
+		},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+
+	// back to the regular TTL after the grace period
+	ctrl.deleted["svc1.ns1"] = time.Now().Add(-2 * time.Minute)
+	tc := test.Case{
+		Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA,
+		Answer: []dns.RR{test.A("svc1.ns1.example.com.   60  IN  A   192.0.1.1")},
+	}
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := test.SortAndCheck(w.Msg, tc); err != nil {
+		t.Errorf("Unexpected answer after the grace period: %v", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com.", "example.org."}
+	gw.updateResources([]string{"Ingress", "HTTPRoute"})
+	gw.resourceFilters.ingressClasses = []string{"nginx"}
+	gw.resourceFilters.gatewayClasses = []string{"istio"}
+
+	status := gw.Status()
+	if !slices.Equal(status.Zones, gw.Zones) {
+		t.Errorf("Expected zones %v, got %v", gw.Zones, status.Zones)
+	}
+	if expected := []string{"Ingress", "HTTPRoute"}; !slices.Equal(status.Resources, expected) {
+		t.Errorf("Expected resources %v, got %v", expected, status.Resources)
+	}
+	if !slices.Equal(status.IngressClasses, []string{"nginx"}) || !slices.Equal(status.GatewayClasses, []string{"istio"}) {
+		t.Errorf("Unexpected class filters %v and %v", status.IngressClasses, status.GatewayClasses)
+	}
+	if !slices.Equal(status.ServiceTypes, defaultServiceTypes) {
+		t.Errorf("Expected service types %v, got %v", defaultServiceTypes, status.ServiceTypes)
+	}
+	if status.Synced {
+		t.Errorf("Expected a Gateway without controller not to be synced")
+	}
+
+	gw.Controller = &KubeController{inactiveResources: []string{"HTTPRoute"}}
+	gw.Controller.hasSynced.Store(true)
+	status = gw.Status()
+	if !status.Synced || !slices.Equal(status.InactiveResources, []string{"HTTPRoute"}) {
+		t.Errorf("Expected a synced status with inactive HTTPRoute, got %+v", status)
+	}
+
+	// the status is a copy
+	status.Zones[0] = "changed."
+	if gw.Zones[0] != "example.com." {
+		t.Errorf("Expected the status not to share the zones of the Gateway")
+	}
+}
+
+func TestPluginApexIPv6(t *testing.T) {
+	gw := newTestGateway()
+	gw.Zones = []string{"example.net."}
+
+	soa := test.SOA("example.net.  60  IN  SOA dns1.kube-system.example.net. hostmaster.example.net. 1499347823 7200 1800 86400 5")
+	tests := []test.Case{
+		// Ingress FQDN == zone with an IPv6 address only
+		{
+			Qname: "example.net.", Qtype: dns.TypeAAAA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.AAAA("example.net.    60  IN  AAAA    fd12:3456:789a:3::")},
+		},
+		{
+			Qname: "example.net.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Ns: []dns.RR{soa},
+		},
+	}
+
+	ctx := context.TODO()
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestPluginZoneResources(t *testing.T) {
+	gw := newTestGateway()
+	gw.Zones = []string{"example.com.", "example.org."}
+	gw.zoneResources = map[string][]*resourceWithIndex{
+		"example.com.": {gw.lookupResource("Ingress")},
+	}
+
+	tests := []test.Case{
+		// Ingress only in example.com
+		{
+			Qname: "domain.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("domain.example.com.    60  IN  A   192.0.0.1")},
+		},
+		{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{
+				test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+			},
+		},
+		// all resources in example.org
+		{
+			Qname: "svc1.ns1.example.org.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("svc1.ns1.example.org.  60  IN  A   192.0.1.1")},
+		},
+	}
+
+	ctx := context.TODO()
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestPluginZoneServiceClusterIPs(t *testing.T) {
+	filters := newGateway().resourceFilters
+	filters.serviceTypes = []string{"LoadBalancer", "ClusterIP"}
+	internal := filters
+	internal.serviceClusterIPs = true
+
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	svc := &core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "ns1"},
+		Spec: core.ServiceSpec{
+			Type:       core.ServiceTypeLoadBalancer,
+			ClusterIP:  "10.96.0.40",
+			ClusterIPs: []string{"10.96.0.40"},
+		},
+		Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+			Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.40"}},
+		}},
+	}
+	if err := ctrl.GetIndexer().Add(svc); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: lookupServiceIndex(ctrl, nil, nil, filters), reverse: noopReverse})
+	gw.Zones = []string{"example.com.", "internal.example.com."}
+	gw.resourceFilters = filters
+	gw.zoneFilters = map[string]*ResourceFilters{"internal.example.com.": &internal}
+
+	tests := []test.Case{
+		// the public zone answers with the load balancer address
+		{
+			Qname: "app.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("app.ns1.example.com.	60	IN	A	192.0.2.40")},
+		},
+		// the internal one with the cluster IP
+		{
+			Qname: "app.ns1.internal.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("app.ns1.internal.example.com.	60	IN	A	10.96.0.40")},
+		},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestPluginFilteredExtendedError(t *testing.T) {
+	gw := newTestGateway()
+	setupEmptyLookupFuncs(gw)
+	ingress := gw.lookupResource("Ingress")
+	lookup := ingress.lookup
+	defer func() { ingress.lookup = lookup }()
+	ingress.lookup = func(_ context.Context, indexKeys []string) lookupResult {
+		return lookupResult{filtered: slices.Contains(indexKeys, "filtered.example.com")}
+	}
+
+	ctx := context.TODO()
+	for qname, expected := range map[string]bool{"filtered.example.com.": true, "missing.example.com.": false} {
+		r := new(dns.Msg)
+		r.SetQuestion(qname, dns.TypeA)
+		r.SetEdns0(4096, false)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(ctx, w, r); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if w.Msg.Rcode != dns.RcodeNameError {
+			t.Errorf("Expected NXDOMAIN for %s, got %s", qname, dns.RcodeToString[w.Msg.Rcode])
+		}
+		ede := extendedError(w.Msg)
+		if found := ede != nil && ede.InfoCode == dns.ExtendedErrorCodeFiltered; found != expected {
+			t.Errorf("Expected a Filtered extended error for %s: %t, got %v", qname, expected, ede)
+		}
+	}
+}
+
+func TestPluginZoneFallthrough(t *testing.T) {
+	gw := newTestGateway()
+	gw.Zones = []string{"example.com.", "internal.example.org."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, Fallen{})
+	gw.Fall = fall.F{Zones: []string{"."}}
+	gw.zoneFallthrough = map[string]zoneFall{"internal.example.org.": {off: true}}
+
+	ctx := context.TODO()
+	for qname, expected := range map[string]bool{"missing.example.com.": true, "missing.internal.example.org.": false} {
+		r := new(dns.Msg)
+		r.SetQuestion(qname, dns.TypeA)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := gw.ServeDNS(ctx, w, r)
+		if fallen := errors.As(err, &Fallen{}); fallen != expected {
+			t.Errorf("Expected %s to fall through: %t, got error %v", qname, expected, err)
+		}
+		if !expected && (w.Msg == nil || w.Msg.Rcode != dns.RcodeNameError) {
+			t.Errorf("Expected NXDOMAIN for %s, got %v", qname, w.Msg)
+		}
+	}
+}
+
+func TestPluginNegativeTTL(t *testing.T) {
+	gw := newTestGateway()
+	gw.soaMinTTL = 10
+	setupEmptyLookupFuncs(gw)
+
+	r := new(dns.Msg)
+	r.SetQuestion("missing.example.com.", dns.TypeA)
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if w.Msg.Rcode != dns.RcodeNameError {
+		t.Fatalf("Expected NXDOMAIN, got %s", dns.RcodeToString[w.Msg.Rcode])
+	}
+	if len(w.Msg.Ns) != 1 {
+		t.Fatalf("Expected a single SOA in the authority section, got %v", w.Msg.Ns)
+	}
+	soa, ok := w.Msg.Ns[0].(*dns.SOA)
+	if !ok {
+		t.Fatalf("Expected a SOA in the authority section, got %s", w.Msg.Ns[0])
+	}
+	if soa.Minttl != 10 {
+		t.Errorf("Expected SOA minimum 10, got %d", soa.Minttl)
+	}
+	if soa.Hdr.Ttl != ttlSOA {
+		t.Errorf("Expected SOA TTL %d, got %d", ttlSOA, soa.Hdr.Ttl)
+	}
+}
+
+func TestPluginRefuseFiltered(t *testing.T) {
+	ctx := context.TODO()
+	for _, refuse := range []bool{false, true} {
+		gw := newTestGateway()
+		gw.refuseFiltered = refuse
+		gw.Resources = []*resourceWithIndex{{
+			name: "Ingress",
+			lookup: func(_ context.Context, indexKeys []string) lookupResult {
+				return lookupResult{filtered: slices.Contains(indexKeys, "filtered.example.com")}
+			},
+			reverse: noopReverse,
+		}}
+
+		filteredRcode := dns.RcodeNameError
+		if refuse {
+			filteredRcode = dns.RcodeRefused
+		}
+		for qname, expected := range map[string]int{"filtered.example.com.": filteredRcode, "missing.example.com.": dns.RcodeNameError} {
+			r := new(dns.Msg)
+			r.SetQuestion(qname, dns.TypeA)
+			w := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := gw.ServeDNS(ctx, w, r); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if w.Msg.Rcode != expected {
+				t.Errorf("refuseFiltered %t: expected %s for %s, got %s", refuse, dns.RcodeToString[expected], qname, dns.RcodeToString[w.Msg.Rcode])
+			}
+			if hasSOA := len(w.Msg.Ns) > 0; hasSOA != (expected == dns.RcodeNameError) {
+				t.Errorf("refuseFiltered %t: unexpected authority section for %s: %v", refuse, qname, w.Msg.Ns)
+			}
+		}
+	}
+}
+
+func extendedError(m *dns.Msg) *dns.EDNS0_EDE {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, option := range opt.Option {
+		if ede, ok := option.(*dns.EDNS0_EDE); ok {
+			return ede
+		}
+	}
+	return nil
+}
+
+func TestPluginRequireAnnotation(t *testing.T) {
+	filters := newGateway().resourceFilters
+	filters.requireHostnameAnnotation = true
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	for _, svc := range []*core.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "annotated", Namespace: "ns1", Annotations: map[string]string{hostnameAnnotationKey: "app.example.com"}},
+			Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+			Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.30"}},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "ns1"},
+			Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+			Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.31"}},
+			}},
+		},
+	} {
+		if err := ctrl.GetIndexer().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: lookupServiceIndex(ctrl, nil, nil, filters), reverse: noopReverse})
+
+	tests := []test.Case{
+		{
+			Qname:  "app.example.com.",
+			Qtype:  dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("app.example.com.	60	IN	A	192.0.2.30")},
+		},
+		// neither Service is published as name.namespace
+		{
+			Qname: "plain.ns1.example.com.",
+			Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")},
+		},
+		{
+			Qname: "annotated.ns1.example.com.",
+			Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")},
+		},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestPluginTXTAnnotation(t *testing.T) {
+	filters := newGateway().resourceFilters
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	if err := ctrl.GetIndexer().Add(&core.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "verified",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				hostnameAnnotationKey: "verified.example.com",
+				txtAnnotationKey:      "google-site-verification=abc123,\nMS=ms12345678\n",
+			},
+		},
+		Spec: core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+		Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+			Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.20"}},
+		}},
+	}); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: lookupServiceIndex(ctrl, nil, nil, filters), reverse: noopReverse})
+
+	tc := test.Case{
+		Qname: "verified.example.com.",
+		Qtype: dns.TypeTXT,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.TXT(`verified.example.com.	60	IN	TXT	"MS=ms12345678"`),
+			test.TXT(`verified.example.com.	60	IN	TXT	"google-site-verification=abc123"`),
+		},
+	}
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := test.SortAndCheck(w.Msg, tc); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPluginIDN(t *testing.T) {
+	filters := newGateway().resourceFilters
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	for name, hostname := range map[string]string{"unicode": "Bücher.example.com", "punycode": "xn--mnchen-3ya.example.com"} {
+		svc := &core.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", Annotations: map[string]string{hostnameAnnotationKey: hostname}},
+			Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+			Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: map[string]string{"unicode": "192.0.2.10", "punycode": "192.0.2.11"}[name]}},
+			}},
+		}
+		if err := ctrl.GetIndexer().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: lookupServiceIndex(ctrl, nil, nil, filters), reverse: noopReverse})
+
+	// names match in their Unicode, punycode and escaped wire forms
+	tests := []struct {
+		qname string
+		addr  string
+	}{
+		{"bücher.example.com.", "192.0.2.10"},
+		{"xn--bcher-kva.example.com.", "192.0.2.10"},
+		{"XN--BCHER-KVA.example.com.", "192.0.2.10"},
+		{`b\195\188cher.example.com.`, "192.0.2.10"},
+		{"münchen.example.com.", "192.0.2.11"},
+		{"xn--mnchen-3ya.example.com.", "192.0.2.11"},
+	}
+	for i, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tc.qname, dns.TypeA)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if len(w.Msg.Answer) != 1 {
+			t.Errorf("Test %d: Expected one answer for %s, got %v", i, tc.qname, w.Msg.Answer)
+			continue
+		}
+		if a, ok := w.Msg.Answer[0].(*dns.A); !ok || a.A.String() != tc.addr {
+			t.Errorf("Test %d: Expected %s for %s, got %v", i, tc.addr, tc.qname, w.Msg.Answer[0])
+		}
+	}
+}
+
+func TestPluginRecordTypePrecedence(t *testing.T) {
+	ingressLookup := func(_ context.Context, keys []string) (result lookupResult) {
+		if slices.Contains(keys, "shared.example.com") {
+			result.addrs = []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+		}
+		return
+	}
+	dnsEndpointLookup := func(_ context.Context, keys []string) (result lookupResult) {
+		if slices.Contains(keys, "shared.example.com") {
+			result.addRecords("TXT", "v=spf1 -all")
+		}
+		return
+	}
+
+	gw := newTestGateway()
+
+	tests := []test.Case{
+		{
+			Qname: "shared.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("shared.example.com.	60	IN	A	192.0.2.1")},
+		},
+		{
+			Qname: "shared.example.com.", Qtype: dns.TypeTXT, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.TXT(`shared.example.com.	60	IN	TXT	"v=spf1 -all"`)},
+		},
+		// a type neither resource has is NODATA
+		{
+			Qname: "shared.example.com.", Qtype: dns.TypeMX, Rcode: dns.RcodeSuccess,
+			Ns: []dns.RR{test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")},
+		},
+	}
+
+	// the records of the queried type are found regardless of the resource order
+	for _, resources := range [][]*resourceWithIndex{
+		{{name: "Ingress", lookup: ingressLookup, reverse: noopReverse}, {name: "DNSEndpoint", lookup: dnsEndpointLookup, reverse: noopReverse}},
+		{{name: "DNSEndpoint", lookup: dnsEndpointLookup, reverse: noopReverse}, {name: "Ingress", lookup: ingressLookup, reverse: noopReverse}},
+	} {
+		gw.Resources = resources
+		for i, tc := range tests {
+			r := tc.Msg()
+			w := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+				t.Fatalf("Test %d: Expected no error, got %v", i, err)
+			}
+			if err := test.SortAndCheck(w.Msg, tc); err != nil {
+				t.Errorf("Test %d with %s first: %v", i, resources[0].name, err)
+			}
+		}
+	}
+}
+
+func TestPluginResourcePrecedence(t *testing.T) {
+	gw := newTestGateway()
+	gw.resourcePrecedence = []string{"Service", "Ingress"}
+	gw.updateResources([]string{"Ingress", "Service"})
+	setupLookupFuncs(gw)
+
+	if names := []string{gw.Resources[0].name, gw.Resources[1].name}; !slices.Equal(names, gw.resourcePrecedence) {
+		t.Fatalf("Expected resources in the order %v, got %v", gw.resourcePrecedence, names)
+	}
+
+	// svc2.ns1 is backed by both an Ingress and a Service
+	tc := test.Case{
+		Qname: "svc2.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{test.A("svc2.ns1.example.com.	60	IN	A	192.0.1.2")},
+	}
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := test.SortAndCheck(w.Msg, tc); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPluginNameFilters(t *testing.T) {
+	soa := test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")
+	tests := []struct {
+		allow, deny []string
+		test.Case
+	}{
+		// a denied Service name is NXDOMAIN
+		{nil, []string{"*.ns1.example.com"}, test.Case{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{soa},
+		}},
+		{nil, []string{"*.ns1.example.com"}, test.Case{
+			Qname: "domain.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("domain.example.com.	60	IN	A	192.0.0.1")},
+		}},
+		// denied names aren't published as PTR targets either
+		{nil, []string{"*.ns1.example.com"}, test.Case{
+			Qname: "1.1.0.192.in-addr.arpa.", Qtype: dns.TypePTR, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{test.SOA("0.192.in-addr.arpa.	60	IN	SOA	dns1.kube-system.0.192.in-addr.arpa. hostmaster.0.192.in-addr.arpa. 1499347823 7200 1800 86400 5")},
+		}},
+		// only allowed names are published
+		{[]string{"domain.example.com"}, nil, test.Case{
+			Qname: "domain.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("domain.example.com.	60	IN	A	192.0.0.1")},
+		}},
+		{[]string{"domain.example.com"}, nil, test.Case{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{soa},
+		}},
+		// denied names take precedence over allowed ones
+		{[]string{"*.example.com"}, []string{"svc1.*"}, test.Case{
+			Qname: "SVC1.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{soa},
+		}},
+		{[]string{"*.example.com"}, []string{"svc1.*"}, test.Case{
+			Qname: "svc2.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("svc2.ns1.example.com.	60	IN	A	192.0.0.2")},
+		}},
+	}
+
+	for i, tc := range tests {
+		gw := newTestGateway()
+		gw.Zones = []string{"example.com.", "0.192.in-addr.arpa."}
+		gw.allowNames = tc.allow
+		gw.denyNames = tc.deny
+
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc.Case); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestPluginZoneAlias(t *testing.T) {
+	aliasSOA := test.SOA("internal.example.com.	60	IN	SOA	dns1.kube-system.internal.example.com. hostmaster.internal.example.com. 1499347823 7200 1800 86400 5")
+	tests := []struct {
+		deny []string
+		test.Case
+	}{
+		// the same objects back names in the zone and its alias
+		{nil, test.Case{
+			Qname: "domain.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("domain.example.com.	60	IN	A	192.0.0.1")},
+		}},
+		{nil, test.Case{
+			Qname: "domain.internal.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("domain.internal.example.com.	60	IN	A	192.0.0.1")},
+		}},
+		{nil, test.Case{
+			Qname: "svc2.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("svc2.ns1.example.com.	60	IN	A	192.0.0.2")},
+		}},
+		{nil, test.Case{
+			Qname: "SVC2.ns1.Internal.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("svc2.ns1.internal.example.com.	60	IN	A	192.0.0.2")},
+		}},
+		// the alias is a zone of its own
+		{nil, test.Case{
+			Qname: "internal.example.com.", Qtype: dns.TypeSOA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{aliasSOA},
+		}},
+		{nil, test.Case{
+			Qname: "missing.internal.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{aliasSOA},
+		}},
+		// names can be filtered in the alias only
+		{[]string{"*.internal.example.com"}, test.Case{
+			Qname: "domain.internal.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{aliasSOA},
+		}},
+		{[]string{"*.internal.example.com"}, test.Case{
+			Qname: "domain.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("domain.example.com.	60	IN	A	192.0.0.1")},
+		}},
+	}
+
+	for i, tc := range tests {
+		gw := newTestGateway()
+		gw.Zones = []string{"example.com.", "internal.example.com."}
+		gw.zoneAliases = map[string]string{"internal.example.com.": "example.com."}
+		gw.denyNames = tc.deny
+
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc.Case); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestPluginHTTPS(t *testing.T) {
+	lookup := func(_ context.Context, keys []string) (result lookupResult) {
+		switch {
+		case slices.Contains(keys, "web.example.com"):
+			result.addrs = []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("192.0.2.1")}
+			result.alpn = []string{"h2", "http/1.1"}
+		case slices.Contains(keys, "plain.example.com"):
+			result.addrs = []netip.Addr{netip.MustParseAddr("192.0.2.2")}
+		case slices.Contains(keys, "txt.example.com"):
+			result.addRecords("TXT", "v=spf1 -all")
+		}
+		return
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "HTTPRoute", lookup: lookup, reverse: noopReverse})
+
+	query := func(qname string) *dns.Msg {
+		t.Helper()
+		r := new(dns.Msg)
+		r.SetQuestion(qname, dns.TypeHTTPS)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return w.Msg
+	}
+
+	tests := []struct {
+		qname    string
+		expected string
+	}{
+		{"web.example.com.", `web.example.com.	60	IN	HTTPS	1 . alpn="h2,http/1.1" ipv4hint="192.0.2.1" ipv6hint="2001:db8::1"`},
+		{"plain.example.com.", `plain.example.com.	60	IN	HTTPS	1 . ipv4hint="192.0.2.2"`},
+	}
+	for _, tc := range tests {
+		m := query(tc.qname)
+		if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+			t.Errorf("Expected a single HTTPS answer for %s, got %v", tc.qname, m)
+			continue
+		}
+		if answer := m.Answer[0].String(); answer != tc.expected {
+			t.Errorf("Expected %s, got %s", tc.expected, answer)
+		}
+	}
+
+	// names without addresses have no HTTPS records
+	if m := query("txt.example.com."); m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 {
+		t.Errorf("Expected NODATA for a name without addresses, got %v", m)
+	}
+	if m := query("missing.example.com."); m.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN for a missing name, got %v", m)
+	}
+}
+
+func TestPluginClientRegion(t *testing.T) {
+	eu1, us, eu2 := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("192.0.2.3")
+	serviceLookup := func(_ context.Context, keys []string) (result lookupResult) {
+		switch {
+		case slices.Contains(keys, "app.example.com"):
+			result.addrs = []netip.Addr{eu1, us, eu2}
+		case slices.Contains(keys, "eu.example.com"):
+			result.addrs = []netip.Addr{eu1, eu2}
+		}
+		result.regions = map[netip.Addr]string{eu1: "eu-west", us: "us-east", eu2: "eu-west"}
+		return
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: serviceLookup, reverse: noopReverse})
+	gw.clientRegions = []clientRegion{
+		{prefix: netip.MustParsePrefix("10.0.0.0/8"), region: "eu-west"},
+		{prefix: netip.MustParsePrefix("10.2.0.0/16"), region: "us-east"},
+	}
+
+	tests := []struct {
+		qname    string
+		subnet   string
+		expected []netip.Addr
+		// of the client subnet in the answer, -1 without one
+		scope int
+	}{
+		// without a client subnet the order is unchanged
+		{"app.example.com.", "", []netip.Addr{eu1, us, eu2}, -1},
+		// the most specific client subnet decides the region
+		{"app.example.com.", "10.2.3.0/24", []netip.Addr{us, eu1, eu2}, 24},
+		{"app.example.com.", "10.1.3.0/24", []netip.Addr{eu1, eu2, us}, 24},
+		// clients in unknown subnets or opting out get the usual order
+		{"app.example.com.", "172.16.0.0/24", []netip.Addr{eu1, us, eu2}, -1},
+		{"app.example.com.", "0.0.0.0/0", []netip.Addr{eu1, us, eu2}, -1},
+		// addresses all in one region are answered alike for every client
+		{"eu.example.com.", "10.1.3.0/24", []netip.Addr{eu1, eu2}, 0},
+		{"eu.example.com.", "10.2.3.0/24", []netip.Addr{eu1, eu2}, 0},
+	}
+
+	for i, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tc.qname, dns.TypeA)
+		r.SetEdns0(4096, false)
+		if tc.subnet != "" {
+			prefix := netip.MustParsePrefix(tc.subnet)
+			r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: uint8(prefix.Bits()),
+				Address:       prefix.Addr().AsSlice(),
+			})
+		}
+
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		var addrs []netip.Addr
+		for _, rr := range w.Msg.Answer {
+			addr, _ := netip.AddrFromSlice(rr.(*dns.A).A.To4())
+			addrs = append(addrs, addr)
+		}
+		if !slices.Equal(addrs, tc.expected) {
+			t.Errorf("Test %d: Expected %v for client subnet %q, got %v", i, tc.expected, tc.subnet, addrs)
+		}
+
+		// tailored answers are scoped to the client subnet, others to all clients
+		scope := -1
+		if opt := w.Msg.IsEdns0(); opt != nil {
+			for _, option := range opt.Option {
+				if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+					scope = int(ecs.SourceScope)
+				}
+			}
+		}
+		if scope != tc.scope {
+			t.Errorf("Test %d: Expected a client subnet scope of %d, got %d", i, tc.scope, scope)
+		}
+	}
+}
+
+func TestPluginPreferFamily(t *testing.T) {
+	serviceLookup := func(_ context.Context, keys []string) (result lookupResult) {
+		switch {
+		case slices.Contains(keys, "dual.example.com"):
+			result.addrs = []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}
+		case slices.Contains(keys, "v6.example.com"):
+			result.addrs = []netip.Addr{netip.MustParseAddr("2001:db8::2")}
+		}
+		return
+	}
+
+	tests := []struct {
+		preferFamily string
+		only         bool
+		qname        string
+		qtype        uint16
+		expected     []uint16
+	}{
+		// without a preference both families are answered, IPv4 first
+		{"", false, "dual.example.com.", dns.TypeA, []uint16{dns.TypeA}},
+		{"", false, "dual.example.com.", dns.TypeAAAA, []uint16{dns.TypeAAAA}},
+		{"", false, "dual.example.com.", dns.TypeANY, []uint16{dns.TypeA, dns.TypeAAAA}},
+		// a preference alone only changes the order
+		{"ipv4", false, "dual.example.com.", dns.TypeAAAA, []uint16{dns.TypeAAAA}},
+		{"ipv4", false, "dual.example.com.", dns.TypeANY, []uint16{dns.TypeA, dns.TypeAAAA}},
+		{"ipv6", false, "dual.example.com.", dns.TypeA, []uint16{dns.TypeA}},
+		{"ipv6", false, "dual.example.com.", dns.TypeANY, []uint16{dns.TypeAAAA, dns.TypeA}},
+		// only the preferred family is answered for names having both
+		{"ipv4", true, "dual.example.com.", dns.TypeA, []uint16{dns.TypeA}},
+		{"ipv4", true, "dual.example.com.", dns.TypeAAAA, nil},
+		{"ipv4", true, "dual.example.com.", dns.TypeANY, []uint16{dns.TypeA}},
+		{"ipv6", true, "dual.example.com.", dns.TypeA, nil},
+		{"ipv6", true, "dual.example.com.", dns.TypeAAAA, []uint16{dns.TypeAAAA}},
+		{"ipv6", true, "dual.example.com.", dns.TypeANY, []uint16{dns.TypeAAAA}},
+		// names with a single family keep it
+		{"ipv4", true, "v6.example.com.", dns.TypeAAAA, []uint16{dns.TypeAAAA}},
+	}
+
+	for i, tc := range tests {
+		gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: serviceLookup, reverse: noopReverse})
+		gw.preferFamily = tc.preferFamily
+		gw.preferFamilyOnly = tc.only
+
+		r := new(dns.Msg)
+		r.SetQuestion(tc.qname, tc.qtype)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if w.Msg.Rcode != dns.RcodeSuccess {
+			t.Errorf("Test %d: Expected NOERROR, got %s", i, dns.RcodeToString[w.Msg.Rcode])
+		}
+		var types []uint16
+		for _, rr := range w.Msg.Answer {
+			types = append(types, rr.Header().Rrtype)
+		}
+		if !slices.Equal(types, tc.expected) {
+			t.Errorf("Test %d: Expected record types %v for %s %s with preferFamily %q only %t, got %v",
+				i, tc.expected, dns.TypeToString[tc.qtype], tc.qname, tc.preferFamily, tc.only, types)
+		}
+	}
+}
+
+func TestPluginZonePreferFamily(t *testing.T) {
+	serviceLookup := func(_ context.Context, keys []string) (result lookupResult) {
+		if slices.Contains(keys, "dual.example.com") || slices.Contains(keys, "dual.example.org") {
+			result.addrs = []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}
+		}
+		return
+	}
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: serviceLookup, reverse: noopReverse})
+	gw.Zones = []string{"example.com.", "example.org."}
+	gw.preferFamily, gw.preferFamilyOnly = familyIPv4, true
+	gw.zonePreferFamily = map[string]familyPreference{"example.org.": {family: familyIPv6, only: true}}
+
+	tests := []struct {
+		qname    string
+		qtype    uint16
+		expected []uint16
+	}{
+		// zones without an entry follow preferFamily
+		{"dual.example.com.", dns.TypeA, []uint16{dns.TypeA}},
+		{"dual.example.com.", dns.TypeAAAA, nil},
+		{"dual.example.org.", dns.TypeA, nil},
+		{"dual.example.org.", dns.TypeAAAA, []uint16{dns.TypeAAAA}},
+		{"dual.example.org.", dns.TypeANY, []uint16{dns.TypeAAAA}},
+	}
+	for i, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tc.qname, tc.qtype)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		var types []uint16
+		for _, rr := range w.Msg.Answer {
+			types = append(types, rr.Header().Rrtype)
+		}
+		if !slices.Equal(types, tc.expected) {
+			t.Errorf("Test %d: Expected record types %v for %s %s, got %v", i, tc.expected, dns.TypeToString[tc.qtype], tc.qname, types)
+		}
+	}
+}
+
+func TestPluginAddressPriority(t *testing.T) {
+	backup, primary, other, secondary := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("192.0.2.3"), netip.MustParseAddr("192.0.2.4")
+	serviceLookup := func(_ context.Context, keys []string) (result lookupResult) {
+		if slices.Contains(keys, "app.example.com") {
+			result.addrs = []netip.Addr{backup, primary, other, secondary}
+			result.priorities = map[netip.Addr]uint32{backup: 20, primary: 0, secondary: 10}
+			// weights only order addresses of the same priority
+			result.weights = map[netip.Addr]uint32{backup: 100, other: 100}
+		}
+		return
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: serviceLookup, reverse: noopReverse})
+
+	expected := []netip.Addr{primary, secondary, backup, other}
+	for i := range 10 {
+		r := new(dns.Msg)
+		r.SetQuestion("app.example.com.", dns.TypeA)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		var addrs []netip.Addr
+		for _, rr := range w.Msg.Answer {
+			addr, _ := netip.AddrFromSlice(rr.(*dns.A).A.To4())
+			addrs = append(addrs, addr)
+		}
+		if !slices.Equal(addrs, expected) {
+			t.Errorf("Test %d: Expected %v, got %v", i, expected, addrs)
+		}
+	}
+}
+
+func TestWeightedShuffle(t *testing.T) {
+	heavy := netip.MustParseAddr("192.0.2.1")
+	light := netip.MustParseAddr("192.0.2.2")
+	unweighted := netip.MustParseAddr("192.0.2.3")
+	addrs := []netip.Addr{unweighted, light, heavy}
+	weights := map[netip.Addr]uint32{heavy: 8, light: 2}
+
+	first := make(map[netip.Addr]int)
+	iterations := 10000
+	for range iterations {
+		shuffled := weightedShuffle(addrs, weights)
+		if len(shuffled) != len(addrs) {
+			t.Fatalf("Expected %d addresses, got %v", len(addrs), shuffled)
+		}
+		first[shuffled[0]]++
+	}
+	// expected shares are 8/11, 2/11 and 1/11
+	if first[heavy] < iterations*6/11 || first[heavy] > iterations*10/11 {
+		t.Errorf("Expected the heavy address first about 8/11 of the time, got %d/%d", first[heavy], iterations)
+	}
+	if first[heavy] <= first[light] || first[light] <= first[unweighted] {
+		t.Errorf("Expected higher weighted addresses first more often, got %v", first)
+	}
+
+	// addresses of weight 0 come last
+	standby := netip.MustParseAddr("192.0.2.4")
+	weights[standby] = 0
+	for range 100 {
+		if shuffled := weightedShuffle([]netip.Addr{standby, unweighted, light, heavy}, weights); shuffled[len(shuffled)-1] != standby {
+			t.Fatalf("Expected %s of weight 0 last, got %v", standby, shuffled)
+		}
+	}
+
+	// the order is untouched without weights
+	if shuffled := weightedShuffle(addrs, nil); !slices.Equal(shuffled, addrs) {
+		t.Errorf("Expected unchanged order %v, got %v", addrs, shuffled)
+	}
+}
+
+func TestPluginFamily(t *testing.T) {
+	ctrl := syncedController()
+
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
-	gw.Next = test.NextHandler(dns.RcodeSuccess, Fallen{})
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
 	gw.ExternalAddrFunc = gw.SelfAddress
 	gw.Controller = ctrl
 	setupLookupFuncs(gw)
 
+	soa := test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")
+	a := test.A("svc1.ns1.example.com.   60  IN  A   192.0.1.1")
+	aaaa := test.AAAA("svc1.ns1.example.com.    60  IN  AAAA    fd12:3456:789a:1::")
+
 	ctx := context.TODO()
-	for i, tc := range testsFallthrough {
-		r := tc.Msg()
+	for i, tc := range []struct {
+		family string
+		test.Case
+	}{
+		{familyAll, test.Case{Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{a}}},
+		{familyAll, test.Case{Qname: "svc1.ns1.example.com.", Qtype: dns.TypeAAAA, Answer: []dns.RR{aaaa}}},
+		{familyIPv4, test.Case{Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{a}}},
+		{familyIPv4, test.Case{Qname: "svc1.ns1.example.com.", Qtype: dns.TypeAAAA, Ns: []dns.RR{soa}}},
+		{familyIPv6, test.Case{Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA, Ns: []dns.RR{soa}}},
+		{familyIPv6, test.Case{Qname: "svc1.ns1.example.com.", Qtype: dns.TypeAAAA, Answer: []dns.RR{aaaa}}},
+	} {
+		gw.family = tc.family
 		w := dnstest.NewRecorder(&test.ResponseWriter{})
 
-		gw.Fall = fall.F{Zones: tc.FallthroughZones}
-		_, err := gw.ServeDNS(ctx, w, r)
+		if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Errorf("Test %d expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc.Case); err != nil {
+			t.Errorf("Test %d (family %s) failed with error: %v", i, tc.family, err)
+		}
+	}
+}
 
-		if errors.As(err, &Fallen{}) && !tc.FallthroughExpected {
-			t.Fatalf("Test %d query resulted unexpectedly in a fall through instead of a response", i)
+func TestPluginZoneFamily(t *testing.T) {
+	gw := newTestGateway()
+	gw.Zones = []string{"example.com.", "example.org."}
+	gw.zoneFamily = map[string]string{"example.org.": familyIPv4}
+
+	soa := test.SOA("example.org.  60  IN  SOA dns1.kube-system.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")
+	tests := []test.Case{
+		// zones without an entry follow family
+		{Qname: "svc1.ns1.example.com.", Qtype: dns.TypeAAAA, Answer: []dns.RR{test.AAAA("svc1.ns1.example.com.	60	IN	AAAA	fd12:3456:789a:1::")}},
+		{Qname: "svc1.ns1.example.org.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("svc1.ns1.example.org.	60	IN	A	192.0.1.1")}},
+		{Qname: "svc1.ns1.example.org.", Qtype: dns.TypeAAAA, Ns: []dns.RR{soa}},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
 		}
-		if err == nil && tc.FallthroughExpected {
-			t.Fatalf("Test %d query resulted unexpectedly in a response instead of a fall through", i)
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestPluginCNAMEGatewayHostnames(t *testing.T) {
+	ctrl := syncedController()
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com.", "example.org."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = ctrl
+	setupLookupFuncs(gw)
+
+	soa := test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")
+	cname := test.CNAME("svc-lb.ns1.example.com.	10	IN	CNAME	lb.example.net.")
+
+	ctx := context.TODO()
+	for i, tc := range []struct {
+		enabled bool
+		zones   map[string]bool
+		test.Case
+	}{
+		{false, nil, test.Case{Qname: "svc-lb.ns1.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("svc-lb.ns1.example.com.	10	IN	A	192.0.1.4")}}},
+		{false, nil, test.Case{Qname: "svc-lb.ns1.example.com.", Qtype: dns.TypeCNAME, Ns: []dns.RR{soa}}},
+		{true, nil, test.Case{Qname: "svc-lb.ns1.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{cname}}},
+		{true, nil, test.Case{Qname: "svc-lb.ns1.example.com.", Qtype: dns.TypeAAAA, Answer: []dns.RR{cname}}},
+		{true, nil, test.Case{Qname: "svc-lb.ns1.example.com.", Qtype: dns.TypeCNAME, Answer: []dns.RR{cname}}},
+		// names backed by IPs are unaffected
+		{true, nil, test.Case{Qname: "svc2.ns1.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("svc2.ns1.example.com.   60  IN  A   192.0.0.2")}}},
+		// a hostname that failed to resolve doesn't make the name exist
+		{false, nil, test.Case{Qname: "svc-unresolved.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError, Ns: []dns.RR{soa}}},
+		{true, nil, test.Case{Qname: "svc-unresolved.ns1.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{
+			test.CNAME("svc-unresolved.ns1.example.com.	60	IN	CNAME	unresolved.example.net."),
+		}}},
+		// zones override the option either way
+		{false, map[string]bool{"example.com.": true}, test.Case{Qname: "svc-lb.ns1.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{cname}}},
+		{true, map[string]bool{"example.com.": false}, test.Case{Qname: "svc-lb.ns1.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("svc-lb.ns1.example.com.	10	IN	A	192.0.1.4")}}},
+		{false, map[string]bool{"example.com.": true}, test.Case{Qname: "svc-lb.ns1.example.org.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("svc-lb.ns1.example.org.	10	IN	A	192.0.1.4")}}},
+	} {
+		gw.cnameGatewayHostnames = tc.enabled
+		gw.zoneCNAMEGatewayHostnames = tc.zones
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		if _, err := gw.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Errorf("Test %d expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc.Case); err != nil {
+			t.Errorf("Test %d failed with error: %v", i, err)
 		}
 	}
 }
 
+var testsPTR = []test.Case{
+	// Service name without zone | Test 0
+	{
+		Qname: "1.1.0.192.in-addr.arpa.", Qtype: dns.TypePTR, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.PTR("1.1.0.192.in-addr.arpa.   60  IN  PTR   svc1.ns1.example.com."),
+		},
+	},
+	// Ingress FQDN | Test 1
+	{
+		Qname: "1.0.0.192.in-addr.arpa.", Qtype: dns.TypePTR, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.PTR("1.0.0.192.in-addr.arpa.   60  IN  PTR   domain.example.com."),
+		},
+	},
+	// Address not backing any object | Test 2
+	{
+		Qname: "9.9.0.192.in-addr.arpa.", Qtype: dns.TypePTR, Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("0.192.in-addr.arpa.  60  IN  SOA dns1.kube-system.0.192.in-addr.arpa. hostmaster.0.192.in-addr.arpa. 1499347823 7200 1800 86400 5"),
+		},
+	},
+}
+
 var tests = []test.Case{
 	// Existing Service IPv4 | Test 0
 	{
@@ -162,7 +1914,7 @@ var tests = []test.Case{
 	},
 	// Real service, wrong query type | Test 7
 	{
-		Qname: "svc3.ns1.example.com.", Qtype: dns.TypeCNAME, Rcode: dns.RcodeSuccess,
+		Qname: "svc1.ns1.example.com.", Qtype: dns.TypeCNAME, Rcode: dns.RcodeSuccess,
 		Ns: []dns.RR{
 			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
 		},
@@ -240,6 +1992,151 @@ var tests = []test.Case{
 			test.A("specific-subdomain.wildcard.example.com. 60  IN  A   192.0.0.7"),
 		},
 	},
+	// DNSEndpoint MX records, malformed targets are skipped | Test 20
+	{
+		Qname: "mail.endpoint.example.com.", Qtype: dns.TypeMX, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.MX("mail.endpoint.example.com. 60  IN  MX  10 mx1.example.com."),
+			test.MX("mail.endpoint.example.com. 60  IN  MX  20 mx2.example.com."),
+		},
+	},
+	// Existing name without MX records | Test 21
+	{
+		Qname: "domain.endpoint.example.com.", Qtype: dns.TypeMX, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Non-existing name queried for MX | Test 22
+	{
+		Qname: "nomail.endpoint.example.com.", Qtype: dns.TypeMX, Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Subdomain delegated by DNSEndpoint NS records | Test 23
+	{
+		Qname: "delegated.endpoint.example.com.", Qtype: dns.TypeNS, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.NS("delegated.endpoint.example.com. 60  IN  NS  ns1.delegated.example.net."),
+			test.NS("delegated.endpoint.example.com. 60  IN  NS  ns2.delegated.example.net."),
+		},
+	},
+	// Non-delegated subdomain queried for NS | Test 24
+	{
+		Qname: "domain.endpoint.example.com.", Qtype: dns.TypeNS, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Service with a TTL annotation | Test 25
+	{
+		Qname: "svc-ttl.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("svc-ttl.ns1.example.com.	15	IN	A	192.0.1.3"),
+		},
+	},
+	// DNSEndpoint TXT records, long values are split into several strings | Test 26
+	{
+		Qname: "txt.endpoint.example.com.", Qtype: dns.TypeTXT, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.TXT(`txt.endpoint.example.com.	60	IN	TXT	"` + strings.Repeat("a", 255) + `" "` + strings.Repeat("a", 45) + `"`),
+			test.TXT(`txt.endpoint.example.com.	60	IN	TXT	"v=spf1 -all"`),
+		},
+	},
+	// Existing Service queried for TXT | Test 27
+	{
+		Qname: "svc2.ns1.example.com.", Qtype: dns.TypeTXT, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Existing DNSEndpoint TXT name queried for A | Test 28
+	{
+		Qname: "txt.endpoint.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Existing DNSEndpoint TXT name queried for AAAA | Test 29
+	{
+		Qname: "txt.endpoint.example.com.", Qtype: dns.TypeAAAA, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Non-existing name queried for AAAA | Test 30
+	{
+		Qname: "svcX.ns1.example.com.", Qtype: dns.TypeAAAA, Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Non-existing name queried for TXT | Test 31
+	{
+		Qname: "svcX.ns1.example.com.", Qtype: dns.TypeTXT, Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Non-existing name queried for an unsupported type | Test 32
+	{
+		Qname: "svcX.ns1.example.com.", Qtype: dns.TypeCNAME, Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Service resolved from a load balancer hostname with a shorter TTL | Test 33
+	{
+		Qname: "svc-lb.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("svc-lb.ns1.example.com.	10	IN	A	192.0.1.4"),
+		},
+	},
+	// Upstream TTL below the floor | Test 34
+	{
+		Qname: "svc-lb-short.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("svc-lb-short.ns1.example.com.	5	IN	A	192.0.1.5"),
+		},
+	},
+	// DNSEndpoint SRV records, malformed targets are skipped | Test 35
+	{
+		Qname: "_sip._tcp.endpoint.example.com.", Qtype: dns.TypeSRV, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.SRV("_sip._tcp.endpoint.example.com.	60	IN	SRV	10 40 5060 sip2.example.com."),
+			test.SRV("_sip._tcp.endpoint.example.com.	60	IN	SRV	10 60 5060 sip1.example.com."),
+		},
+	},
+	// Existing name without SRV records | Test 36
+	{
+		Qname: "domain.endpoint.example.com.", Qtype: dns.TypeSRV, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.  60  IN  SOA dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// DNSEndpoint DS records of a signed delegation, malformed targets are skipped | Test 37
+	{
+		Qname: "delegated.endpoint.example.com.", Qtype: dns.TypeDS, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.DS("delegated.endpoint.example.com.	60	IN	DS	12345 13 2 3A8F6E0F1F8C0A4C7D7B2E9F5A1C4E6B8D0F2A4C6E8B0D2F4A6C8E0B2D4F6A8C"),
+		},
+	},
+	// DNSEndpoint DNSKEY records, malformed targets are skipped | Test 38
+	{
+		Qname: "delegated.endpoint.example.com.", Qtype: dns.TypeDNSKEY, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.DNSKEY("delegated.endpoint.example.com.	60	IN	DNSKEY	257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ=="),
+		},
+	},
+	// DNSEndpoint CAA records, malformed targets are skipped | Test 39
+	{
+		Qname: "caa.endpoint.example.com.", Qtype: dns.TypeCAA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CAA(`caa.endpoint.example.com.	60	IN	CAA	0 issue "letsencrypt.org"`),
+			test.CAA(`caa.endpoint.example.com.	60	IN	CAA	128 iodef "mailto:security@example.com"`),
+		},
+	},
 }
 
 var testsFallthrough = []FallthroughCase{
@@ -268,47 +2165,121 @@ var testsFallthrough = []FallthroughCase{
 		Case:             test.Case{Qname: "dns1.kube-system.example.com.", Qtype: dns.TypeA},
 		FallthroughZones: []string{"."}, FallthroughExpected: false,
 	},
+	// No match found, fallthrough restricted to TXT | Test 5
+	{
+		Case:             test.Case{Qname: "_acme-challenge.example.com.", Qtype: dns.TypeTXT},
+		FallthroughZones: []string{"."}, FallthroughTypes: []uint16{dns.TypeTXT}, FallthroughExpected: true,
+	},
+	// No match found, A query doesn't fall through when restricted to TXT | Test 6
+	{
+		Case:             test.Case{Qname: "_acme-challenge.example.com.", Qtype: dns.TypeA},
+		FallthroughZones: []string{"."}, FallthroughTypes: []uint16{dns.TypeTXT}, FallthroughExpected: false,
+	},
+	// Name with A records but no TXT records queried for TXT | Test 7
+	{
+		Case:             test.Case{Qname: "domain.endpoint.example.com.", Qtype: dns.TypeTXT},
+		FallthroughZones: []string{"."}, FallthroughExpected: true,
+	},
+	// Name with A records queried for A is still answered | Test 8
+	{
+		Case:             test.Case{Qname: "domain.endpoint.example.com.", Qtype: dns.TypeA},
+		FallthroughZones: []string{"."}, FallthroughExpected: false,
+	},
+	// Name with TXT records queried for TXT is answered | Test 9
+	{
+		Case:             test.Case{Qname: "txt.endpoint.example.com.", Qtype: dns.TypeTXT},
+		FallthroughZones: []string{"."}, FallthroughExpected: false,
+	},
+	// Name with A records but no TXT records, fallthrough for different zone | Test 10
+	{
+		Case:             test.Case{Qname: "domain.endpoint.example.com.", Qtype: dns.TypeTXT},
+		FallthroughZones: []string{"not-example.com."}, FallthroughExpected: false,
+	},
 }
 
 var testServiceIndexes = map[string][]netip.Addr{
 	"svc1.ns1":         {netip.MustParseAddr("192.0.1.1"), netip.MustParseAddr("fd12:3456:789a:1::")},
 	"svc2.ns1":         {netip.MustParseAddr("192.0.1.2")},
 	"svc3.ns1":         {},
+	"svc-ttl.ns1":      {netip.MustParseAddr("192.0.1.3")},
+	"svc-lb.ns1":       {netip.MustParseAddr("192.0.1.4")},
+	"svc-lb-short.ns1": {netip.MustParseAddr("192.0.1.5")},
 	"dns1.kube-system": {netip.MustParseAddr("192.0.1.53")},
 }
 
-func testServiceLookup(keys []string) (results []netip.Addr) {
+var testServiceTTLs = map[string]uint32{
+	"svc-ttl.ns1": 15,
+}
+
+// load balancer hostnames the addresses of services were resolved from
+var testServiceHostnames = map[string][]string{
+	"svc-lb.ns1": {"lb.example.net"},
+	// the hostname failed to resolve
+	"svc-unresolved.ns1": {"unresolved.example.net"},
+}
+
+// TTLs of the records the load balancer hostnames of services resolved to
+var testServiceUpstreamTTLs = map[string]uint32{
+	"svc-lb.ns1":       10,
+	"svc-lb-short.ns1": 1,
+}
+
+func testServiceLookup(_ context.Context, keys []string) (results lookupResult) {
 	for _, key := range keys {
-		results = append(results, testServiceIndexes[strings.ToLower(key)]...)
+		results.addrs = append(results.addrs, testServiceIndexes[strings.ToLower(key)]...)
+		if ttl, ok := testServiceTTLs[strings.ToLower(key)]; ok {
+			results.setTTL(ttl)
+		}
+		if ttl, ok := testServiceUpstreamTTLs[strings.ToLower(key)]; ok {
+			results.setUpstreamTTL(ttl)
+		}
+		results.hostnames = append(results.hostnames, testServiceHostnames[strings.ToLower(key)]...)
 	}
 	return results
 }
 
+var testServiceReverseIndexes = map[string][]string{
+	"192.0.1.1": {"svc1.ns1"},
+}
+
+func testServiceReverseLookup(addr netip.Addr) []string {
+	return testServiceReverseIndexes[addr.String()]
+}
+
 var testIngressIndexes = map[string][]netip.Addr{
 	"domain.example.com":                      {netip.MustParseAddr("192.0.0.1")},
 	"svc2.ns1.example.com":                    {netip.MustParseAddr("192.0.0.2")},
 	"example.com":                             {netip.MustParseAddr("192.0.0.3")},
+	"example.net":                             {netip.MustParseAddr("fd12:3456:789a:3::")},
 	"shadow.example.com":                      {netip.MustParseAddr("192.0.0.4")},
 	"shadow-vs.example.com":                   {netip.MustParseAddr("192.0.0.5")},
 	"*.wildcard.example.com":                  {netip.MustParseAddr("192.0.0.6")},
 	"specific-subdomain.wildcard.example.com": {netip.MustParseAddr("192.0.0.7")},
 }
 
-func testIngressLookup(keys []string) (results []netip.Addr) {
+func testIngressLookup(_ context.Context, keys []string) (results lookupResult) {
 	for _, key := range keys {
-		results = append(results, testIngressIndexes[strings.ToLower(key)]...)
+		results.addrs = append(results.addrs, testIngressIndexes[strings.ToLower(key)]...)
 	}
 	return results
 }
 
+var testIngressReverseIndexes = map[string][]string{
+	"192.0.0.1": {"domain.example.com"},
+}
+
+func testIngressReverseLookup(addr netip.Addr) []string {
+	return testIngressReverseIndexes[addr.String()]
+}
+
 var testRouteIndexes = map[string][]netip.Addr{
 	"domain.gw.example.com": {netip.MustParseAddr("192.0.2.1")},
 	"shadow.example.com":    {netip.MustParseAddr("192.0.2.4")},
 }
 
-func testRouteLookup(keys []string) (results []netip.Addr) {
+func testRouteLookup(_ context.Context, keys []string) (results lookupResult) {
 	for _, key := range keys {
-		results = append(results, testRouteIndexes[strings.ToLower(key)]...)
+		results.addrs = append(results.addrs, testRouteIndexes[strings.ToLower(key)]...)
 	}
 	return results
 }
@@ -316,21 +2287,89 @@ func testRouteLookup(keys []string) (results []netip.Addr) {
 var testDNSEndpointIndexes = map[string][]netip.Addr{
 	"domain.endpoint.example.com": {netip.MustParseAddr("192.0.4.1")},
 	"endpoint.example.com":        {netip.MustParseAddr("192.0.4.4")},
+	"mixed.endpoint.example.com":  {netip.MustParseAddr("192.0.4.5"), netip.MustParseAddr("fd12:3456:789a:4::5")},
+}
+
+var testDNSEndpointRecordIndexes = map[string]map[string][]string{
+	"mail.endpoint.example.com": {
+		"MX": {"10 mx1.example.com", "20 mx2.example.com.", "not-a-preference mx3.example.com"},
+	},
+	"delegated.endpoint.example.com": {
+		"NS": {"ns1.delegated.example.net", "ns2.delegated.example.net."},
+		"DS": {
+			"12345 13 2 3a8f6e0f1f8c0a4c7d7b2e9f5a1c4e6b8d0f2a4c6e8b0d2f 4a6c8e0b2d4f6a8c",
+			"12345 13 2 not-hex",
+			"12345 13 sha256",
+			"key 13 2 3A8F",
+		},
+		"DNSKEY": {
+			"257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+			"257 2 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+			"257 3 13 not*base64",
+		},
+	},
+	"caa.endpoint.example.com": {
+		"CAA": {
+			`0 issue "letsencrypt.org"`,
+			"128 iodef mailto:security@example.com",
+			`0 issue "letsencrypt.org"`,
+			"256 issue ca.example.net",
+			"0 is-sue ca.example.net",
+			"0 issue",
+		},
+	},
+	"txt.endpoint.example.com": {
+		"TXT": {"v=spf1 -all", strings.Repeat("a", 300)},
+	},
+	"_sip._tcp.endpoint.example.com": {
+		"SRV": {"10 60 5060 sip1.example.com", "10 40 5060 sip2.example.com.", "10 5060 sip3.example.com", "10 60 port sip4.example.com"},
+	},
+	"mixed.endpoint.example.com": {
+		"TXT": {"v=spf1 -all"},
+	},
 }
 
-func testDNSEndpointLookup(keys []string) (results []netip.Addr) {
+func testDNSEndpointLookup(_ context.Context, keys []string) (results lookupResult) {
 	for _, key := range keys {
-		results = append(results, testDNSEndpointIndexes[strings.ToLower(key)]...)
+		results.addrs = append(results.addrs, testDNSEndpointIndexes[strings.ToLower(key)]...)
+		for recordType, data := range testDNSEndpointRecordIndexes[strings.ToLower(key)] {
+			results.addRecords(recordType, data...)
+		}
 	}
 	return results
 }
 
+// syncedController returns a controller whose informers count as synced
+func syncedController() *KubeController {
+	ctrl := &KubeController{}
+	ctrl.hasSynced.Store(true)
+	return ctrl
+}
+
+// newTestGateway returns a synced Gateway for example.com. answering from the
+// given resources, or from the test lookup functions without any
+func newTestGateway(resources ...*resourceWithIndex) *Gateway {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = syncedController()
+	if len(resources) > 0 {
+		gw.Resources = resources
+	} else {
+		setupLookupFuncs(gw)
+	}
+	return gw
+}
+
 func setupLookupFuncs(gw *Gateway) {
 	if resource := gw.lookupResource("Ingress"); resource != nil {
 		resource.lookup = testIngressLookup
+		resource.reverse = testIngressReverseLookup
 	}
 	if resource := gw.lookupResource("Service"); resource != nil {
 		resource.lookup = testServiceLookup
+		resource.reverse = testServiceReverseLookup
 	}
 	if resource := gw.lookupResource("HTTPRoute"); resource != nil {
 		resource.lookup = testRouteLookup
@@ -2,34 +2,58 @@ package gateway
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"net"
 	"net/netip"
+	"os"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+	istio_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
+	istioClient "istio.io/client-go/pkg/clientset/versioned"
 	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
 	networking "k8s.io/api/networking/v1"
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	meta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/ptr"
 	externaldnsv1 "sigs.k8s.io/external-dns/apis/v1alpha1"
-	"sigs.k8s.io/external-dns/source"
 	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
 	gatewayapi_v1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	gatewayapi_v1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
 	gatewayClient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
 )
 
 const (
 	defaultResyncPeriod              = 0
+	inactiveResourcesWarningInterval = 5 * time.Minute
+	inactiveResourcesRecheckInterval = 30 * time.Second
+	syncAttemptTimeout               = 30 * time.Second
+	syncRetryMaxBackoff              = 2 * time.Minute
+	hostnameResolveTimeout           = 2 * time.Second
+	resolutionWarningInterval        = 5 * time.Minute
+	connectionCheckInterval          = 10 * time.Second
 	ingressHostnameIndex             = "ingressHostname"
 	serviceHostnameIndex             = "serviceHostname"
 	gatewayUniqueIndex               = "gatewayIndex"
@@ -37,452 +61,1489 @@ const (
 	tlsRouteHostnameIndex            = "tlsRouteHostname"
 	grpcRouteHostnameIndex           = "grpcRouteHostname"
 	externalDNSHostnameIndex         = "externalDNSHostname"
+	ingressAddressIndex              = "ingressAddress"
+	serviceAddressIndex              = "serviceAddress"
+	externalDNSAddressIndex          = "externalDNSAddress"
+	headlessServiceHostnameIndex     = "headlessServiceHostname"
+	endpointSliceServiceIndex        = "endpointSliceService"
+	virtualServiceHostnameIndex      = "virtualServiceHostname"
+	serviceSelectorIndex             = "serviceSelector"
+	gatewayServiceIndex              = "gatewayService"
+	gatewayHostnameIndex             = "gatewayHostname"
 	hostnameAnnotationKey            = "coredns.io/hostname"
 	externalDnsHostnameAnnotationKey = "external-dns.alpha.kubernetes.io/hostname"
+	externalDnsTTLAnnotationKey      = "external-dns.alpha.kubernetes.io/ttl"
+	externalDnsTargetAnnotationKey   = "external-dns.alpha.kubernetes.io/target"
+	ttlAnnotationKey                 = "coredns.io/ttl"
+	weightAnnotationKey              = "coredns.io/weight"
+	alpnAnnotationKey                = "coredns.io/alpn"
+	regionAnnotationKey              = "coredns.io/region"
+	txtAnnotationKey                 = "coredns.io/txt"
+	priorityAnnotationKey            = "coredns.io/priority"
+	gatewayServiceAnnotationKey      = "coredns.io/gateway-service"
+	gatewayNameLabelKey              = "gateway.networking.k8s.io/gateway-name"
 	externalDNSEndpointGroup         = "externaldns.k8s.io/v1alpha1"
 	externalDNSEndpointKind          = "DNSEndpoint"
 )
 
 var (
-	apiextensionsClient  *apiextensionsclientset.Clientset
-	externaldnsCRDClient rest.Interface
+	resolvConf = "/etc/resolv.conf"
+	hostsFile  = "/etc/hosts"
+	// hostnames that failed to resolve mapped to the time they were last
+	// warned about, as every query of a name backed by them fails again
+	resolutionWarnings sync.Map
 )
 
 // KubeController stores the current runtime configuration and cache
 type KubeController struct {
-	client      kubernetes.Interface
-	gwClient    gatewayClient.Interface
-	controllers []cache.SharedIndexInformer
-	hasSynced   bool
+	// ctx is passed to all list, watch and connection check calls, cancel
+	// aborts the ones in progress when the controller is stopped
+	ctx      context.Context
+	cancel   context.CancelFunc
+	client   kubernetes.Interface
+	gwClient gatewayClient.Interface
+	gateway  *Gateway
+	crds     crdClients
+	// resources whose lookups are backed by the informers of this controller
+	resources []*resourceWithIndex
+	// mu guards controllers, inactiveResources and stopCh, which change when
+	// a missing CRD gets installed while running
+	mu          sync.RWMutex
+	controllers map[string]cache.SharedIndexInformer
+	stopCh      <-chan struct{}
+	// configured resources that aren't watched since their CRD or API is unavailable
+	inactiveResources []string
+	// deletedMu guards deleted, the index keys of recently deleted objects
+	// mapped to the time of their deletion
+	deletedMu sync.Mutex
+	deleted   map[string]time.Time
+	// stop is closed by Stop, done once run has returned
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	// set when a list or watch call failed, until the API server is reachable again
+	disconnected atomic.Bool
+	// set once the informers have synced, read by queries of other goroutines
+	hasSynced atomic.Bool
 }
 
-func newKubeController(ctx context.Context, c *kubernetes.Clientset, gw *gatewayClient.Clientset, originalGateway *Gateway) *KubeController {
+// hostnameIndexes are the hostname indexes whose deleted objects are tracked
+// for the deleteGrace option
+var hostnameIndexes = []string{
+	ingressHostnameIndex,
+	serviceHostnameIndex,
+	httpRouteHostnameIndex,
+	tlsRouteHostnameIndex,
+	grpcRouteHostnameIndex,
+	externalDNSHostnameIndex,
+	headlessServiceHostnameIndex,
+	virtualServiceHostnameIndex,
+}
+
+// crdClients are the clients of a cluster for the CRD based resources, the
+// external-dns and Istio ones are nil if their API isn't available
+type crdClients struct {
+	apiextensions apiextensionsclientset.Interface
+	externaldns   rest.Interface
+	istio         istioClient.Interface
+}
+
+func newKubeController(ctx context.Context, c kubernetes.Interface, gw gatewayClient.Interface, crds crdClients, originalGateway *Gateway) *KubeController {
+	return newClusterController(ctx, c, gw, crds, originalGateway, originalGateway.Resources)
+}
+
+// newClusterController builds a controller backing the lookups of the given
+// resources, further clusters back copies of the gateway's resources whose
+// results the gateway merges with its own
+func newClusterController(ctx context.Context, c kubernetes.Interface, gw gatewayClient.Interface, crds crdClients, originalGateway *Gateway, resources []*resourceWithIndex) *KubeController {
 	log.Infof("Building k8s_gateway controller")
 
+	ctx, cancel := context.WithCancel(ctx)
 	ctrl := &KubeController{
-		client:   c,
-		gwClient: gw,
+		ctx:         ctx,
+		cancel:      cancel,
+		client:      c,
+		gwClient:    gw,
+		gateway:     originalGateway,
+		crds:        crds,
+		resources:   resources,
+		controllers: make(map[string]cache.SharedIndexInformer),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
 	}
 
 	configuredResources := dereferenceStrings(originalGateway.ConfiguredResources)
-	routingResources := []string{"HTTPRoute", "TLSRoute", "GRPCRoute"}
-
-	shouldInitGateway := false
-	for _, r := range routingResources {
-		if slices.Contains(configuredResources, r) {
-			shouldInitGateway = true
-			break
-		}
-	}
-
-	if crdExists(apiextensionsClient, "gatewayclasses.gateway.networking.k8s.io") && shouldInitGateway {
-		gatewayController := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc:  gatewayLister(ctx, ctrl.gwClient, core.NamespaceAll),
-				WatchFunc: gatewayWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
-			},
-			&gatewayapi_v1.Gateway{},
-			defaultResyncPeriod,
-			cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
-		)
-		ctrl.controllers = append(ctrl.controllers, gatewayController)
-		log.Infof("GatewayAPI controller initialized")
 
-		for _, resourceName := range routingResources {
-			if !slices.Contains(configuredResources, resourceName) {
-				continue
-			}
-			resource := originalGateway.lookupResource(resourceName)
-			if resource == nil {
-				continue
-			}
-
-			switch resourceName {
-			case "HTTPRoute":
-				httpRouteController := cache.NewSharedIndexInformer(
-					&cache.ListWatch{
-						ListFunc:  httpRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
-						WatchFunc: httpRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
-					},
-					&gatewayapi_v1.HTTPRoute{},
-					defaultResyncPeriod,
-					cache.Indexers{httpRouteHostnameIndex: httpRouteHostnameIndexFunc},
-				)
-				resource.lookup = lookupHttpRouteIndex(httpRouteController, gatewayController, originalGateway.resourceFilters.gatewayClasses)
-				ctrl.controllers = append(ctrl.controllers, httpRouteController)
-				log.Infof("HTTPRoute controller initialized")
-
-			case "TLSRoute":
-				tlsRouteController := cache.NewSharedIndexInformer(
-					&cache.ListWatch{
-						ListFunc:  tlsRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
-						WatchFunc: tlsRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
-					},
-					&gatewayapi_v1alpha2.TLSRoute{},
-					defaultResyncPeriod,
-					cache.Indexers{tlsRouteHostnameIndex: tlsRouteHostnameIndexFunc},
-				)
-				resource.lookup = lookupTLSRouteIndex(tlsRouteController, gatewayController, originalGateway.resourceFilters.gatewayClasses)
-				ctrl.controllers = append(ctrl.controllers, tlsRouteController)
-				log.Infof("TLSRoute controller initialized")
-
-			case "GRPCRoute":
-				grpcRouteController := cache.NewSharedIndexInformer(
-					&cache.ListWatch{
-						ListFunc:  grpcRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
-						WatchFunc: grpcRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
-					},
-					&gatewayapi_v1.GRPCRoute{},
-					defaultResyncPeriod,
-					cache.Indexers{grpcRouteHostnameIndex: grpcRouteHostnameIndexFunc},
-				)
-				resource.lookup = lookupGRPCRouteIndex(grpcRouteController, gatewayController, originalGateway.resourceFilters.gatewayClasses)
-				ctrl.controllers = append(ctrl.controllers, grpcRouteController)
-				log.Infof("GRPCRoute controller initialized")
-			}
-		}
-	}
-
-	for _, resourceName := range []string{"Ingress", "Service"} {
-		if slices.Contains(dereferenceStrings(originalGateway.ConfiguredResources), resourceName) {
-			if resource := originalGateway.lookupResource(resourceName); resource != nil {
+	for _, resourceName := range []string{"Ingress", "Service", "Endpoints"} {
+		if slices.Contains(configuredResources, resourceName) {
+			if resource := ctrl.lookupResource(resourceName); resource != nil {
 				switch resourceName {
 				case "Ingress":
 					ingressController := cache.NewSharedIndexInformer(
 						&cache.ListWatch{
-							ListFunc:  ingressLister(ctx, ctrl.client, core.NamespaceAll),
-							WatchFunc: ingressWatcher(ctx, ctrl.client, core.NamespaceAll),
+							ListFunc:  ingressLister(ctrl.ctx, ctrl.client, core.NamespaceAll),
+							WatchFunc: ingressWatcher(ctrl.ctx, ctrl.client, core.NamespaceAll),
 						},
 						&networking.Ingress{},
-						defaultResyncPeriod,
-						cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc},
+						ctrl.gateway.resyncPeriod,
+						cache.Indexers{
+							ingressHostnameIndex: ingressHostnameIndexFunc(ctrl.gateway.resourceFilters),
+							ingressAddressIndex:  ingressAddressIndexFunc,
+						},
 					)
-					resource.lookup = lookupIngressIndex(ingressController, originalGateway.resourceFilters.ingressClasses)
-					ctrl.controllers = append(ctrl.controllers, ingressController)
+					resource.setLookups(lookupIngressIndex(ingressController, ctrl.gateway.resourceFilters), reverseLookupIngressIndex(ingressController, ctrl.gateway.indexFilters().ingressClasses))
+					ctrl.addController("Ingress", ingressController)
 					log.Infof("Ingress controller initialized")
 
 				case "Service":
-					serviceController := cache.NewSharedIndexInformer(
-						&cache.ListWatch{
-							ListFunc:  serviceLister(ctx, ctrl.client, core.NamespaceAll),
-							WatchFunc: serviceWatcher(ctx, ctrl.client, core.NamespaceAll),
-						},
-						&core.Service{},
-						defaultResyncPeriod,
-						cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc},
-					)
-					resource.lookup = lookupServiceIndex(serviceController)
-					ctrl.controllers = append(ctrl.controllers, serviceController)
+					serviceController := ctrl.serviceInformer()
+					ctrl.addIndexers("Service", serviceController, cache.Indexers{
+						serviceHostnameIndex: serviceHostnameIndexFunc(ctrl.gateway.indexFilters()),
+						serviceAddressIndex:  serviceAddressIndexFunc(ctrl.gateway.indexFilters()),
+					})
+					// NodePort and Local policy Services resolve to the nodes hosting their endpoints
+					var nodeController, nodeEndpointSliceController cache.SharedIndexInformer
+					if ctrl.gateway.resourceFilters.nodeAddressType != "" || ctrl.gateway.resourceFilters.localPolicyAddressType != "" {
+						nodeController = cache.NewSharedIndexInformer(
+							&cache.ListWatch{
+								ListFunc:  nodeLister(ctrl.ctx, ctrl.client),
+								WatchFunc: nodeWatcher(ctrl.ctx, ctrl.client),
+							},
+							&core.Node{},
+							ctrl.gateway.resyncPeriod,
+							cache.Indexers{},
+						)
+						ctrl.addController("Service/Node", nodeController)
+						nodeEndpointSliceController = ctrl.endpointSliceInformer()
+					}
+					lookup := lookupServiceIndex(serviceController, nodeController, nodeEndpointSliceController, ctrl.gateway.resourceFilters)
+					if ctrl.gateway.statusGracePeriod > 0 {
+						// load balancers being reprovisioned leave the status empty for a while
+						lastKnown := newLastKnownAddresses(ctrl.gateway.statusGracePeriod, ctrl.gateway.resourceFilters)
+						if _, err := serviceController.AddEventHandler(lastKnown.eventHandler(serviceHostnameIndexFunc(ctrl.gateway.indexFilters()))); err != nil {
+							log.Warningf("Failed to track the load balancer addresses of Services: %s", err)
+						}
+						lookup = lookupWithFallback(lookup, lastKnown.lookup)
+					}
+					resource.setLookups(lookup, reverseLookupServiceIndex(serviceController))
 					log.Infof("Service controller initialized")
+
+				case "Endpoints":
+					headlessServiceController := ctrl.serviceInformer()
+					ctrl.addIndexers("Service", headlessServiceController, cache.Indexers{headlessServiceHostnameIndex: headlessServiceHostnameIndexFunc})
+					resource.setLookups(lookupEndpointsIndex(headlessServiceController, ctrl.endpointSliceInformer()), nil)
+					log.Infof("Endpoints controller initialized")
 				}
 			}
 		}
 	}
 
-	if crdExists(apiextensionsClient, "dnsendpoints.externaldns.k8s.io") && slices.Contains(dereferenceStrings(originalGateway.ConfiguredResources), "DNSEndpoint") {
-		if resource := originalGateway.lookupResource("DNSEndpoint"); resource != nil {
-			dnsEndpointController := cache.NewSharedIndexInformer(
-				&cache.ListWatch{
-					WatchFunc: dnsEndpointWatcher(ctx, core.NamespaceAll),
-					ListFunc:  dnsEndpointLister(ctx, core.NamespaceAll),
-				},
-				&externaldnsv1.DNSEndpoint{},
-				defaultResyncPeriod,
-				cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
-			)
-			resource.lookup = lookupDNSEndpoint(dnsEndpointController)
-			ctrl.controllers = append(ctrl.controllers, dnsEndpointController)
-			log.Infof("DNSEndpoint controller initialized")
-		}
+	ctrl.activateResources()
+	if len(ctrl.inactiveResources) > 0 {
+		ctrl.warnInactiveResources()
 	}
 
 	return ctrl
 }
 
-func (ctrl *KubeController) run() {
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-
-	var synced []cache.InformerSynced
+// activateResources starts watching the configured CRD based resources whose
+// CRD is installed and that aren't watched yet. The remaining ones are marked
+// inactive until a later call finds their CRD.
+func (ctrl *KubeController) activateResources() {
+	configuredResources := dereferenceStrings(ctrl.gateway.ConfiguredResources)
+	var inactive []string
 
-	log.Infof("Starting k8s_gateway controller")
-	for _, ctrl := range ctrl.controllers {
-		go ctrl.Run(stopCh)
-		synced = append(synced, ctrl.HasSynced)
+	var routeResources []string
+	for _, r := range []string{"HTTPRoute", "TLSRoute", "GRPCRoute"} {
+		if slices.Contains(configuredResources, r) {
+			routeResources = append(routeResources, r)
+		}
+	}
+	if len(routeResources) > 0 && !ctrl.hasController("Gateway") {
+		if crdExists(ctrl.ctx, ctrl.crds.apiextensions, "gatewayclasses.gateway.networking.k8s.io") {
+			ctrl.initGatewayAPI(routeResources)
+		} else {
+			inactive = append(inactive, routeResources...)
+		}
 	}
 
-	log.Infof("Waiting for controllers to sync")
-	if !cache.WaitForCacheSync(stopCh, synced...) {
-		ctrl.hasSynced = false
+	if slices.Contains(configuredResources, "DNSEndpoint") && !ctrl.hasController("DNSEndpoint") {
+		if ctrl.crds.externaldns != nil && crdExists(ctrl.ctx, ctrl.crds.apiextensions, "dnsendpoints.externaldns.k8s.io") {
+			ctrl.initDNSEndpoint()
+		} else {
+			inactive = append(inactive, "DNSEndpoint")
+		}
 	}
-	log.Infof("Synced all required resources")
-	ctrl.hasSynced = true
 
-	<-stopCh
-}
+	if slices.Contains(configuredResources, "VirtualService") && !ctrl.hasController("VirtualService") {
+		if ctrl.crds.istio != nil && crdExists(ctrl.ctx, ctrl.crds.apiextensions, "virtualservices.networking.istio.io") {
+			ctrl.initVirtualService()
+		} else {
+			inactive = append(inactive, "VirtualService")
+		}
+	}
 
-// HasSynced returns true if all controllers have been synced
-func (ctrl *KubeController) HasSynced() bool {
-	return ctrl.hasSynced
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	for _, r := range ctrl.inactiveResources {
+		if !slices.Contains(inactive, r) {
+			log.Infof("Resource %s is now active", r)
+			inactiveResources.WithLabelValues(r).Set(0)
+		}
+	}
+	for _, r := range inactive {
+		inactiveResources.WithLabelValues(r).Set(1)
+	}
+	ctrl.inactiveResources = inactive
 }
 
-// RunKubeController kicks off the k8s controllers
-func (gw *Gateway) RunKubeController(ctx context.Context) error {
-	config, err := gw.getClientConfig()
-	if err != nil {
-		return err
+// initGatewayAPI starts watching Gateways and the given route resources
+func (ctrl *KubeController) initGatewayAPI(resources []string) {
+	gatewayController := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc:  gatewayLister(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+			WatchFunc: gatewayWatcher(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+		},
+		&gatewayapi_v1.Gateway{},
+		ctrl.gateway.resyncPeriod,
+		cache.Indexers{
+			gatewayUniqueIndex:   gatewayIndexFunc,
+			gatewayHostnameIndex: gatewayHostnameIndexFunc,
+		},
+	)
+	ctrl.addController("Gateway", gatewayController)
+	gatewayServiceController := ctrl.serviceInformer()
+	ctrl.addIndexers("Service", gatewayServiceController, cache.Indexers{gatewayServiceIndex: gatewayServiceIndexFunc})
+	var referenceGrantController cache.SharedIndexInformer
+	if ctrl.gateway.resourceFilters.requireReferenceGrants {
+		referenceGrantController = cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				ListFunc:  referenceGrantLister(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+				WatchFunc: referenceGrantWatcher(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+			},
+			&gatewayapi_v1beta1.ReferenceGrant{},
+			ctrl.gateway.resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		)
+		ctrl.addController("ReferenceGrant", referenceGrantController)
 	}
+	log.Infof("GatewayAPI controller initialized")
 
-	kubeClient, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return err
+	// the routes depend on the Gateways, their Services and ReferenceGrants
+	dependencies := []cache.SharedIndexInformer{gatewayController, gatewayServiceController}
+	if referenceGrantController != nil {
+		dependencies = append(dependencies, referenceGrantController)
 	}
+	lookups := make(map[string]lookupFunc)
+	informers := make(map[string]cache.SharedIndexInformer)
+	for _, resourceName := range resources {
+		resource := ctrl.lookupResource(resourceName)
+		if resource == nil {
+			continue
+		}
 
-	apiextensionsClient, err = apiextensionsclientset.NewForConfig(config)
-	if err != nil {
-		return err
-	}
+		switch resourceName {
+		case "HTTPRoute":
+			httpRouteController := cache.NewSharedIndexInformer(
+				&cache.ListWatch{
+					ListFunc:  httpRouteLister(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+					WatchFunc: httpRouteWatcher(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+				},
+				&gatewayapi_v1.HTTPRoute{},
+				ctrl.gateway.resyncPeriod,
+				cache.Indexers{httpRouteHostnameIndex: ctrl.routeIndexFunc(httpRouteHostnameIndexFunc)},
+			)
+			lookups[resourceName] = lookupHttpRouteIndex(httpRouteController, gatewayController, gatewayServiceController, referenceGrantController, ctrl.gateway.resourceFilters)
+			informers[resourceName] = httpRouteController
+			ctrl.addController("HTTPRoute", httpRouteController)
+			log.Infof("HTTPRoute controller initialized")
 
-	gwAPIClient, err := gatewayClient.NewForConfig(config)
-	if err != nil {
-		return err
-	}
+		case "TLSRoute":
+			tlsRouteController := cache.NewSharedIndexInformer(
+				&cache.ListWatch{
+					ListFunc:  tlsRouteLister(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+					WatchFunc: tlsRouteWatcher(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+				},
+				&gatewayapi_v1alpha2.TLSRoute{},
+				ctrl.gateway.resyncPeriod,
+				cache.Indexers{tlsRouteHostnameIndex: ctrl.routeIndexFunc(tlsRouteHostnameIndexFunc)},
+			)
+			lookups[resourceName] = lookupTLSRouteIndex(tlsRouteController, gatewayController, gatewayServiceController, referenceGrantController, ctrl.gateway.resourceFilters)
+			informers[resourceName] = tlsRouteController
+			ctrl.addController("TLSRoute", tlsRouteController)
+			log.Infof("TLSRoute controller initialized")
 
-	externaldnsCRDClient, _, err = source.NewCRDClientForAPIVersionKind(kubeClient, gw.configFile, "", externalDNSEndpointGroup, externalDNSEndpointKind)
-	if err != nil {
-		log.Warningf("crd %s not found. ignoring and continuing execution", externalDNSEndpointGroup)
+		case "GRPCRoute":
+			grpcRouteController := cache.NewSharedIndexInformer(
+				&cache.ListWatch{
+					ListFunc:  grpcRouteLister(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+					WatchFunc: grpcRouteWatcher(ctrl.ctx, ctrl.gwClient, core.NamespaceAll),
+				},
+				&gatewayapi_v1.GRPCRoute{},
+				ctrl.gateway.resyncPeriod,
+				cache.Indexers{grpcRouteHostnameIndex: ctrl.routeIndexFunc(grpcRouteHostnameIndexFunc)},
+			)
+			lookups[resourceName] = lookupGRPCRouteIndex(grpcRouteController, gatewayController, gatewayServiceController, referenceGrantController, ctrl.gateway.resourceFilters)
+			informers[resourceName] = grpcRouteController
+			ctrl.addController("GRPCRoute", grpcRouteController)
+			log.Infof("GRPCRoute controller initialized")
+		}
 	}
 
-	gw.Controller = newKubeController(ctx, kubeClient, gwAPIClient, gw)
-	go gw.Controller.run()
-
-	return nil
+	// Gateways annotated with a hostname resolve directly, after the routes
+	// of the first enabled route resource
+	for _, resourceName := range resources {
+		if lookup, ok := lookups[resourceName]; ok {
+			lookups[resourceName] = lookupWithFallback(lookup, lookupGatewayHostnameIndex(gatewayController, gatewayServiceController, ctrl.gateway.resourceFilters))
+			break
+		}
+	}
+	for resourceName, lookup := range lookups {
+		ctrl.publishLookups(ctrl.lookupResource(resourceName), lookup, nil, append(slices.Clip(dependencies), informers[resourceName])...)
+	}
 }
 
-func crdExists(clientset *apiextensionsclientset.Clientset, crdName string) bool {
-	_, err := clientset.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
-	if err != nil {
-		log.Warningf("error getting crd %s, error: %s", crdName, err.Error())
-	} else {
-		log.Infof("crd %s found", crdName)
+// initDNSEndpoint starts watching external-dns DNSEndpoints
+func (ctrl *KubeController) initDNSEndpoint() {
+	if resource := ctrl.lookupResource("DNSEndpoint"); resource != nil {
+		dnsEndpointController := cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				WatchFunc: dnsEndpointWatcher(ctrl.ctx, ctrl.crds.externaldns, core.NamespaceAll),
+				ListFunc:  dnsEndpointLister(ctrl.ctx, ctrl.crds.externaldns, core.NamespaceAll),
+			},
+			&externaldnsv1.DNSEndpoint{},
+			ctrl.gateway.resyncPeriod,
+			cache.Indexers{
+				externalDNSHostnameIndex: dnsEndpointTargetIndexFunc,
+				externalDNSAddressIndex:  dnsEndpointAddressIndexFunc,
+			},
+		)
+		ctrl.addController("DNSEndpoint", dnsEndpointController)
+		ctrl.publishLookups(resource, lookupDNSEndpoint(dnsEndpointController), reverseLookupDNSEndpoint(dnsEndpointController), dnsEndpointController)
+		log.Infof("DNSEndpoint controller initialized")
 	}
-	return err == nil
 }
 
-func (gw *Gateway) getClientConfig() (*rest.Config, error) {
-	if gw.configFile != "" {
-		overrides := &clientcmd.ConfigOverrides{}
-		overrides.CurrentContext = gw.configContext
-
-		config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			&clientcmd.ClientConfigLoadingRules{ExplicitPath: gw.configFile},
-			overrides,
+// initVirtualService starts watching Istio VirtualServices and Gateways
+func (ctrl *KubeController) initVirtualService() {
+	if resource := ctrl.lookupResource("VirtualService"); resource != nil {
+		virtualServiceController := cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				ListFunc:  virtualServiceLister(ctrl.ctx, ctrl.crds.istio, core.NamespaceAll),
+				WatchFunc: virtualServiceWatcher(ctrl.ctx, ctrl.crds.istio, core.NamespaceAll),
+			},
+			&istio_v1beta1.VirtualService{},
+			ctrl.gateway.resyncPeriod,
+			cache.Indexers{virtualServiceHostnameIndex: virtualServiceHostnameIndexFunc},
 		)
-
-		return config.ClientConfig()
+		istioGatewayController := cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				ListFunc:  istioGatewayLister(ctrl.ctx, ctrl.crds.istio, core.NamespaceAll),
+				WatchFunc: istioGatewayWatcher(ctrl.ctx, ctrl.crds.istio, core.NamespaceAll),
+			},
+			&istio_v1beta1.Gateway{},
+			ctrl.gateway.resyncPeriod,
+			cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+		)
+		istioServiceController := ctrl.serviceInformer()
+		ctrl.addIndexers("Service", istioServiceController, cache.Indexers{serviceSelectorIndex: serviceSelectorIndexFunc})
+		ctrl.addController("VirtualService", virtualServiceController)
+		ctrl.addController("VirtualService/Gateway", istioGatewayController)
+		ctrl.publishLookups(resource, lookupVirtualServiceIndex(virtualServiceController, istioGatewayController, istioServiceController, ctrl.gateway.resourceFilters), nil, virtualServiceController, istioGatewayController, istioServiceController)
+		log.Infof("VirtualService controller initialized")
 	}
-
-	return rest.InClusterConfig()
 }
 
-func dereferenceStrings(ptrs []*string) []string {
-	var strs []string
-	for _, ptr := range ptrs {
-		if ptr != nil {
-			strs = append(strs, *ptr)
-		}
+// publishLookups sets the lookup functions of a resource. A resource activated
+// while the controller is running isn't part of the initial sync, so it keeps
+// its previous lookups until its own informers have synced instead of
+// answering from an empty cache.
+func (ctrl *KubeController) publishLookups(resource *resourceWithIndex, lookup lookupFunc, reverse reverseLookupFunc, informers ...cache.SharedIndexInformer) {
+	ctrl.mu.RLock()
+	stopCh := ctrl.stopCh
+	ctrl.mu.RUnlock()
+
+	if stopCh == nil {
+		resource.setLookups(lookup, reverse)
+		return
 	}
-	return strs
+	synced := make([]cache.InformerSynced, len(informers))
+	for i, informer := range informers {
+		synced[i] = informer.HasSynced
+	}
+	go func() {
+		if cache.WaitForCacheSync(stopCh, synced...) {
+			log.Infof("Resource %s has synced", resource.name)
+			resource.setLookups(lookup, reverse)
+		}
+	}()
 }
 
-func httpRouteLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
-	return func(opts metav1.ListOptions) (runtime.Object, error) {
-		return c.GatewayV1().HTTPRoutes(ns).List(ctx, opts)
+// addController registers an informer, starting it right away if the
+// controller is already running
+func (ctrl *KubeController) addController(name string, informer cache.SharedIndexInformer) {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	ctrl.controllers[name] = informer
+	ctrl.trackDeletions(name, informer, informer.GetIndexer().GetIndexers())
+	if ctrl.gateway.answerCache != nil {
+		if _, err := informer.AddEventHandler(ctrl.gateway.answerCache.eventHandler()); err != nil {
+			log.Warningf("Failed to invalidate cached answers on changes of %s: %s", name, err)
+		}
+	}
+	if ctrl.stopCh != nil {
+		ctrl.startInformer(name, informer)
 	}
 }
 
-func tlsRouteLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
-	return func(opts metav1.ListOptions) (runtime.Object, error) {
-		return c.GatewayV1alpha2().TLSRoutes(ns).List(ctx, opts)
-	}
+// serviceInformer returns the informer of the Services of the cluster, which
+// is shared by all resources that need them, each adding its own indexes
+func (ctrl *KubeController) serviceInformer() cache.SharedIndexInformer {
+	return ctrl.sharedInformer("Service", func() cache.SharedIndexInformer {
+		return cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				ListFunc:  serviceLister(ctrl.ctx, ctrl.client, core.NamespaceAll),
+				WatchFunc: serviceWatcher(ctrl.ctx, ctrl.client, core.NamespaceAll),
+			},
+			&core.Service{},
+			ctrl.gateway.resyncPeriod,
+			cache.Indexers{},
+		)
+	})
 }
 
-func grpcRouteLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
-	return func(opts metav1.ListOptions) (runtime.Object, error) {
-		return c.GatewayV1().GRPCRoutes(ns).List(ctx, opts)
-	}
+// endpointSliceInformer returns the informer of the EndpointSlices of the
+// cluster, indexed by their Service and shared by all resources that need them
+func (ctrl *KubeController) endpointSliceInformer() cache.SharedIndexInformer {
+	return ctrl.sharedInformer("EndpointSlice", func() cache.SharedIndexInformer {
+		return cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				ListFunc:  endpointSliceLister(ctrl.ctx, ctrl.client, core.NamespaceAll),
+				WatchFunc: endpointSliceWatcher(ctrl.ctx, ctrl.client, core.NamespaceAll),
+			},
+			&discovery.EndpointSlice{},
+			ctrl.gateway.resyncPeriod,
+			cache.Indexers{endpointSliceServiceIndex: endpointSliceServiceIndexFunc},
+		)
+	})
 }
 
-func gatewayLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
-	return func(opts metav1.ListOptions) (runtime.Object, error) {
-		return c.GatewayV1().Gateways(ns).List(ctx, opts)
+// sharedInformer returns the registered informer of the given name, creating
+// and registering it on first use
+func (ctrl *KubeController) sharedInformer(name string, newInformer func() cache.SharedIndexInformer) cache.SharedIndexInformer {
+	ctrl.mu.RLock()
+	informer, ok := ctrl.controllers[name]
+	ctrl.mu.RUnlock()
+	if ok {
+		return informer
 	}
+	informer = newInformer()
+	ctrl.addController(name, informer)
+	return informer
 }
 
-func ingressLister(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
-	return func(opts metav1.ListOptions) (runtime.Object, error) {
-		return c.NetworkingV1().Ingresses(ns).List(ctx, opts)
+// addIndexers adds the indexes of a resource to a shared informer, which
+// indexes the objects it already holds if it is running
+func (ctrl *KubeController) addIndexers(name string, informer cache.SharedIndexInformer, indexers cache.Indexers) {
+	if err := informer.AddIndexers(indexers); err != nil {
+		log.Warningf("Failed to add indexes to the %s controller: %s", name, err)
+		return
 	}
+	ctrl.trackDeletions(name, informer, indexers)
 }
 
-func serviceLister(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
-	return func(opts metav1.ListOptions) (runtime.Object, error) {
-		return c.CoreV1().Services(ns).List(ctx, opts)
+// trackDeletions records the deleted hostnames of the given indexes of an
+// informer that are hostname indexes, if deleteGrace is enabled
+func (ctrl *KubeController) trackDeletions(name string, informer cache.SharedIndexInformer, indexers cache.Indexers) {
+	if ctrl.gateway.deleteGracePeriod == 0 {
+		return
+	}
+	for index, indexFunc := range indexers {
+		if !slices.Contains(hostnameIndexes, index) {
+			continue
+		}
+		if _, err := informer.AddEventHandler(ctrl.deletionHandler(indexFunc)); err != nil {
+			log.Warningf("Failed to track deletions of %s: %s", name, err)
+		}
 	}
 }
 
-func httpRouteWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
-	return func(opts metav1.ListOptions) (watch.Interface, error) {
-		return c.GatewayV1().HTTPRoutes(ns).Watch(ctx, opts)
+// deletionHandler records the hostnames of deleted objects, and the ones an
+// update removed from an object
+func (ctrl *KubeController) deletionHandler(indexFunc cache.IndexFunc) cache.ResourceEventHandler {
+	hostnames := func(obj interface{}) []string {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		keys, _ := indexFunc(obj)
+		return keys
 	}
-}
 
-func tlsRouteWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
-	return func(opts metav1.ListOptions) (watch.Interface, error) {
-		return c.GatewayV1alpha2().TLSRoutes(ns).Watch(ctx, opts)
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			current := hostnames(newObj)
+			var removed []string
+			for _, hostname := range hostnames(oldObj) {
+				if !slices.Contains(current, hostname) {
+					removed = append(removed, hostname)
+				}
+			}
+			ctrl.recordDeleted(removed)
+		},
+		DeleteFunc: func(obj interface{}) {
+			ctrl.recordDeleted(hostnames(obj))
+		},
 	}
 }
 
-func grpcRouteWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
-	return func(opts metav1.ListOptions) (watch.Interface, error) {
-		return c.GatewayV1().GRPCRoutes(ns).Watch(ctx, opts)
+func (ctrl *KubeController) recordDeleted(hostnames []string) {
+	if len(hostnames) == 0 {
+		return
 	}
-}
 
-func gatewayWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
-	return func(opts metav1.ListOptions) (watch.Interface, error) {
-		return c.GatewayV1().Gateways(ns).Watch(ctx, opts)
+	ctrl.deletedMu.Lock()
+	defer ctrl.deletedMu.Unlock()
+
+	now := time.Now()
+	// forget deletions that are out of the grace period
+	for hostname, deletedAt := range ctrl.deleted {
+		if now.Sub(deletedAt) > ctrl.gateway.deleteGracePeriod {
+			delete(ctrl.deleted, hostname)
+		}
+	}
+	if ctrl.deleted == nil {
+		ctrl.deleted = make(map[string]time.Time)
+	}
+	for _, hostname := range hostnames {
+		log.Debugf("Hostname %s was deleted", hostname)
+		ctrl.deleted[strings.ToLower(hostname)] = now
 	}
 }
 
-func ingressWatcher(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
-	return func(opts metav1.ListOptions) (watch.Interface, error) {
-		return c.NetworkingV1().Ingresses(ns).Watch(ctx, opts)
+// recentlyDeleted reports whether an object with one of the index keys was
+// deleted within the given period
+func (ctrl *KubeController) recentlyDeleted(indexKeys []string, period time.Duration) bool {
+	ctrl.deletedMu.Lock()
+	defer ctrl.deletedMu.Unlock()
+
+	for _, key := range indexKeys {
+		if deletedAt, ok := ctrl.deleted[strings.ToLower(key)]; ok && time.Since(deletedAt) <= period {
+			return true
+		}
 	}
+	return false
 }
 
-func serviceWatcher(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
-	return func(opts metav1.ListOptions) (watch.Interface, error) {
-		return c.CoreV1().Services(ns).Watch(ctx, opts)
-	}
+// lastKnownAddresses keeps the load balancer status entries of Services whose
+// status became empty, keyed by their hostnames, to serve their IPs and
+// resolved hostnames for a grace period
+type lastKnownAddresses struct {
+	period  time.Duration
+	filters ResourceFilters
+	mu      sync.Mutex
+	entries map[string]lastKnownEntry
 }
 
-func dnsEndpointWatcher(ctx context.Context, ns string) func(metav1.ListOptions) (watch.Interface, error) {
-	return func(opts metav1.ListOptions) (watch.Interface, error) {
-		opts.Watch = true
-		return externaldnsCRDClient.Get().
-			Resource("dnsendpoints").
-			Namespace(ns).
-			VersionedParams(&opts, metav1.ParameterCodec).
-			Watch(ctx)
-	}
+type lastKnownEntry struct {
+	ingress   []core.LoadBalancerIngress
+	emptiedAt time.Time
 }
 
-func dnsEndpointLister(ctx context.Context, ns string) func(metav1.ListOptions) (runtime.Object, error) {
-	return func(opts metav1.ListOptions) (runtime.Object, error) {
-		return externaldnsCRDClient.Get().
-			Resource("dnsendpoints").
-			Namespace(ns).
-			VersionedParams(&opts, metav1.ParameterCodec).
-			Do(ctx).
-			Get()
-	}
+func newLastKnownAddresses(period time.Duration, filters ResourceFilters) *lastKnownAddresses {
+	return &lastKnownAddresses{period: period, filters: filters, entries: make(map[string]lastKnownEntry)}
 }
 
-// indexes based on "namespace/name" as the key
-func gatewayIndexFunc(obj interface{}) ([]string, error) {
-	metaObj, err := meta.Accessor(obj)
-	if err != nil {
-		return []string{""}, fmt.Errorf("object has no meta: %v", err)
+// eventHandler records the load balancer status of Services whose status
+// became empty, and forgets it once it is populated again
+func (l *lastKnownAddresses) eventHandler(indexFunc cache.IndexFunc) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldService, ok := oldObj.(*core.Service)
+			if !ok {
+				return
+			}
+			newService, ok := newObj.(*core.Service)
+			if !ok {
+				return
+			}
+			hostnames, _ := indexFunc(oldService)
+			if len(newService.Status.LoadBalancer.Ingress) > 0 {
+				l.forget(hostnames)
+				return
+			}
+			l.record(hostnames, oldService.Status.LoadBalancer.Ingress)
+		},
 	}
-	return []string{fmt.Sprintf("%s/%s", metaObj.GetNamespace(), metaObj.GetName())}, nil
 }
 
-func httpRouteHostnameIndexFunc(obj interface{}) ([]string, error) {
-	httpRoute, ok := obj.(*gatewayapi_v1.HTTPRoute)
-	if !ok {
-		return []string{}, nil
+func (l *lastKnownAddresses) record(hostnames []string, ingress []core.LoadBalancerIngress) {
+	if len(hostnames) == 0 || len(ingress) == 0 {
+		return
 	}
 
-	var hostnames []string
-	for _, hostname := range httpRoute.Spec.Hostnames {
-		log.Debugf("Adding index %s for httpRoute %s", httpRoute.Name, hostname)
-		hostnames = append(hostnames, string(hostname))
-	}
-	return hostnames, nil
-}
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-func tlsRouteHostnameIndexFunc(obj interface{}) ([]string, error) {
-	tlsRoute, ok := obj.(*gatewayapi_v1alpha2.TLSRoute)
-	if !ok {
-		return []string{}, nil
+	now := time.Now()
+	// forget addresses that are out of the grace period
+	for hostname, entry := range l.entries {
+		if now.Sub(entry.emptiedAt) > l.period {
+			delete(l.entries, hostname)
+		}
 	}
-
-	var hostnames []string
-	for _, hostname := range tlsRoute.Spec.Hostnames {
-		log.Debugf("Adding index %s for tlsRoute %s", tlsRoute.Name, hostname)
-		hostnames = append(hostnames, string(hostname))
+	for _, hostname := range hostnames {
+		log.Debugf("Keeping load balancer status %v of %s while it is empty", ingress, hostname)
+		l.entries[strings.ToLower(hostname)] = lastKnownEntry{ingress: ingress, emptiedAt: now}
 	}
-	return hostnames, nil
 }
 
-func grpcRouteHostnameIndexFunc(obj interface{}) ([]string, error) {
-	grpcRoute, ok := obj.(*gatewayapi_v1.GRPCRoute)
-	if !ok {
-		return []string{}, nil
-	}
+func (l *lastKnownAddresses) forget(hostnames []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	var hostnames []string
-	for _, hostname := range grpcRoute.Spec.Hostnames {
-		log.Debugf("Adding index %s for grpcRoute %s", grpcRoute.Name, hostname)
-		hostnames = append(hostnames, string(hostname))
+	for _, hostname := range hostnames {
+		delete(l.entries, strings.ToLower(hostname))
 	}
-	return hostnames, nil
 }
 
-func ingressHostnameIndexFunc(obj interface{}) ([]string, error) {
-	ingress, ok := obj.(*networking.Ingress)
-	if !ok {
-		return []string{}, nil
+// lookup returns the last known addresses of the index keys within the grace
+// period, resolving the hostnames of the load balancer status like a Service
+func (l *lastKnownAddresses) lookup(ctx context.Context, indexKeys []string) (result lookupResult) {
+	var ingress []core.LoadBalancerIngress
+	l.mu.Lock()
+	for _, key := range indexKeys {
+		if entry, ok := l.entries[normalizeHostname(key)]; ok && time.Since(entry.emptiedAt) <= l.period {
+			ingress = append(ingress, entry.ingress...)
+		}
 	}
+	l.mu.Unlock()
 
-	var hostnames []string
-	for _, rule := range ingress.Spec.Rules {
-		log.Debugf("Adding index %s for ingress %s", rule.Host, ingress.Name)
-		hostnames = append(hostnames, rule.Host)
+	if len(ingress) == 0 {
+		return
 	}
-	return hostnames, nil
+	// resolved without holding the lock
+	return fetchServiceLoadBalancerIPs(ctx, ingress, queryFilters(ctx, l.filters))
 }
 
-func serviceHostnameIndexFunc(obj interface{}) ([]string, error) {
-	service, ok := obj.(*core.Service)
-	if !ok {
-		return []string{}, nil
+// lookupResource returns the resource of the given name backed by the controller
+func (ctrl *KubeController) lookupResource(name string) *resourceWithIndex {
+	for _, resource := range ctrl.resources {
+		if resource.name == name {
+			return resource
+		}
 	}
+	return nil
+}
 
-	if service.Spec.Type != core.ServiceTypeLoadBalancer {
-		return []string{}, nil
-	}
+func (ctrl *KubeController) hasController(name string) bool {
+	ctrl.mu.RLock()
+	defer ctrl.mu.RUnlock()
+	_, ok := ctrl.controllers[name]
+	return ok
+}
 
-	hostname := service.Name + "." + service.Namespace
-	hostnames := []string{}
-	if annotation, exists := checkServiceAnnotation(hostnameAnnotationKey, service); exists {
-		if checkDomainValid(annotation) {
-			hostnames = []string{annotation}
-			log.Debugf("Adding index %s for service %s", annotation, service.Name)
-		}
-	} else if annotation, exists := checkServiceAnnotation(externalDnsHostnameAnnotationKey, service); exists {
-		for _, hostname := range splitHostnameAnnotation(annotation) {
-			if checkDomainValid(hostname) {
-				hostnames = append(hostnames, hostname)
-				log.Debugf("Adding index %s for service %s", hostname, service.Name)
-			}
-		}
-	} else {
-		hostnames = []string{hostname}
+func (ctrl *KubeController) startInformer(name string, informer cache.SharedIndexInformer) {
+	if err := informer.SetWatchErrorHandler(ctrl.watchErrorHandler(name)); err != nil {
+		log.Warningf("Failed to set watch error handler for %s: %s", name, err)
 	}
-
-	return hostnames, nil
+	go informer.Run(ctrl.stopCh)
+}
+
+func (ctrl *KubeController) run() {
+	defer close(ctrl.done)
+	stopCh := ctrl.stop
+
+	var synced []cache.InformerSynced
+
+	log.Infof("Starting k8s_gateway controller")
+	ctrl.mu.Lock()
+	ctrl.stopCh = stopCh
+	for name, informer := range ctrl.controllers {
+		ctrl.startInformer(name, informer)
+		synced = append(synced, informer.HasSynced)
+	}
+	ctrl.mu.Unlock()
+
+	go wait.Until(ctrl.recheckInactiveResources, inactiveResourcesRecheckInterval, stopCh)
+	go wait.Until(ctrl.warnInactiveResources, inactiveResourcesWarningInterval, stopCh)
+	go wait.Until(ctrl.checkConnection, connectionCheckInterval, stopCh)
+
+	ctrl.waitForSync(stopCh, synced...)
+
+	<-stopCh
+}
+
+// Stop stops all informers and background checks of the controller, e.g.
+// when the plugin is reloaded, aborting their API calls in progress. It can
+// safely be called more than once.
+func (ctrl *KubeController) Stop() {
+	ctrl.stopOnce.Do(func() {
+		log.Infof("Stopping k8s_gateway controller")
+		close(ctrl.stop)
+		ctrl.cancel()
+	})
+}
+
+// recheckInactiveResources starts watching inactive resources once their CRD
+// has been installed
+func (ctrl *KubeController) recheckInactiveResources() {
+	ctrl.mu.RLock()
+	inactive := len(ctrl.inactiveResources)
+	ctrl.mu.RUnlock()
+
+	if inactive > 0 {
+		ctrl.activateResources()
+	}
+}
+
+// waitForSync waits for all informers to sync. The informers keep retrying
+// failed list/watch calls on their own, so instead of giving up after a
+// failed attempt the wait is repeated with an increasing backoff.
+func (ctrl *KubeController) waitForSync(stopCh <-chan struct{}, synced ...cache.InformerSynced) {
+	backoff := wait.Backoff{
+		Duration: syncAttemptTimeout,
+		Factor:   2,
+		Steps:    math.MaxInt32,
+		Cap:      syncRetryMaxBackoff,
+	}
+
+	for attempt := 1; ; attempt++ {
+		log.Infof("Waiting for controllers to sync")
+
+		timeout := backoff.Step()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		go func() {
+			select {
+			case <-stopCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ok := cache.WaitForCacheSync(ctx.Done(), synced...)
+		cancel()
+		if ok {
+			log.Infof("Synced all required resources")
+			ctrl.hasSynced.Store(true)
+			return
+		}
+
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		log.Warningf("Controllers haven't synced after %s (attempt %d), still retrying", timeout, attempt)
+	}
+}
+
+// watchErrorHandler logs failed list/watch calls of an informer, which are
+// retried by the informer itself, and marks the controller as disconnected
+// until checkConnection reaches the API server again
+func (ctrl *KubeController) watchErrorHandler(name string) cache.WatchErrorHandler {
+	return func(_ *cache.Reflector, err error) {
+		log.Warningf("Failed to list or watch %s, retrying: %s", name, err)
+		ctrl.disconnected.Store(true)
+	}
+}
+
+// checkConnection clears the disconnected state once the API server responds
+func (ctrl *KubeController) checkConnection() {
+	if !ctrl.disconnected.Load() {
+		return
+	}
+	// the discovery client takes no context, so the check is abandoned
+	// instead once the controller is stopped
+	result := make(chan error, 1)
+	go func() {
+		_, err := ctrl.client.Discovery().ServerVersion()
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			log.Debugf("API server still unreachable: %s", err)
+			return
+		}
+	case <-ctrl.ctx.Done():
+		return
+	}
+	log.Infof("API server reachable again")
+	ctrl.disconnected.Store(false)
+}
+
+// isDisconnected reports whether the informers failed to reach the API server
+// since its last successful check, their caches may be out of date meanwhile
+func (ctrl *KubeController) isDisconnected() bool {
+	return ctrl.disconnected.Load()
+}
+
+// warnInactiveResources logs the configured resources that never resolve
+// because their CRD or API is unavailable
+func (ctrl *KubeController) warnInactiveResources() {
+	ctrl.mu.RLock()
+	defer ctrl.mu.RUnlock()
+
+	if len(ctrl.inactiveResources) > 0 {
+		log.Warningf("configured resources %v are inactive: their CRDs are not installed or not accessible", ctrl.inactiveResources)
+	}
+}
+
+// HasSynced returns true if all controllers have been synced
+func (ctrl *KubeController) HasSynced() bool {
+	return ctrl.hasSynced.Load()
+}
+
+// indexSummary describes how many objects every controller holds and whether
+// it has synced, one entry per controller sorted by name
+func (ctrl *KubeController) indexSummary() []string {
+	ctrl.mu.RLock()
+	defer ctrl.mu.RUnlock()
+
+	var summary []string
+	for _, name := range slices.Sorted(maps.Keys(ctrl.controllers)) {
+		informer := ctrl.controllers[name]
+		summary = append(summary, fmt.Sprintf("%s objects=%d synced=%t", name, len(informer.GetIndexer().ListKeys()), informer.HasSynced()))
+	}
+	return summary
+}
+
+// RunKubeController kicks off the k8s controllers, one per kubeconfig context
+func (gw *Gateway) RunKubeController(ctx context.Context) error {
+	kubeContexts := gw.configContexts
+	if len(kubeContexts) == 0 {
+		// the current context of the kubeconfig, or the in-cluster config
+		kubeContexts = []string{""}
+	}
+
+	for i, kubeContext := range kubeContexts {
+		config, err := gw.getClientConfig(kubeContext)
+		if err != nil {
+			return err
+		}
+
+		kubeClient, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+
+		gwAPIClient, err := gatewayClient.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+
+		var crds crdClients
+		crds.apiextensions, err = apiextensionsclientset.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+
+		crds.externaldns, err = newDNSEndpointClient(kubeClient, config)
+		if err != nil {
+			log.Warningf("crd %s not found. ignoring and continuing execution", externalDNSEndpointGroup)
+		}
+
+		crds.istio, err = istioClient.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			gw.Controller = newKubeController(ctx, kubeClient, gwAPIClient, crds, gw)
+			continue
+		}
+		log.Infof("Resolving names of kubeconfig context %s as well", kubeContext)
+		gw.clusters = append(gw.clusters, newClusterController(ctx, kubeClient, gwAPIClient, crds, gw, gw.clusterResources()))
+	}
+
+	for _, ctrl := range gw.controllers() {
+		go ctrl.run()
+	}
+	return nil
+}
+
+// newDNSEndpointClient returns a REST client for the external-dns DNSEndpoints
+// of a cluster, or an error if the cluster doesn't serve them
+func newDNSEndpointClient(kubeClient kubernetes.Interface, config *rest.Config) (rest.Interface, error) {
+	groupVersion, err := schema.ParseGroupVersion(externalDNSEndpointGroup)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := kubeClient.Discovery().ServerResourcesForGroupVersion(groupVersion.String())
+	if err != nil {
+		return nil, fmt.Errorf("error listing resources in GroupVersion %q: %w", groupVersion, err)
+	}
+	if !slices.ContainsFunc(resources.APIResources, func(resource metav1.APIResource) bool { return resource.Kind == externalDNSEndpointKind }) {
+		return nil, fmt.Errorf("unable to find Resource Kind %q in GroupVersion %q", externalDNSEndpointKind, groupVersion)
+	}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(groupVersion, &externaldnsv1.DNSEndpoint{}, &externaldnsv1.DNSEndpointList{})
+	metav1.AddToGroupVersion(scheme, groupVersion)
+
+	config = rest.CopyConfig(config)
+	config.GroupVersion = &groupVersion
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = serializer.WithoutConversionCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+	client, err := rest.UnversionedRESTClientFor(config)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func crdExists(ctx context.Context, clientset apiextensionsclientset.Interface, crdName string) bool {
+	_, err := clientset.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crdName, metav1.GetOptions{})
+	if err != nil {
+		log.Debugf("error getting crd %s, error: %s", crdName, err.Error())
+	} else {
+		log.Infof("crd %s found", crdName)
+	}
+	return err == nil
+}
+
+func (gw *Gateway) getClientConfig(kubeContext string) (*rest.Config, error) {
+	if gw.configFile != "" {
+		overrides := &clientcmd.ConfigOverrides{}
+		overrides.CurrentContext = kubeContext
+
+		config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: gw.configFile},
+			overrides,
+		)
+
+		return config.ClientConfig()
+	}
+
+	return rest.InClusterConfig()
+}
+
+func dereferenceStrings(ptrs []*string) []string {
+	var strs []string
+	for _, ptr := range ptrs {
+		if ptr != nil {
+			strs = append(strs, *ptr)
+		}
+	}
+	return strs
+}
+
+func httpRouteLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.GatewayV1().HTTPRoutes(ns).List(ctx, opts)
+	}
+}
+
+func tlsRouteLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.GatewayV1alpha2().TLSRoutes(ns).List(ctx, opts)
+	}
+}
+
+func grpcRouteLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.GatewayV1().GRPCRoutes(ns).List(ctx, opts)
+	}
+}
+
+func gatewayLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.GatewayV1().Gateways(ns).List(ctx, opts)
+	}
+}
+
+func referenceGrantLister(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.GatewayV1beta1().ReferenceGrants(ns).List(ctx, opts)
+	}
+}
+
+func ingressLister(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.NetworkingV1().Ingresses(ns).List(ctx, opts)
+	}
+}
+
+func serviceLister(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().Services(ns).List(ctx, opts)
+	}
+}
+
+func endpointSliceLister(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.DiscoveryV1().EndpointSlices(ns).List(ctx, opts)
+	}
+}
+
+func httpRouteWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.GatewayV1().HTTPRoutes(ns).Watch(ctx, opts)
+	}
+}
+
+func tlsRouteWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.GatewayV1alpha2().TLSRoutes(ns).Watch(ctx, opts)
+	}
+}
+
+func grpcRouteWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.GatewayV1().GRPCRoutes(ns).Watch(ctx, opts)
+	}
+}
+
+func referenceGrantWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.GatewayV1beta1().ReferenceGrants(ns).Watch(ctx, opts)
+	}
+}
+
+func gatewayWatcher(ctx context.Context, c gatewayClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.GatewayV1().Gateways(ns).Watch(ctx, opts)
+	}
+}
+
+func ingressWatcher(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.NetworkingV1().Ingresses(ns).Watch(ctx, opts)
+	}
+}
+
+func serviceWatcher(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.CoreV1().Services(ns).Watch(ctx, opts)
+	}
+}
+
+func nodeLister(ctx context.Context, c kubernetes.Interface) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().Nodes().List(ctx, opts)
+	}
+}
+
+func nodeWatcher(ctx context.Context, c kubernetes.Interface) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.CoreV1().Nodes().Watch(ctx, opts)
+	}
+}
+
+func endpointSliceWatcher(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.DiscoveryV1().EndpointSlices(ns).Watch(ctx, opts)
+	}
+}
+
+func virtualServiceLister(ctx context.Context, c istioClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.NetworkingV1beta1().VirtualServices(ns).List(ctx, opts)
+	}
+}
+
+func virtualServiceWatcher(ctx context.Context, c istioClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.NetworkingV1beta1().VirtualServices(ns).Watch(ctx, opts)
+	}
+}
+
+func istioGatewayLister(ctx context.Context, c istioClient.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.NetworkingV1beta1().Gateways(ns).List(ctx, opts)
+	}
+}
+
+func istioGatewayWatcher(ctx context.Context, c istioClient.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.NetworkingV1beta1().Gateways(ns).Watch(ctx, opts)
+	}
+}
+
+func dnsEndpointWatcher(ctx context.Context, c rest.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		opts.Watch = true
+		return c.Get().
+			Resource("dnsendpoints").
+			Namespace(ns).
+			VersionedParams(&opts, metav1.ParameterCodec).
+			Watch(ctx)
+	}
+}
+
+func dnsEndpointLister(ctx context.Context, c rest.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.Get().
+			Resource("dnsendpoints").
+			Namespace(ns).
+			VersionedParams(&opts, metav1.ParameterCodec).
+			Do(ctx).
+			Get()
+	}
+}
+
+// indexes based on "namespace/name" as the key
+func gatewayIndexFunc(obj interface{}) ([]string, error) {
+	metaObj, err := meta.Accessor(obj)
+	if err != nil {
+		return []string{""}, fmt.Errorf("object has no meta: %v", err)
+	}
+	return []string{fmt.Sprintf("%s/%s", metaObj.GetNamespace(), metaObj.GetName())}, nil
+}
+
+func gatewayHostnameIndexFunc(obj interface{}) ([]string, error) {
+	gateway, ok := obj.(*gatewayapi_v1.Gateway)
+	if !ok {
+		return []string{}, nil
+	}
+
+	annotation, exists := gateway.Annotations[hostnameAnnotationKey]
+	if !exists {
+		return []string{}, nil
+	}
+
+	var hostnames []string
+	for _, hostname := range splitHostnameAnnotation(annotation) {
+		hostname = normalizeHostname(hostname)
+		if checkDomainValid(hostname) {
+			log.Debugf("Adding index %s for gateway %s", hostname, gateway.Name)
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames, nil
+}
+
+func httpRouteHostnameIndexFunc(obj interface{}) ([]string, error) {
+	httpRoute, ok := obj.(*gatewayapi_v1.HTTPRoute)
+	if !ok {
+		return []string{}, nil
+	}
+
+	return routeHostnames("httpRoute", httpRoute.ObjectMeta, httpRoute.Spec.Hostnames), nil
+}
+
+func tlsRouteHostnameIndexFunc(obj interface{}) ([]string, error) {
+	tlsRoute, ok := obj.(*gatewayapi_v1alpha2.TLSRoute)
+	if !ok {
+		return []string{}, nil
+	}
+
+	return routeHostnames("tlsRoute", tlsRoute.ObjectMeta, tlsRoute.Spec.Hostnames), nil
+}
+
+func grpcRouteHostnameIndexFunc(obj interface{}) ([]string, error) {
+	grpcRoute, ok := obj.(*gatewayapi_v1.GRPCRoute)
+	if !ok {
+		return []string{}, nil
+	}
+
+	return routeHostnames("grpcRoute", grpcRoute.ObjectMeta, grpcRoute.Spec.Hostnames), nil
+}
+
+// routeHostnames returns the hostnames a route is published under, its spec
+// hostnames followed by the additional ones of its hostname annotation
+func routeHostnames(kind string, route metav1.ObjectMeta, specHostnames []gatewayapi_v1.Hostname) []string {
+	var hostnames []string
+	for _, hostname := range specHostnames {
+		log.Debugf("Adding index %s for %s %s", hostname, kind, route.Name)
+		hostnames = append(hostnames, normalizeHostname(string(hostname)))
+	}
+	annotated, _ := annotationHostnames(route.Annotations)
+	for _, hostname := range annotated {
+		if !slices.Contains(hostnames, hostname) {
+			log.Debugf("Adding index %s for %s %s from its annotation", hostname, kind, route.Name)
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames
+}
+
+// routeIndexFunc returns the hostname index function of a route resource, with
+// backendRefHostnames the routes are indexed under their Service backends too
+func (ctrl *KubeController) routeIndexFunc(indexFunc cache.IndexFunc) cache.IndexFunc {
+	if !ctrl.gateway.resourceFilters.backendRefHostnames {
+		return indexFunc
+	}
+	return func(obj interface{}) ([]string, error) {
+		hostnames, err := indexFunc(obj)
+		for _, hostname := range routeBackendHostnames(obj) {
+			if !slices.Contains(hostnames, hostname) {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+		return hostnames, err
+	}
+}
+
+// routeBackendHostnames returns the "name.namespace" hostnames of the Services
+// a route forwards to, the ones the Services themselves are published under
+func routeBackendHostnames(obj interface{}) (hostnames []string) {
+	var route metav1.ObjectMeta
+	var refs []gatewayapi_v1.BackendObjectReference
+	switch obj := obj.(type) {
+	case *gatewayapi_v1.HTTPRoute:
+		route = obj.ObjectMeta
+		for _, rule := range obj.Spec.Rules {
+			for _, ref := range rule.BackendRefs {
+				refs = append(refs, ref.BackendObjectReference)
+			}
+		}
+	case *gatewayapi_v1alpha2.TLSRoute:
+		route = obj.ObjectMeta
+		for _, rule := range obj.Spec.Rules {
+			for _, ref := range rule.BackendRefs {
+				refs = append(refs, ref.BackendObjectReference)
+			}
+		}
+	case *gatewayapi_v1.GRPCRoute:
+		route = obj.ObjectMeta
+		for _, rule := range obj.Spec.Rules {
+			for _, ref := range rule.BackendRefs {
+				refs = append(refs, ref.BackendObjectReference)
+			}
+		}
+	}
+
+	for _, ref := range refs {
+		// backends are Services unless they name another group or kind
+		if ptr.Deref(ref.Group, "") != "" || ptr.Deref(ref.Kind, "Service") != "Service" {
+			continue
+		}
+		namespace := string(ptr.Deref(ref.Namespace, gatewayapi_v1.Namespace(route.Namespace)))
+		hostname := normalizeHostname(string(ref.Name) + "." + namespace)
+		if !slices.Contains(hostnames, hostname) {
+			log.Debugf("Adding index %s for backend of route %s", hostname, route.Name)
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames
+}
+
+func ingressHostnameIndexFunc(filters ResourceFilters) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		ingress, ok := obj.(*networking.Ingress)
+		if !ok {
+			return []string{}, nil
+		}
+
+		if filters.readyIngressesOnly && !ingressReady(ingress, filters.ingressReadyAnnotation) {
+			log.Debugf("Skipping ingress %s/%s that isn't ready", ingress.Namespace, ingress.Name)
+			return []string{}, nil
+		}
+		return ingressHostnames(ingress), nil
+	}
+}
+
+// ingressReady reports whether an Ingress has a load balancer address and, if
+// an annotation key is given, carries that annotation set to "true"
+func ingressReady(ingress *networking.Ingress, annotation string) bool {
+	if len(ingress.Status.LoadBalancer.Ingress) == 0 {
+		return false
+	}
+	return annotation == "" || strings.EqualFold(strings.TrimSpace(ingress.Annotations[annotation]), "true")
+}
+
+func ingressHostnames(ingress *networking.Ingress) []string {
+	var hostnames []string
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" {
+			continue
+		}
+		log.Debugf("Adding index %s for ingress %s", rule.Host, ingress.Name)
+		hostnames = append(hostnames, normalizeHostname(rule.Host))
+	}
+
+	// TLS hosts may differ from the rule hosts, e.g. for TLS passthrough
+	for _, tls := range ingress.Spec.TLS {
+		for _, host := range tls.Hosts {
+			if hostname := normalizeHostname(host); hostname != "" && !slices.Contains(hostnames, hostname) {
+				log.Debugf("Adding index %s for TLS host of ingress %s", hostname, ingress.Name)
+				hostnames = append(hostnames, hostname)
+			}
+		}
+	}
+
+	// catch-all ingresses are only reachable under their annotated hostnames
+	if len(hostnames) == 0 && ingress.Spec.DefaultBackend != nil {
+		hostnames, _ = annotationHostnames(ingress.Annotations)
+		for _, hostname := range hostnames {
+			log.Debugf("Adding index %s for default backend of ingress %s", hostname, ingress.Name)
+		}
+	}
+	return hostnames
+}
+
+func serviceHostnameIndexFunc(filters ResourceFilters) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		service, ok := obj.(*core.Service)
+		if !ok {
+			return []string{}, nil
+		}
+
+		if !serviceSelected(service, filters) {
+			return []string{}, nil
+		}
+
+		hostnames := serviceHostnames(service)
+		if filters.indexLoadBalancerHostnames {
+			hostnames = append(hostnames, loadBalancerHostnames(service)...)
+		}
+		return hostnames, nil
+	}
+}
+
+// serviceSelected reports whether a Service is published at all
+func serviceSelected(service *core.Service, filters ResourceFilters) bool {
+	if !slices.Contains(filters.serviceTypes, string(service.Spec.Type)) {
+		return false
+	}
+	if filters.requireHostnameAnnotation {
+		if _, exists := annotationHostnames(service.Annotations); !exists {
+			return false
+		}
+	}
+	return true
+}
+
+func serviceHostnames(service *core.Service) []string {
+	hostnames, exists := annotationHostnames(service.Annotations)
+	if !exists {
+		return []string{service.Name + "." + service.Namespace}
+	}
+	for _, hostname := range hostnames {
+		log.Debugf("Adding index %s for service %s", hostname, service.Name)
+	}
+
+	return hostnames
+}
+
+// loadBalancerHostnames returns the valid hostnames assigned to a Service by its load balancer
+func loadBalancerHostnames(service *core.Service) (hostnames []string) {
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		hostname := normalizeHostname(ingress.Hostname)
+		if hostname != "" && checkDomainValid(hostname) {
+			log.Debugf("Adding index %s for service %s", hostname, service.Name)
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return
+}
+
+// annotationHostnames returns the valid hostnames listed in the coredns.io
+// hostname annotation or else in the external-dns one. Both annotations may
+// list several comma-separated hostnames.
+func annotationHostnames(annotations map[string]string) ([]string, bool) {
+	annotation, exists := annotations[hostnameAnnotationKey]
+	if !exists {
+		annotation, exists = annotations[externalDnsHostnameAnnotationKey]
+	}
+	if !exists {
+		return nil, false
+	}
+
+	hostnames := []string{}
+	for _, hostname := range splitHostnameAnnotation(annotation) {
+		hostname = normalizeHostname(hostname)
+		if checkDomainValid(hostname) {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames, true
+}
+
+// targetAnnotation parses the external-dns target annotation, a comma-separated
+// list of IPs and hostnames published instead of the Service's own addresses
+func targetAnnotation(annotations map[string]string) (addrs []netip.Addr, hostnames []string, exists bool) {
+	annotation, exists := annotations[externalDnsTargetAnnotationKey]
+	if !exists {
+		return nil, nil, false
+	}
+
+	for _, target := range splitHostnameAnnotation(strings.ToLower(annotation)) {
+		if addr, err := parseAddr(target); err == nil {
+			addrs = append(addrs, addr)
+			continue
+		}
+		target = strings.TrimSuffix(target, ".")
+		if !checkDomainValid(target) {
+			log.Warningf("Ignoring invalid target annotation value %q", target)
+			continue
+		}
+		hostnames = append(hostnames, target)
+	}
+	return addrs, hostnames, true
+}
+
+// indexes VirtualServices by their hosts
+func virtualServiceHostnameIndexFunc(obj interface{}) ([]string, error) {
+	virtualService, ok := obj.(*istio_v1beta1.VirtualService)
+	if !ok {
+		return []string{}, nil
+	}
+
+	var hostnames []string
+	for _, host := range virtualService.Spec.Hosts {
+		log.Debugf("Adding index %s for VirtualService %s", host, virtualService.Name)
+		hostnames = append(hostnames, normalizeHostname(host))
+	}
+	return hostnames, nil
+}
+
+// indexes services by every "key=value" pair of their pod selector
+func serviceSelectorIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*core.Service)
+	if !ok {
+		return []string{}, nil
+	}
+
+	var selectors []string
+	for key, value := range service.Spec.Selector {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", key, value))
+	}
+	return selectors, nil
+}
+
+// indexes services by the "namespace/name" of the Gateway they were generated
+// for, using the gateway-name label from GEP-1762
+func gatewayServiceIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*core.Service)
+	if !ok {
+		return []string{}, nil
+	}
+
+	if gatewayName, exists := service.Labels[gatewayNameLabelKey]; exists {
+		return []string{fmt.Sprintf("%s/%s", service.Namespace, gatewayName)}, nil
+	}
+	return []string{}, nil
+}
+
+// indexes headless services the same way as any other service
+func headlessServiceHostnameIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*core.Service)
+	if !ok {
+		return []string{}, nil
+	}
+
+	if service.Spec.ClusterIP != core.ClusterIPNone {
+		return []string{}, nil
+	}
+
+	return serviceHostnames(service), nil
+}
+
+// indexes endpointSlices based on "namespace/name" of the service they belong to
+func endpointSliceServiceIndexFunc(obj interface{}) ([]string, error) {
+	endpointSlice, ok := obj.(*discovery.EndpointSlice)
+	if !ok {
+		return []string{}, nil
+	}
+
+	serviceName, exists := endpointSlice.Labels[discovery.LabelServiceName]
+	if !exists {
+		return []string{}, nil
+	}
+	return []string{fmt.Sprintf("%s/%s", endpointSlice.Namespace, serviceName)}, nil
 }
 
 func splitHostnameAnnotation(annotation string) []string {
@@ -497,17 +1558,173 @@ func dnsEndpointTargetIndexFunc(obj interface{}) ([]string, error) {
 	var hostnames []string
 	for _, endpoint := range dnsEndpoint.Spec.Endpoints {
 		log.Debugf("Adding index %s for DNSEndpoint %s", endpoint.DNSName, dnsEndpoint.Name)
-		hostnames = append(hostnames, endpoint.DNSName)
+		hostnames = append(hostnames, normalizeHostname(endpoint.DNSName))
 	}
 	return hostnames, nil
 }
 
-func checkServiceAnnotation(annotation string, service *core.Service) (string, bool) {
-	if annotationValue, exists := service.Annotations[annotation]; exists {
-		return strings.ToLower(annotationValue), true
+// indexes ingresses by the IPs from their status
+func ingressAddressIndexFunc(obj interface{}) ([]string, error) {
+	ingress, ok := obj.(*networking.Ingress)
+	if !ok {
+		return []string{}, nil
+	}
+
+	var addrs []string
+	for _, address := range ingress.Status.LoadBalancer.Ingress {
+		if addr, err := parseAddr(address.IP); err == nil {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	return addrs, nil
+}
+
+// indexes services by their cluster IPs, external IPs or the IPs from their status
+func serviceAddressIndexFunc(filters ResourceFilters) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		service, ok := obj.(*core.Service)
+		if !ok {
+			return []string{}, nil
+		}
+
+		if !serviceSelected(service, filters) {
+			return []string{}, nil
+		}
+
+		var addrs []string
+		if targets, _, ok := targetAnnotation(service.Annotations); ok {
+			for _, addr := range targets {
+				addrs = append(addrs, addr.String())
+			}
+			return addrs, nil
+		}
+
+		if filters.serviceClusterIPs || service.Spec.Type == core.ServiceTypeClusterIP {
+			for _, addr := range fetchServiceClusterIPs(service) {
+				addrs = append(addrs, addr.String())
+			}
+			if service.Spec.Type == core.ServiceTypeClusterIP {
+				return addrs, nil
+			}
+			// zones without serviceClusterIPs answer with the addresses below
+		}
+
+		ips := service.Spec.ExternalIPs
+		if len(ips) == 0 {
+			for _, address := range service.Status.LoadBalancer.Ingress {
+				ips = append(ips, address.IP)
+			}
+		}
+
+		for _, ip := range ips {
+			if addr, err := parseAddr(ip); err == nil {
+				addrs = append(addrs, addr.String())
+			}
+		}
+		return addrs, nil
+	}
+}
+
+// indexes DNSEndpoints by the targets of their A and AAAA records
+func dnsEndpointAddressIndexFunc(obj interface{}) ([]string, error) {
+	dnsEndpoint, ok := obj.(*externaldnsv1.DNSEndpoint)
+	if !ok {
+		return []string{}, nil
+	}
+
+	var addrs []string
+	for _, endpoint := range dnsEndpoint.Spec.Endpoints {
+		switch strings.ToUpper(endpoint.RecordType) {
+		case "A", "AAAA":
+			for _, target := range endpoint.Targets {
+				if addr, err := parseAddr(target); err == nil {
+					addrs = append(addrs, addr.String())
+				}
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// parseTTLAnnotation reads the coredns.io TTL annotation, a number of seconds,
+// or else the external-dns one, which is either a number of seconds or a Go
+// duration string like "1m"
+func parseTTLAnnotation(annotations map[string]string) (uint32, bool) {
+	if value, exists := annotations[ttlAnnotationKey]; exists {
+		seconds, err := strconv.ParseUint(value, 10, 32)
+		if err == nil {
+			return uint32(seconds), true
+		}
+		log.Warningf("Ignoring invalid TTL annotation value %q", value)
+	}
+
+	value, exists := annotations[externalDnsTTLAnnotationKey]
+	if !exists {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return uint32(seconds), true
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 || duration.Seconds() > math.MaxUint32 {
+		log.Warningf("Ignoring invalid TTL annotation value %q", value)
+		return 0, false
+	}
+	return uint32(duration.Seconds()), true
+}
+
+// normalizeHostname lowercases a hostname and converts its internationalized
+// labels to punycode, so Unicode and ASCII forms of a name match the same
+// index keys. Labels that aren't valid IDNs are only lowercased.
+func normalizeHostname(hostname string) string {
+	hostname = unescapeLabels(hostname)
+	if isASCII(hostname) {
+		// most names need no IDNA conversion, lowercasing doesn't allocate if they are already
+		return strings.ToLower(hostname)
+	}
+	labels := strings.Split(strings.ToLower(hostname), ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		ascii, err := idna.Lookup.ToASCII(label)
+		if err != nil {
+			log.Debugf("Ignoring invalid IDN label %q: %v", label, err)
+			continue
+		}
+		labels[i] = ascii
+	}
+	return strings.Join(labels, ".")
+}
+
+// unescapeLabels decodes the \DDD escapes of non-ASCII bytes in a query name
+func unescapeLabels(name string) string {
+	if !strings.Contains(name, "\\") {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) {
+			if n, err := strconv.ParseUint(name[i+1:i+4], 10, 8); err == nil && n >= 0x80 {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(name[i])
 	}
+	return b.String()
+}
 
-	return "", false
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
 }
 
 func checkDomainValid(domain string) bool {
@@ -523,202 +1740,830 @@ func checkDomainValid(domain string) bool {
 	return false
 }
 
-func lookupServiceIndex(ctrl cache.SharedIndexInformer) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+// parseWeightAnnotation reads the coredns.io weight annotation, a
+// non-negative integer
+func parseWeightAnnotation(annotations map[string]string) (uint32, bool) {
+	value, exists := annotations[weightAnnotationKey]
+	if !exists {
+		return 0, false
+	}
+	weight, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		log.Warningf("Ignoring invalid weight annotation value %q", value)
+		return 0, false
+	}
+	return uint32(weight), true
+}
+
+// parseALPNAnnotation reads the coredns.io alpn annotation, a comma-separated
+// list of the protocols advertised in HTTPS answers, e.g. "h2,http/1.1"
+func parseALPNAnnotation(annotations map[string]string) []string {
+	value, exists := annotations[alpnAnnotationKey]
+	if !exists {
+		return nil
+	}
+	var alpn []string
+	for _, protocol := range splitHostnameAnnotation(value) {
+		if protocol == "" || len(protocol) > 255 {
+			log.Warningf("Ignoring invalid ALPN annotation protocol %q", protocol)
+			continue
+		}
+		alpn = append(alpn, protocol)
+	}
+	return alpn
+}
+
+// parsePriorityAnnotation reads the coredns.io priority annotation, a
+// comma-separated list of ADDRESS=PRIORITY pairs, e.g. "192.0.2.1=0,192.0.2.2=10"
+func parsePriorityAnnotation(annotations map[string]string) map[netip.Addr]uint32 {
+	value, exists := annotations[priorityAnnotationKey]
+	if !exists {
+		return nil
+	}
+	priorities := make(map[netip.Addr]uint32)
+	for _, pair := range splitHostnameAnnotation(value) {
+		ip, rawPriority, found := strings.Cut(pair, "=")
+		addr, err := parseAddr(ip)
+		if !found || err != nil {
+			log.Warningf("Ignoring invalid priority annotation entry %q", pair)
+			continue
+		}
+		priority, err := strconv.ParseUint(rawPriority, 10, 32)
+		if err != nil {
+			log.Warningf("Ignoring invalid priority annotation entry %q", pair)
+			continue
+		}
+		priorities[addr] = uint32(priority)
+	}
+	return priorities
+}
+
+// parseTXTAnnotation reads the coredns.io txt annotation, a comma or newline
+// separated list of TXT record values, e.g. domain verification tokens
+func parseTXTAnnotation(annotations map[string]string) (values []string) {
+	value, exists := annotations[txtAnnotationKey]
+	if !exists {
+		return nil
+	}
+	for _, txt := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if txt = strings.TrimSpace(txt); txt != "" {
+			values = append(values, txt)
+		}
+	}
+	return values
+}
+
+// resolveHostnameConflicts applies a hostnameConflicts policy to the objects
+// matching a hostname: "first" keeps the namespace of the oldest object, and
+// "reject" drops all of them if they span several namespaces
+func resolveHostnameConflicts(objs []interface{}, policy string) []interface{} {
+	if len(objs) < 2 || (policy != conflictFirst && policy != conflictReject) {
+		return objs
+	}
+	var oldest metav1.Object
+	namespaces := make(map[string]struct{})
+	for _, obj := range objs {
+		metaObj, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		namespaces[metaObj.GetNamespace()] = struct{}{}
+		if oldest == nil {
+			oldest = metaObj
+			continue
+		}
+		// objects created in the same second are ordered by namespace to stay deterministic
+		created, oldestCreated := metaObj.GetCreationTimestamp(), oldest.GetCreationTimestamp()
+		if created.Before(&oldestCreated) || (created.Equal(&oldestCreated) && metaObj.GetNamespace() < oldest.GetNamespace()) {
+			oldest = metaObj
+		}
+	}
+	if len(namespaces) < 2 {
+		return objs
+	}
+	if policy == conflictReject {
+		log.Warningf("Rejecting hostname claimed by objects in namespaces %v", slices.Sorted(maps.Keys(namespaces)))
+		return nil
+	}
+	return slices.DeleteFunc(objs, func(obj interface{}) bool {
+		metaObj, err := meta.Accessor(obj)
+		return err != nil || metaObj.GetNamespace() != oldest.GetNamespace()
+	})
+}
+
+func lookupServiceIndex(ctrl, nodes, endpointSlices cache.SharedIndexInformer, filters ResourceFilters) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
+		filters := queryFilters(ctx, filters)
 		var objs []interface{}
 		for _, key := range indexKeys {
-			obj, _ := ctrl.GetIndexer().ByIndex(serviceHostnameIndex, strings.ToLower(key))
+			obj, _ := ctrl.GetIndexer().ByIndex(serviceHostnameIndex, normalizeHostname(key))
 			objs = append(objs, obj...)
 		}
 		log.Debugf("Found %d matching Service objects", len(objs))
-		for _, obj := range objs {
+		// the index holds the services of every zone
+		objs = slices.DeleteFunc(objs, func(obj interface{}) bool {
+			service, _ := obj.(*core.Service)
+			if !serviceSelected(service, filters) {
+				log.Debugf("Skipping service of type '%s'", service.Spec.Type)
+				result.filtered = true
+				return true
+			}
+			return false
+		})
+		for _, obj := range resolveHostnameConflicts(objs, filters.hostnameConflicts) {
 			service, _ := obj.(*core.Service)
 
-			if len(service.Spec.ExternalIPs) > 0 {
+			if ttl, ok := parseTTLAnnotation(service.Annotations); ok {
+				result.setTTL(ttl)
+			}
+			result.addRecords("TXT", parseTXTAnnotation(service.Annotations)...)
+
+			var addrs lookupResult
+			targets, targetHostnames, hasTargets := targetAnnotation(service.Annotations)
+			externalIPs := false
+			switch {
+			case hasTargets:
+				addrs.addrs = targets
+				for _, hostname := range targetHostnames {
+					addrs.merge(fetchHostnameIPs(ctx, filters.resolver, "Service", hostname))
+				}
+			case filters.serviceClusterIPs || service.Spec.Type == core.ServiceTypeClusterIP:
+				addrs.addrs = fetchServiceClusterIPs(service)
+			case len(service.Spec.ExternalIPs) > 0:
 				for _, ip := range service.Spec.ExternalIPs {
-					result = append(result, netip.MustParseAddr(ip))
+					addrs.addrs = append(addrs.addrs, netip.MustParseAddr(ip).Unmap())
 				}
+				if filters.mergeExternalIPs {
+					// e.g. a static IPv4 external IP next to an IPv6 status address
+					status := fetchServiceLoadBalancerIPs(ctx, service.Status.LoadBalancer.Ingress, filters)
+					status.addrs = slices.DeleteFunc(status.addrs, func(addr netip.Addr) bool {
+						return slices.Contains(addrs.addrs, addr)
+					})
+					addrs.merge(status)
+					break
+				}
+				externalIPs = true
+			case filters.nodeAddressType != "" && service.Spec.Type == core.ServiceTypeNodePort:
+				addrs.addrs = fetchServiceNodeIPs(nodes, endpointSlices, service, core.NodeAddressType(filters.nodeAddressType))
+			case filters.localPolicyAddressType != "" && service.Spec.ExternalTrafficPolicy == core.ServiceExternalTrafficPolicyLocal &&
+				(service.Spec.Type == core.ServiceTypeLoadBalancer || service.Spec.Type == core.ServiceTypeNodePort):
+				// only nodes running a pod of the Service accept its external traffic
+				addrs.addrs = fetchServiceNodeIPs(nodes, endpointSlices, service, core.NodeAddressType(filters.localPolicyAddressType))
+			default:
+				addrs = fetchServiceLoadBalancerIPs(ctx, service.Status.LoadBalancer.Ingress, filters)
+			}
+
+			if weight, ok := parseWeightAnnotation(service.Annotations); ok {
+				addrs.setWeight(weight)
+			}
+			addrs.setPriorities(parsePriorityAnnotation(service.Annotations))
+			if region := strings.TrimSpace(service.Annotations[regionAnnotationKey]); region != "" {
+				addrs.setRegion(region)
+			}
+			result.merge(addrs)
+
+			if externalIPs {
 				// in case externalIPs are defined, ignoring status field completely
 				return
 			}
+		}
+		return
+	}
+}
+
+func reverseLookupServiceIndex(ctrl cache.SharedIndexInformer) func(netip.Addr) []string {
+	return func(addr netip.Addr) (result []string) {
+		objs, _ := ctrl.GetIndexer().ByIndex(serviceAddressIndex, addr.String())
+		log.Debugf("Found %d matching Service objects for %s", len(objs), addr)
+		for _, obj := range objs {
+			service, _ := obj.(*core.Service)
+			result = append(result, serviceHostnames(service)...)
+		}
+		return
+	}
+}
+
+func lookupEndpointsIndex(svc, endpointSlices cache.SharedIndexInformer) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
+		var objs []interface{}
+		for _, key := range indexKeys {
+			obj, _ := svc.GetIndexer().ByIndex(headlessServiceHostnameIndex, normalizeHostname(key))
+			objs = append(objs, obj...)
+		}
+		log.Debugf("Found %d matching headless Service objects", len(objs))
+		for _, obj := range objs {
+			service, _ := obj.(*core.Service)
+
+			sliceObjs, _ := endpointSlices.GetIndexer().ByIndex(endpointSliceServiceIndex, fmt.Sprintf("%s/%s", service.Namespace, service.Name))
+			log.Debugf("Found %d matching EndpointSlice objects", len(sliceObjs))
+			for _, sliceObj := range sliceObjs {
+				endpointSlice, _ := sliceObj.(*discovery.EndpointSlice)
+				result.addrs = append(result.addrs, fetchEndpointSliceIPs(endpointSlice)...)
+			}
+		}
+		return
+	}
+}
+
+func lookupVirtualServiceIndex(vs, gw, svc cache.SharedIndexInformer, filters ResourceFilters) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
+		var objs []interface{}
+		for _, key := range indexKeys {
+			obj, _ := vs.GetIndexer().ByIndex(virtualServiceHostnameIndex, normalizeHostname(key))
+			objs = append(objs, obj...)
+		}
+		log.Debugf("Found %d matching VirtualService objects", len(objs))
+
+		for _, obj := range objs {
+			virtualService, _ := obj.(*istio_v1beta1.VirtualService)
+			result.merge(lookupIstioGateways(ctx, gw, svc, virtualService.Spec.Gateways, virtualService.Namespace, filters))
+		}
+		return
+	}
+}
+
+// lookupIstioGateways resolves the Istio Gateways referenced by a VirtualService
+// to the LoadBalancer IPs of the Services selecting their gateway pods
+func lookupIstioGateways(ctx context.Context, gw, svc cache.SharedIndexInformer, refs []string, ns string, filters ResourceFilters) (result lookupResult) {
+	for _, gwRef := range refs {
+		// the reserved "mesh" gateway stands for sidecars, not an ingress
+		if gwRef == "mesh" {
+			continue
+		}
+
+		gwKey := gwRef
+		if !strings.Contains(gwRef, "/") {
+			gwKey = fmt.Sprintf("%s/%s", ns, gwRef)
+		}
+
+		gwObjs, _ := gw.GetIndexer().ByIndex(gatewayUniqueIndex, gwKey)
+		log.Debugf("Found %d matching Istio gateway objects", len(gwObjs))
+
+		for _, gwObj := range gwObjs {
+			istioGateway, _ := gwObj.(*istio_v1beta1.Gateway)
+			selector := istioGateway.Spec.Selector
+			if len(selector) == 0 {
+				continue
+			}
+
+			var svcObjs []interface{}
+			for key, value := range selector {
+				svcObjs, _ = svc.GetIndexer().ByIndex(serviceSelectorIndex, fmt.Sprintf("%s=%s", key, value))
+				break
+			}
 
-			result = append(result, fetchServiceLoadBalancerIPs(service.Status.LoadBalancer.Ingress)...)
+			for _, svcObj := range svcObjs {
+				service, _ := svcObj.(*core.Service)
+				if !labels.SelectorFromSet(selector).Matches(labels.Set(service.Spec.Selector)) {
+					continue
+				}
+				result.merge(fetchServiceLoadBalancerIPs(ctx, service.Status.LoadBalancer.Ingress, filters))
+			}
 		}
-		return
 	}
+	return
 }
 
-func lookupHttpRouteIndex(http, gw cache.SharedIndexInformer, gwclasses []string) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+func lookupHttpRouteIndex(http, gw, svc, grants cache.SharedIndexInformer, filters ResourceFilters) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
-			obj, _ := http.GetIndexer().ByIndex(httpRouteHostnameIndex, strings.ToLower(key))
+			obj, _ := http.GetIndexer().ByIndex(httpRouteHostnameIndex, normalizeHostname(key))
 			objs = append(objs, obj...)
 		}
 		log.Debugf("Found %d matching httpRoute objects", len(objs))
 
 		for _, obj := range objs {
 			httpRoute, _ := obj.(*gatewayapi_v1.HTTPRoute)
-			result = append(result, lookupGateways(gw, httpRoute.Spec.ParentRefs, httpRoute.Namespace, gwclasses)...)
+			addrs := lookupGateways(ctx, gw, svc, "HTTPRoute", grantedParentRefs(grants, "HTTPRoute", httpRoute.Namespace, httpRoute.Spec.ParentRefs), routeStatus(httpRoute.Status.RouteStatus, filters), httpRoute.Namespace, filters)
+			addrs.alpn = parseALPNAnnotation(httpRoute.Annotations)
+			result.merge(addrs)
 		}
 		return
 	}
 }
 
-func lookupTLSRouteIndex(tls, gw cache.SharedIndexInformer, gwclasses []string) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+func lookupTLSRouteIndex(tls, gw, svc, grants cache.SharedIndexInformer, filters ResourceFilters) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
-			obj, _ := tls.GetIndexer().ByIndex(tlsRouteHostnameIndex, strings.ToLower(key))
+			obj, _ := tls.GetIndexer().ByIndex(tlsRouteHostnameIndex, normalizeHostname(key))
 			objs = append(objs, obj...)
 		}
 		log.Debugf("Found %d matching tlsRoute objects", len(objs))
 
 		for _, obj := range objs {
 			tlsRoute, _ := obj.(*gatewayapi_v1alpha2.TLSRoute)
-			result = append(result, lookupGateways(gw, tlsRoute.Spec.ParentRefs, tlsRoute.Namespace, gwclasses)...)
+			addrs := lookupGateways(ctx, gw, svc, "TLSRoute", grantedParentRefs(grants, "TLSRoute", tlsRoute.Namespace, tlsRoute.Spec.ParentRefs), routeStatus(tlsRoute.Status.RouteStatus, filters), tlsRoute.Namespace, filters)
+			addrs.alpn = parseALPNAnnotation(tlsRoute.Annotations)
+			result.merge(addrs)
 		}
 		return
 	}
 }
 
-func lookupGRPCRouteIndex(grpc, gw cache.SharedIndexInformer, gwclasses []string) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+func lookupGRPCRouteIndex(grpc, gw, svc, grants cache.SharedIndexInformer, filters ResourceFilters) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
-			obj, _ := grpc.GetIndexer().ByIndex(grpcRouteHostnameIndex, strings.ToLower(key))
+			obj, _ := grpc.GetIndexer().ByIndex(grpcRouteHostnameIndex, normalizeHostname(key))
 			objs = append(objs, obj...)
 		}
 		log.Debugf("Found %d matching grpcRoute objects", len(objs))
 
 		for _, obj := range objs {
 			grpcRoute, _ := obj.(*gatewayapi_v1.GRPCRoute)
-			result = append(result, lookupGateways(gw, grpcRoute.Spec.ParentRefs, grpcRoute.Namespace, gwclasses)...)
+			addrs := lookupGateways(ctx, gw, svc, "GRPCRoute", grantedParentRefs(grants, "GRPCRoute", grpcRoute.Namespace, grpcRoute.Spec.ParentRefs), routeStatus(grpcRoute.Status.RouteStatus, filters), grpcRoute.Namespace, filters)
+			addrs.alpn = parseALPNAnnotation(grpcRoute.Annotations)
+			result.merge(addrs)
 		}
 		return
 	}
 }
 
-func lookupGateways(gw cache.SharedIndexInformer, refs []gatewayapi_v1.ParentReference, ns string, gwclasses []string) (result []netip.Addr) {
+// routeStatus returns the status to check parent attachments against, or nil
+// when routes are resolved regardless of whether they were accepted
+func routeStatus(status gatewayapi_v1.RouteStatus, filters ResourceFilters) *gatewayapi_v1.RouteStatus {
+	if !filters.acceptedRoutesOnly {
+		return nil
+	}
+	return &status
+}
+
+// routeAccepted reports whether the route status has an Accepted=True
+// condition for the parent Gateway in the given namespace
+func routeAccepted(status *gatewayapi_v1.RouteStatus, gwRef gatewayapi_v1.ParentReference, ns, routeNs string) bool {
+	for _, parent := range status.Parents {
+		parentNs := routeNs
+		if parent.ParentRef.Namespace != nil {
+			parentNs = string(*parent.ParentRef.Namespace)
+		}
+		if parent.ParentRef.Name != gwRef.Name || parentNs != ns {
+			continue
+		}
+		if meta.IsStatusConditionTrue(parent.Conditions, string(gatewayapi_v1.RouteConditionAccepted)) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantedParentRefs drops the parent refs to Gateways in other namespaces
+// that no ReferenceGrant allows the route to attach to. All refs are kept
+// when ReferenceGrants aren't required.
+func grantedParentRefs(grants cache.SharedIndexInformer, kind, ns string, refs []gatewayapi_v1.ParentReference) []gatewayapi_v1.ParentReference {
+	if grants == nil {
+		return refs
+	}
+
+	var granted []gatewayapi_v1.ParentReference
+	for _, ref := range refs {
+		if ref.Namespace == nil || string(*ref.Namespace) == ns || referenceGranted(grants, kind, ns, string(*ref.Namespace), string(ref.Name)) {
+			granted = append(granted, ref)
+			continue
+		}
+		log.Debugf("Skipping gateway %s/%s without a ReferenceGrant for %s in %s", *ref.Namespace, ref.Name, kind, ns)
+	}
+	return granted
+}
+
+// referenceGranted reports whether a ReferenceGrant in the Gateway namespace
+// allows routes of the kind in the given namespace to refer to the Gateway
+func referenceGranted(grants cache.SharedIndexInformer, kind, fromNs, gwNs, gwName string) bool {
+	objs, _ := grants.GetIndexer().ByIndex(cache.NamespaceIndex, gwNs)
+	for _, obj := range objs {
+		grant, _ := obj.(*gatewayapi_v1beta1.ReferenceGrant)
+
+		fromRoute := slices.ContainsFunc(grant.Spec.From, func(from gatewayapi_v1beta1.ReferenceGrantFrom) bool {
+			return from.Group == gatewayapi_v1.GroupName && string(from.Kind) == kind && string(from.Namespace) == fromNs
+		})
+		toGateway := slices.ContainsFunc(grant.Spec.To, func(to gatewayapi_v1beta1.ReferenceGrantTo) bool {
+			return to.Group == gatewayapi_v1.GroupName && to.Kind == "Gateway" && (to.Name == nil || string(*to.Name) == gwName)
+		})
+		if fromRoute && toGateway {
+			return true
+		}
+	}
+	return false
+}
+
+// routeListenerProtocols are the listener protocols each route kind can attach to
+var routeListenerProtocols = map[string][]gatewayapi_v1.ProtocolType{
+	"HTTPRoute": {gatewayapi_v1.HTTPProtocolType, gatewayapi_v1.HTTPSProtocolType},
+	"GRPCRoute": {gatewayapi_v1.HTTPProtocolType, gatewayapi_v1.HTTPSProtocolType},
+	"TLSRoute":  {gatewayapi_v1.TLSProtocolType, gatewayapi_v1.TCPProtocolType},
+}
+
+// gatewayServesRoute reports whether a Gateway has a listener, or the one named
+// by the parent reference, whose protocol is compatible with the route kind.
+// Gateways without any listeners, which the API doesn't admit, aren't checked.
+func gatewayServesRoute(gw *gatewayapi_v1.Gateway, kind string, gwRef gatewayapi_v1.ParentReference) bool {
+	protocols, ok := routeListenerProtocols[kind]
+	if !ok || len(gw.Spec.Listeners) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(gw.Spec.Listeners, func(listener gatewayapi_v1.Listener) bool {
+		if gwRef.SectionName != nil && listener.Name != *gwRef.SectionName {
+			return false
+		}
+		return slices.Contains(protocols, listener.Protocol)
+	})
+}
+
+func lookupGateways(ctx context.Context, gw, svc cache.SharedIndexInformer, kind string, refs []gatewayapi_v1.ParentReference, status *gatewayapi_v1.RouteStatus, ns string, filters ResourceFilters) (result lookupResult) {
+	// a route can reference the same Gateway once per listener
+	seen := make(map[string]bool)
 	for _, gwRef := range refs {
 
+		gwNs := ns
 		if gwRef.Namespace != nil {
-			ns = string(*gwRef.Namespace)
+			gwNs = string(*gwRef.Namespace)
 		}
-		gwKey := fmt.Sprintf("%s/%s", ns, gwRef.Name)
+		gwKey := fmt.Sprintf("%s/%s", gwNs, gwRef.Name)
 
+		if status != nil && !routeAccepted(status, gwRef, gwNs, ns) {
+			log.Debugf("Skipping gateway %s that hasn't accepted the route", gwKey)
+			continue
+		}
 		gwObjs, _ := gw.GetIndexer().ByIndex(gatewayUniqueIndex, gwKey)
 		log.Debugf("Found %d matching gateway objects", len(gwObjs))
 
 		for _, gwObj := range gwObjs {
 			gw, _ := gwObj.(*gatewayapi_v1.Gateway)
-
-			if len(gwclasses) > 0 && !slices.Contains(gwclasses, string(gw.Spec.GatewayClassName)) {
-				log.Debugf("Skipping gateway of '%s' gatewayClass", string(gw.Spec.GatewayClassName))
+			if !gatewayServesRoute(gw, kind, gwRef) {
+				log.Debugf("Skipping gateway %s without a listener for %s", gwKey, kind)
 				continue
 			}
-
-			result = append(result, fetchGatewayIPs(gw)...)
+			if seen[gwKey] {
+				continue
+			}
+			seen[gwKey] = true
+			result.merge(gatewayAddresses(ctx, svc, gw, filters))
 		}
 	}
 	return
 }
 
-func lookupIngressIndex(ctrl cache.SharedIndexInformer, ingclasses []string) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+// gatewayAddresses returns the addresses of a Gateway of an allowed class
+func gatewayAddresses(ctx context.Context, svc cache.SharedIndexInformer, gw *gatewayapi_v1.Gateway, filters ResourceFilters) (result lookupResult) {
+	filters = queryFilters(ctx, filters)
+	if len(filters.gatewayClasses) > 0 && !slices.Contains(filters.gatewayClasses, string(gw.Spec.GatewayClassName)) {
+		log.Debugf("Skipping gateway of '%s' gatewayClass", string(gw.Spec.GatewayClassName))
+		result.filtered = true
+		return
+	}
+
+	if filters.programmedGatewaysOnly && !gatewayProgrammed(gw) {
+		log.Debugf("Skipping gateway %s/%s that isn't accepted and programmed", gw.Namespace, gw.Name)
+		return
+	}
+
+	result = fetchGatewayIPs(ctx, gw, filters.resolver)
+	if len(result.addrs) == 0 && filters.gatewayAddressAnnotation != "" {
+		result = fetchGatewayAnnotationIPs(gw, filters.gatewayAddressAnnotation)
+	}
+	if len(result.addrs) == 0 {
+		// some implementations only publish the address on the Service backing the Gateway
+		result = fetchGatewayServiceIPs(ctx, svc, gw, filters)
+	}
+	return
+}
+
+// gatewayProgrammed reports whether the Gateway status has Accepted=True and
+// Programmed=True conditions, i.e. its data plane is ready
+func gatewayProgrammed(gw *gatewayapi_v1.Gateway) bool {
+	return meta.IsStatusConditionTrue(gw.Status.Conditions, string(gatewayapi_v1.GatewayConditionAccepted)) &&
+		meta.IsStatusConditionTrue(gw.Status.Conditions, string(gatewayapi_v1.GatewayConditionProgrammed))
+}
+
+// lookupGatewayHostnameIndex resolves Gateways by their hostname annotation
+func lookupGatewayHostnameIndex(gw, svc cache.SharedIndexInformer, filters ResourceFilters) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
+		var objs []interface{}
+		for _, key := range indexKeys {
+			obj, _ := gw.GetIndexer().ByIndex(gatewayHostnameIndex, normalizeHostname(key))
+			objs = append(objs, obj...)
+		}
+		log.Debugf("Found %d matching annotated Gateway objects", len(objs))
+
+		for _, obj := range objs {
+			gateway, _ := obj.(*gatewayapi_v1.Gateway)
+			result.merge(gatewayAddresses(ctx, svc, gateway, filters))
+		}
+		return
+	}
+}
+
+// lookupWithFallback consults fallback when primary has no records
+func lookupWithFallback(primary, fallback lookupFunc) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) lookupResult {
+		result := primary(ctx, indexKeys)
+		if !result.isEmpty() {
+			return result
+		}
+		result.merge(fallback(ctx, indexKeys))
+		return result
+	}
+}
+
+func lookupIngressIndex(ctrl cache.SharedIndexInformer, filters ResourceFilters) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
+		filters := queryFilters(ctx, filters)
 		var objs []interface{}
 		for _, key := range indexKeys {
-			obj, _ := ctrl.GetIndexer().ByIndex(ingressHostnameIndex, strings.ToLower(key))
+			obj, _ := ctrl.GetIndexer().ByIndex(ingressHostnameIndex, normalizeHostname(key))
 			objs = append(objs, obj...)
 		}
 		log.Debugf("Found %d matching Ingress objects", len(objs))
+		// conflicts are only resolved between ingresses that aren't filtered out
+		objs = slices.DeleteFunc(objs, func(obj interface{}) bool {
+			ingress, _ := obj.(*networking.Ingress)
+			if className := ptr.Deref(ingress.Spec.IngressClassName, ""); len(filters.ingressClasses) > 0 && !slices.Contains(filters.ingressClasses, className) {
+				log.Debugf("Skipping ingress of '%s' ingressClass", className)
+				result.filtered = true
+				return true
+			}
+			return false
+		})
+		for _, obj := range resolveHostnameConflicts(objs, filters.hostnameConflicts) {
+			ingress, _ := obj.(*networking.Ingress)
+
+			if ttl, ok := parseTTLAnnotation(ingress.Annotations); ok {
+				result.setTTL(ttl)
+			}
+			result.addRecords("TXT", parseTXTAnnotation(ingress.Annotations)...)
+
+			addrs := fetchIngressLoadBalancerIPs(ctx, ingress.Status.LoadBalancer.Ingress, filters)
+			if weight, ok := parseWeightAnnotation(ingress.Annotations); ok {
+				addrs.setWeight(weight)
+			}
+			addrs.setPriorities(parsePriorityAnnotation(ingress.Annotations))
+			addrs.alpn = parseALPNAnnotation(ingress.Annotations)
+			result.merge(addrs)
+		}
+
+		return
+	}
+}
+
+func reverseLookupIngressIndex(ctrl cache.SharedIndexInformer, ingclasses []string) func(netip.Addr) []string {
+	return func(addr netip.Addr) (result []string) {
+		objs, _ := ctrl.GetIndexer().ByIndex(ingressAddressIndex, addr.String())
+		log.Debugf("Found %d matching Ingress objects for %s", len(objs), addr)
 		for _, obj := range objs {
 			ingress, _ := obj.(*networking.Ingress)
 
-			if len(ingclasses) > 0 && !slices.Contains(ingclasses, *ingress.Spec.IngressClassName) {
-				log.Debugf("Skipping ingress of '%s' ingressClass", *ingress.Spec.IngressClassName)
+			if className := ptr.Deref(ingress.Spec.IngressClassName, ""); len(ingclasses) > 0 && !slices.Contains(ingclasses, className) {
+				log.Debugf("Skipping ingress of '%s' ingressClass", className)
 				continue
 			}
 
-			result = append(result, fetchIngressLoadBalancerIPs(ingress.Status.LoadBalancer.Ingress)...)
+			result = append(result, ingressHostnames(ingress)...)
 		}
-
 		return
 	}
 }
 
-func lookupDNSEndpoint(ctrl cache.SharedIndexInformer) func([]string) (results []netip.Addr) {
-	return func(indexKeys []string) (result []netip.Addr) {
-		var objs []interface{}
-		for _, key := range indexKeys {
-			obj, _ := ctrl.GetIndexer().ByIndex(externalDNSHostnameIndex, strings.ToLower(key))
-			objs = append(objs, obj...)
+// dnsEndpointRecords add the targets of DNSEndpoint record types that aren't
+// kept as raw data to a lookup result, the targets of the other types
+// answered by recordBuilders are added as is and the rest are ignored
+var dnsEndpointRecords = map[string]func(result *lookupResult, targets []string){
+	"A":    addDNSEndpointAddrs,
+	"AAAA": addDNSEndpointAddrs,
+	"CNAME": func(result *lookupResult, targets []string) {
+		// answered as is, unlike load balancer hostnames they aren't resolved
+		result.addRecords("CNAME", targets...)
+	},
+}
+
+func addDNSEndpointAddrs(result *lookupResult, targets []string) {
+	for _, target := range targets {
+		addr, err := parseAddr(target)
+		if err != nil {
+			continue
+		}
+		result.addrs = append(result.addrs, addr)
+	}
+}
+
+func lookupDNSEndpoint(ctrl cache.SharedIndexInformer) lookupFunc {
+	return func(ctx context.Context, indexKeys []string) (result lookupResult) {
+		objs := lookupDNSEndpointObjects(ctrl, indexKeys)
+		// wildcards match names any number of labels below them, the closest one wins
+		for len(objs) == 0 {
+			if indexKeys = parentWildcards(indexKeys); len(indexKeys) == 0 {
+				break
+			}
+			objs = lookupDNSEndpointObjects(ctrl, indexKeys)
 		}
 		log.Debugf("Found %d matching DNSEndpoint objects", len(objs))
+		// an object is found once per matching key, and may define other names as well
+		seen := make(map[*externaldnsv1.DNSEndpoint]bool, len(objs))
+		for _, obj := range objs {
+			dnsEndpoint, _ := obj.(*externaldnsv1.DNSEndpoint)
+			if seen[dnsEndpoint] {
+				continue
+			}
+			seen[dnsEndpoint] = true
+
+			for _, endpoint := range dnsEndpoint.Spec.Endpoints {
+				name := normalizeHostname(endpoint.DNSName)
+				if !slices.ContainsFunc(indexKeys, func(key string) bool { return normalizeHostname(key) == name }) {
+					continue
+				}
+				// record types are matched case-insensitively, e.g. "a" or "Aaaa"
+				recordType := strings.ToUpper(endpoint.RecordType)
+				if add, ok := dnsEndpointRecords[recordType]; ok {
+					add(&result, endpoint.Targets)
+				} else if _, ok := recordBuilders[dns.StringToType[recordType]]; ok {
+					result.addRecords(recordType, endpoint.Targets...)
+				}
+			}
+		}
+		return result
+	}
+}
+
+func lookupDNSEndpointObjects(ctrl cache.SharedIndexInformer, indexKeys []string) (objs []interface{}) {
+	for _, key := range indexKeys {
+		obj, _ := ctrl.GetIndexer().ByIndex(externalDNSHostnameIndex, normalizeHostname(key))
+		objs = append(objs, obj...)
+	}
+	return
+}
+
+// parentWildcards returns the wildcards one label above the wildcard keys,
+// e.g. `*.apps.example.com` for `*.z.apps.example.com`. Other keys are dropped.
+func parentWildcards(indexKeys []string) (parents []string) {
+	for _, key := range indexKeys {
+		rest, ok := strings.CutPrefix(key, "*.")
+		if !ok {
+			continue
+		}
+		if _, parent, found := strings.Cut(rest, "."); found {
+			parents = append(parents, "*."+parent)
+		}
+	}
+	return
+}
+
+func reverseLookupDNSEndpoint(ctrl cache.SharedIndexInformer) func(netip.Addr) []string {
+	return func(addr netip.Addr) (result []string) {
+		objs, _ := ctrl.GetIndexer().ByIndex(externalDNSAddressIndex, addr.String())
+		log.Debugf("Found %d matching DNSEndpoint objects for %s", len(objs), addr)
 		for _, obj := range objs {
 			dnsEndpoint, _ := obj.(*externaldnsv1.DNSEndpoint)
 
 			for _, endpoint := range dnsEndpoint.Spec.Endpoints {
-				for _, target := range endpoint.Targets {
-					if endpoint.RecordType == "A" || endpoint.RecordType == "AAAA" {
-						addr, err := netip.ParseAddr(target)
-						if err != nil {
-							continue
+				switch strings.ToUpper(endpoint.RecordType) {
+				case "A", "AAAA":
+					for _, target := range endpoint.Targets {
+						if target, err := parseAddr(target); err == nil && target == addr {
+							result = append(result, endpoint.DNSName)
+							break
 						}
-						result = append(result, addr)
 					}
 				}
 			}
 		}
-		return result
+		return
 	}
 }
 
-func fetchGatewayIPs(gw *gatewayapi_v1.Gateway) (results []netip.Addr) {
+func fetchGatewayIPs(ctx context.Context, gw *gatewayapi_v1.Gateway, resolver hostnameResolver) (result lookupResult) {
 	for _, addr := range gw.Status.Addresses {
-		if *addr.Type == gatewayapi_v1.IPAddressType {
-			addr, err := netip.ParseAddr(addr.Value)
+		switch {
+		case addr.Type == nil || *addr.Type == gatewayapi_v1.IPAddressType:
+			// the type defaults to IPAddress when unset
+			addr, err := parseAddr(addr.Value)
 			if err != nil {
 				continue
 			}
-			results = append(results, addr)
+			result.addrs = append(result.addrs, addr)
+
+		case *addr.Type == gatewayapi_v1.HostnameAddressType:
+			result.merge(fetchHostnameIPs(ctx, resolver, "Gateway", addr.Value))
+
+		default:
+			log.Debugf("Skipping address %s of unsupported type %s on gateway %s/%s", addr.Value, *addr.Type, gw.Namespace, gw.Name)
+		}
+	}
+	return
+}
+
+// fetchGatewayAnnotationIPs returns the comma-separated IPs of an annotation on
+// the Gateway, e.g. a static IP assigned by the cloud provider
+func fetchGatewayAnnotationIPs(gw *gatewayapi_v1.Gateway, key string) (result lookupResult) {
+	annotation, exists := gw.Annotations[key]
+	if !exists {
+		return
+	}
+	for _, value := range splitHostnameAnnotation(annotation) {
+		addr, err := parseAddr(value)
+		if err != nil {
+			log.Warningf("Ignoring invalid address %q in annotation %s of gateway %s/%s", value, key, gw.Namespace, gw.Name)
 			continue
 		}
+		result.addrs = append(result.addrs, addr)
+	}
+	return
+}
 
-		if *addr.Type == gatewayapi_v1.HostnameAddressType {
-			ips, err := net.LookupIP(addr.Value)
-			if err != nil {
-				continue
-			}
-			for _, ip := range ips {
-				addr, err := netip.ParseAddr(ip.String())
-				if err != nil {
-					continue
-				}
-				results = append(results, addr)
-			}
+// fetchGatewayServiceIPs returns the LoadBalancer IPs of the Service backing a
+// Gateway, either named by the gateway-service annotation ("name" or
+// "namespace/name") or labeled with the Gateway's name
+func fetchGatewayServiceIPs(ctx context.Context, svc cache.SharedIndexInformer, gw *gatewayapi_v1.Gateway, filters ResourceFilters) (result lookupResult) {
+	var svcObjs []interface{}
+	if ref, exists := gw.Annotations[gatewayServiceAnnotationKey]; exists {
+		key := ref
+		if !strings.Contains(ref, "/") {
+			key = fmt.Sprintf("%s/%s", gw.Namespace, ref)
+		}
+		if obj, exists, _ := svc.GetIndexer().GetByKey(key); exists {
+			svcObjs = append(svcObjs, obj)
+		}
+	} else {
+		svcObjs, _ = svc.GetIndexer().ByIndex(gatewayServiceIndex, fmt.Sprintf("%s/%s", gw.Namespace, gw.Name))
+	}
+	log.Debugf("Found %d Service objects backing gateway %s/%s", len(svcObjs), gw.Namespace, gw.Name)
+
+	for _, obj := range svcObjs {
+		service, _ := obj.(*core.Service)
+		result.merge(fetchServiceLoadBalancerIPs(ctx, service.Status.LoadBalancer.Ingress, filters))
+	}
+	return
+}
+
+// fetchServiceClusterIPs returns all cluster IPs of a (possibly dual-stack) service,
+// headless services have none
+func fetchServiceClusterIPs(service *core.Service) (results []netip.Addr) {
+	clusterIPs := service.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && service.Spec.ClusterIP != "" {
+		clusterIPs = []string{service.Spec.ClusterIP}
+	}
+	for _, ip := range clusterIPs {
+		if ip == core.ClusterIPNone {
+			continue
+		}
+		addr, err := parseAddr(ip)
+		if err != nil {
+			continue
 		}
+		results = append(results, addr)
 	}
 	return
 }
 
-func fetchServiceLoadBalancerIPs(ingresses []core.LoadBalancerIngress) (results []netip.Addr) {
-	for _, address := range ingresses {
-		if address.Hostname != "" {
-			log.Debugf("Looking up hostname %s", address.Hostname)
-			ips, err := net.LookupIP(address.Hostname)
-			if err != nil {
+// fetchServiceNodeIPs returns the addresses of the given type of the nodes
+// hosting ready endpoints of a Service, each node once
+func fetchServiceNodeIPs(nodes, endpointSlices cache.SharedIndexInformer, service *core.Service, addressType core.NodeAddressType) (results []netip.Addr) {
+	sliceObjs, _ := endpointSlices.GetIndexer().ByIndex(endpointSliceServiceIndex, fmt.Sprintf("%s/%s", service.Namespace, service.Name))
+	log.Debugf("Found %d matching EndpointSlice objects", len(sliceObjs))
+
+	seen := make(map[string]bool)
+	for _, sliceObj := range sliceObjs {
+		endpointSlice, _ := sliceObj.(*discovery.EndpointSlice)
+		for _, endpoint := range endpointSlice.Endpoints {
+			// a nil ready condition should be interpreted as ready
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			nodeName := ptr.Deref(endpoint.NodeName, "")
+			if nodeName == "" || seen[nodeName] {
+				continue
+			}
+			seen[nodeName] = true
+
+			nodeObj, exists, _ := nodes.GetIndexer().GetByKey(nodeName)
+			if !exists {
 				continue
 			}
-			for _, ip := range ips {
-				addr, err := netip.ParseAddr(ip.String())
-				if err != nil {
+			node, _ := nodeObj.(*core.Node)
+			for _, address := range node.Status.Addresses {
+				if address.Type != addressType {
 					continue
 				}
-				results = append(results, addr)
+				if addr, err := parseAddr(address.Address); err == nil {
+					results = append(results, addr)
+				}
 			}
-		} else if address.IP != "" {
-			addr, err := netip.ParseAddr(address.IP)
+		}
+	}
+	return
+}
+
+// fetchEndpointSliceIPs returns the addresses of all ready endpoints
+func fetchEndpointSliceIPs(endpointSlice *discovery.EndpointSlice) (results []netip.Addr) {
+	if endpointSlice.AddressType == discovery.AddressTypeFQDN {
+		return
+	}
+	for _, endpoint := range endpointSlice.Endpoints {
+		// a nil ready condition should be interpreted as ready
+		if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+		for _, address := range endpoint.Addresses {
+			addr, err := parseAddr(address)
 			if err != nil {
 				continue
 			}
@@ -728,30 +2573,257 @@ func fetchServiceLoadBalancerIPs(ingresses []core.LoadBalancerIngress) (results
 	return
 }
 
-func fetchIngressLoadBalancerIPs(ingresses []networking.IngressLoadBalancerIngress) (results []netip.Addr) {
+// fetchServiceLoadBalancerIPs returns the load balancer addresses, resolving
+// hostnames unless preferIP is set and the entry carries an IP as well
+func fetchServiceLoadBalancerIPs(ctx context.Context, ingresses []core.LoadBalancerIngress, filters ResourceFilters) (result lookupResult) {
 	for _, address := range ingresses {
-		if address.Hostname != "" {
-			log.Debugf("Looking up hostname %s", address.Hostname)
-			ips, err := net.LookupIP(address.Hostname)
-			if err != nil {
-				continue
-			}
-			for _, ip := range ips {
-				addr, err := netip.ParseAddr(ip.String())
-				if err != nil {
-					continue
-				}
-				results = append(results, addr)
-			}
-		} else if address.IP != "" {
-			addr, err := netip.ParseAddr(address.IP)
+		result.merge(fetchLoadBalancerIPs(ctx, "Service", address.IP, address.Hostname, filters))
+	}
+	return
+}
+
+func fetchIngressLoadBalancerIPs(ctx context.Context, ingresses []networking.IngressLoadBalancerIngress, filters ResourceFilters) (result lookupResult) {
+	for _, address := range ingresses {
+		result.merge(fetchLoadBalancerIPs(ctx, "Ingress", address.IP, address.Hostname, filters))
+	}
+	return
+}
+
+// parseAddr parses an IP address, turning IPv4-mapped IPv6 addresses like
+// ::ffff:192.0.2.1 into IPv4 ones so they're served as A records
+func parseAddr(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	return addr.Unmap(), err
+}
+
+func fetchLoadBalancerIPs(ctx context.Context, resource, ip, hostname string, filters ResourceFilters) (result lookupResult) {
+	if hostname != "" && (ip == "" || !filters.preferLoadBalancerIPs) {
+		return fetchHostnameIPs(ctx, filters.resolver, resource, hostname)
+	}
+	if addr, err := parseAddr(ip); err == nil {
+		result.addrs = append(result.addrs, addr)
+	}
+	return
+}
+
+// fetchHostnameIPs resolves a load balancer hostname of a resource, keeping
+// the TTL of the upstream records so answers don't outlive them. The hostname
+// itself is kept for zones answering it as a CNAME target.
+func fetchHostnameIPs(ctx context.Context, resolver hostnameResolver, resource, hostname string) (result lookupResult) {
+	result.hostnames = []string{hostname}
+
+	log.Debugf("Looking up hostname %s", hostname)
+	addrs, ttl, err := resolver.resolve(ctx, hostname)
+	if err != nil {
+		// otherwise names only backed by this hostname turn into NXDOMAIN
+		// without a trace, unless their zone answers the hostname as a CNAME
+		resolutionErrors.WithLabelValues(resource).Inc()
+		now := time.Now()
+		if last, ok := resolutionWarnings.Load(hostname); ok && now.Sub(last.(time.Time)) < resolutionWarningInterval {
+			log.Debugf("Failed to resolve hostname %s of a %s: %s", hostname, resource, err)
+			return
+		}
+		resolutionWarnings.Store(hostname, now)
+		log.Warningf("Failed to resolve hostname %s of a %s: %s", hostname, resource, err)
+		return
+	}
+	result.addrs = addrs
+	if ttl != nil {
+		result.setUpstreamTTL(*ttl)
+	}
+	return
+}
+
+// hostnameResolver resolves load balancer hostnames to their addresses and the
+// lowest TTL of the records they were resolved from, if known
+type hostnameResolver interface {
+	resolve(ctx context.Context, hostname string) ([]netip.Addr, *uint32, error)
+}
+
+// resolverFunc resolves hostnames with a function, e.g. a stub in tests
+type resolverFunc func(ctx context.Context, hostname string) ([]netip.Addr, *uint32, error)
+
+func (f resolverFunc) resolve(ctx context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+	return f(ctx, hostname)
+}
+
+// upstreamResolver resolves the load balancer hostnames of a plugin instance.
+// The nameservers are queried directly rather than through a net.Resolver, so
+// the TTLs of the records are known.
+type upstreamResolver struct {
+	// nameservers (host:port) of the instance, those of resolvConf unless set
+	servers []string
+	// addresses of the names in hostsFile, which take precedence over DNS
+	hosts map[string][]netip.Addr
+	// resolves hostnames when no nameserver is known at all
+	system interface {
+		LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+	}
+}
+
+// newUpstreamResolver builds a resolver querying the given nameservers, or
+// those of resolvConf. The files are only read once, when the plugin is set up.
+func newUpstreamResolver(servers []string) *upstreamResolver {
+	if len(servers) == 0 {
+		servers = resolvConfServers()
+	}
+	return &upstreamResolver{servers: servers, hosts: readHosts(hostsFile), system: &net.Resolver{}}
+}
+
+func (r *upstreamResolver) resolve(ctx context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+	if addrs, ok := r.hosts[strings.ToLower(strings.TrimSuffix(hostname, "."))]; ok {
+		// like the system resolver, hosts entries carry no TTL
+		return addrs, nil, nil
+	}
+
+	// the query waits for the hostname, so a slow nameserver mustn't hold it
+	// past the time clients wait for an answer
+	ctx, cancel := context.WithTimeout(ctx, hostnameResolveTimeout)
+	defer cancel()
+	if len(r.servers) == 0 {
+		// the TTL isn't available from the system resolver
+		return r.lookupSystem(ctx, hostname)
+	}
+
+	answers := make([][]dns.RR, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := new(dns.Msg)
+			m.SetQuestion(dns.Fqdn(hostname), qtype)
+			in, err := exchangeResolvers(ctx, m, r.servers)
 			if err != nil {
-				continue
+				errs[i] = err
+				return
 			}
-			results = append(results, addr)
+			answers[i] = in.Answer
+		}()
+	}
+	wg.Wait()
+
+	var addrs []netip.Addr
+	var ttl *uint32
+	for _, rr := range slices.Concat(answers...) {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A.To4())
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+			addr = addr.Unmap()
+		case *dns.CNAME:
+			// the CNAME chain bounds the TTL as well
+		default:
+			continue
+		}
+		if ttl == nil || rr.Header().Ttl < *ttl {
+			rrTTL := rr.Header().Ttl
+			ttl = &rrTTL
+		}
+		if addr.IsValid() {
+			addrs = append(addrs, addr)
 		}
 	}
-	return
+	if len(addrs) == 0 {
+		if err := errors.Join(errs...); err != nil {
+			return nil, nil, err
+		}
+		// NXDOMAIN or no records of either family, like the system resolver
+		return nil, nil, &net.DNSError{Err: "no such host", Name: hostname, IsNotFound: true}
+	}
+	return addrs, ttl, nil
+}
+
+// lookupSystem looks up both address families of a hostname on their own,
+// since a combined lookup may skip AAAA records on hosts without IPv6
+func (r *upstreamResolver) lookupSystem(ctx context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+	found := make([][]netip.Addr, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, network := range []string{"ip4", "ip6"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found[i], errs[i] = r.system.LookupNetIP(ctx, network, hostname)
+		}()
+	}
+	wg.Wait()
+
+	var addrs []netip.Addr
+	for _, addr := range slices.Concat(found...) {
+		addrs = append(addrs, addr.Unmap())
+	}
+	if len(addrs) == 0 {
+		if err := errors.Join(errs...); err != nil {
+			return nil, nil, err
+		}
+	}
+	return addrs, nil, nil
+}
+
+// resolvConfServers returns the nameservers (host:port) of resolvConf
+func resolvConfServers() []string {
+	config, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil {
+		return nil
+	}
+	var addrs []string
+	for _, server := range config.Servers {
+		addrs = append(addrs, net.JoinHostPort(server, config.Port))
+	}
+	return addrs
+}
+
+// readHosts returns the addresses of the names in a hosts file, keyed by lower
+// case name
+func readHosts(path string) map[string][]netip.Addr {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	hosts := make(map[string][]netip.Addr)
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			hosts[name] = append(hosts[name], addr.WithZone("").Unmap())
+		}
+	}
+	return hosts
+}
+
+// exchangeResolvers sends a query to the nameservers in order until one of
+// them answers, truncated answers are retried over TCP. Answers other than
+// NOERROR and NXDOMAIN, e.g. SERVFAIL or REFUSED, move on to the next one.
+func exchangeResolvers(ctx context.Context, m *dns.Msg, servers []string) (in *dns.Msg, err error) {
+	udp, tcp := new(dns.Client), &dns.Client{Net: "tcp"}
+	for _, server := range servers {
+		in, _, err = udp.ExchangeContext(ctx, m, server)
+		if err == nil && in.Truncated {
+			in, _, err = tcp.ExchangeContext(ctx, m, server)
+		}
+		if err != nil {
+			continue
+		}
+		if in.Rcode == dns.RcodeSuccess || in.Rcode == dns.RcodeNameError {
+			return in, nil
+		}
+		err = fmt.Errorf("nameserver %s answered %s", server, dns.RcodeToString[in.Rcode])
+	}
+	return nil, err
 }
 
 // the below is borrowed from k/k's GitHub repo
@@ -3,28 +3,53 @@ package gateway
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	golog "log"
+	"maps"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/miekg/dns"
+	dto "github.com/prometheus/client_model/go"
+	istio_api_v1beta1 "istio.io/api/networking/v1beta1"
+	istio_v1beta1 "istio.io/client-go/pkg/apis/networking/v1beta1"
 	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
 	networking "k8s.io/api/networking/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsFake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
 	fakeRest "k8s.io/client-go/rest/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
 	externaldnsv1 "sigs.k8s.io/external-dns/apis/v1alpha1"
 	"sigs.k8s.io/external-dns/endpoint"
 	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
 	gatewayapi_v1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	gatewayapi_v1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
 	gatewayClient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
 	gwFake "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/fake"
 )
@@ -113,10 +138,10 @@ func TestController(t *testing.T) {
 	client := fake.NewClientset()
 	gwClient := gwFake.NewClientset()
 	ctrl := &KubeController{
-		client:    client,
-		gwClient:  gwClient,
-		hasSynced: true,
+		client:   client,
+		gwClient: gwClient,
 	}
+	ctrl.hasSynced.Store(true)
 	addServices(client)
 	addIngresses(client)
 	addGateways(gwClient)
@@ -131,32 +156,32 @@ func TestController(t *testing.T) {
 	gw.Controller = ctrl
 
 	for index, testObj := range testIngresses {
-		found, _ := ingressHostnameIndexFunc(testObj)
+		found, _ := ingressHostnameIndexFunc(gw.resourceFilters)(testObj)
 		if !isFound(index, found) {
 			t.Errorf("Ingress key %s not found in index: %v", index, found)
 		}
-		ips := fetchIngressLoadBalancerIPs(testObj.Status.LoadBalancer.Ingress)
+		ips := fetchIngressLoadBalancerIPs(context.TODO(), testObj.Status.LoadBalancer.Ingress, ResourceFilters{}).addrs
 		if len(ips) != 1 {
 			t.Errorf("Unexpected number of IPs found %d", len(ips))
 		}
 	}
 
 	for index, testObj := range testServices {
-		found, _ := serviceHostnameIndexFunc(testObj)
+		found, _ := serviceHostnameIndexFunc(gw.resourceFilters)(testObj)
 		indices := strings.Split(index, ",")
 		for _, idx := range indices {
 			if !isFound(strings.TrimSpace(idx), found) {
 				t.Errorf("Service key %s not found in index: %v", idx, found)
 			}
 		}
-		ips := fetchServiceLoadBalancerIPs(testObj.Status.LoadBalancer.Ingress)
+		ips := fetchServiceLoadBalancerIPs(context.TODO(), testObj.Status.LoadBalancer.Ingress, ResourceFilters{}).addrs
 		if len(ips) != 1 {
 			t.Errorf("Unexpected number of IPs found %d", len(ips))
 		}
 	}
 
 	for index, testObj := range testBadServices {
-		found, _ := serviceHostnameIndexFunc(testObj)
+		found, _ := serviceHostnameIndexFunc(gw.resourceFilters)(testObj)
 		if isFound(index, found) {
 			t.Errorf("Unexpected service key %s found in index: %v", index, found)
 		}
@@ -198,6 +223,3294 @@ func TestController(t *testing.T) {
 	}
 }
 
+func TestLookupDNSEndpointRecordTypeCase(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(testDNSEndpointLowercase); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	addrs := lookupDNSEndpoint(ctrl)(context.TODO(), []string{"lower.example.com"}).addrs
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.201"), netip.MustParseAddr("2001:db8::2")}
+	if !slices.Equal(addrs, expected) {
+		t.Errorf("Expected addresses %v, got %v", expected, addrs)
+	}
+}
+
+func TestLookupDNSEndpointMixedTypes(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(&externaldnsv1.DNSEndpoint{
+		ObjectMeta: metav1.ObjectMeta{Name: "ep-mixed", Namespace: "ns1"},
+		Spec: externaldnsv1.DNSEndpointSpec{
+			Endpoints: []*endpoint.Endpoint{
+				{DNSName: "other.example.com", RecordType: "A", Targets: endpoint.Targets{"192.0.2.71"}},
+				{DNSName: "mixed.example.com", RecordType: "A", Targets: endpoint.Targets{"192.0.2.70"}},
+				{DNSName: "Mixed.example.com", RecordType: "AAAA", Targets: endpoint.Targets{"2001:db8::70"}},
+				{DNSName: "mixed.example.com", RecordType: "TXT", Targets: endpoint.Targets{"v=spf1 -all"}},
+				{DNSName: "other.example.com", RecordType: "TXT", Targets: endpoint.Targets{"other"}},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	lookup := lookupDNSEndpoint(ctrl)
+	// both keys of the name match the object, its records are still added once
+	result := lookup(context.TODO(), []string{"mixed.example.com", "mixed"})
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.70"), netip.MustParseAddr("2001:db8::70")}
+	if !slices.Equal(result.addrs, expected) {
+		t.Errorf("Expected addresses %v, got %v", expected, result.addrs)
+	}
+	if !slices.Equal(result.records["TXT"], []string{"v=spf1 -all"}) {
+		t.Errorf("Expected TXT records [v=spf1 -all], got %v", result.records["TXT"])
+	}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeTXT} {
+		if !result.hasType(qtype) {
+			t.Errorf("Expected the name to answer %s queries", dns.TypeToString[qtype])
+		}
+	}
+
+	// records of other names of the object aren't added
+	result = lookup(context.TODO(), []string{"other.example.com", "other"})
+	if expected := []netip.Addr{netip.MustParseAddr("192.0.2.71")}; !slices.Equal(result.addrs, expected) {
+		t.Errorf("Expected addresses %v, got %v", expected, result.addrs)
+	}
+	if !slices.Equal(result.records["TXT"], []string{"other"}) {
+		t.Errorf("Expected TXT records [other], got %v", result.records["TXT"])
+	}
+}
+
+func TestLookupDNSEndpointMX(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(testDNSEndpointMX); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	result := lookupDNSEndpoint(ctrl)(context.TODO(), []string{"mail.example.com"})
+	expected := []string{"10 mx1.example.com", "20 mx2.example.com"}
+	if !slices.Equal(result.records["MX"], expected) {
+		t.Errorf("Expected MX records %v, got %v", expected, result.records["MX"])
+	}
+	if len(result.addrs) != 0 {
+		t.Errorf("Expected no addresses, got %v", result.addrs)
+	}
+}
+
+func TestLookupDNSEndpointSRV(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(testDNSEndpointSRV); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	result := lookupDNSEndpoint(ctrl)(context.TODO(), []string{"_http._tcp.example.com"})
+	expected := []string{"10 50 8080 web1.example.com", "20 50 8080 web2.example.com"}
+	if !slices.Equal(result.records["SRV"], expected) {
+		t.Errorf("Expected SRV records %v, got %v", expected, result.records["SRV"])
+	}
+	if len(result.addrs) != 0 {
+		t.Errorf("Expected no addresses, got %v", result.addrs)
+	}
+}
+
+func TestLookupDNSEndpointDS(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(testDNSEndpointDS); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	result := lookupDNSEndpoint(ctrl)(context.TODO(), []string{"child.example.com"})
+	if expected := []string{"ns1.child.example.net"}; !slices.Equal(result.records["NS"], expected) {
+		t.Errorf("Expected NS records %v, got %v", expected, result.records["NS"])
+	}
+	if expected := []string{"2371 13 2 1F987CC6583E92DF0890718C42D5C9F3A1C2B0E7F8D9A6B5C4D3E2F1A0B9C8D7"}; !slices.Equal(result.records["DS"], expected) {
+		t.Errorf("Expected DS records %v, got %v", expected, result.records["DS"])
+	}
+
+	// the DS record is served for the delegated child zone
+	records := newGateway().DS("child.example.com.", 60, result.records["DS"])
+	if len(records) != 1 {
+		t.Fatalf("Expected a single DS record, got %v", records)
+	}
+	if ds := records[0].(*dns.DS); ds.KeyTag != 2371 || ds.Algorithm != dns.ECDSAP256SHA256 || ds.DigestType != dns.SHA256 {
+		t.Errorf("Expected DS 2371 13 2, got %s", ds)
+	}
+
+	// digests split into several fields are joined like in zone files
+	records = newGateway().DS("child.example.com.", 60, []string{"2371 13 2 1f987cc6 583e92df"})
+	if len(records) != 1 || records[0].(*dns.DS).Digest != "1F987CC6583E92DF" {
+		t.Errorf("Expected a DS record with digest 1F987CC6583E92DF, got %v", records)
+	}
+}
+
+func TestLookupDNSEndpointCAA(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(testDNSEndpointCAA); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	result := lookupDNSEndpoint(ctrl)(context.TODO(), []string{"secure.example.com"})
+	expected := []string{`0 issue "letsencrypt.org"`, "0 issuewild ;", "0 bad_tag value"}
+	if !slices.Equal(result.records["CAA"], expected) {
+		t.Errorf("Expected CAA records %v, got %v", expected, result.records["CAA"])
+	}
+
+	records := newGateway().CAA("secure.example.com.", 60, result.records["CAA"])
+	if len(records) != 2 {
+		t.Fatalf("Expected two CAA records, got %v", records)
+	}
+	if caa := records[0].(*dns.CAA); caa.Flag != 0 || caa.Tag != "issue" || caa.Value != "letsencrypt.org" {
+		t.Errorf(`Expected CAA 0 issue "letsencrypt.org", got %s`, caa)
+	}
+	if caa := records[1].(*dns.CAA); caa.Flag != 0 || caa.Tag != "issuewild" || caa.Value != ";" {
+		t.Errorf(`Expected CAA 0 issuewild ";", got %s`, caa)
+	}
+}
+
+func TestLookupDNSEndpointWildcard(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(testDNSEndpointWildcard); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+	if err := ctrl.GetIndexer().Add(testDNSEndpointDeepWildcard); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	tests := []struct {
+		qname    string
+		expected []netip.Addr
+	}{
+		{"x.apps.example.com.", []netip.Addr{netip.MustParseAddr("192.0.2.60")}},
+		{"y.z.apps.example.com.", []netip.Addr{netip.MustParseAddr("192.0.2.60")}},
+		{"a.b.c.apps.example.com.", []netip.Addr{netip.MustParseAddr("192.0.2.60")}},
+		// the closest wildcard wins
+		{"y.deep.apps.example.com.", []netip.Addr{netip.MustParseAddr("192.0.2.61")}},
+		{"x.y.deep.apps.example.com.", []netip.Addr{netip.MustParseAddr("192.0.2.61")}},
+		// wildcards don't match names above them
+		{"apps.example.com.", nil},
+		{"x.example.com.", nil},
+	}
+
+	gw := newGateway()
+	lookup := lookupDNSEndpoint(ctrl)
+	for i, tc := range tests {
+		var addrs []netip.Addr
+		for _, indexKeys := range gw.getQueryIndexKeySets(tc.qname, "example.com.") {
+			if addrs = lookup(context.TODO(), indexKeys).addrs; len(addrs) > 0 {
+				break
+			}
+		}
+		if !slices.Equal(addrs, tc.expected) {
+			t.Errorf("Test %d: Expected %v for %s, got %v", i, tc.expected, tc.qname, addrs)
+		}
+	}
+}
+
+func TestLookupDNSEndpointNS(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(testDNSEndpointNS); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	result := lookupDNSEndpoint(ctrl)(context.TODO(), []string{"sub.example.com"})
+	expected := []string{"ns1.example.net", "ns2.example.net"}
+	if !slices.Equal(result.records["NS"], expected) {
+		t.Errorf("Expected NS records %v, got %v", expected, result.records["NS"])
+	}
+}
+
+func TestPluginDNSEndpointRecordTypes(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := ctrl.GetIndexer().Add(&externaldnsv1.DNSEndpoint{
+		ObjectMeta: metav1.ObjectMeta{Name: "ep-types", Namespace: "ns1"},
+		Spec: externaldnsv1.DNSEndpointSpec{
+			Endpoints: []*endpoint.Endpoint{
+				{DNSName: "host.example.com", RecordType: "A", Targets: endpoint.Targets{"192.0.2.80"}},
+				{DNSName: "host.example.com", RecordType: "AAAA", Targets: endpoint.Targets{"2001:db8::80"}},
+				{DNSName: "host.example.com", RecordType: "TXT", Targets: endpoint.Targets{"v=spf1 -all"}},
+				{DNSName: "host.example.com", RecordType: "MX", Targets: endpoint.Targets{"10 mx.example.com"}},
+				{DNSName: "host.example.com", RecordType: "CAA", Targets: endpoint.Targets{`0 issue "letsencrypt.org"`}},
+				{DNSName: "host.example.com", RecordType: "LOC", Targets: endpoint.Targets{"52 22 23.000 N 4 53 32.000 E -2.00m"}},
+				{DNSName: "_sip._udp.example.com", RecordType: "SRV", Targets: endpoint.Targets{"10 50 5060 sip.example.com"}},
+				{DNSName: "sub.example.com", RecordType: "NS", Targets: endpoint.Targets{"ns1.example.net"}},
+				{DNSName: "alias.example.com", RecordType: "CNAME", Targets: endpoint.Targets{"host.example.net"}},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "DNSEndpoint", lookup: lookupDNSEndpoint(ctrl), reverse: noopReverse})
+
+	tests := []test.Case{
+		{
+			Qname: "host.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("host.example.com.	60	IN	A	192.0.2.80")},
+		},
+		{
+			Qname: "host.example.com.", Qtype: dns.TypeAAAA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.AAAA("host.example.com.	60	IN	AAAA	2001:db8::80")},
+		},
+		{
+			Qname: "host.example.com.", Qtype: dns.TypeTXT, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.TXT(`host.example.com.	60	IN	TXT	"v=spf1 -all"`)},
+		},
+		{
+			Qname: "host.example.com.", Qtype: dns.TypeMX, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.MX("host.example.com.	60	IN	MX	10 mx.example.com.")},
+		},
+		{
+			Qname: "host.example.com.", Qtype: dns.TypeCAA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.CAA(`host.example.com.	60	IN	CAA	0 issue "letsencrypt.org"`)},
+		},
+		// unsupported record types are ignored
+		{
+			Qname: "host.example.com.", Qtype: dns.TypeLOC, Rcode: dns.RcodeSuccess,
+			Ns: []dns.RR{test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")},
+		},
+		{
+			Qname: "_sip._udp.example.com.", Qtype: dns.TypeSRV, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.SRV("_sip._udp.example.com.	60	IN	SRV	10 50 5060 sip.example.com.")},
+		},
+		{
+			Qname: "sub.example.com.", Qtype: dns.TypeNS, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.NS("sub.example.com.	60	IN	NS	ns1.example.net.")},
+		},
+		// CNAMEs are answered for every type without cnameGatewayHostnames
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeCNAME, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.CNAME("alias.example.com.	60	IN	CNAME	host.example.net.")},
+		},
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.CNAME("alias.example.com.	60	IN	CNAME	host.example.net.")},
+		},
+	}
+
+	for i, tc := range tests {
+		r := tc.Msg()
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestLookupServiceLocalTrafficPolicy(t *testing.T) {
+	filters := newGateway().resourceFilters
+	filters.localPolicyAddressType = "ExternalIP"
+
+	services := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	nodes := cache.NewSharedIndexInformer(&cache.ListWatch{}, &core.Node{}, defaultResyncPeriod, cache.Indexers{})
+	endpointSlices := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&discovery.EndpointSlice{},
+		defaultResyncPeriod,
+		cache.Indexers{endpointSliceServiceIndex: endpointSliceServiceIndexFunc},
+	)
+
+	status := core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{Ingress: []core.LoadBalancerIngress{{IP: "198.51.100.1"}}}}
+	for _, service := range []*core.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "svc-local", Namespace: "ns1"},
+			Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer, ExternalTrafficPolicy: core.ServiceExternalTrafficPolicyLocal},
+			Status:     status,
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "svc-cluster", Namespace: "ns1"},
+			Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer, ExternalTrafficPolicy: core.ServiceExternalTrafficPolicyCluster},
+			Status:     status,
+		},
+	} {
+		if err := services.GetIndexer().Add(service); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+	for name, addrs := range map[string][]core.NodeAddress{
+		"node-1": {{Type: core.NodeInternalIP, Address: "10.0.0.1"}, {Type: core.NodeExternalIP, Address: "192.0.2.1"}},
+		"node-2": {{Type: core.NodeInternalIP, Address: "10.0.0.2"}, {Type: core.NodeExternalIP, Address: "192.0.2.2"}},
+		"node-3": {{Type: core.NodeInternalIP, Address: "10.0.0.3"}, {Type: core.NodeExternalIP, Address: "192.0.2.3"}},
+	} {
+		if err := nodes.GetIndexer().Add(&core.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     core.NodeStatus{Addresses: addrs},
+		}); err != nil {
+			t.Fatalf("Failed to add Node to indexer: %s", err)
+		}
+	}
+	for _, service := range []string{"svc-local", "svc-cluster"} {
+		if err := endpointSlices.GetIndexer().Add(&discovery.EndpointSlice{
+			ObjectMeta:  metav1.ObjectMeta{Name: service + "-ipv4", Namespace: "ns1", Labels: map[string]string{discovery.LabelServiceName: service}},
+			AddressType: discovery.AddressTypeIPv4,
+			Endpoints: []discovery.Endpoint{
+				{Addresses: []string{"10.244.0.1"}, NodeName: ptr.To("node-1")},
+				{Addresses: []string{"10.244.0.2"}, NodeName: ptr.To("node-2"), Conditions: discovery.EndpointConditions{Ready: ptr.To(false)}},
+				{Addresses: []string{"10.244.0.3"}, NodeName: ptr.To("node-3")},
+			},
+		}); err != nil {
+			t.Fatalf("Failed to add EndpointSlice to indexer: %s", err)
+		}
+	}
+
+	// only the nodes hosting ready endpoints of the Local policy Service
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.3")}
+	addrs := lookupServiceIndex(services, nodes, endpointSlices, filters)(context.TODO(), []string{"svc-local.ns1"}).addrs
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	if !slices.Equal(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+
+	// Cluster policy Services keep resolving to their load balancer
+	expected = []netip.Addr{netip.MustParseAddr("198.51.100.1")}
+	if addrs := lookupServiceIndex(services, nodes, endpointSlices, filters)(context.TODO(), []string{"svc-cluster.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+
+	filters.localPolicyAddressType = "InternalIP"
+	expected = []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.3")}
+	addrs = lookupServiceIndex(services, nodes, endpointSlices, filters)(context.TODO(), []string{"svc-local.ns1"}).addrs
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	if !slices.Equal(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+
+	// without the option, Local policy Services resolve to their load balancer as well
+	filters.localPolicyAddressType = ""
+	expected = []netip.Addr{netip.MustParseAddr("198.51.100.1")}
+	if addrs := lookupServiceIndex(services, nodes, endpointSlices, filters)(context.TODO(), []string{"svc-local.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+}
+
+func TestPluginWildcardPrecedence(t *testing.T) {
+	ingresses := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&networking.Ingress{},
+		defaultResyncPeriod,
+		cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc(newGateway().resourceFilters)},
+	)
+	if err := ingresses.GetIndexer().Add(&networking.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ing-wildcard", Namespace: "ns1"},
+		Spec:       networking.IngressSpec{Rules: []networking.IngressRule{{Host: "*.apps.example.com"}}},
+		Status: networking.IngressStatus{LoadBalancer: networking.IngressLoadBalancerStatus{
+			Ingress: []networking.IngressLoadBalancerIngress{{IP: "192.0.2.100"}},
+		}},
+	}); err != nil {
+		t.Fatalf("Failed to add Ingress to indexer: %s", err)
+	}
+	dnsEndpoints := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{externalDNSHostnameIndex: dnsEndpointTargetIndexFunc},
+	)
+	if err := dnsEndpoints.GetIndexer().Add(&externaldnsv1.DNSEndpoint{
+		ObjectMeta: metav1.ObjectMeta{Name: "ep-specific", Namespace: "ns1"},
+		Spec: externaldnsv1.DNSEndpointSpec{
+			Endpoints: []*endpoint.Endpoint{{DNSName: "api.apps.example.com", RecordType: "A", Targets: endpoint.Targets{"192.0.2.101"}}},
+		},
+	}); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	gw := newTestGateway()
+	ingress := &resourceWithIndex{name: "Ingress", lookup: lookupIngressIndex(ingresses, gw.resourceFilters), reverse: noopReverse}
+	dnsEndpoint := &resourceWithIndex{name: "DNSEndpoint", lookup: lookupDNSEndpoint(dnsEndpoints), reverse: noopReverse}
+
+	tests := []test.Case{
+		// the specific name wins over the wildcard of an earlier resource
+		{
+			Qname: "api.apps.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("api.apps.example.com.	60	IN	A	192.0.2.101")},
+		},
+		{
+			Qname: "web.apps.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("web.apps.example.com.	60	IN	A	192.0.2.100")},
+		},
+		// the specific name exists, so its missing types aren't taken from the wildcard
+		{
+			Qname: "api.apps.example.com.", Qtype: dns.TypeAAAA, Rcode: dns.RcodeSuccess,
+			Ns: []dns.RR{test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")},
+		},
+	}
+
+	for _, resources := range [][]*resourceWithIndex{{ingress, dnsEndpoint}, {dnsEndpoint, ingress}} {
+		gw.Resources = resources
+		for i, tc := range tests {
+			w := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+				t.Fatalf("Test %d: Expected no error, got %v", i, err)
+			}
+			if err := test.SortAndCheck(w.Msg, tc); err != nil {
+				t.Errorf("Test %d with %s first: %v", i, resources[0].name, err)
+			}
+		}
+	}
+}
+
+func TestLookupServiceMergeExternalIPs(t *testing.T) {
+	filters := newGateway().resourceFilters
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	if err := ctrl.GetIndexer().Add(&core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-ext", Namespace: "ns1"},
+		Spec: core.ServiceSpec{
+			Type:        core.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"192.0.2.10"},
+		},
+		Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+			Ingress: []core.LoadBalancerIngress{{IP: "2001:db8::10"}, {IP: "192.0.2.10"}},
+		}},
+	}); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	// the status is ignored by default
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.10")}
+	if addrs := lookupServiceIndex(ctrl, nil, nil, filters)(context.TODO(), []string{"svc-ext.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+
+	filters.mergeExternalIPs = true
+	expected = []netip.Addr{netip.MustParseAddr("192.0.2.10"), netip.MustParseAddr("2001:db8::10")}
+	if addrs := lookupServiceIndex(ctrl, nil, nil, filters)(context.TODO(), []string{"svc-ext.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+}
+
+func TestLookupServiceRegion(t *testing.T) {
+	filters := newGateway().resourceFilters
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	for _, svc := range []struct{ name, region, ip string }{
+		{"svc-eu", "eu-west", "192.0.2.1"},
+		{"svc-us", " us-east ", "192.0.2.2"},
+	} {
+		if err := ctrl.GetIndexer().Add(&core.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        svc.name,
+				Namespace:   "ns1",
+				Annotations: map[string]string{hostnameAnnotationKey: "app.example.com", regionAnnotationKey: svc.region},
+			},
+			Spec: core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+			Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: svc.ip}},
+			}},
+		}); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	result := lookupServiceIndex(ctrl, nil, nil, filters)(context.TODO(), []string{"app.example.com"})
+	expected := map[netip.Addr]string{
+		netip.MustParseAddr("192.0.2.1"): "eu-west",
+		netip.MustParseAddr("192.0.2.2"): "us-east",
+	}
+	if !maps.Equal(result.regions, expected) {
+		t.Errorf("Expected regions %v, got %v", expected, result.regions)
+	}
+}
+
+func TestLookupServiceHostnameConflicts(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	services := []*core.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "app",
+				Namespace:         "tenant-a",
+				CreationTimestamp: metav1.NewTime(created.Add(time.Hour)),
+				Annotations:       map[string]string{hostnameAnnotationKey: "app.example.com"},
+			},
+			Spec: core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+			Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.1"}},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "app",
+				Namespace:         "tenant-b",
+				CreationTimestamp: metav1.NewTime(created),
+				Annotations:       map[string]string{hostnameAnnotationKey: "app.example.com"},
+			},
+			Spec: core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+			Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.2"}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		policy   string
+		expected []netip.Addr
+	}{
+		{conflictUnion, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")}},
+		{conflictFirst, []netip.Addr{netip.MustParseAddr("192.0.2.2")}},
+		{conflictReject, nil},
+	}
+	for _, tc := range tests {
+		filters := newGateway().resourceFilters
+		filters.hostnameConflicts = tc.policy
+		ctrl := cache.NewSharedIndexInformer(
+			&cache.ListWatch{},
+			&core.Service{},
+			defaultResyncPeriod,
+			cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+		)
+		for _, svc := range services {
+			if err := ctrl.GetIndexer().Add(svc); err != nil {
+				t.Fatalf("Failed to add Service to indexer: %s", err)
+			}
+		}
+
+		result := lookupServiceIndex(ctrl, nil, nil, filters)(context.TODO(), []string{"app.example.com"})
+		slices.SortFunc(result.addrs, netip.Addr.Compare)
+		if !slices.Equal(result.addrs, tc.expected) {
+			t.Errorf("Policy %s: expected addresses %v, got %v", tc.policy, tc.expected, result.addrs)
+		}
+	}
+}
+
+func TestLookupServicePriority(t *testing.T) {
+	filters := newGateway().resourceFilters
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	if err := ctrl.GetIndexer().Add(&core.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "federated",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				hostnameAnnotationKey: "app.example.com",
+				// entries for addresses the Service doesn't have and malformed ones are ignored
+				priorityAnnotationKey: "192.0.2.2=0, 192.0.2.1=10,192.0.2.9=5,192.0.2.3,2001:db8::1=high",
+			},
+		},
+		Spec: core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+		Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+			Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.1"}, {IP: "192.0.2.2"}, {IP: "192.0.2.3"}, {IP: "2001:db8::1"}},
+		}},
+	}); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	result := lookupServiceIndex(ctrl, nil, nil, filters)(context.TODO(), []string{"app.example.com"})
+	expected := map[netip.Addr]uint32{
+		netip.MustParseAddr("192.0.2.1"): 10,
+		netip.MustParseAddr("192.0.2.2"): 0,
+	}
+	if !maps.Equal(result.priorities, expected) {
+		t.Errorf("Expected priorities %v, got %v", expected, result.priorities)
+	}
+	ordered := []netip.Addr{netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.3")}
+	if addrs := preferPriority(result.addrs[:3], result.priorities); !slices.Equal(addrs, ordered) {
+		t.Errorf("Expected addresses ordered as %v, got %v", ordered, addrs)
+	}
+}
+
+func TestLookupServiceTypes(t *testing.T) {
+	filters := newGateway().resourceFilters
+	filters.serviceTypes = []string{"LoadBalancer", "ClusterIP"}
+
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	for _, svc := range testClusterIPServices {
+		if err := ctrl.GetIndexer().Add(svc.service); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+	if err := ctrl.GetIndexer().Add(testBadServices["svc1.ns2"]); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	lookup := lookupServiceIndex(ctrl, nil, nil, filters)
+	for key, expected := range map[string][]netip.Addr{
+		"svc-dual.ns1":     {netip.MustParseAddr("10.96.0.10"), netip.MustParseAddr("fd00:10:96::a")},
+		"svc-headless.ns1": nil,
+		"svc1.ns2":         nil,
+	} {
+		if addrs := lookup(context.TODO(), []string{key}).addrs; !slices.Equal(addrs, expected) {
+			t.Errorf("Expected %s to resolve to %v, got %v", key, expected, addrs)
+		}
+	}
+
+	// LoadBalancer services only by default
+	if found, _ := serviceHostnameIndexFunc(newGateway().resourceFilters)(testClusterIPServices["dual-stack"].service); len(found) != 0 {
+		t.Errorf("Unexpected ClusterIP service indexed by default: %v", found)
+	}
+}
+
+func TestServiceRequireHostnameAnnotation(t *testing.T) {
+	filters := newGateway().resourceFilters
+	filters.requireHostnameAnnotation = true
+
+	annotated := testServices["svc1.ns1"].DeepCopy()
+	annotated.Annotations = map[string]string{hostnameAnnotationKey: "svc1.example.com"}
+	externalDNS := testServices["svc1.ns1"].DeepCopy()
+	externalDNS.Annotations = map[string]string{externalDnsHostnameAnnotationKey: "svc1.example.org"}
+	unannotated := testServices["svc2.ns1"]
+
+	for _, tc := range []struct {
+		service   *core.Service
+		hostnames []string
+		addrs     []string
+	}{
+		{annotated, []string{"svc1.example.com"}, []string{"192.0.0.1"}},
+		{externalDNS, []string{"svc1.example.org"}, []string{"192.0.0.1"}},
+		{unannotated, []string{}, []string{}},
+	} {
+		if hostnames, _ := serviceHostnameIndexFunc(filters)(tc.service); !slices.Equal(hostnames, tc.hostnames) {
+			t.Errorf("Service %s: expected hostnames %v, got %v", tc.service.Name, tc.hostnames, hostnames)
+		}
+		if addrs, _ := serviceAddressIndexFunc(filters)(tc.service); !slices.Equal(addrs, tc.addrs) {
+			t.Errorf("Service %s: expected reverse index %v, got %v", tc.service.Name, tc.addrs, addrs)
+		}
+	}
+
+	// unannotated Services are published as name.namespace by default
+	if hostnames, _ := serviceHostnameIndexFunc(newGateway().resourceFilters)(unannotated); !slices.Equal(hostnames, []string{"svc2.ns1"}) {
+		t.Errorf("Expected svc2.ns1 to be indexed by default, got %v", hostnames)
+	}
+}
+
+func TestLookupServiceNodePort(t *testing.T) {
+	filters := newGateway().resourceFilters
+	filters.serviceTypes = []string{"NodePort"}
+	filters.nodeAddressType = "ExternalIP"
+
+	services := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	nodes := cache.NewSharedIndexInformer(&cache.ListWatch{}, &core.Node{}, defaultResyncPeriod, cache.Indexers{})
+	endpointSlices := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&discovery.EndpointSlice{},
+		defaultResyncPeriod,
+		cache.Indexers{endpointSliceServiceIndex: endpointSliceServiceIndexFunc},
+	)
+
+	if err := services.GetIndexer().Add(&core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-np", Namespace: "ns1"},
+		Spec:       core.ServiceSpec{Type: core.ServiceTypeNodePort},
+	}); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+	for name, addrs := range map[string][]core.NodeAddress{
+		"node-1": {{Type: core.NodeInternalIP, Address: "10.0.0.1"}, {Type: core.NodeExternalIP, Address: "192.0.2.1"}},
+		"node-2": {{Type: core.NodeInternalIP, Address: "10.0.0.2"}, {Type: core.NodeExternalIP, Address: "2001:db8::2"}},
+		"node-3": {{Type: core.NodeInternalIP, Address: "10.0.0.3"}, {Type: core.NodeExternalIP, Address: "192.0.2.3"}},
+		"node-4": {{Type: core.NodeInternalIP, Address: "10.0.0.4"}, {Type: core.NodeExternalIP, Address: "192.0.2.4"}},
+	} {
+		if err := nodes.GetIndexer().Add(&core.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     core.NodeStatus{Addresses: addrs},
+		}); err != nil {
+			t.Fatalf("Failed to add Node to indexer: %s", err)
+		}
+	}
+	labels := map[string]string{discovery.LabelServiceName: "svc-np"}
+	for _, slice := range []*discovery.EndpointSlice{
+		{
+			ObjectMeta:  metav1.ObjectMeta{Name: "svc-np-ipv4", Namespace: "ns1", Labels: labels},
+			AddressType: discovery.AddressTypeIPv4,
+			Endpoints: []discovery.Endpoint{
+				{Addresses: []string{"10.244.0.1"}, NodeName: ptr.To("node-1")},
+				{Addresses: []string{"10.244.0.2"}, NodeName: ptr.To("node-1")},
+				{Addresses: []string{"10.244.0.3"}, NodeName: ptr.To("node-3"), Conditions: discovery.EndpointConditions{Ready: ptr.To(false)}},
+			},
+		},
+		{
+			ObjectMeta:  metav1.ObjectMeta{Name: "svc-np-ipv6", Namespace: "ns1", Labels: labels},
+			AddressType: discovery.AddressTypeIPv6,
+			Endpoints: []discovery.Endpoint{
+				{Addresses: []string{"fd00::1"}, NodeName: ptr.To("node-1")},
+				{Addresses: []string{"fd00::2"}, NodeName: ptr.To("node-2"), Conditions: discovery.EndpointConditions{Ready: ptr.To(true)}},
+				{Addresses: []string{"fd00::5"}, NodeName: ptr.To("node-5")},
+			},
+		},
+		{
+			// another Service's endpoints on node-4
+			ObjectMeta:  metav1.ObjectMeta{Name: "other", Namespace: "ns1", Labels: map[string]string{discovery.LabelServiceName: "other"}},
+			AddressType: discovery.AddressTypeIPv4,
+			Endpoints:   []discovery.Endpoint{{Addresses: []string{"10.244.0.4"}, NodeName: ptr.To("node-4")}},
+		},
+	} {
+		if err := endpointSlices.GetIndexer().Add(slice); err != nil {
+			t.Fatalf("Failed to add EndpointSlice to indexer: %s", err)
+		}
+	}
+
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::2")}
+	addrs := lookupServiceIndex(services, nodes, endpointSlices, filters)(context.TODO(), []string{"svc-np.ns1"}).addrs
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	if !slices.Equal(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+
+	filters.nodeAddressType = "InternalIP"
+	expected = []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")}
+	addrs = lookupServiceIndex(services, nodes, endpointSlices, filters)(context.TODO(), []string{"svc-np.ns1"}).addrs
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	if !slices.Equal(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+}
+
+func TestLookupServiceLoadBalancerHostname(t *testing.T) {
+	resolver := resolverFunc(func(_ context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+		return []netip.Addr{netip.MustParseAddr("198.51.100.10")}, nil, nil
+	})
+
+	filters := newGateway().resourceFilters
+	filters.resolver = resolver
+	filters.indexLoadBalancerHostnames = true
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	svc := &core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-elb", Namespace: "ns1"},
+		Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+		Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+			Ingress: []core.LoadBalancerIngress{{Hostname: "A1B2.elb.example.com"}},
+		}},
+	}
+	if err := ctrl.GetIndexer().Add(svc); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	lookup := lookupServiceIndex(ctrl, nil, nil, filters)
+	expected := []netip.Addr{netip.MustParseAddr("198.51.100.10")}
+	for _, key := range []string{"svc-elb.ns1", "a1b2.elb.example.com"} {
+		if addrs := lookup(context.TODO(), []string{key}).addrs; !slices.Equal(addrs, expected) {
+			t.Errorf("Expected %v for %s, got %v", expected, key, addrs)
+		}
+	}
+
+	// load balancer hostnames aren't indexed by default
+	if hostnames, _ := serviceHostnameIndexFunc(newGateway().resourceFilters)(svc); !slices.Equal(hostnames, []string{"svc-elb.ns1"}) {
+		t.Errorf("Expected only svc-elb.ns1 to be indexed by default, got %v", hostnames)
+	}
+
+	// the name of the load balancer hostname doesn't point to itself
+	if cnames := newGateway().CNAME("a1b2.elb.example.com.", 60, []string{"a1b2.elb.example.com"}); len(cnames) != 0 {
+		t.Errorf("Expected no CNAME to the queried name itself, got %v", cnames)
+	}
+}
+
+func TestNormalizeHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		expected string
+	}{
+		{"Example.COM", "example.com"},
+		{"bücher.example.com", "xn--bcher-kva.example.com"},
+		{"BÜCHER.example.com", "xn--bcher-kva.example.com"},
+		{"xn--bcher-kva.example.com", "xn--bcher-kva.example.com"},
+		{`b\195\188cher.example.com`, "xn--bcher-kva.example.com"},
+		{"*.bücher.example.com", "*.xn--bcher-kva.example.com"},
+		{"svc1.ns1", "svc1.ns1"},
+		// invalid IDN labels are kept as they are
+		{"a\u200db.example.com", "a\u200db.example.com"},
+	}
+	for _, tc := range tests {
+		if hostname := normalizeHostname(tc.hostname); hostname != tc.expected {
+			t.Errorf("Expected %q for %q, got %q", tc.expected, tc.hostname, hostname)
+		}
+	}
+}
+
+func TestParseALPNAnnotation(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		expected    []string
+	}{
+		{nil, nil},
+		{map[string]string{alpnAnnotationKey: "h2"}, []string{"h2"}},
+		{map[string]string{alpnAnnotationKey: "h3, h2,http/1.1"}, []string{"h3", "h2", "http/1.1"}},
+		{map[string]string{alpnAnnotationKey: "h2,,h3"}, []string{"h2", "h3"}},
+		{map[string]string{alpnAnnotationKey: strings.Repeat("a", 256)}, nil},
+	}
+	for _, tc := range tests {
+		if alpn := parseALPNAnnotation(tc.annotations); !slices.Equal(alpn, tc.expected) {
+			t.Errorf("Expected %v for %v, got %v", tc.expected, tc.annotations, alpn)
+		}
+	}
+}
+
+func TestLookupServiceClusterIPs(t *testing.T) {
+	filters := newGateway().resourceFilters
+	filters.serviceTypes = []string{"LoadBalancer", "ClusterIP"}
+	filters.serviceClusterIPs = true
+
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{
+			serviceHostnameIndex: serviceHostnameIndexFunc(filters),
+			serviceAddressIndex:  serviceAddressIndexFunc(filters),
+		},
+	)
+	svc := &core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-lb", Namespace: "ns1"},
+		Spec: core.ServiceSpec{
+			Type:       core.ServiceTypeLoadBalancer,
+			ClusterIP:  "10.96.0.20",
+			ClusterIPs: []string{"10.96.0.20", "fd00:10:96::14"},
+		},
+		Status: core.ServiceStatus{
+			LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.20"}},
+			},
+		},
+	}
+	for _, obj := range []*core.Service{svc, testClusterIPServices["headless"].service} {
+		if err := ctrl.GetIndexer().Add(obj); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	lookup := lookupServiceIndex(ctrl, nil, nil, filters)
+	expected := []netip.Addr{netip.MustParseAddr("10.96.0.20"), netip.MustParseAddr("fd00:10:96::14")}
+	if addrs := lookup(context.TODO(), []string{"svc-lb.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected svc-lb.ns1 to resolve to %v, got %v", expected, addrs)
+	}
+	if result := lookup(context.TODO(), []string{"svc-headless.ns1"}); !result.isEmpty() {
+		t.Errorf("Expected headless service not to resolve, got %v", result.addrs)
+	}
+	if hostnames := reverseLookupServiceIndex(ctrl)(netip.MustParseAddr("fd00:10:96::14")); !slices.Equal(hostnames, []string{"svc-lb.ns1"}) {
+		t.Errorf("Expected cluster IP to reverse resolve to svc-lb.ns1, got %v", hostnames)
+	}
+	// for zones without the option
+	if hostnames := reverseLookupServiceIndex(ctrl)(netip.MustParseAddr("192.0.2.20")); !slices.Equal(hostnames, []string{"svc-lb.ns1"}) {
+		t.Errorf("Expected load balancer IP to reverse resolve to svc-lb.ns1, got %v", hostnames)
+	}
+
+	// load balancer addresses without the option
+	filters.serviceClusterIPs = false
+	expected = []netip.Addr{netip.MustParseAddr("192.0.2.20")}
+	if addrs := lookupServiceIndex(ctrl, nil, nil, filters)(context.TODO(), []string{"svc-lb.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected svc-lb.ns1 to resolve to %v, got %v", expected, addrs)
+	}
+}
+
+func TestLookupServiceTTL(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(newGateway().resourceFilters)},
+	)
+	for name, ttl := range map[string]string{"svc1": "30", "svc2": "15"} {
+		svc := testServices[name+".ns1"].DeepCopy()
+		svc.Annotations = map[string]string{
+			hostnameAnnotationKey:       "shared.example.com",
+			externalDnsTTLAnnotationKey: ttl,
+		}
+		if err := ctrl.GetIndexer().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	result := lookupServiceIndex(ctrl, nil, nil, newGateway().resourceFilters)(context.TODO(), []string{"shared.example.com"})
+	if ttl := result.ttlOr(ttlDefault); ttl != 15 {
+		t.Errorf("Expected lowest annotated TTL 15, got %d", ttl)
+	}
+
+	// objects without the annotation keep the default TTL
+	result = lookupServiceIndex(ctrl, nil, nil, newGateway().resourceFilters)(context.TODO(), []string{"svc3.ns1"})
+	if result.ttl != nil {
+		t.Errorf("Expected no TTL override, got %d", *result.ttl)
+	}
+}
+
+func TestLookupServiceWeight(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(newGateway().resourceFilters)},
+	)
+	for name, weight := range map[string]string{"svc1": "3", "svc2": "abc"} {
+		svc := testServices[name+".ns1"].DeepCopy()
+		svc.Annotations = map[string]string{
+			hostnameAnnotationKey: "shared.example.com",
+			weightAnnotationKey:   weight,
+		}
+		if err := ctrl.GetIndexer().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	// the invalid weight is ignored, leaving the default weight
+	result := lookupServiceIndex(ctrl, nil, nil, newGateway().resourceFilters)(context.TODO(), []string{"shared.example.com"})
+	expected := map[netip.Addr]uint32{netip.MustParseAddr("192.0.0.1"): 3}
+	if !maps.Equal(result.weights, expected) {
+		t.Errorf("Expected weights %v, got %v", expected, result.weights)
+	}
+
+	// a weight of 0 is kept rather than counting as 1
+	standby := testServices["svc2.ns1"].DeepCopy()
+	standby.Annotations = map[string]string{weightAnnotationKey: "0"}
+	if err := ctrl.GetIndexer().Update(standby); err != nil {
+		t.Fatalf("Failed to update Service in indexer: %s", err)
+	}
+	result = lookupServiceIndex(ctrl, nil, nil, newGateway().resourceFilters)(context.TODO(), []string{"svc2.ns1"})
+	expected = map[netip.Addr]uint32{}
+	for _, addr := range result.addrs {
+		expected[addr] = 0
+	}
+	if len(expected) == 0 || !maps.Equal(result.weights, expected) {
+		t.Errorf("Expected weights %v, got %v", expected, result.weights)
+	}
+}
+
+func TestLookupServiceTarget(t *testing.T) {
+	resolver := resolverFunc(func(_ context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+		if hostname != "lb.example.net" {
+			return nil, nil, fmt.Errorf("unexpected hostname %s", hostname)
+		}
+		return []netip.Addr{netip.MustParseAddr("198.51.100.1")}, nil, nil
+	})
+
+	filters := newGateway().resourceFilters
+	filters.resolver = resolver
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{
+			serviceHostnameIndex: serviceHostnameIndexFunc(filters),
+			serviceAddressIndex:  serviceAddressIndexFunc(filters),
+		},
+	)
+	for name, target := range map[string]string{"svc1": "203.0.113.1, 2001:db8::1", "svc2": "LB.example.net."} {
+		svc := testServices[name+".ns1"].DeepCopy()
+		svc.Annotations = map[string]string{externalDnsTargetAnnotationKey: target}
+		if err := ctrl.GetIndexer().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+	lookup := lookupServiceIndex(ctrl, nil, nil, filters)
+
+	// IP targets replace the load balancer status
+	expected := []netip.Addr{netip.MustParseAddr("203.0.113.1"), netip.MustParseAddr("2001:db8::1")}
+	if addrs := lookup(context.TODO(), []string{"svc1.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected svc1.ns1 to resolve to %v, got %v", expected, addrs)
+	}
+	if hostnames := reverseLookupServiceIndex(ctrl)(netip.MustParseAddr("203.0.113.1")); !slices.Equal(hostnames, []string{"svc1.ns1"}) {
+		t.Errorf("Expected target IP to reverse resolve to svc1.ns1, got %v", hostnames)
+	}
+	if hostnames := reverseLookupServiceIndex(ctrl)(netip.MustParseAddr("192.0.0.1")); len(hostnames) != 0 {
+		t.Errorf("Expected load balancer IP not to reverse resolve, got %v", hostnames)
+	}
+
+	// hostname targets are resolved and kept as CNAME targets
+	result := lookup(context.TODO(), []string{"svc2.ns1"})
+	expected = []netip.Addr{netip.MustParseAddr("198.51.100.1")}
+	if !slices.Equal(result.addrs, expected) {
+		t.Errorf("Expected svc2.ns1 to resolve to %v, got %v", expected, result.addrs)
+	}
+	if cnames := result.hostnames; !slices.Equal(cnames, []string{"lb.example.net"}) {
+		t.Errorf("Expected CNAME target lb.example.net, got %v", cnames)
+	}
+
+	// the load balancer status is used without the annotation
+	svc := testServices["svc2.ns1"].DeepCopy()
+	if err := ctrl.GetIndexer().Update(svc); err != nil {
+		t.Fatalf("Failed to update Service in indexer: %s", err)
+	}
+	expected = []netip.Addr{netip.MustParseAddr("192.0.0.2")}
+	if addrs := lookup(context.TODO(), []string{"svc2.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected svc2.ns1 to resolve to %v, got %v", expected, addrs)
+	}
+}
+
+func TestParseWeightAnnotation(t *testing.T) {
+	tests := []struct {
+		value          string
+		expectedWeight uint32
+		expectedOk     bool
+	}{
+		{"5", 5, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"1.5", 0, false},
+		{"abc", 0, false},
+	}
+	for _, test := range tests {
+		weight, ok := parseWeightAnnotation(map[string]string{weightAnnotationKey: test.value})
+		if weight != test.expectedWeight || ok != test.expectedOk {
+			t.Errorf("Annotation %q: expected weight %d (%t), got %d (%t)", test.value, test.expectedWeight, test.expectedOk, weight, ok)
+		}
+	}
+	if _, ok := parseWeightAnnotation(nil); ok {
+		t.Errorf("Expected missing weight annotation to be ignored")
+	}
+}
+
+func TestParseTTLAnnotation(t *testing.T) {
+	for value, expected := range map[string]uint32{
+		"15":  15,
+		"1m":  60,
+		"90s": 90,
+		"-5":  0,
+		"abc": 0,
+	} {
+		ttl, _ := parseTTLAnnotation(map[string]string{externalDnsTTLAnnotationKey: value})
+		if ttl != expected {
+			t.Errorf("Annotation %q: expected TTL %d, got %d", value, expected, ttl)
+		}
+	}
+	if _, ok := parseTTLAnnotation(map[string]string{externalDnsTTLAnnotationKey: "abc"}); ok {
+		t.Errorf("Expected invalid TTL annotation to be ignored")
+	}
+
+	tests := []struct {
+		annotations map[string]string
+		expectedTTL uint32
+		expectedOk  bool
+	}{
+		{map[string]string{ttlAnnotationKey: "30"}, 30, true},
+		{map[string]string{ttlAnnotationKey: "0"}, 0, true},
+		{map[string]string{ttlAnnotationKey: "-1"}, 0, false},
+		{map[string]string{ttlAnnotationKey: "1m"}, 0, false},
+		{map[string]string{ttlAnnotationKey: "abc"}, 0, false},
+		// takes precedence over the external-dns annotation, unless it's invalid
+		{map[string]string{ttlAnnotationKey: "30", externalDnsTTLAnnotationKey: "15"}, 30, true},
+		{map[string]string{ttlAnnotationKey: "abc", externalDnsTTLAnnotationKey: "15"}, 15, true},
+	}
+	for i, test := range tests {
+		ttl, ok := parseTTLAnnotation(test.annotations)
+		if ttl != test.expectedTTL || ok != test.expectedOk {
+			t.Errorf("Test %d: Expected TTL %d (%t), got %d (%t)", i, test.expectedTTL, test.expectedOk, ttl, ok)
+		}
+	}
+}
+
+func TestLookupRouteBackendRefHostnames(t *testing.T) {
+	gwCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+	)
+	gateway := testGateways["ns1/gw-1"].DeepCopy()
+	gateway.Status.Addresses[0].Type = ptr.To(gatewayapi_v1.IPAddressType)
+	if err := gwCtrl.GetIndexer().Add(gateway); err != nil {
+		t.Fatalf("Failed to add Gateway to indexer: %s", err)
+	}
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayServiceIndex: gatewayServiceIndexFunc},
+	)
+
+	route := &gatewayapi_v1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "route-backends", Namespace: "ns1"},
+		Spec: gatewayapi_v1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{
+				ParentRefs: []gatewayapi_v1.ParentReference{{Name: "gw-1"}},
+			},
+			Hostnames: []gatewayapi_v1.Hostname{"app.example.com"},
+			Rules: []gatewayapi_v1.HTTPRouteRule{{
+				BackendRefs: []gatewayapi_v1.HTTPBackendRef{
+					{BackendRef: gatewayapi_v1.BackendRef{BackendObjectReference: gatewayapi_v1.BackendObjectReference{Name: "Backend"}}},
+					{BackendRef: gatewayapi_v1.BackendRef{BackendObjectReference: gatewayapi_v1.BackendObjectReference{
+						Name: "shared", Namespace: ptr.To(gatewayapi_v1.Namespace("ns2")),
+					}}},
+					// only Services are published
+					{BackendRef: gatewayapi_v1.BackendRef{BackendObjectReference: gatewayapi_v1.BackendObjectReference{
+						Name: "bucket", Group: ptr.To(gatewayapi_v1.Group("storage.example.com")), Kind: ptr.To(gatewayapi_v1.Kind("Bucket")),
+					}}},
+				},
+			}},
+		},
+	}
+
+	tests := []struct {
+		backendRefHostnames bool
+		expected            []string
+	}{
+		{false, []string{"app.example.com"}},
+		{true, []string{"app.example.com", "backend.ns1", "shared.ns2"}},
+	}
+	gwAddr := []netip.Addr{netip.MustParseAddr("192.0.2.100")}
+	for i, tc := range tests {
+		gw := newGateway()
+		gw.resourceFilters.backendRefHostnames = tc.backendRefHostnames
+		indexFunc := (&KubeController{gateway: gw}).routeIndexFunc(httpRouteHostnameIndexFunc)
+		if hostnames, _ := indexFunc(route); !slices.Equal(hostnames, tc.expected) {
+			t.Errorf("Test %d: Expected hostnames %v, got %v", i, tc.expected, hostnames)
+		}
+
+		routeCtrl := cache.NewSharedIndexInformer(
+			&cache.ListWatch{},
+			&gatewayapi_v1.HTTPRoute{},
+			defaultResyncPeriod,
+			cache.Indexers{httpRouteHostnameIndex: indexFunc},
+		)
+		if err := routeCtrl.GetIndexer().Add(route); err != nil {
+			t.Fatalf("Failed to add HTTPRoute to indexer: %s", err)
+		}
+		lookup := lookupHttpRouteIndex(routeCtrl, gwCtrl, svcCtrl, nil, gw.resourceFilters)
+		addrs := lookup(context.TODO(), []string{"backend.ns1.example.com", "backend.ns1"}).addrs
+		if tc.backendRefHostnames && !slices.Equal(addrs, gwAddr) {
+			t.Errorf("Test %d: Expected the backend name to resolve to %v, got %v", i, gwAddr, addrs)
+		}
+		if !tc.backendRefHostnames && len(addrs) != 0 {
+			t.Errorf("Test %d: Expected the backend name not to resolve, got %v", i, addrs)
+		}
+	}
+}
+
+func TestLookupAcceptedRoutes(t *testing.T) {
+	gwCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+	)
+	gateway := testGateways["ns1/gw-1"].DeepCopy()
+	gateway.Status.Addresses[0].Type = ptr.To(gatewayapi_v1.IPAddressType)
+	if err := gwCtrl.GetIndexer().Add(gateway); err != nil {
+		t.Fatalf("Failed to add Gateway to indexer: %s", err)
+	}
+	routeCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.HTTPRoute{},
+		defaultResyncPeriod,
+		cache.Indexers{httpRouteHostnameIndex: httpRouteHostnameIndexFunc},
+	)
+	for name, accepted := range map[string]metav1.ConditionStatus{
+		"accepted": metav1.ConditionTrue,
+		"rejected": metav1.ConditionFalse,
+	} {
+		route := &gatewayapi_v1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", Annotations: map[string]string{alpnAnnotationKey: "h2,http/1.1"}},
+			Spec: gatewayapi_v1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{
+					ParentRefs: []gatewayapi_v1.ParentReference{{Name: "gw-1"}},
+				},
+				Hostnames: []gatewayapi_v1.Hostname{gatewayapi_v1.Hostname(name + ".example.com")},
+			},
+			Status: gatewayapi_v1.HTTPRouteStatus{
+				RouteStatus: gatewayapi_v1.RouteStatus{
+					Parents: []gatewayapi_v1.RouteParentStatus{{
+						ParentRef: gatewayapi_v1.ParentReference{Name: "gw-1"},
+						Conditions: []metav1.Condition{{
+							Type:   string(gatewayapi_v1.RouteConditionAccepted),
+							Status: accepted,
+						}},
+					}},
+				},
+			},
+		}
+		if err := routeCtrl.GetIndexer().Add(route); err != nil {
+			t.Fatalf("Failed to add HTTPRoute to indexer: %s", err)
+		}
+	}
+
+	gwAddr := []netip.Addr{netip.MustParseAddr("192.0.2.100")}
+
+	// attachment status is ignored by default
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayServiceIndex: gatewayServiceIndexFunc},
+	)
+
+	lookup := lookupHttpRouteIndex(routeCtrl, gwCtrl, svcCtrl, nil, newGateway().resourceFilters)
+	if addrs := lookup(context.TODO(), []string{"rejected.example.com"}).addrs; !slices.Equal(addrs, gwAddr) {
+		t.Errorf("Expected rejected route to resolve to %v by default, got %v", gwAddr, addrs)
+	}
+
+	filters := newGateway().resourceFilters
+	filters.acceptedRoutesOnly = true
+	lookup = lookupHttpRouteIndex(routeCtrl, gwCtrl, svcCtrl, nil, filters)
+	if addrs := lookup(context.TODO(), []string{"accepted.example.com"}).addrs; !slices.Equal(addrs, gwAddr) {
+		t.Errorf("Expected accepted route to resolve to %v, got %v", gwAddr, addrs)
+	}
+	if alpn := lookup(context.TODO(), []string{"accepted.example.com"}).alpn; !slices.Equal(alpn, []string{"h2", "http/1.1"}) {
+		t.Errorf("Expected the ALPN protocols of the route, got %v", alpn)
+	}
+	if addrs := lookup(context.TODO(), []string{"rejected.example.com"}).addrs; len(addrs) != 0 {
+		t.Errorf("Expected rejected route to be excluded, got %v", addrs)
+	}
+}
+
+func TestGatewayAddressesProgrammed(t *testing.T) {
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayServiceIndex: gatewayServiceIndexFunc},
+	)
+	condition := func(conditionType gatewayapi_v1.GatewayConditionType, status metav1.ConditionStatus) metav1.Condition {
+		return metav1.Condition{Type: string(conditionType), Status: status}
+	}
+	tests := []struct {
+		conditions []metav1.Condition
+		programmed bool
+	}{
+		{[]metav1.Condition{
+			condition(gatewayapi_v1.GatewayConditionAccepted, metav1.ConditionTrue),
+			condition(gatewayapi_v1.GatewayConditionProgrammed, metav1.ConditionTrue),
+		}, true},
+		{[]metav1.Condition{
+			condition(gatewayapi_v1.GatewayConditionAccepted, metav1.ConditionTrue),
+			condition(gatewayapi_v1.GatewayConditionProgrammed, metav1.ConditionFalse),
+		}, false},
+		{[]metav1.Condition{
+			condition(gatewayapi_v1.GatewayConditionAccepted, metav1.ConditionFalse),
+			condition(gatewayapi_v1.GatewayConditionProgrammed, metav1.ConditionTrue),
+		}, false},
+		{nil, false},
+	}
+
+	gwAddr := []netip.Addr{netip.MustParseAddr("192.0.2.100")}
+	for i, tc := range tests {
+		gateway := testGateways["ns1/gw-1"].DeepCopy()
+		gateway.Status.Addresses[0].Type = ptr.To(gatewayapi_v1.IPAddressType)
+		gateway.Status.Conditions = tc.conditions
+
+		// conditions are ignored by default
+		filters := newGateway().resourceFilters
+		if addrs := gatewayAddresses(context.TODO(), svcCtrl, gateway, filters).addrs; !slices.Equal(addrs, gwAddr) {
+			t.Errorf("Test %d: Expected %v by default, got %v", i, gwAddr, addrs)
+		}
+
+		filters.programmedGatewaysOnly = true
+		var expected []netip.Addr
+		if tc.programmed {
+			expected = gwAddr
+		}
+		if addrs := gatewayAddresses(context.TODO(), svcCtrl, gateway, filters).addrs; !slices.Equal(addrs, expected) {
+			t.Errorf("Test %d: Expected %v with programmedGatewaysOnly, got %v", i, expected, addrs)
+		}
+	}
+}
+
+func TestLookupVirtualServiceIndex(t *testing.T) {
+	vsCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&istio_v1beta1.VirtualService{},
+		defaultResyncPeriod,
+		cache.Indexers{virtualServiceHostnameIndex: virtualServiceHostnameIndexFunc},
+	)
+	gwCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&istio_v1beta1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+	)
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceSelectorIndex: serviceSelectorIndexFunc},
+	)
+	for _, vs := range testVirtualServices {
+		if err := vsCtrl.GetIndexer().Add(vs); err != nil {
+			t.Fatalf("Failed to add VirtualService to indexer: %s", err)
+		}
+	}
+	if err := gwCtrl.GetIndexer().Add(testIstioGateway); err != nil {
+		t.Fatalf("Failed to add Istio Gateway to indexer: %s", err)
+	}
+	for _, svc := range testIstioServices {
+		if err := svcCtrl.GetIndexer().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	lookup := lookupVirtualServiceIndex(vsCtrl, gwCtrl, svcCtrl, newGateway().resourceFilters)
+	for key, expected := range map[string][]netip.Addr{
+		"app.example.com":  {netip.MustParseAddr("192.0.2.50")},
+		"APP.example.com":  {netip.MustParseAddr("192.0.2.50")},
+		"mesh.example.com": nil,
+		"none.example.com": nil,
+	} {
+		if addrs := lookup(context.TODO(), []string{key}).addrs; !slices.Equal(addrs, expected) {
+			t.Errorf("Expected %s to resolve to %v, got %v", key, expected, addrs)
+		}
+	}
+}
+
+func TestGrantedParentRefs(t *testing.T) {
+	grants := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1beta1.ReferenceGrant{},
+		defaultResyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+	grant := &gatewayapi_v1beta1.ReferenceGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "grant", Namespace: "infra"},
+		Spec: gatewayapi_v1beta1.ReferenceGrantSpec{
+			From: []gatewayapi_v1beta1.ReferenceGrantFrom{{Group: gatewayapi_v1.GroupName, Kind: "HTTPRoute", Namespace: "ns1"}},
+			To:   []gatewayapi_v1beta1.ReferenceGrantTo{{Group: gatewayapi_v1.GroupName, Kind: "Gateway", Name: ptr.To(gatewayapi_v1.ObjectName("gw-granted"))}},
+		},
+	}
+	if err := grants.GetIndexer().Add(grant); err != nil {
+		t.Fatalf("Failed to add ReferenceGrant to indexer: %s", err)
+	}
+
+	refs := []gatewayapi_v1.ParentReference{
+		{Name: "gw-local"},
+		{Name: "gw-same", Namespace: ptr.To(gatewayapi_v1.Namespace("ns1"))},
+		{Name: "gw-granted", Namespace: ptr.To(gatewayapi_v1.Namespace("infra"))},
+		{Name: "gw-ungranted", Namespace: ptr.To(gatewayapi_v1.Namespace("infra"))},
+		{Name: "gw-granted", Namespace: ptr.To(gatewayapi_v1.Namespace("other"))},
+	}
+	names := func(refs []gatewayapi_v1.ParentReference) (result []string) {
+		for _, ref := range refs {
+			ns := "ns1"
+			if ref.Namespace != nil {
+				ns = string(*ref.Namespace)
+			}
+			result = append(result, ns+"/"+string(ref.Name))
+		}
+		return
+	}
+
+	expected := []string{"ns1/gw-local", "ns1/gw-same", "infra/gw-granted"}
+	if granted := names(grantedParentRefs(grants, "HTTPRoute", "ns1", refs)); !slices.Equal(granted, expected) {
+		t.Errorf("Expected granted refs %v, got %v", expected, granted)
+	}
+	// the grant only covers HTTPRoutes from ns1
+	expected = []string{"ns1/gw-local", "ns1/gw-same"}
+	if granted := names(grantedParentRefs(grants, "TLSRoute", "ns1", refs)); !slices.Equal(granted, expected) {
+		t.Errorf("Expected granted refs %v, got %v", expected, granted)
+	}
+	// every ref is kept when grants aren't required
+	if granted := grantedParentRefs(nil, "HTTPRoute", "ns1", refs); len(granted) != len(refs) {
+		t.Errorf("Expected all %d refs without required grants, got %d", len(refs), len(granted))
+	}
+}
+
+func TestFetchGatewayServiceIPs(t *testing.T) {
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayServiceIndex: gatewayServiceIndexFunc},
+	)
+	for _, svc := range testGatewayServices {
+		if err := svcCtrl.GetIndexer().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	labeled := &gatewayapi_v1.Gateway{ObjectMeta: metav1.ObjectMeta{Name: "gw-2", Namespace: "ns1"}}
+	annotated := &gatewayapi_v1.Gateway{ObjectMeta: metav1.ObjectMeta{
+		Name:        "gw-3",
+		Namespace:   "ns1",
+		Annotations: map[string]string{gatewayServiceAnnotationKey: "infra/shared-lb"},
+	}}
+	unbacked := &gatewayapi_v1.Gateway{ObjectMeta: metav1.ObjectMeta{Name: "gw-4", Namespace: "ns1"}}
+
+	for _, tc := range []struct {
+		gateway  *gatewayapi_v1.Gateway
+		expected []netip.Addr
+	}{
+		{labeled, []netip.Addr{netip.MustParseAddr("192.0.2.110")}},
+		{annotated, []netip.Addr{netip.MustParseAddr("192.0.2.120")}},
+		{unbacked, nil},
+	} {
+		if addrs := fetchGatewayServiceIPs(context.TODO(), svcCtrl, tc.gateway, ResourceFilters{}).addrs; !slices.Equal(addrs, tc.expected) {
+			t.Errorf("Gateway %s: expected %v, got %v", tc.gateway.Name, tc.expected, addrs)
+		}
+	}
+
+	// status addresses take precedence over the backing Service
+	gwCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+	)
+	withStatus := labeled.DeepCopy()
+	withStatus.Status.Addresses = []gatewayapi_v1.GatewayStatusAddress{{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: "192.0.2.100"}}
+	if err := gwCtrl.GetIndexer().Add(withStatus); err != nil {
+		t.Fatalf("Failed to add Gateway to indexer: %s", err)
+	}
+	refs := []gatewayapi_v1.ParentReference{{Name: "gw-2"}}
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.100")}
+	if addrs := lookupGateways(context.TODO(), gwCtrl, svcCtrl, "HTTPRoute", refs, nil, "ns1", newGateway().resourceFilters).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected status addresses %v, got %v", expected, addrs)
+	}
+
+	if err := gwCtrl.GetIndexer().Update(labeled); err != nil {
+		t.Fatalf("Failed to update Gateway in indexer: %s", err)
+	}
+	expected = []netip.Addr{netip.MustParseAddr("192.0.2.110")}
+	if addrs := lookupGateways(context.TODO(), gwCtrl, svcCtrl, "HTTPRoute", refs, nil, "ns1", newGateway().resourceFilters).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected fallback to Service addresses %v, got %v", expected, addrs)
+	}
+}
+
+func TestGatewayAddressAnnotation(t *testing.T) {
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayServiceIndex: gatewayServiceIndexFunc},
+	)
+	for _, svc := range testGatewayServices {
+		if err := svcCtrl.GetIndexer().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+
+	const key = "cloud.example.com/static-ip"
+	filters := newGateway().resourceFilters
+	filters.gatewayAddressAnnotation = key
+
+	annotated := &gatewayapi_v1.Gateway{ObjectMeta: metav1.ObjectMeta{
+		Name:        "gw-5",
+		Namespace:   "ns1",
+		Annotations: map[string]string{key: "192.0.2.130, 2001:db8::130,invalid"},
+	}}
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.130"), netip.MustParseAddr("2001:db8::130")}
+	if addrs := gatewayAddresses(context.TODO(), svcCtrl, annotated, filters).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected annotation addresses %v, got %v", expected, addrs)
+	}
+	if addrs := gatewayAddresses(context.TODO(), svcCtrl, annotated, newGateway().resourceFilters).addrs; len(addrs) != 0 {
+		t.Errorf("Expected the annotation to be ignored unless configured, got %v", addrs)
+	}
+
+	// status addresses take precedence over the annotation
+	withStatus := annotated.DeepCopy()
+	withStatus.Status.Addresses = []gatewayapi_v1.GatewayStatusAddress{{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: "192.0.2.100"}}
+	expected = []netip.Addr{netip.MustParseAddr("192.0.2.100")}
+	if addrs := gatewayAddresses(context.TODO(), svcCtrl, withStatus, filters).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected status addresses %v, got %v", expected, addrs)
+	}
+
+	// and the annotation over the backing Service
+	labeled := &gatewayapi_v1.Gateway{ObjectMeta: metav1.ObjectMeta{
+		Name:        "gw-2",
+		Namespace:   "ns1",
+		Annotations: map[string]string{key: "192.0.2.130"},
+	}}
+	expected = []netip.Addr{netip.MustParseAddr("192.0.2.130")}
+	if addrs := gatewayAddresses(context.TODO(), svcCtrl, labeled, filters).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected annotation addresses %v, got %v", expected, addrs)
+	}
+}
+
+func TestLookupGatewaysDuplicateParentRefs(t *testing.T) {
+	gwCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+	)
+	gateway := &gatewayapi_v1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw-1", Namespace: "ns1"},
+		Status: gatewayapi_v1.GatewayStatus{Addresses: []gatewayapi_v1.GatewayStatusAddress{
+			{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: "192.0.2.100"},
+			{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: "2001:db8::100"},
+		}},
+	}
+	if err := gwCtrl.GetIndexer().Add(gateway); err != nil {
+		t.Fatalf("Failed to add Gateway to indexer: %s", err)
+	}
+
+	// the same Gateway referenced once per listener, and once with an explicit namespace
+	refs := []gatewayapi_v1.ParentReference{
+		{Name: "gw-1", SectionName: ptr.To(gatewayapi_v1.SectionName("http"))},
+		{Name: "gw-1", SectionName: ptr.To(gatewayapi_v1.SectionName("https"))},
+		{Name: "gw-1", Namespace: ptr.To(gatewayapi_v1.Namespace("ns1"))},
+	}
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.100"), netip.MustParseAddr("2001:db8::100")}
+	if addrs := lookupGateways(context.TODO(), gwCtrl, nil, "HTTPRoute", refs, nil, "ns1", newGateway().resourceFilters).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected each address once %v, got %v", expected, addrs)
+	}
+}
+
+func TestLookupGatewaysListenerProtocols(t *testing.T) {
+	gwCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+	)
+	for name, listeners := range map[string][]gatewayapi_v1.Listener{
+		"tcp-only": {{Name: "tcp", Protocol: gatewayapi_v1.TCPProtocolType, Port: 5432}},
+		"mixed": {
+			{Name: "tls", Protocol: gatewayapi_v1.TLSProtocolType, Port: 8443},
+			{Name: "https", Protocol: gatewayapi_v1.HTTPSProtocolType, Port: 443},
+		},
+	} {
+		if err := gwCtrl.GetIndexer().Add(&gatewayapi_v1.Gateway{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1"},
+			Spec:       gatewayapi_v1.GatewaySpec{Listeners: listeners},
+			Status: gatewayapi_v1.GatewayStatus{Addresses: []gatewayapi_v1.GatewayStatusAddress{
+				{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: "192.0.2.100"},
+			}},
+		}); err != nil {
+			t.Fatalf("Failed to add Gateway to indexer: %s", err)
+		}
+	}
+
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.100")}
+	tests := []struct {
+		kind     string
+		ref      gatewayapi_v1.ParentReference
+		expected []netip.Addr
+	}{
+		{"HTTPRoute", gatewayapi_v1.ParentReference{Name: "tcp-only"}, nil},
+		{"GRPCRoute", gatewayapi_v1.ParentReference{Name: "tcp-only"}, nil},
+		{"TLSRoute", gatewayapi_v1.ParentReference{Name: "tcp-only"}, expected},
+		{"HTTPRoute", gatewayapi_v1.ParentReference{Name: "mixed"}, expected},
+		// only the listener named by the parent reference counts
+		{"HTTPRoute", gatewayapi_v1.ParentReference{Name: "mixed", SectionName: ptr.To(gatewayapi_v1.SectionName("tls"))}, nil},
+		{"GRPCRoute", gatewayapi_v1.ParentReference{Name: "mixed", SectionName: ptr.To(gatewayapi_v1.SectionName("https"))}, expected},
+		{"TLSRoute", gatewayapi_v1.ParentReference{Name: "mixed", SectionName: ptr.To(gatewayapi_v1.SectionName("https"))}, nil},
+	}
+	for i, tc := range tests {
+		refs := []gatewayapi_v1.ParentReference{tc.ref}
+		if addrs := lookupGateways(context.TODO(), gwCtrl, nil, tc.kind, refs, nil, "ns1", newGateway().resourceFilters).addrs; !slices.Equal(addrs, tc.expected) {
+			t.Errorf("Test %d: Expected %v for a %s attached to %s, got %v", i, tc.expected, tc.kind, tc.ref.Name, addrs)
+		}
+	}
+}
+
+func TestLookupGatewayHostnameAnnotation(t *testing.T) {
+	gwCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{
+			gatewayUniqueIndex:   gatewayIndexFunc,
+			gatewayHostnameIndex: gatewayHostnameIndexFunc,
+		},
+	)
+	gateways := []*gatewayapi_v1.Gateway{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "gw-annotated",
+				Namespace:   "ns1",
+				Annotations: map[string]string{hostnameAnnotationKey: "Gateway.example.com, gw.example.com"},
+			},
+			Spec: gatewayapi_v1.GatewaySpec{GatewayClassName: "internal"},
+			Status: gatewayapi_v1.GatewayStatus{Addresses: []gatewayapi_v1.GatewayStatusAddress{
+				{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: "192.0.2.200"},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw-plain", Namespace: "ns1"},
+			Status: gatewayapi_v1.GatewayStatus{Addresses: []gatewayapi_v1.GatewayStatusAddress{
+				{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: "192.0.2.201"},
+			}},
+		},
+	}
+	for _, gateway := range gateways {
+		if err := gwCtrl.GetIndexer().Add(gateway); err != nil {
+			t.Fatalf("Failed to add Gateway to indexer: %s", err)
+		}
+	}
+
+	filters := newGateway().resourceFilters
+	lookup := lookupGatewayHostnameIndex(gwCtrl, nil, filters)
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.200")}
+	for _, hostname := range []string{"gateway.example.com", "gw.example.com"} {
+		if addrs := lookup(context.TODO(), []string{hostname}).addrs; !slices.Equal(addrs, expected) {
+			t.Errorf("Expected %v for %s, got %v", expected, hostname, addrs)
+		}
+	}
+	if result := lookup(context.TODO(), []string{"gw-plain.example.com"}); !result.isEmpty() {
+		t.Errorf("Expected no addresses for a Gateway without the annotation, got %v", result.addrs)
+	}
+
+	// route matches take precedence over annotated Gateways
+	routeAddr := netip.MustParseAddr("192.0.2.1")
+	routes := func(_ context.Context, indexKeys []string) (result lookupResult) {
+		if slices.Contains(indexKeys, "gw.example.com") {
+			result.addrs = []netip.Addr{routeAddr}
+		}
+		return
+	}
+	combined := lookupWithFallback(routes, lookup)
+	if addrs := combined(context.TODO(), []string{"gw.example.com"}).addrs; !slices.Equal(addrs, []netip.Addr{routeAddr}) {
+		t.Errorf("Expected the route address, got %v", addrs)
+	}
+	if addrs := combined(context.TODO(), []string{"gateway.example.com"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected the annotated Gateway address %v, got %v", expected, addrs)
+	}
+
+	filters.gatewayClasses = []string{"external"}
+	result := lookupGatewayHostnameIndex(gwCtrl, nil, filters)(context.TODO(), []string{"gw.example.com"})
+	if !result.isEmpty() || !result.filtered {
+		t.Errorf("Expected the Gateway to be filtered by its class, got %v", result.addrs)
+	}
+
+	// the classes of the zone of a query take precedence
+	zoneFilters := filters
+	zoneFilters.gatewayClasses = nil
+	ctx := context.WithValue(context.TODO(), zoneFiltersKey{}, &zoneFilters)
+	if addrs := lookupGatewayHostnameIndex(gwCtrl, nil, filters)(ctx, []string{"gw.example.com"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected the Gateway address %v in the zone, got %v", expected, addrs)
+	}
+}
+
+func TestFetchHostnameIPsTTL(t *testing.T) {
+	resolver := resolverFunc(func(_ context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+		ttl := map[string]uint32{"lb1.example.net": 30, "lb2.example.net": 10}[hostname]
+		return []netip.Addr{netip.MustParseAddr("198.51.100.1")}, &ttl, nil
+	})
+
+	result := fetchServiceLoadBalancerIPs(context.TODO(), []core.LoadBalancerIngress{
+		{Hostname: "lb1.example.net"},
+		{Hostname: "lb2.example.net"},
+		{IP: "192.0.2.1"},
+	}, ResourceFilters{resolver: resolver})
+	if len(result.addrs) != 3 {
+		t.Errorf("Expected 3 addresses, got %v", result.addrs)
+	}
+	if result.upstreamTTL == nil || *result.upstreamTTL != 10 {
+		t.Errorf("Expected the lowest upstream TTL 10, got %v", result.upstreamTTL)
+	}
+	if expected := []string{"lb1.example.net", "lb2.example.net"}; !slices.Equal(result.hostnames, expected) {
+		t.Errorf("Expected CNAME targets %v, got %v", expected, result.hostnames)
+	}
+
+	// plain IPs carry no upstream TTL
+	result = fetchIngressLoadBalancerIPs(context.TODO(), []networking.IngressLoadBalancerIngress{{IP: "192.0.2.1"}}, ResourceFilters{resolver: resolver})
+	if result.upstreamTTL != nil {
+		t.Errorf("Expected no upstream TTL, got %d", *result.upstreamTTL)
+	}
+}
+
+func TestFetchHostnameIPsErrors(t *testing.T) {
+	resolver := resolverFunc(func(_ context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+		return nil, nil, fmt.Errorf("lookup %s: no such host", hostname)
+	})
+
+	resolutionErrorCount := func(resource string) float64 {
+		metric := &dto.Metric{}
+		if err := resolutionErrors.WithLabelValues(resource).Write(metric); err != nil {
+			t.Fatalf("Failed to read resolution errors metric: %s", err)
+		}
+		return metric.GetCounter().GetValue()
+	}
+	before := map[string]float64{}
+	for _, resource := range []string{"Service", "Ingress", "Gateway"} {
+		before[resource] = resolutionErrorCount(resource)
+	}
+
+	results := []lookupResult{
+		fetchServiceLoadBalancerIPs(context.TODO(), []core.LoadBalancerIngress{{Hostname: "lb1.example.net"}, {Hostname: "lb2.example.net"}}, ResourceFilters{resolver: resolver}),
+		fetchIngressLoadBalancerIPs(context.TODO(), []networking.IngressLoadBalancerIngress{{Hostname: "lb.example.net"}}, ResourceFilters{resolver: resolver}),
+		fetchGatewayIPs(context.TODO(), &gatewayapi_v1.Gateway{Status: gatewayapi_v1.GatewayStatus{Addresses: []gatewayapi_v1.GatewayStatusAddress{
+			{Type: ptr.To(gatewayapi_v1.HostnameAddressType), Value: "lb.example.net"},
+		}}}, resolver),
+	}
+	for i, result := range results {
+		if len(result.addrs) != 0 {
+			t.Errorf("Test %d: Expected no addresses, got %v", i, result.addrs)
+		}
+	}
+	for resource, expected := range map[string]float64{"Service": 2, "Ingress": 1, "Gateway": 1} {
+		if value := resolutionErrorCount(resource) - before[resource]; value != expected {
+			t.Errorf("Expected resolution errors metric for %s to increase by %v, got %v", resource, expected, value)
+		}
+	}
+
+	// failures answered by a nameserver rather than returned by the resolver
+	server := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		if r.Question[0].Name == "missing.example.net." {
+			m.SetRcode(r, dns.RcodeNameError)
+		} else {
+			m.SetRcode(r, dns.RcodeServerFailure)
+		}
+		if err := w.WriteMsg(m); err != nil {
+			t.Errorf("Failed to write response: %s", err)
+		}
+	})
+	defer server.Close()
+
+	upstream := newUpstreamResolver([]string{server.Addr})
+	for _, hostname := range []string{"missing.example.net", "failing.example.net"} {
+		before := resolutionErrorCount("Service")
+		if result := fetchHostnameIPs(context.TODO(), upstream, "Service", hostname); len(result.addrs) != 0 {
+			t.Errorf("Expected no addresses for %s, got %v", hostname, result.addrs)
+		}
+		if value := resolutionErrorCount("Service") - before; value != 1 {
+			t.Errorf("Expected resolution errors metric to increase by 1 for %s, got %v", hostname, value)
+		}
+	}
+}
+
+func TestFetchHostnameIPsWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	golog.SetOutput(&buf)
+	defer golog.SetOutput(os.Stderr)
+
+	resolver := resolverFunc(func(_ context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+		return nil, nil, fmt.Errorf("lookup %s: no such host", hostname)
+	})
+	// every query of a name backed by the hostname looks it up again
+	for range 3 {
+		fetchHostnameIPs(context.TODO(), resolver, "Service", "warned.example.net")
+	}
+	if count := strings.Count(buf.String(), "Failed to resolve hostname warned.example.net"); count != 1 {
+		t.Errorf("Expected a single warning for repeated failures, got %d:\n%s", count, buf.String())
+	}
+}
+
+func TestFetchGatewayIPsAddressTypes(t *testing.T) {
+	gateway := &gatewayapi_v1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw-1", Namespace: "ns1"},
+		Status: gatewayapi_v1.GatewayStatus{Addresses: []gatewayapi_v1.GatewayStatusAddress{
+			{Value: "192.0.2.1"},
+			{Value: "not-an-ip.example.com"},
+			{Type: ptr.To(gatewayapi_v1.AddressType("example.com/custom")), Value: "192.0.2.2"},
+			{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: "2001:db8::1"},
+		}},
+	}
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}
+	if result := fetchGatewayIPs(context.TODO(), gateway, nil); !slices.Equal(result.addrs, expected) || len(result.records) != 0 {
+		t.Errorf("Expected addresses %v only, got %v and records %v", expected, result.addrs, result.records)
+	}
+}
+
+func TestMappedAddresses(t *testing.T) {
+	mapped := "::ffff:192.0.2.1"
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+
+	if addrs := fetchServiceLoadBalancerIPs(context.TODO(), []core.LoadBalancerIngress{{IP: mapped}}, ResourceFilters{}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected Service addresses %v, got %v", expected, addrs)
+	}
+	if addrs := fetchIngressLoadBalancerIPs(context.TODO(), []networking.IngressLoadBalancerIngress{{IP: mapped}}, ResourceFilters{}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected Ingress addresses %v, got %v", expected, addrs)
+	}
+	gateway := &gatewayapi_v1.Gateway{Status: gatewayapi_v1.GatewayStatus{Addresses: []gatewayapi_v1.GatewayStatusAddress{
+		{Type: ptr.To(gatewayapi_v1.IPAddressType), Value: mapped},
+	}}}
+	if addrs := fetchGatewayIPs(context.TODO(), gateway, nil).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected Gateway addresses %v, got %v", expected, addrs)
+	}
+
+	// served as an A record rather than an AAAA one
+	filters := newGateway().resourceFilters
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	svc := testServices["svc1.ns1"].DeepCopy()
+	svc.Status.LoadBalancer.Ingress = []core.LoadBalancerIngress{{IP: mapped}}
+	if err := ctrl.GetIndexer().Add(svc); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	gw := newTestGateway(&resourceWithIndex{name: "Service", lookup: lookupServiceIndex(ctrl, nil, nil, filters), reverse: noopReverse})
+	for _, tc := range []test.Case{
+		{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc1.ns1.example.com.	60	IN	A	192.0.2.1")},
+		},
+		{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeAAAA,
+			Ns: []dns.RR{test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")},
+		},
+	} {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("%s %s: %v", tc.Qname, dns.TypeToString[tc.Qtype], err)
+		}
+	}
+}
+
+func TestFetchLoadBalancerIPsPreference(t *testing.T) {
+	resolver := resolverFunc(func(_ context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+		return []netip.Addr{netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("198.51.100.2")}, nil, nil
+	})
+
+	resolved := []netip.Addr{netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("198.51.100.2")}
+	status := netip.MustParseAddr("192.0.2.1")
+	tests := []struct {
+		preferIP      bool
+		expectedAddrs []netip.Addr
+		expectedCNAME []string
+	}{
+		{false, resolved, []string{"lb.example.net"}},
+		{true, []netip.Addr{status}, nil},
+	}
+
+	for i, test := range tests {
+		result := fetchServiceLoadBalancerIPs(context.TODO(), []core.LoadBalancerIngress{{IP: "192.0.2.1", Hostname: "lb.example.net"}}, ResourceFilters{resolver: resolver, preferLoadBalancerIPs: test.preferIP})
+		if !slices.Equal(result.addrs, test.expectedAddrs) || !slices.Equal(result.hostnames, test.expectedCNAME) {
+			t.Errorf("Test %d: Expected Service addresses %v and CNAME %v, got %v and %v", i, test.expectedAddrs, test.expectedCNAME, result.addrs, result.hostnames)
+		}
+
+		result = fetchIngressLoadBalancerIPs(context.TODO(), []networking.IngressLoadBalancerIngress{{IP: "192.0.2.1", Hostname: "lb.example.net"}}, ResourceFilters{resolver: resolver, preferLoadBalancerIPs: test.preferIP})
+		if !slices.Equal(result.addrs, test.expectedAddrs) || !slices.Equal(result.hostnames, test.expectedCNAME) {
+			t.Errorf("Test %d: Expected Ingress addresses %v and CNAME %v, got %v and %v", i, test.expectedAddrs, test.expectedCNAME, result.addrs, result.hostnames)
+		}
+	}
+
+	// hostnames without an IP are still resolved
+	result := fetchServiceLoadBalancerIPs(context.TODO(), []core.LoadBalancerIngress{{Hostname: "lb.example.net"}}, ResourceFilters{resolver: resolver, preferLoadBalancerIPs: true})
+	if !slices.Equal(result.addrs, resolved) {
+		t.Errorf("Expected hostname to resolve to %v, got %v", resolved, result.addrs)
+	}
+}
+
+type stubResolver map[string][]netip.Addr
+
+func (r stubResolver) LookupNetIP(_ context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, ok := r[network+"/"+host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func TestResolveHostnameSystemResolver(t *testing.T) {
+	conf := resolvConf
+	defer func() { resolvConf = conf }()
+	resolvConf = "/nonexistent/resolv.conf"
+	resolver := newUpstreamResolver(nil)
+	resolver.system = stubResolver{
+		"ip4/dual.example.net": {netip.MustParseAddr("198.51.100.1")},
+		"ip6/dual.example.net": {netip.MustParseAddr("2001:db8::1")},
+		"ip4/v4.example.net":   {netip.MustParseAddr("::ffff:198.51.100.2")},
+	}
+
+	tests := []struct {
+		hostname  string
+		expected  []netip.Addr
+		shouldErr bool
+	}{
+		{"dual.example.net", []netip.Addr{netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("2001:db8::1")}, false},
+		{"v4.example.net", []netip.Addr{netip.MustParseAddr("198.51.100.2")}, false},
+		{"missing.example.net", nil, true},
+	}
+
+	for i, test := range tests {
+		addrs, ttl, err := resolver.resolve(context.TODO(), test.hostname)
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %d: Expected error %t, got %v", i, test.shouldErr, err)
+		}
+		if !slices.Equal(addrs, test.expected) {
+			t.Errorf("Test %d: Expected %s to resolve to %v, got %v", i, test.hostname, test.expected, addrs)
+		}
+		if ttl != nil {
+			t.Errorf("Test %d: Expected no TTL from the system resolver, got %d", i, *ttl)
+		}
+	}
+}
+
+func TestResolveHostnameUpstreamResolvers(t *testing.T) {
+	var queries atomic.Int32
+	server := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			m.Answer = []dns.RR{test.A("lb.example.net.	30	IN	A	203.0.113.7")}
+		}
+		if err := w.WriteMsg(m); err != nil {
+			t.Errorf("Failed to write response: %s", err)
+		}
+	})
+	defer server.Close()
+
+	conf := resolvConf
+	defer func() { resolvConf = conf }()
+	// neither resolv.conf nor the system resolver are used
+	resolvConf = "/nonexistent/resolv.conf"
+	resolver := newUpstreamResolver([]string{server.Addr})
+	resolver.system = stubResolver{"ip4/lb.example.net": {netip.MustParseAddr("198.51.100.1")}}
+
+	addrs, ttl, err := resolver.resolve(context.TODO(), "lb.example.net")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if expected := []netip.Addr{netip.MustParseAddr("203.0.113.7")}; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected lb.example.net to resolve to %v, got %v", expected, addrs)
+	}
+	if ttl == nil || *ttl != 30 {
+		t.Errorf("Expected the TTL of the upstream record, got %v", ttl)
+	}
+	if queries.Load() != 2 {
+		t.Errorf("Expected A and AAAA queries to the upstream resolver, got %d queries", queries.Load())
+	}
+}
+
+func TestResolveHostnameTruncated(t *testing.T) {
+	server := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+			// the answer doesn't fit, the client has to retry over TCP
+			m.Truncated = true
+		} else if r.Question[0].Qtype == dns.TypeA {
+			m.Answer = []dns.RR{test.A("lb.example.net.	30	IN	A	203.0.113.7")}
+		}
+		if err := w.WriteMsg(m); err != nil {
+			t.Errorf("Failed to write response: %s", err)
+		}
+	})
+	defer server.Close()
+
+	addrs, _, err := newUpstreamResolver([]string{server.Addr}).resolve(context.TODO(), "lb.example.net")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if expected := []netip.Addr{netip.MustParseAddr("203.0.113.7")}; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected lb.example.net to resolve to %v over TCP, got %v", expected, addrs)
+	}
+}
+
+func TestResolveHostnameRcode(t *testing.T) {
+	// dnstest servers share the default handler, so this one is served apart
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	failing := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		if err := w.WriteMsg(m); err != nil {
+			t.Errorf("Failed to write response: %s", err)
+		}
+	})}
+	go failing.ActivateAndServe()
+	defer failing.Shutdown()
+	failingAddr := conn.LocalAddr().String()
+
+	server := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch {
+		case r.Question[0].Name != "lb.example.net.":
+			m.Rcode = dns.RcodeNameError
+		case r.Question[0].Qtype == dns.TypeA:
+			m.Answer = []dns.RR{test.A("lb.example.net.	30	IN	A	203.0.113.7")}
+		}
+		if err := w.WriteMsg(m); err != nil {
+			t.Errorf("Failed to write response: %s", err)
+		}
+	})
+	defer server.Close()
+
+	// a failing nameserver falls back to the next one
+	addrs, _, err := newUpstreamResolver([]string{failingAddr, server.Addr}).resolve(context.TODO(), "lb.example.net")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if expected := []netip.Addr{netip.MustParseAddr("203.0.113.7")}; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected lb.example.net to resolve to %v from the second nameserver, got %v", expected, addrs)
+	}
+
+	tests := []struct {
+		servers  []string
+		hostname string
+		notFound bool
+	}{
+		{[]string{server.Addr}, "missing.example.net", true},
+		{[]string{failingAddr}, "lb.example.net", false},
+	}
+	for i, test := range tests {
+		addrs, _, err := newUpstreamResolver(test.servers).resolve(context.TODO(), test.hostname)
+		if err == nil {
+			t.Errorf("Test %d: Expected an error, got addresses %v", i, addrs)
+			continue
+		}
+		var dnsErr *net.DNSError
+		if notFound := errors.As(err, &dnsErr) && dnsErr.IsNotFound; notFound != test.notFound {
+			t.Errorf("Test %d: Expected a not found error %t, got %v", i, test.notFound, err)
+		}
+	}
+}
+
+func TestResolveHostnameHostsFile(t *testing.T) {
+	hosts := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hosts, []byte("# static entries\n198.51.100.1 LB.example.net lb # the load balancer\n2001:db8::1 lb.example.net.\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write hosts file: %s", err)
+	}
+	file := hostsFile
+	defer func() { hostsFile = file }()
+	hostsFile = hosts
+
+	// no nameserver is queried for names in the hosts file
+	resolver := newUpstreamResolver([]string{"192.0.2.53:53"})
+	resolver.system = stubResolver{}
+	addrs, ttl, err := resolver.resolve(context.TODO(), "lb.example.net.")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if expected := []netip.Addr{netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("2001:db8::1")}; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected lb.example.net to resolve to %v, got %v", expected, addrs)
+	}
+	if ttl != nil {
+		t.Errorf("Expected no TTL for hosts entries, got %d", *ttl)
+	}
+}
+
+func TestResolveHostnameContext(t *testing.T) {
+	// a nameserver that never answers
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := newUpstreamResolver([]string{conn.LocalAddr().String()}).resolve(ctx, "lb.example.net"); err == nil {
+		t.Errorf("Expected an error from an unresponsive nameserver")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the resolution to end with the context, took %s", elapsed)
+	}
+}
+
+func TestControllerSyncRetry(t *testing.T) {
+	var listCalls atomic.Int32
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+				// the API server is briefly unavailable on startup
+				if listCalls.Add(1) == 1 {
+					return nil, fmt.Errorf("service unavailable")
+				}
+				return &core.ServiceList{}, nil
+			},
+			WatchFunc: func(metav1.ListOptions) (watch.Interface, error) {
+				return watch.NewFake(), nil
+			},
+		},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{},
+	)
+	ctrl := &KubeController{ctx: context.TODO(), client: fake.NewClientset(), controllers: map[string]cache.SharedIndexInformer{"Service": informer}}
+	go ctrl.run()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !ctrl.HasSynced() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Controller didn't sync after %d list calls", listCalls.Load())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if calls := listCalls.Load(); calls < 2 {
+		t.Errorf("Expected the failed list to be retried, got %d calls", calls)
+	}
+}
+
+func TestLookupEndpointsIndex(t *testing.T) {
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{headlessServiceHostnameIndex: headlessServiceHostnameIndexFunc},
+	)
+	sliceCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&discovery.EndpointSlice{},
+		defaultResyncPeriod,
+		cache.Indexers{endpointSliceServiceIndex: endpointSliceServiceIndexFunc},
+	)
+	for _, svc := range testClusterIPServices {
+		if err := svcCtrl.GetIndexer().Add(svc.service); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+	if err := sliceCtrl.GetIndexer().Add(testEndpointSlice); err != nil {
+		t.Fatalf("Failed to add EndpointSlice to indexer: %s", err)
+	}
+
+	lookup := lookupEndpointsIndex(svcCtrl, sliceCtrl)
+	expected := []netip.Addr{netip.MustParseAddr("10.244.0.10"), netip.MustParseAddr("10.244.1.10")}
+	if addrs := lookup(context.TODO(), []string{"svc-headless.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected headless service to resolve to %v, got %v", expected, addrs)
+	}
+
+	// services with a cluster IP aren't resolved to their endpoints
+	if addrs := lookup(context.TODO(), []string{"svc-dual.ns1"}).addrs; len(addrs) != 0 {
+		t.Errorf("Expected no addresses for a non-headless service, got %v", addrs)
+	}
+}
+
+func TestLastKnownServiceAddresses(t *testing.T) {
+	filters := newGateway().resourceFilters
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(filters)},
+	)
+	provisioned := &core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-churn", Namespace: "ns1"},
+		Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+		Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+			Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.140"}},
+		}},
+	}
+	emptied := provisioned.DeepCopy()
+	emptied.Status.LoadBalancer.Ingress = nil
+	if err := ctrl.GetIndexer().Add(emptied); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	lastKnown := newLastKnownAddresses(time.Minute, filters)
+	handler := lastKnown.eventHandler(serviceHostnameIndexFunc(filters))
+	lookup := lookupWithFallback(lookupServiceIndex(ctrl, nil, nil, filters), lastKnown.lookup)
+
+	handler.OnUpdate(provisioned, emptied)
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.140")}
+	if addrs := lookup(context.TODO(), []string{"svc-churn.ns1"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected last known addresses %v during the grace period, got %v", expected, addrs)
+	}
+
+	// the addresses expire with the grace period
+	lastKnown.entries["svc-churn.ns1"] = lastKnownEntry{ingress: provisioned.Status.LoadBalancer.Ingress, emptiedAt: time.Now().Add(-2 * time.Minute)}
+	if result := lookup(context.TODO(), []string{"svc-churn.ns1"}); !result.isEmpty() {
+		t.Errorf("Expected no addresses after the grace period, got %v", result.addrs)
+	}
+
+	// and are forgotten once the status is populated again
+	handler.OnUpdate(provisioned, emptied)
+	reprovisioned := provisioned.DeepCopy()
+	reprovisioned.Status.LoadBalancer.Ingress = []core.LoadBalancerIngress{{IP: "192.0.2.141"}}
+	handler.OnUpdate(emptied, reprovisioned)
+	if len(lastKnown.entries) != 0 {
+		t.Errorf("Expected last known addresses to be forgotten, got %v", lastKnown.entries)
+	}
+
+	// hostnames of the last status are still resolved
+	filters.resolver = resolverFunc(func(_ context.Context, hostname string) ([]netip.Addr, *uint32, error) {
+		if hostname != "lb.example.net" {
+			return nil, nil, fmt.Errorf("unexpected hostname %s", hostname)
+		}
+		return []netip.Addr{netip.MustParseAddr("198.51.100.140")}, nil, nil
+	})
+	lastKnown = newLastKnownAddresses(time.Minute, filters)
+	handler = lastKnown.eventHandler(serviceHostnameIndexFunc(filters))
+	lookup = lookupWithFallback(lookupServiceIndex(ctrl, nil, nil, filters), lastKnown.lookup)
+	withHostname := provisioned.DeepCopy()
+	withHostname.Status.LoadBalancer.Ingress = []core.LoadBalancerIngress{{Hostname: "lb.example.net"}}
+	handler.OnUpdate(withHostname, emptied)
+	result := lookup(context.TODO(), []string{"svc-churn.ns1"})
+	expected = []netip.Addr{netip.MustParseAddr("198.51.100.140")}
+	if !slices.Equal(result.addrs, expected) {
+		t.Errorf("Expected the resolved hostname %v during the grace period, got %v", expected, result.addrs)
+	}
+	if hostnames := []string{"lb.example.net"}; !slices.Equal(result.hostnames, hostnames) {
+		t.Errorf("Expected the CNAME target %v, got %v", hostnames, result.hostnames)
+	}
+}
+
+func TestLookupEndpointsIndexHostnameAnnotation(t *testing.T) {
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{headlessServiceHostnameIndex: headlessServiceHostnameIndexFunc},
+	)
+	sliceCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&discovery.EndpointSlice{},
+		defaultResyncPeriod,
+		cache.Indexers{endpointSliceServiceIndex: endpointSliceServiceIndexFunc},
+	)
+	if err := svcCtrl.GetIndexer().Add(&core.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "svc-pods",
+			Namespace:   "ns1",
+			Annotations: map[string]string{hostnameAnnotationKey: "pods.example.com"},
+		},
+		Spec: core.ServiceSpec{Type: core.ServiceTypeClusterIP, ClusterIP: core.ClusterIPNone},
+	}); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+	if err := sliceCtrl.GetIndexer().Add(&discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc-pods-fghij",
+			Namespace: "ns1",
+			Labels:    map[string]string{discovery.LabelServiceName: "svc-pods"},
+		},
+		AddressType: discovery.AddressTypeIPv6,
+		Endpoints: []discovery.Endpoint{
+			{Addresses: []string{"fd00:10:244::20"}},
+			{Addresses: []string{"fd00:10:244::21"}, Conditions: discovery.EndpointConditions{Ready: ptr.To(false)}},
+		},
+	}); err != nil {
+		t.Fatalf("Failed to add EndpointSlice to indexer: %s", err)
+	}
+
+	lookup := lookupEndpointsIndex(svcCtrl, sliceCtrl)
+	expected := []netip.Addr{netip.MustParseAddr("fd00:10:244::20")}
+	if addrs := lookup(context.TODO(), []string{"pods.example.com"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected annotated headless service to resolve to %v, got %v", expected, addrs)
+	}
+	// the annotation replaces the name.namespace hostname
+	if addrs := lookup(context.TODO(), []string{"svc-pods.ns1"}).addrs; len(addrs) != 0 {
+		t.Errorf("Expected no addresses for the name of an annotated service, got %v", addrs)
+	}
+}
+
+func TestFetchServiceClusterIPs(t *testing.T) {
+	for name, tc := range testClusterIPServices {
+		addrs := fetchServiceClusterIPs(tc.service)
+		if !slices.Equal(addrs, tc.expected) {
+			t.Errorf("Service %s: expected addresses %v, got %v", name, tc.expected, addrs)
+		}
+	}
+}
+
+func TestPluginDualStackClusterIP(t *testing.T) {
+	gw, err := parse(caddy.NewTestController("dns", `k8s_gateway example.com {
+		serviceTypes ClusterIP
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse Corefile: %v", err)
+	}
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(gw.resourceFilters)},
+	)
+	for _, tc := range testClusterIPServices {
+		if err := ctrl.GetIndexer().Add(tc.service); err != nil {
+			t.Fatalf("Failed to add Service to indexer: %s", err)
+		}
+	}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = syncedController()
+	gw.Resources = []*resourceWithIndex{{name: "Service", lookup: lookupServiceIndex(ctrl, nil, nil, gw.resourceFilters), reverse: noopReverse}}
+
+	soa := test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5")
+	tests := []test.Case{
+		{
+			Qname: "svc-dual.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("svc-dual.ns1.example.com.	60	IN	A	10.96.0.10")},
+		},
+		{
+			Qname: "svc-dual.ns1.example.com.", Qtype: dns.TypeAAAA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.AAAA("svc-dual.ns1.example.com.	60	IN	AAAA	fd00:10:96::a")},
+		},
+		{
+			Qname: "svc-single.ns1.example.com.", Qtype: dns.TypeAAAA, Rcode: dns.RcodeSuccess,
+			Ns: []dns.RR{soa},
+		},
+		// headless services have no cluster IP
+		{
+			Qname: "svc-headless.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Ns: []dns.RR{soa},
+		},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestReverseLookupServiceIndex(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{
+			serviceHostnameIndex: serviceHostnameIndexFunc(newGateway().resourceFilters),
+			serviceAddressIndex:  serviceAddressIndexFunc(newGateway().resourceFilters),
+		},
+	)
+	svc := testServices["svc1.ns1"]
+	if err := ctrl.GetIndexer().Add(svc); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	reverse := reverseLookupServiceIndex(ctrl)
+	addr := netip.MustParseAddr("192.0.0.1")
+	if hostnames := reverse(addr); !slices.Equal(hostnames, []string{"svc1.ns1"}) {
+		t.Errorf("Expected reverse lookup of %s to return svc1.ns1, got %v", addr, hostnames)
+	}
+
+	// deleting the forward object must also remove its reverse entry
+	if err := ctrl.GetIndexer().Delete(svc); err != nil {
+		t.Fatalf("Failed to delete Service from indexer: %s", err)
+	}
+	if hostnames := reverse(addr); len(hostnames) != 0 {
+		t.Errorf("Expected no hostnames for %s after delete, got %v", addr, hostnames)
+	}
+}
+
+func TestReverseLookupDNSEndpoint(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&externaldnsv1.DNSEndpoint{},
+		defaultResyncPeriod,
+		cache.Indexers{
+			externalDNSHostnameIndex: dnsEndpointTargetIndexFunc,
+			externalDNSAddressIndex:  dnsEndpointAddressIndexFunc,
+		},
+	)
+	ep := testDNSEndpoints["dual.example.com"]
+	if err := ctrl.GetIndexer().Add(ep); err != nil {
+		t.Fatalf("Failed to add DNSEndpoint to indexer: %s", err)
+	}
+
+	reverse := reverseLookupDNSEndpoint(ctrl)
+	for _, addr := range []netip.Addr{netip.MustParseAddr("192.0.2.200"), netip.MustParseAddr("2001:db8::1")} {
+		if hostnames := reverse(addr); !slices.Equal(hostnames, []string{"dual.example.com"}) {
+			t.Errorf("Expected reverse lookup of %s to return dual.example.com, got %v", addr, hostnames)
+		}
+	}
+
+	if err := ctrl.GetIndexer().Delete(ep); err != nil {
+		t.Fatalf("Failed to delete DNSEndpoint from indexer: %s", err)
+	}
+	if hostnames := reverse(netip.MustParseAddr("192.0.2.200")); len(hostnames) != 0 {
+		t.Errorf("Expected no hostnames after delete, got %v", hostnames)
+	}
+}
+
+func TestServiceHostnameAnnotations(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		expected    []string
+	}{
+		{nil, []string{"svc1.ns1"}},
+		{map[string]string{hostnameAnnotationKey: "a.example.org,b.example.org"}, []string{"a.example.org", "b.example.org"}},
+		{map[string]string{hostnameAnnotationKey: "a.example.org, Invalid_Name,b.example.org"}, []string{"a.example.org", "b.example.org"}},
+		{map[string]string{externalDnsHostnameAnnotationKey: "c.example.org,d.example.org"}, []string{"c.example.org", "d.example.org"}},
+		{map[string]string{
+			hostnameAnnotationKey:            "a.example.org,b.example.org",
+			externalDnsHostnameAnnotationKey: "c.example.org",
+		}, []string{"a.example.org", "b.example.org"}},
+	}
+
+	for i, test := range tests {
+		service := &core.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc1", Namespace: "ns1", Annotations: test.annotations}}
+		if hostnames := serviceHostnames(service); !slices.Equal(hostnames, test.expected) {
+			t.Errorf("Test %d: Expected hostnames %v, got %v", i, test.expected, hostnames)
+		}
+	}
+}
+
+func TestLookupIngressClassFiltered(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&networking.Ingress{},
+		defaultResyncPeriod,
+		cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc(newGateway().resourceFilters)},
+	)
+	ingress := testIngresses["a.example.org"].DeepCopy()
+	ingress.Spec.IngressClassName = ptr.To("nginx")
+	if err := ctrl.GetIndexer().Add(ingress); err != nil {
+		t.Fatalf("Failed to add Ingress to indexer: %s", err)
+	}
+
+	filters := newGateway().resourceFilters
+	filters.ingressClasses = []string{"traefik"}
+	result := lookupIngressIndex(ctrl, filters)(context.TODO(), []string{"a.example.org"})
+	if !result.isEmpty() || !result.filtered {
+		t.Errorf("Expected the Ingress to be filtered, got %v (filtered %t)", result.addrs, result.filtered)
+	}
+
+	filters.ingressClasses = []string{"nginx"}
+	result = lookupIngressIndex(ctrl, filters)(context.TODO(), []string{"a.example.org"})
+	if result.isEmpty() || result.filtered {
+		t.Errorf("Expected the Ingress to match, got %v (filtered %t)", result.addrs, result.filtered)
+	}
+}
+
+func TestPluginIngressClasses(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&networking.Ingress{},
+		defaultResyncPeriod,
+		cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc(newGateway().resourceFilters)},
+	)
+	for name, class := range map[string]*string{"nginx": ptr.To("nginx"), "traefik": ptr.To("traefik"), "unset": nil} {
+		ingress := testIngresses["a.example.org"].DeepCopy()
+		ingress.Name = name
+		ingress.Spec.IngressClassName = class
+		ingress.Spec.Rules[0].Host = name + ".example.org"
+		if err := ctrl.GetIndexer().Add(ingress); err != nil {
+			t.Fatalf("Failed to add Ingress to indexer: %s", err)
+		}
+	}
+
+	soa := test.SOA("example.org.	60	IN	SOA	dns1.kube-system.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")
+	resolved := func(name string) test.Case {
+		return test.Case{
+			Qname: name, Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A(name + "	60	IN	A	192.0.0.1")},
+		}
+	}
+	filtered := func(name string) test.Case {
+		return test.Case{Qname: name, Qtype: dns.TypeA, Rcode: dns.RcodeNameError, Ns: []dns.RR{soa}}
+	}
+
+	for _, tc := range []struct {
+		input string
+		cases []test.Case
+	}{
+		// an empty filter matches all classes
+		{`k8s_gateway example.org`, []test.Case{
+			resolved("nginx.example.org."), resolved("traefik.example.org."), resolved("unset.example.org."),
+		}},
+		{`k8s_gateway example.org {
+			ingressClasses nginx,internal
+		}`, []test.Case{
+			resolved("nginx.example.org."), filtered("traefik.example.org."), filtered("unset.example.org."),
+		}},
+	} {
+		gw, err := parse(caddy.NewTestController("dns", tc.input))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		gw.Controller = syncedController()
+		gw.Resources = []*resourceWithIndex{{name: "Ingress", lookup: lookupIngressIndex(ctrl, gw.resourceFilters), reverse: noopReverse}}
+		for _, c := range tc.cases {
+			w := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := gw.ServeDNS(context.TODO(), w, c.Msg()); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if err := test.SortAndCheck(w.Msg, c); err != nil {
+				t.Errorf("%s with ingressClasses %v: %v", c.Qname, gw.resourceFilters.ingressClasses, err)
+			}
+		}
+	}
+}
+
+func TestPluginZoneFilters(t *testing.T) {
+	gw, err := parse(caddy.NewTestController("dns", `k8s_gateway example.org example.com {
+		ingressClasses nginx
+		zoneIngressClasses example.com traefik
+		zoneServiceTypes example.com LoadBalancer ClusterIP
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ingressCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&networking.Ingress{},
+		defaultResyncPeriod,
+		cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc(gw.indexFilters())},
+	)
+	for host, class := range map[string]string{"nginx.example.org": "nginx", "nginx.example.com": "nginx", "traefik.example.com": "traefik"} {
+		ingress := testIngresses["a.example.org"].DeepCopy()
+		ingress.Name = host
+		ingress.Spec.IngressClassName = ptr.To(class)
+		ingress.Spec.Rules[0].Host = host
+		if err := ingressCtrl.GetIndexer().Add(ingress); err != nil {
+			t.Fatalf("Failed to add Ingress to indexer: %s", err)
+		}
+	}
+	serviceCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc(gw.indexFilters())},
+	)
+	if err := serviceCtrl.GetIndexer().Add(testClusterIPServices["dual-stack"].service); err != nil {
+		t.Fatalf("Failed to add Service to indexer: %s", err)
+	}
+
+	gw.Controller = syncedController()
+	gw.Resources = []*resourceWithIndex{
+		{name: "Ingress", lookup: lookupIngressIndex(ingressCtrl, gw.resourceFilters), reverse: noopReverse},
+		{name: "Service", lookup: lookupServiceIndex(serviceCtrl, nil, nil, gw.resourceFilters), reverse: noopReverse},
+	}
+
+	nxdomain := func(name, zone string) test.Case {
+		return test.Case{Qname: name, Qtype: dns.TypeA, Rcode: dns.RcodeNameError, Ns: []dns.RR{
+			test.SOA(zone + "	60	IN	SOA	dns1.kube-system." + zone + " hostmaster." + zone + " 1499347823 7200 1800 86400 5"),
+		}}
+	}
+	tests := []test.Case{
+		{
+			Qname: "nginx.example.org.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("nginx.example.org.	60	IN	A	192.0.0.1")},
+		},
+		nxdomain("nginx.example.com.", "example.com."),
+		{
+			Qname: "traefik.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("traefik.example.com.	60	IN	A	192.0.0.1")},
+		},
+		// ClusterIP services are only published in example.com
+		{
+			Qname: "svc-dual.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("svc-dual.ns1.example.com.	60	IN	A	10.96.0.10")},
+		},
+		nxdomain("svc-dual.ns1.example.org.", "example.org."),
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestIndexFilters(t *testing.T) {
+	gw := newGateway()
+	gw.resourceFilters.ingressClasses = []string{"nginx"}
+	internal := gw.resourceFilters
+	internal.ingressClasses = []string{"internal", "nginx"}
+	internal.serviceTypes = []string{"ClusterIP"}
+	gw.zoneFilters = map[string]*ResourceFilters{"internal.example.org.": &internal}
+
+	filters := gw.indexFilters()
+	if expected := []string{"nginx", "internal"}; !slices.Equal(filters.ingressClasses, expected) {
+		t.Errorf("Expected ingress classes %v, got %v", expected, filters.ingressClasses)
+	}
+	if expected := []string{"LoadBalancer", "ClusterIP"}; !slices.Equal(filters.serviceTypes, expected) {
+		t.Errorf("Expected service types %v, got %v", expected, filters.serviceTypes)
+	}
+
+	// a zone without classes publishes all of them
+	internal.ingressClasses = nil
+	if filters := gw.indexFilters(); filters.ingressClasses != nil {
+		t.Errorf("Expected no ingress classes, got %v", filters.ingressClasses)
+	}
+}
+
+func TestIngressDefaultBackend(t *testing.T) {
+	backend := &networking.IngressBackend{Service: &networking.IngressServiceBackend{Name: "svc1"}}
+	tests := []struct {
+		spec        networking.IngressSpec
+		annotations map[string]string
+		expected    []string
+	}{
+		{networking.IngressSpec{DefaultBackend: backend}, map[string]string{hostnameAnnotationKey: "catchall.example.org"}, []string{"catchall.example.org"}},
+		{networking.IngressSpec{DefaultBackend: backend}, map[string]string{externalDnsHostnameAnnotationKey: "a.example.org,b.example.org"}, []string{"a.example.org", "b.example.org"}},
+		{networking.IngressSpec{DefaultBackend: backend}, nil, nil},
+		// host rules take precedence over the annotation
+		{networking.IngressSpec{
+			DefaultBackend: backend,
+			Rules:          []networking.IngressRule{{Host: "rule.example.org"}},
+		}, map[string]string{hostnameAnnotationKey: "catchall.example.org"}, []string{"rule.example.org"}},
+		{networking.IngressSpec{}, map[string]string{hostnameAnnotationKey: "catchall.example.org"}, nil},
+	}
+
+	for i, test := range tests {
+		ingress := &networking.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "ing1", Namespace: "ns1", Annotations: test.annotations},
+			Spec:       test.spec,
+		}
+		if hostnames, _ := ingressHostnameIndexFunc(newGateway().resourceFilters)(ingress); !slices.Equal(hostnames, test.expected) {
+			t.Errorf("Test %d: Expected hostnames %v, got %v", i, test.expected, hostnames)
+		}
+	}
+}
+
+func TestHostnameIndexCase(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "mixed", Namespace: "ns1"}
+	tests := []struct {
+		indexFunc cache.IndexFunc
+		obj       interface{}
+	}{
+		{ingressHostnameIndexFunc(newGateway().resourceFilters), &networking.Ingress{
+			ObjectMeta: meta,
+			Spec:       networking.IngressSpec{Rules: []networking.IngressRule{{Host: "API.Example.com"}}},
+		}},
+		{httpRouteHostnameIndexFunc, &gatewayapi_v1.HTTPRoute{
+			ObjectMeta: meta,
+			Spec:       gatewayapi_v1.HTTPRouteSpec{Hostnames: []gatewayapi_v1.Hostname{"API.Example.com"}},
+		}},
+		{tlsRouteHostnameIndexFunc, &gatewayapi_v1alpha2.TLSRoute{
+			ObjectMeta: meta,
+			Spec:       gatewayapi_v1alpha2.TLSRouteSpec{Hostnames: []gatewayapi_v1.Hostname{"API.Example.com"}},
+		}},
+		{grpcRouteHostnameIndexFunc, &gatewayapi_v1.GRPCRoute{
+			ObjectMeta: meta,
+			Spec:       gatewayapi_v1.GRPCRouteSpec{Hostnames: []gatewayapi_v1.Hostname{"API.Example.com"}},
+		}},
+		{dnsEndpointTargetIndexFunc, &externaldnsv1.DNSEndpoint{
+			ObjectMeta: meta,
+			Spec: externaldnsv1.DNSEndpointSpec{
+				Endpoints: []*endpoint.Endpoint{{DNSName: "API.Example.com", RecordType: "A", Targets: endpoint.Targets{"192.0.2.90"}}},
+			},
+		}},
+	}
+
+	expected := []string{"api.example.com"}
+	for i, tc := range tests {
+		if hostnames, _ := tc.indexFunc(tc.obj); !slices.Equal(hostnames, expected) {
+			t.Errorf("Test %d: Expected %T to be indexed under %v, got %v", i, tc.obj, expected, hostnames)
+		}
+	}
+
+	// lowercased query keys find objects declaring mixed-case hostnames
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&networking.Ingress{},
+		defaultResyncPeriod,
+		cache.Indexers{ingressHostnameIndex: tests[0].indexFunc},
+	)
+	ingress := tests[0].obj.(*networking.Ingress)
+	ingress.Status.LoadBalancer.Ingress = []networking.IngressLoadBalancerIngress{{IP: "192.0.2.90"}}
+	if err := ctrl.GetIndexer().Add(ingress); err != nil {
+		t.Fatalf("Failed to add Ingress to indexer: %s", err)
+	}
+	addrs := lookupIngressIndex(ctrl, newGateway().resourceFilters)(context.TODO(), []string{"api.example.com"}).addrs
+	if expected := []netip.Addr{netip.MustParseAddr("192.0.2.90")}; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected addresses %v, got %v", expected, addrs)
+	}
+}
+
+func TestIngressReadyOnly(t *testing.T) {
+	const annotation = "example.com/backends-ready"
+	pending := &networking.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ing-new", Namespace: "ns1"},
+		Spec:       networking.IngressSpec{Rules: []networking.IngressRule{{Host: "new.example.org"}}},
+	}
+	provisioned := pending.DeepCopy()
+	provisioned.Status.LoadBalancer.Ingress = []networking.IngressLoadBalancerIngress{{IP: "192.0.2.60"}}
+	annotated := provisioned.DeepCopy()
+	annotated.Annotations = map[string]string{annotation: "true"}
+
+	readyOnly := newGateway().resourceFilters
+	readyOnly.readyIngressesOnly = true
+	readyAnnotation := readyOnly
+	readyAnnotation.ingressReadyAnnotation = annotation
+
+	expected := []string{"new.example.org"}
+	tests := []struct {
+		filters  ResourceFilters
+		ingress  *networking.Ingress
+		expected []string
+	}{
+		{newGateway().resourceFilters, pending, expected},
+		{readyOnly, pending, nil},
+		{readyOnly, provisioned, expected},
+		{readyAnnotation, provisioned, nil},
+		{readyAnnotation, annotated, expected},
+	}
+	for i, tc := range tests {
+		if hostnames, _ := ingressHostnameIndexFunc(tc.filters)(tc.ingress); !slices.Equal(hostnames, tc.expected) {
+			t.Errorf("Test %d: Expected hostnames %v, got %v", i, tc.expected, hostnames)
+		}
+	}
+}
+
+func TestIngressTLSHosts(t *testing.T) {
+	ctrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&networking.Ingress{},
+		defaultResyncPeriod,
+		cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc(newGateway().resourceFilters)},
+	)
+	ingress := &networking.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ing-tls", Namespace: "ns1"},
+		Spec: networking.IngressSpec{
+			Rules: []networking.IngressRule{{Host: "web.example.org"}},
+			TLS: []networking.IngressTLS{
+				{Hosts: []string{"web.example.org", "Passthrough.example.org"}},
+				{Hosts: []string{"passthrough.example.org", "sni.example.org"}},
+			},
+		},
+		Status: networking.IngressStatus{LoadBalancer: networking.IngressLoadBalancerStatus{
+			Ingress: []networking.IngressLoadBalancerIngress{{IP: "192.0.2.50"}},
+		}},
+	}
+	if err := ctrl.GetIndexer().Add(ingress); err != nil {
+		t.Fatalf("Failed to add Ingress to indexer: %s", err)
+	}
+
+	expected := []string{"web.example.org", "passthrough.example.org", "sni.example.org"}
+	if hostnames, _ := ingressHostnameIndexFunc(newGateway().resourceFilters)(ingress); !slices.Equal(hostnames, expected) {
+		t.Errorf("Expected each hostname once %v, got %v", expected, hostnames)
+	}
+
+	lookup := lookupIngressIndex(ctrl, newGateway().resourceFilters)
+	addrs := []netip.Addr{netip.MustParseAddr("192.0.2.50")}
+	for _, hostname := range expected {
+		if result := lookup(context.TODO(), []string{hostname}).addrs; !slices.Equal(result, addrs) {
+			t.Errorf("Expected %s to resolve to %v, got %v", hostname, addrs, result)
+		}
+	}
+}
+
+func TestRouteHostnameAnnotations(t *testing.T) {
+	annotations := map[string]string{externalDnsHostnameAnnotationKey: "Extra.example.com,invalid_name"}
+	parentRefs := []gatewayapi_v1.ParentReference{{Name: "gw-1"}}
+	httpRoute := &gatewayapi_v1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "annotated", Namespace: "ns1", Annotations: annotations},
+		Spec: gatewayapi_v1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{ParentRefs: parentRefs},
+		},
+	}
+	tlsRoute := &gatewayapi_v1alpha2.TLSRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "annotated", Namespace: "ns1", Annotations: annotations},
+		Spec: gatewayapi_v1alpha2.TLSRouteSpec{
+			CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{ParentRefs: parentRefs},
+			Hostnames:       []gatewayapi_v1alpha2.Hostname{"tls.example.com", "extra.example.com"},
+		},
+	}
+	grpcRoute := &gatewayapi_v1.GRPCRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "annotated",
+			Namespace:   "ns1",
+			Annotations: map[string]string{hostnameAnnotationKey: "grpc.example.com", externalDnsHostnameAnnotationKey: "ignored.example.com"},
+		},
+		Spec: gatewayapi_v1.GRPCRouteSpec{
+			CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{ParentRefs: parentRefs},
+		},
+	}
+
+	tests := []struct {
+		indexFunc cache.IndexFunc
+		route     interface{}
+		expected  []string
+	}{
+		// a route without spec hostnames is published under its annotation only
+		{httpRouteHostnameIndexFunc, httpRoute, []string{"extra.example.com"}},
+		// annotated names repeating a spec hostname are indexed once
+		{tlsRouteHostnameIndexFunc, tlsRoute, []string{"tls.example.com", "extra.example.com"}},
+		// coredns.io/hostname takes precedence
+		{grpcRouteHostnameIndexFunc, grpcRoute, []string{"grpc.example.com"}},
+	}
+	for i, tc := range tests {
+		if hostnames, _ := tc.indexFunc(tc.route); !slices.Equal(hostnames, tc.expected) {
+			t.Errorf("Test %d: Expected hostnames %v, got %v", i, tc.expected, hostnames)
+		}
+	}
+
+	// the annotated name resolves through the parent Gateway
+	gwCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+	)
+	gateway := testGateways["ns1/gw-1"].DeepCopy()
+	gateway.Status.Addresses[0].Type = ptr.To(gatewayapi_v1.IPAddressType)
+	if err := gwCtrl.GetIndexer().Add(gateway); err != nil {
+		t.Fatalf("Failed to add Gateway to indexer: %s", err)
+	}
+	routeCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&gatewayapi_v1.HTTPRoute{},
+		defaultResyncPeriod,
+		cache.Indexers{httpRouteHostnameIndex: httpRouteHostnameIndexFunc},
+	)
+	if err := routeCtrl.GetIndexer().Add(httpRoute); err != nil {
+		t.Fatalf("Failed to add HTTPRoute to indexer: %s", err)
+	}
+	svcCtrl := cache.NewSharedIndexInformer(
+		&cache.ListWatch{},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{gatewayServiceIndex: gatewayServiceIndexFunc},
+	)
+
+	lookup := lookupHttpRouteIndex(routeCtrl, gwCtrl, svcCtrl, nil, newGateway().resourceFilters)
+	expected := []netip.Addr{netip.MustParseAddr("192.0.2.100")}
+	if addrs := lookup(context.TODO(), []string{"extra.example.com"}).addrs; !slices.Equal(addrs, expected) {
+		t.Errorf("Expected extra.example.com to resolve to %v, got %v", expected, addrs)
+	}
+}
+
+func TestInactiveResources(t *testing.T) {
+	gw := newGateway()
+	gw.updateResources([]string{"HTTPRoute", "Ingress"})
+	gw.SetConfiguredResources([]string{"HTTPRoute", "Ingress"})
+
+	ctrl := newKubeController(context.TODO(), fake.NewClientset(), gwFake.NewClientset(), crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw)
+
+	if !slices.Equal(ctrl.inactiveResources, []string{"HTTPRoute"}) {
+		t.Errorf("Expected HTTPRoute to be inactive, got %v", ctrl.inactiveResources)
+	}
+	for resource, expected := range map[string]float64{"HTTPRoute": 1, "Ingress": 0} {
+		metric := &dto.Metric{}
+		if err := inactiveResources.WithLabelValues(resource).Write(metric); err != nil {
+			t.Fatalf("Failed to read inactive resources metric: %s", err)
+		}
+		if value := metric.GetGauge().GetValue(); value != expected {
+			t.Errorf("Expected inactive resources metric for %s to be %v, got %v", resource, expected, value)
+		}
+	}
+}
+
+func TestControllerStop(t *testing.T) {
+	gw := newGateway()
+	gw.updateResources([]string{"Ingress", "Service"})
+	gw.SetConfiguredResources([]string{"Ingress", "Service"})
+
+	ctrl := newKubeController(context.TODO(), fake.NewClientset(), gwFake.NewClientset(), crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw)
+	go ctrl.run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(context.Context) (bool, error) {
+		return ctrl.HasSynced(), nil
+	}); err != nil {
+		t.Fatalf("Expected the controller to sync: %s", err)
+	}
+
+	ctrl.Stop()
+	ctrl.Stop()
+
+	select {
+	case <-ctrl.done:
+	case <-ctx.Done():
+		t.Fatalf("Expected the controller to return after Stop")
+	}
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(context.Context) (bool, error) {
+		for _, informer := range ctrl.controllers {
+			if !informer.IsStopped() {
+				return false, nil
+			}
+		}
+		return true, nil
+	}); err != nil {
+		t.Errorf("Expected all informers to stop: %s", err)
+	}
+
+	// lists in progress return once stopped instead of waiting for the API server
+	var listing atomic.Int32
+	httpClient := fakeRest.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+		listing.Add(1)
+		defer listing.Add(-1)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	client, err := kubernetes.NewForConfigAndClient(&rest.Config{Host: "https://api.example.com"}, httpClient)
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	ctrl = newKubeController(context.TODO(), client, gwFake.NewClientset(), crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw)
+	go ctrl.run()
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(context.Context) (bool, error) {
+		return listing.Load() > 0, nil
+	}); err != nil {
+		t.Fatalf("Expected the informers to list: %s", err)
+	}
+
+	ctrl.Stop()
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(context.Context) (bool, error) {
+		return listing.Load() == 0, nil
+	}); err != nil {
+		t.Errorf("Expected the lists in progress to return after Stop: %s", err)
+	}
+}
+
+func TestSharedInformers(t *testing.T) {
+	gw := newGateway()
+	gw.resourceFilters.nodeAddressType = "InternalIP"
+	gw.updateResources([]string{"Service", "Endpoints", "HTTPRoute"})
+	gw.SetConfiguredResources([]string{"Service", "Endpoints", "HTTPRoute"})
+
+	ctrl := newKubeController(context.TODO(), fake.NewClientset(), gwFake.NewClientset(), crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw)
+	ctrl.initGatewayAPI([]string{"HTTPRoute"})
+
+	expected := []string{"EndpointSlice", "Gateway", "HTTPRoute", "Service", "Service/Node"}
+	if names := slices.Sorted(maps.Keys(ctrl.controllers)); !slices.Equal(names, expected) {
+		t.Fatalf("Expected informers %v, got %v", expected, names)
+	}
+	// every resource adds its indexes to the one Service informer
+	expected = []string{gatewayServiceIndex, headlessServiceHostnameIndex, serviceAddressIndex, serviceHostnameIndex}
+	if indexes := slices.Sorted(maps.Keys(ctrl.controllers["Service"].GetIndexer().GetIndexers())); !slices.Equal(indexes, expected) {
+		t.Errorf("Expected Service indexes %v, got %v", expected, indexes)
+	}
+}
+
+func TestControllerResyncPeriod(t *testing.T) {
+	gw := newGateway()
+	gw.resyncPeriod = 10 * time.Minute
+	gw.updateResources([]string{"Ingress", "Service", "Endpoints", "HTTPRoute", "TLSRoute", "GRPCRoute"})
+	gw.SetConfiguredResources([]string{"Ingress", "Service", "Endpoints", "HTTPRoute", "TLSRoute", "GRPCRoute"})
+
+	ctrl := newKubeController(context.TODO(), fake.NewClientset(), gwFake.NewClientset(), crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw)
+	ctrl.initGatewayAPI([]string{"HTTPRoute", "TLSRoute", "GRPCRoute"})
+
+	expected := []string{"EndpointSlice", "GRPCRoute", "Gateway", "HTTPRoute", "Ingress", "Service", "TLSRoute"}
+	if names := slices.Sorted(maps.Keys(ctrl.controllers)); !slices.Equal(names, expected) {
+		t.Fatalf("Expected informers %v, got %v", expected, names)
+	}
+	for name, informer := range ctrl.controllers {
+		// the informers don't expose their resync period
+		period := time.Duration(reflect.ValueOf(informer).Elem().FieldByName("defaultEventHandlerResyncPeriod").Int())
+		if period != gw.resyncPeriod {
+			t.Errorf("Expected %s informer to resync every %s, got %s", name, gw.resyncPeriod, period)
+		}
+	}
+}
+
+func TestServeStale(t *testing.T) {
+	client := fake.NewClientset(&core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc1", Namespace: "ns1"},
+		Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+		Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+			Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.1"}},
+		}},
+	})
+	var unreachable atomic.Bool
+	client.PrependReactor("get", "version", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if unreachable.Load() {
+			return true, nil, fmt.Errorf("connection refused")
+		}
+		return false, nil, nil
+	})
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.serveStale = true
+	gw.serveStaleTTL = 10
+	gw.updateResources([]string{"Service"})
+	gw.SetConfiguredResources([]string{"Service"})
+	gw.Controller = newKubeController(context.TODO(), client, gwFake.NewClientset(), crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw)
+	go gw.Controller.run()
+	defer gw.Controller.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(context.Context) (bool, error) {
+		return gw.Controller.HasSynced(), nil
+	}); err != nil {
+		t.Fatalf("Expected the controller to sync: %s", err)
+	}
+
+	query := func(ttl uint32) {
+		t.Helper()
+		tc := test.Case{
+			Qname: "svc1.ns1.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A(fmt.Sprintf("svc1.ns1.example.com.	%d	IN	A	192.0.2.1", ttl))},
+		}
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Error(err)
+		}
+	}
+	query(60)
+
+	// the API server becomes unreachable after the initial sync
+	unreachable.Store(true)
+	gw.Controller.watchErrorHandler("Service")(nil, fmt.Errorf("connection refused"))
+	query(10)
+	gw.Controller.checkConnection()
+	query(10)
+
+	unreachable.Store(false)
+	gw.Controller.checkConnection()
+	query(60)
+}
+
+func TestMultipleClusters(t *testing.T) {
+	service := func(name, ip string) *core.Service {
+		return &core.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1"},
+			Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer},
+			Status: core.ServiceStatus{LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: ip}},
+			}},
+		}
+	}
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.configContexts = []string{"east", "west"}
+	gw.updateResources([]string{"Service"})
+	gw.SetConfiguredResources([]string{"Service"})
+	gw.Controller = newKubeController(context.TODO(), fake.NewClientset(service("east", "192.0.2.1"), service("shared", "192.0.2.10")), gwFake.NewClientset(), crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw)
+	gw.clusters = []*KubeController{newClusterController(context.TODO(),
+		fake.NewClientset(service("west", "192.0.2.2"), service("shared", "192.0.2.20")), gwFake.NewClientset(),
+		crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw, gw.clusterResources())}
+	for _, ctrl := range gw.controllers() {
+		go ctrl.run()
+		defer ctrl.Stop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(context.Context) (bool, error) {
+		return gw.hasSynced(), nil
+	}); err != nil {
+		t.Fatalf("Expected the controllers to sync: %s", err)
+	}
+
+	tests := []test.Case{
+		{
+			Qname: "east.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("east.ns1.example.com.	60	IN	A	192.0.2.1")},
+		},
+		{
+			Qname: "west.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("west.ns1.example.com.	60	IN	A	192.0.2.2")},
+		},
+		// the addresses of a name in both clusters are merged
+		{
+			Qname: "shared.ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.A("shared.ns1.example.com.	60	IN	A	192.0.2.10"),
+				test.A("shared.ns1.example.com.	60	IN	A	192.0.2.20"),
+			},
+		},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Fatalf("Test %d: Expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+
+	// both clusters back the reverse lookups as well
+	hostnames := gw.reverseLookup(gw.lookupResource("Service"), netip.MustParseAddr("192.0.2.2"))
+	if expected := []string{"west.ns1"}; !slices.Equal(hostnames, expected) {
+		t.Errorf("Expected hostnames %v, got %v", expected, hostnames)
+	}
+
+	// each cluster checks its own CRDs, here only the second one has the Gateway API
+	gateway := testGateways["ns1/gw-1"].DeepCopy()
+	gateway.Status.Addresses[0].Type = ptr.To(gatewayapi_v1.IPAddressType)
+	route := &gatewayapi_v1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "route-west", Namespace: "ns1"},
+		Spec: gatewayapi_v1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{
+				ParentRefs: []gatewayapi_v1.ParentReference{{Name: "gw-1"}},
+			},
+			Hostnames: []gatewayapi_v1.Hostname{"app.example.com"},
+		},
+	}
+	crdClient := apiextensionsFake.NewClientset()
+	if err := crdClient.Tracker().Add(&apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "gatewayclasses.gateway.networking.k8s.io"},
+	}); err != nil {
+		t.Fatalf("Failed to add CRD: %s", err)
+	}
+
+	gw = newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.configContexts = []string{"east", "west"}
+	gw.updateResources([]string{"HTTPRoute", "Service"})
+	gw.SetConfiguredResources([]string{"HTTPRoute", "Service"})
+	gw.Controller = newKubeController(context.TODO(), fake.NewClientset(), gwFake.NewClientset(),
+		crdClients{apiextensions: apiextensionsFake.NewClientset()}, gw)
+	// added with its resource, the tracker would guess "gatewaies" as the plural
+	// of Gateway and the field managed one of NewClientset has no mapping for it
+	gwClient := gwFake.NewSimpleClientset(route)
+	if err := gwClient.Tracker().Create(gatewayapi_v1.SchemeGroupVersion.WithResource("gateways"), gateway, "ns1"); err != nil {
+		t.Fatalf("Failed to create Gateway: %s", err)
+	}
+	west := newClusterController(context.TODO(), fake.NewClientset(), gwClient,
+		crdClients{apiextensions: crdClient}, gw, gw.clusterResources())
+	gw.clusters = []*KubeController{west}
+	if gw.Controller.hasController("HTTPRoute") {
+		t.Errorf("Expected no HTTPRoute controller in the first cluster without the Gateway API CRDs")
+	}
+	if !west.hasController("HTTPRoute") {
+		t.Fatalf("Expected an HTTPRoute controller in the second cluster with the Gateway API CRDs")
+	}
+	for _, ctrl := range gw.controllers() {
+		go ctrl.run()
+		defer ctrl.Stop()
+	}
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(context.Context) (bool, error) {
+		return gw.hasSynced(), nil
+	}); err != nil {
+		t.Fatalf("Expected the controllers to sync: %s", err)
+	}
+
+	tc := test.Case{
+		Qname: "app.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{test.A("app.example.com.	60	IN	A	192.0.2.100")},
+	}
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := test.SortAndCheck(w.Msg, tc); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestActivateInstalledCRDs(t *testing.T) {
+	crdClient := apiextensionsFake.NewClientset()
+
+	gw := newGateway()
+	gw.updateResources([]string{"HTTPRoute", "Ingress"})
+	gw.SetConfiguredResources([]string{"HTTPRoute", "Ingress"})
+
+	ctrl := newKubeController(context.TODO(), fake.NewClientset(), gwFake.NewClientset(), crdClients{apiextensions: crdClient}, gw)
+	if ctrl.hasController("HTTPRoute") {
+		t.Fatalf("Expected no HTTPRoute controller without the Gateway API CRDs")
+	}
+	controllers := len(ctrl.controllers)
+
+	crd := &apiextensionsv1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: "gatewayclasses.gateway.networking.k8s.io"}}
+	if err := crdClient.Tracker().Add(crd); err != nil {
+		t.Fatalf("Failed to create CRD: %s", err)
+	}
+	ctrl.recheckInactiveResources()
+
+	for _, name := range []string{"Gateway", "Service", "HTTPRoute"} {
+		if !ctrl.hasController(name) {
+			t.Errorf("Expected %s controller after the CRD was installed", name)
+		}
+	}
+	if len(ctrl.controllers) != controllers+3 {
+		t.Errorf("Expected %d controllers, got %d", controllers+3, len(ctrl.controllers))
+	}
+	if len(ctrl.inactiveResources) != 0 {
+		t.Errorf("Expected no inactive resources, got %v", ctrl.inactiveResources)
+	}
+
+	metric := &dto.Metric{}
+	if err := inactiveResources.WithLabelValues("HTTPRoute").Write(metric); err != nil {
+		t.Fatalf("Failed to read inactive resources metric: %s", err)
+	}
+	if value := metric.GetGauge().GetValue(); value != 0 {
+		t.Errorf("Expected inactive resources metric for HTTPRoute to be 0, got %v", value)
+	}
+}
+
+func TestPublishLookupsOnceSynced(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	gw := newGateway()
+	ctrl := &KubeController{gateway: gw, stopCh: stopCh}
+	synced := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc:  serviceLister(context.TODO(), fake.NewClientset(), core.NamespaceAll),
+			WatchFunc: serviceWatcher(context.TODO(), fake.NewClientset(), core.NamespaceAll),
+		},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{},
+	)
+	unsynced := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+				return nil, fmt.Errorf("unreachable")
+			},
+			WatchFunc: serviceWatcher(context.TODO(), fake.NewClientset(), core.NamespaceAll),
+		},
+		&core.Service{},
+		defaultResyncPeriod,
+		cache.Indexers{},
+	)
+	go synced.Run(stopCh)
+	go unsynced.Run(stopCh)
+
+	published := func(context.Context, []string) lookupResult {
+		return lookupResult{addrs: []netip.Addr{netip.MustParseAddr("192.0.2.1")}}
+	}
+	waiting := &resourceWithIndex{name: "HTTPRoute", lookup: noop, reverse: noopReverse}
+	ready := &resourceWithIndex{name: "DNSEndpoint", lookup: noop, reverse: noopReverse}
+	ctrl.publishLookups(waiting, published, nil, synced, unsynced)
+	ctrl.publishLookups(ready, published, nil, synced)
+
+	// queries keep being served while the lookups are published
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(context.Context) (bool, error) {
+		return len(gw.lookup(context.TODO(), ready, []string{"app.example.com"}).addrs) > 0, nil
+	}); err != nil {
+		t.Fatalf("Expected the lookups to be published once the informer has synced: %s", err)
+	}
+	if addrs := gw.lookup(context.TODO(), waiting, []string{"app.example.com"}).addrs; len(addrs) != 0 {
+		t.Errorf("Expected no lookups to be published before all informers have synced, got %v", addrs)
+	}
+}
+
 func isFound(s string, ss []string) bool {
 	for _, str := range ss {
 		if str == s {
@@ -413,6 +3726,117 @@ var testServices = map[string]*core.Service{
 			},
 		},
 	},
+	"annotation-list1,annotation-list2": {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc3",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				"coredns.io/hostname": "annotation-list1, annotation-list2",
+			},
+		},
+		Spec: core.ServiceSpec{
+			Type: core.ServiceTypeLoadBalancer,
+		},
+		Status: core.ServiceStatus{
+			LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{
+					{IP: "192.0.0.3"},
+				},
+			},
+		},
+	},
+}
+
+var testVirtualServices = map[string]*istio_v1beta1.VirtualService{
+	"app.example.com": {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "ns1",
+		},
+		Spec: istio_api_v1beta1.VirtualService{
+			Hosts:    []string{"app.example.com"},
+			Gateways: []string{"istio-system/ingress"},
+		},
+	},
+	"mesh.example.com": {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mesh",
+			Namespace: "ns1",
+		},
+		Spec: istio_api_v1beta1.VirtualService{
+			Hosts:    []string{"mesh.example.com"},
+			Gateways: []string{"mesh"},
+		},
+	},
+}
+
+var testIstioGateway = &istio_v1beta1.Gateway{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ingress",
+		Namespace: "istio-system",
+	},
+	Spec: istio_api_v1beta1.Gateway{
+		Selector: map[string]string{"istio": "ingressgateway"},
+	},
+}
+
+var testIstioServices = map[string]*core.Service{
+	"istio-ingressgateway.istio-system": {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "istio-ingressgateway",
+			Namespace: "istio-system",
+		},
+		Spec: core.ServiceSpec{
+			Type:     core.ServiceTypeLoadBalancer,
+			Selector: map[string]string{"app": "istio-ingressgateway", "istio": "ingressgateway"},
+		},
+		Status: core.ServiceStatus{
+			LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.50"}},
+			},
+		},
+	},
+	"istio-eastwestgateway.istio-system": {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "istio-eastwestgateway",
+			Namespace: "istio-system",
+		},
+		Spec: core.ServiceSpec{
+			Type:     core.ServiceTypeLoadBalancer,
+			Selector: map[string]string{"istio": "eastwestgateway"},
+		},
+		Status: core.ServiceStatus{
+			LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.51"}},
+			},
+		},
+	},
+}
+
+var testGatewayServices = map[string]*core.Service{
+	"ns1/gw-2-lb": {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gw-2-lb",
+			Namespace: "ns1",
+			Labels:    map[string]string{gatewayNameLabelKey: "gw-2"},
+		},
+		Status: core.ServiceStatus{
+			LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.110"}},
+			},
+		},
+	},
+	"infra/shared-lb": {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "shared-lb",
+			Namespace: "infra",
+		},
+		Status: core.ServiceStatus{
+			LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.120"}},
+			},
+		},
+	},
 }
 
 var testGateways = map[string]*gatewayapi_v1.Gateway{
@@ -533,3 +3957,218 @@ var testDNSEndpoints = map[string]*externaldnsv1.DNSEndpoint{
 		},
 	},
 }
+
+var testDNSEndpointLowercase = &externaldnsv1.DNSEndpoint{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ep2",
+		Namespace: "ns1",
+	},
+	Spec: externaldnsv1.DNSEndpointSpec{
+		Endpoints: []*endpoint.Endpoint{
+			{
+				DNSName:    "lower.example.com",
+				RecordType: "a",
+				Targets:    []string{"192.0.2.201"},
+			},
+			{
+				DNSName:    "lower.example.com",
+				RecordType: "Aaaa",
+				Targets:    []string{"2001:db8::2"},
+			},
+		},
+	},
+}
+
+var testClusterIPServices = map[string]struct {
+	service  *core.Service
+	expected []netip.Addr
+}{
+	"dual-stack": {
+		service: &core.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "svc-dual",
+				Namespace: "ns1",
+			},
+			Spec: core.ServiceSpec{
+				Type:       core.ServiceTypeClusterIP,
+				ClusterIP:  "10.96.0.10",
+				ClusterIPs: []string{"10.96.0.10", "fd00:10:96::a"},
+			},
+		},
+		expected: []netip.Addr{netip.MustParseAddr("10.96.0.10"), netip.MustParseAddr("fd00:10:96::a")},
+	},
+	"single-stack": {
+		service: &core.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "svc-single",
+				Namespace: "ns1",
+			},
+			Spec: core.ServiceSpec{
+				Type:      core.ServiceTypeClusterIP,
+				ClusterIP: "10.96.0.11",
+			},
+		},
+		expected: []netip.Addr{netip.MustParseAddr("10.96.0.11")},
+	},
+	"headless": {
+		service: &core.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "svc-headless",
+				Namespace: "ns1",
+			},
+			Spec: core.ServiceSpec{
+				Type:       core.ServiceTypeClusterIP,
+				ClusterIP:  core.ClusterIPNone,
+				ClusterIPs: []string{core.ClusterIPNone},
+			},
+		},
+		expected: nil,
+	},
+}
+
+var testDNSEndpointMX = &externaldnsv1.DNSEndpoint{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ep-mx",
+		Namespace: "ns1",
+	},
+	Spec: externaldnsv1.DNSEndpointSpec{
+		Endpoints: []*endpoint.Endpoint{
+			{
+				DNSName:    "mail.example.com",
+				RecordType: "MX",
+				Targets:    []string{"10 mx1.example.com", "20 mx2.example.com"},
+			},
+		},
+	},
+}
+
+var testDNSEndpointSRV = &externaldnsv1.DNSEndpoint{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ep-srv",
+		Namespace: "ns1",
+	},
+	Spec: externaldnsv1.DNSEndpointSpec{
+		Endpoints: []*endpoint.Endpoint{
+			{
+				DNSName:    "_http._tcp.example.com",
+				RecordType: "SRV",
+				Targets:    []string{"10 50 8080 web1.example.com", "20 50 8080 web2.example.com"},
+			},
+		},
+	},
+}
+
+var testDNSEndpointDS = &externaldnsv1.DNSEndpoint{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ep-ds",
+		Namespace: "ns1",
+	},
+	Spec: externaldnsv1.DNSEndpointSpec{
+		Endpoints: []*endpoint.Endpoint{
+			{
+				DNSName:    "child.example.com",
+				RecordType: "NS",
+				Targets:    []string{"ns1.child.example.net"},
+			},
+			{
+				DNSName:    "child.example.com",
+				RecordType: "DS",
+				Targets:    []string{"2371 13 2 1F987CC6583E92DF0890718C42D5C9F3A1C2B0E7F8D9A6B5C4D3E2F1A0B9C8D7"},
+			},
+		},
+	},
+}
+
+var testDNSEndpointCAA = &externaldnsv1.DNSEndpoint{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ep-caa",
+		Namespace: "ns1",
+	},
+	Spec: externaldnsv1.DNSEndpointSpec{
+		Endpoints: []*endpoint.Endpoint{
+			{
+				DNSName:    "secure.example.com",
+				RecordType: "A",
+				Targets:    []string{"192.0.2.50"},
+			},
+			{
+				DNSName:    "secure.example.com",
+				RecordType: "caa",
+				Targets:    []string{`0 issue "letsencrypt.org"`, "0 issuewild ;", "0 bad_tag value"},
+			},
+		},
+	},
+}
+
+var testDNSEndpointWildcard = &externaldnsv1.DNSEndpoint{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ep-wildcard",
+		Namespace: "ns1",
+	},
+	Spec: externaldnsv1.DNSEndpointSpec{
+		Endpoints: []*endpoint.Endpoint{
+			{
+				DNSName:    "*.apps.example.com",
+				RecordType: "A",
+				Targets:    []string{"192.0.2.60"},
+			},
+		},
+	},
+}
+
+var testDNSEndpointDeepWildcard = &externaldnsv1.DNSEndpoint{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ep-deep-wildcard",
+		Namespace: "ns1",
+	},
+	Spec: externaldnsv1.DNSEndpointSpec{
+		Endpoints: []*endpoint.Endpoint{
+			{
+				DNSName:    "*.deep.apps.example.com",
+				RecordType: "A",
+				Targets:    []string{"192.0.2.61"},
+			},
+		},
+	},
+}
+
+var testDNSEndpointNS = &externaldnsv1.DNSEndpoint{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "ep-ns",
+		Namespace: "ns1",
+	},
+	Spec: externaldnsv1.DNSEndpointSpec{
+		Endpoints: []*endpoint.Endpoint{
+			{
+				DNSName:    "sub.example.com",
+				RecordType: "ns",
+				Targets:    []string{"ns1.example.net", "ns2.example.net"},
+			},
+		},
+	},
+}
+
+var testEndpointSlice = &discovery.EndpointSlice{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "svc-headless-abcde",
+		Namespace: "ns1",
+		Labels: map[string]string{
+			discovery.LabelServiceName: "svc-headless",
+		},
+	},
+	AddressType: discovery.AddressTypeIPv4,
+	Endpoints: []discovery.Endpoint{
+		{
+			Addresses:  []string{"10.244.0.10"},
+			Conditions: discovery.EndpointConditions{Ready: ptr.To(true)},
+		},
+		{
+			Addresses:  []string{"10.244.1.10"},
+			Conditions: discovery.EndpointConditions{Ready: ptr.To(true)},
+		},
+		{
+			Addresses:  []string{"10.244.2.10"},
+			Conditions: discovery.EndpointConditions{Ready: ptr.To(false)},
+		},
+	},
+}
@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// inactiveResources reports configured resources that aren't watched because their CRD or API is unavailable.
+	inactiveResources = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "inactive_resources",
+		Help:      "Configured resources that are not watched because their CRD or API is unavailable.",
+	}, []string{"resource"})
+
+	// answerCacheHits and answerCacheMisses count the queries served from the answer cache and the ones that weren't.
+	answerCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "answer_cache_hits_total",
+		Help:      "Counter of queries answered from the answer cache.",
+	})
+	answerCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "answer_cache_misses_total",
+		Help:      "Counter of queries not found in the answer cache.",
+	})
+	// resolutionErrors counts failed lookups of load balancer hostnames, by the type of resource they belong to.
+	resolutionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "resolution_errors_total",
+		Help:      "Counter of failed load balancer hostname lookups.",
+	}, []string{"resource"})
+	// answerCacheEntries reports the number of cached answers.
+	answerCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "answer_cache_entries",
+		Help:      "Number of answers in the answer cache.",
+	})
+)
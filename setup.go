@@ -2,18 +2,34 @@ package gateway
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"net/netip"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/miekg/dns"
 )
 
 var log = clog.NewWithPlugin(thisPlugin)
 
 const thisPlugin = "k8s_gateway"
 
+var supportedServiceTypes = []string{"LoadBalancer", "ClusterIP", "NodePort"}
+
+var supportedNodeAddressTypes = []string{"InternalIP", "ExternalIP"}
+
+var supportedFamilies = []string{familyAll, familyIPv4, familyIPv6}
+
+var supportedHostnameConflicts = []string{conflictUnion, conflictFirst, conflictReject}
+
 func init() {
 	plugin.Register(thisPlugin, setup)
 }
@@ -28,6 +44,13 @@ func setup(c *caddy.Controller) error {
 	if err != nil {
 		return plugin.Error(thisPlugin, err)
 	}
+	// stop the informers of this instance when CoreDNS reloads or exits
+	c.OnShutdown(func() error {
+		for _, ctrl := range gw.controllers() {
+			ctrl.Stop()
+		}
+		return nil
+	})
 	gw.ExternalAddrFunc = gw.SelfAddress
 
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
@@ -38,8 +61,113 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
+// parseClassNames splits space or comma separated class names, which have to
+// be valid object names
+func parseClassNames(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no classes given")
+	}
+	var classes []string
+	for _, arg := range args {
+		for _, class := range strings.Split(arg, ",") {
+			if !isdns1123Hostname(class) {
+				return nil, fmt.Errorf("invalid class name '%s'", class)
+			}
+			classes = append(classes, class)
+		}
+	}
+	return classes, nil
+}
+
+// servedZone normalizes the zone of a per-zone option, which must be one of
+// the zones served by the plugin
+func servedZone(c *caddy.Controller, gw *Gateway, option, arg string) (string, error) {
+	zone := plugin.Host(arg).NormalizeExact()
+	if len(zone) == 0 || !slices.Contains(gw.Zones, zone[0]) {
+		return "", c.Errf("Zone '%s' of '%s' is not served by the plugin", arg, option)
+	}
+	return zone[0], nil
+}
+
+// checkResourceNames returns an error for the first name of an option that
+// isn't a supported resource, listing the supported ones
+func checkResourceNames(c *caddy.Controller, option string, names []string) error {
+	supported := staticResourceNames()
+	for _, name := range names {
+		if !slices.Contains(supported, name) {
+			return c.Errf("Unknown resource '%s' in '%s', must be one of %v", name, option, supported)
+		}
+	}
+	return nil
+}
+
+// parseFamilyPreference parses the `ipv4 | ipv6 [only]` arguments of an option
+func parseFamilyPreference(c *caddy.Controller, option string, args []string) (familyPreference, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return familyPreference{}, c.ArgErr()
+	}
+	if args[0] != familyIPv4 && args[0] != familyIPv6 {
+		return familyPreference{}, c.Errf("Unsupported preferred address family '%s', must be one of %v", args[0], []string{familyIPv4, familyIPv6})
+	}
+	if len(args) == 2 && args[1] != "only" {
+		return familyPreference{}, c.Errf("Unknown %s argument '%s', expected 'only'", option, args[1])
+	}
+	return familyPreference{family: args[0], only: len(args) == 2}, nil
+}
+
+// checkServiceTypes returns an error for the first unsupported service type
+func checkServiceTypes(c *caddy.Controller, types []string) error {
+	for _, serviceType := range types {
+		if !slices.Contains(supportedServiceTypes, serviceType) {
+			return c.Errf("Unsupported service type '%s', must be one of %v", serviceType, supportedServiceTypes)
+		}
+	}
+	return nil
+}
+
+// parseFallthroughTypes parses the record types restricting fallthrough
+func parseFallthroughTypes(args []string) ([]uint16, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no types given")
+	}
+	var qtypes []uint16
+	for _, arg := range args {
+		qtype, ok := dns.StringToType[strings.ToUpper(arg)]
+		if !ok {
+			return nil, fmt.Errorf("unknown record type '%s'", arg)
+		}
+		qtypes = append(qtypes, qtype)
+	}
+	return qtypes, nil
+}
+
+// parseResolverAddr parses a nameserver IP with an optional port, defaulting
+// to port 53, into a host:port address
+func parseResolverAddr(arg string) (string, error) {
+	if addr, err := netip.ParseAddr(arg); err == nil {
+		return netip.AddrPortFrom(addr, 53).String(), nil
+	}
+	addrPort, err := netip.ParseAddrPort(arg)
+	if err != nil {
+		return "", err
+	}
+	if addrPort.Port() == 0 {
+		return "", fmt.Errorf("invalid port 0")
+	}
+	return addrPort.String(), nil
+}
+
 func parse(c *caddy.Controller) (*Gateway, error) {
 	gw := newGateway()
+	var zoneResources map[string][]string
+	// overrides of the resource filters of a zone, applied once all options are parsed
+	var zoneFilterOptions map[string][]func(*ResourceFilters)
+	overrideZoneFilters := func(zone string, option func(*ResourceFilters)) {
+		if zoneFilterOptions == nil {
+			zoneFilterOptions = make(map[string][]func(*ResourceFilters))
+		}
+		zoneFilterOptions[zone] = append(zoneFilterOptions[zone], option)
+	}
 
 	for c.Next() {
 		zones := c.RemainingArgs()
@@ -59,21 +187,202 @@ func parse(c *caddy.Controller) (*Gateway, error) {
 		for c.NextBlock() {
 			switch c.Val() {
 			case "fallthrough":
-				gw.Fall.SetZonesFromArgs(c.RemainingArgs())
+				// zones may be followed by `types TYPE...` to restrict fallthrough to those query types
+				args := c.RemainingArgs()
+				if i := slices.Index(args, "types"); i >= 0 {
+					qtypes, err := parseFallthroughTypes(args[i+1:])
+					if err != nil {
+						return nil, c.Errf("Incorrectly formatted 'fallthrough' types: %s", err)
+					}
+					gw.fallthroughTypes = append(gw.fallthroughTypes, qtypes...)
+					args = args[:i]
+				}
+				gw.Fall.SetZonesFromArgs(args)
+			case "zoneFallthrough":
+				// overrides fallthrough for one of the zones, e.g. `zoneFallthrough internal.example.com off`
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				zone, err := servedZone(c, gw, "zoneFallthrough", args[0])
+				if err != nil {
+					return nil, err
+				}
+				var zf zoneFall
+				switch {
+				case len(args) == 1:
+				case len(args) == 2 && args[1] == "off":
+					zf.off = true
+				case args[1] == "types":
+					qtypes, err := parseFallthroughTypes(args[2:])
+					if err != nil {
+						return nil, c.Errf("Incorrectly formatted 'zoneFallthrough' types: %s", err)
+					}
+					zf.types = qtypes
+				default:
+					return nil, c.Errf("Incorrectly formatted 'zoneFallthrough' for zone '%s'", args[0])
+				}
+				if gw.zoneFallthrough == nil {
+					gw.zoneFallthrough = make(map[string]zoneFall)
+				}
+				gw.zoneFallthrough[zone] = zf
 			case "secondary":
 				args := c.RemainingArgs()
 				if len(args) == 0 {
 					return nil, c.ArgErr()
 				}
-				gw.secondNS = args[0]
+				gw.secondNS = args
+			case "nameservers":
+				// either `none` or the absolute names replacing the synthesized apex NS records
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				if len(args) == 1 && args[0] == "none" {
+					gw.disableNameservers = true
+					continue
+				}
+				for _, arg := range args {
+					if _, ok := dns.IsDomainName(arg); !ok {
+						return nil, c.Errf("Invalid nameserver name '%s'", arg)
+					}
+					gw.nameserverNames = append(gw.nameserverNames, dns.Fqdn(strings.ToLower(arg)))
+				}
+			case "zoneResources":
+				// restricts the resources looked up for names in one of the zones
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return nil, c.ArgErr()
+				}
+				zone, err := servedZone(c, gw, "zoneResources", args[0])
+				if err != nil {
+					return nil, err
+				}
+				if zoneResources == nil {
+					zoneResources = make(map[string][]string)
+				}
+				zoneResources[zone] = args[1:]
+			case "clientRegion":
+				// e.g. `clientRegion eu-west 10.1.0.0/16 fd00:1::/48`, matched against the EDNS Client Subnet of queries
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return nil, c.ArgErr()
+				}
+				for _, arg := range args[1:] {
+					prefix, err := netip.ParsePrefix(arg)
+					if err != nil {
+						return nil, c.Errf("Invalid client subnet '%s' of region '%s': %v", arg, args[0], err)
+					}
+					gw.clientRegions = append(gw.clientRegions, clientRegion{prefix: prefix.Masked(), region: args[0]})
+				}
+			case "upstreamResolvers":
+				// nameservers resolving load balancer hostnames, e.g. `upstreamResolvers 10.0.0.2 [fd00::2]:5353`
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, arg := range args {
+					server, err := parseResolverAddr(arg)
+					if err != nil {
+						return nil, c.Errf("Invalid upstream resolver '%s': %v", arg, err)
+					}
+					gw.upstreamResolvers = append(gw.upstreamResolvers, server)
+				}
+				gw.resourceFilters.resolver = newUpstreamResolver(gw.upstreamResolvers)
+			case "zoneAlias":
+				// mirrors the names of a served zone in another zone, e.g. `zoneAlias internal.example.com example.com`
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				alias := plugin.Host(args[0]).NormalizeExact()
+				target := plugin.Host(args[1]).NormalizeExact()
+				if len(target) == 0 || !slices.Contains(gw.Zones, target[0]) {
+					return nil, c.Errf("Zone '%s' of 'zoneAlias' is not served by the plugin", args[1])
+				}
+				if len(alias) == 0 || slices.Contains(gw.Zones, alias[0]) || gw.zoneAliases[alias[0]] != "" {
+					return nil, c.Errf("Alias zone '%s' is already served by the plugin", args[0])
+				}
+				if gw.zoneAliases == nil {
+					gw.zoneAliases = make(map[string]string)
+				}
+				gw.zoneAliases[alias[0]] = target[0]
+			case "stripSubdomain":
+				// answers names below a subdomain of the zones as the same names in the zone, e.g. `stripSubdomain svc`
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				subdomain := strings.Trim(strings.ToLower(args[0]), ".")
+				if _, ok := dns.IsDomainName(subdomain); !ok || subdomain == "" {
+					return nil, c.Errf("Invalid subdomain '%s' of 'stripSubdomain'", args[0])
+				}
+				gw.stripSubdomain = subdomain
+			case "static":
+				// records served regardless of the cluster state, e.g. `static www.example.com A 192.0.2.1`
+				args := c.RemainingArgs()
+				if len(args) < 3 {
+					return nil, c.ArgErr()
+				}
+				name := dns.Fqdn(strings.ToLower(args[0]))
+				if _, ok := dns.IsDomainName(name); !ok || plugin.Zones(gw.Zones).Matches(name) == "" {
+					return nil, c.Errf("Static record name '%s' is not in a zone served by the plugin", args[0])
+				}
+				recordType := strings.ToUpper(args[1])
+				if recordType != "A" && recordType != "AAAA" {
+					return nil, c.Errf("Unsupported static record type '%s'", args[1])
+				}
+				for _, arg := range args[2:] {
+					addr, err := parseAddr(arg)
+					if err != nil || addr.Is4() != (recordType == "A") {
+						return nil, c.Errf("Invalid %s static record address '%s'", recordType, arg)
+					}
+					if gw.staticRecords == nil {
+						gw.staticRecords = make(map[string][]netip.Addr)
+					}
+					key := stripClosingDot(name)
+					gw.staticRecords[key] = append(gw.staticRecords[key], addr)
+				}
+			case "dname":
+				// redirects the names below an owner to another subtree, e.g. `dname old.example.com new.example.com`
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				owner := dns.Fqdn(strings.ToLower(args[0]))
+				if _, ok := dns.IsDomainName(owner); !ok || plugin.Zones(gw.Zones).Matches(owner) == "" {
+					return nil, c.Errf("DNAME owner '%s' is not in a zone served by the plugin", args[0])
+				}
+				target := dns.Fqdn(strings.ToLower(args[1]))
+				if _, ok := dns.IsDomainName(target); !ok || dns.IsSubDomain(owner, target) {
+					return nil, c.Errf("Invalid DNAME target '%s' for '%s'", args[1], args[0])
+				}
+				if _, exists := gw.dnameTargets[owner]; exists {
+					return nil, c.Errf("Duplicate DNAME owner '%s'", args[0])
+				}
+				if gw.dnameTargets == nil {
+					gw.dnameTargets = make(map[string]string)
+				}
+				gw.dnameTargets[owner] = target
 			case "resources":
 				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.Errf("Incorrectly formatted 'resource' parameter")
+				}
+				if err := checkResourceNames(c, "resources", args); err != nil {
+					return nil, err
+				}
 				gw.updateResources(args)
 				gw.SetConfiguredResources(args)
-
+			case "resourcePrecedence":
+				args := c.RemainingArgs()
 				if len(args) == 0 {
-					return nil, c.Errf("Incorrectly formatted 'resource' parameter")
+					return nil, c.ArgErr()
+				}
+				if err := checkResourceNames(c, "resourcePrecedence", args); err != nil {
+					return nil, err
 				}
+				gw.resourcePrecedence = args
 			case "ttl":
 				args := c.RemainingArgs()
 				if len(args) == 0 {
@@ -87,40 +396,507 @@ func parse(c *caddy.Controller) (*Gateway, error) {
 					return nil, c.Errf("ttl must be in range [0, 3600]: %d", t)
 				}
 				gw.ttlLow = uint32(t)
+			case "upstreamTTLFloor":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				t, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if t < 0 || t > 3600 {
+					return nil, c.Errf("upstreamTTLFloor must be in range [0, 3600]: %d", t)
+				}
+				gw.upstreamTTLFloor = uint32(t)
+			case "negativeTTL":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				t, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if t < 0 || t > 3600 {
+					return nil, c.Errf("negativeTTL must be in range [0, 3600]: %d", t)
+				}
+				gw.soaMinTTL = uint32(t)
 			case "apex":
 				args := c.RemainingArgs()
 				if len(args) == 0 {
 					return nil, c.ArgErr()
 				}
 				gw.apex = args[0]
+			case "hostmaster":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				gw.hostmaster = args[0]
 			case "kubeconfig":
 				args := c.RemainingArgs()
 				if len(args) == 0 {
 					return nil, c.ArgErr()
 				}
 				gw.configFile = args[0]
-				if len(args) == 2 {
-					gw.configContext = args[1]
+				// the objects of several contexts' clusters are merged
+				gw.configContexts = args[1:]
+				if len(slices.Compact(slices.Sorted(slices.Values(gw.configContexts)))) != len(gw.configContexts) {
+					return nil, c.Errf("duplicate kubeconfig context in %v", gw.configContexts)
+				}
+
+			case "ingressClasses", "gatewayClasses":
+				// classes may be separated by spaces or commas
+				option := c.Val()
+				classes, err := parseClassNames(c.RemainingArgs())
+				if err != nil {
+					return nil, c.Errf("Incorrectly formatted '%s' parameter: %s", option, err)
+				}
+				if option == "ingressClasses" {
+					gw.resourceFilters.ingressClasses = classes
+				} else {
+					gw.resourceFilters.gatewayClasses = classes
+				}
+
+			case "zoneIngressClasses", "zoneGatewayClasses":
+				// overrides the classes of the block for one of the zones, e.g. `zoneIngressClasses internal.example.com internal`
+				option := c.Val()
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return nil, c.ArgErr()
+				}
+				zone, err := servedZone(c, gw, option, args[0])
+				if err != nil {
+					return nil, err
+				}
+				classes, err := parseClassNames(args[1:])
+				if err != nil {
+					return nil, c.Errf("Incorrectly formatted '%s' parameter: %s", option, err)
+				}
+				overrideZoneFilters(zone, func(filters *ResourceFilters) {
+					if option == "zoneIngressClasses" {
+						filters.ingressClasses = classes
+					} else {
+						filters.gatewayClasses = classes
+					}
+				})
+
+			case "serviceTypes":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.Errf("Incorrectly formatted 'serviceTypes' parameter")
+				}
+				if err := checkServiceTypes(c, args); err != nil {
+					return nil, err
+				}
+				gw.resourceFilters.serviceTypes = args
+
+			case "zoneServiceTypes":
+				// overrides serviceTypes for one of the zones, e.g. `zoneServiceTypes internal.example.com LoadBalancer ClusterIP`
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return nil, c.ArgErr()
+				}
+				zone, err := servedZone(c, gw, "zoneServiceTypes", args[0])
+				if err != nil {
+					return nil, err
+				}
+				if err := checkServiceTypes(c, args[1:]); err != nil {
+					return nil, err
+				}
+				overrideZoneFilters(zone, func(filters *ResourceFilters) {
+					filters.serviceTypes = args[1:]
+				})
+
+			case "nodePortAddresses":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+				addressType := "InternalIP"
+				if len(args) == 1 {
+					addressType = args[0]
+				}
+				if !slices.Contains(supportedNodeAddressTypes, addressType) {
+					return nil, c.Errf("Unsupported node address type '%s', must be one of %v", addressType, supportedNodeAddressTypes)
+				}
+				gw.resourceFilters.nodeAddressType = addressType
+
+			case "localTrafficPolicyAddresses":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+				addressType := "ExternalIP"
+				if len(args) == 1 {
+					addressType = args[0]
+				}
+				if !slices.Contains(supportedNodeAddressTypes, addressType) {
+					return nil, c.Errf("Unsupported node address type '%s', must be one of %v", addressType, supportedNodeAddressTypes)
+				}
+				gw.resourceFilters.localPolicyAddressType = addressType
+
+			case "hostnameConflicts":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				if !slices.Contains(supportedHostnameConflicts, args[0]) {
+					return nil, c.Errf("Unsupported hostname conflict policy '%s', must be one of %v", args[0], supportedHostnameConflicts)
+				}
+				gw.resourceFilters.hostnameConflicts = args[0]
+
+			case "acceptedRoutesOnly":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.acceptedRoutesOnly = true
+
+			case "programmedGatewaysOnly":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.programmedGatewaysOnly = true
+
+			case "readyIngressesOnly":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.readyIngressesOnly = true
+				if len(args) == 1 {
+					gw.resourceFilters.ingressReadyAnnotation = args[0]
 				}
 
-			case "ingressClasses":
+			case "backendRefHostnames":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.backendRefHostnames = true
+
+			case "requireReferenceGrants":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.requireReferenceGrants = true
+
+			case "preferLoadBalancerIPs":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.preferLoadBalancerIPs = true
+
+			case "gatewayAddressAnnotation":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.gatewayAddressAnnotation = args[0]
+
+			case "mergeExternalIPs":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.mergeExternalIPs = true
+
+			case "requireAnnotation":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.requireHostnameAnnotation = true
+
+			case "indexLoadBalancerHostnames":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.indexLoadBalancerHostnames = true
+
+			case "serviceClusterIPs":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.resourceFilters.serviceClusterIPs = true
+
+			case "zoneServiceClusterIPs":
+				// overrides serviceClusterIPs for one of the zones, e.g. `zoneServiceClusterIPs internal.example.com`
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[1] != "off") {
+					return nil, c.ArgErr()
+				}
+				zone, err := servedZone(c, gw, "zoneServiceClusterIPs", args[0])
+				if err != nil {
+					return nil, err
+				}
+				enabled := len(args) == 1
+				overrideZoneFilters(zone, func(filters *ResourceFilters) {
+					filters.serviceClusterIPs = enabled
+				})
+
+			case "family":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				if !slices.Contains(supportedFamilies, args[0]) {
+					return nil, c.Errf("Unsupported address family '%s', must be one of %v", args[0], supportedFamilies)
+				}
+				gw.family = args[0]
+
+			case "zoneFamily":
+				// overrides family for one of the zones, e.g. `zoneFamily internal.example.com ipv4`
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				zone, err := servedZone(c, gw, "zoneFamily", args[0])
+				if err != nil {
+					return nil, err
+				}
+				if !slices.Contains(supportedFamilies, args[1]) {
+					return nil, c.Errf("Unsupported address family '%s', must be one of %v", args[1], supportedFamilies)
+				}
+				if gw.zoneFamily == nil {
+					gw.zoneFamily = make(map[string]string)
+				}
+				gw.zoneFamily[zone] = args[1]
+
+			case "preferFamily":
+				preference, err := parseFamilyPreference(c, "preferFamily", c.RemainingArgs())
+				if err != nil {
+					return nil, err
+				}
+				gw.preferFamily = preference.family
+				gw.preferFamilyOnly = preference.only
+
+			case "zonePreferFamily":
+				// overrides preferFamily for one of the zones, e.g. `zonePreferFamily internal.example.com ipv6 only`
 				args := c.RemainingArgs()
 				if len(args) == 0 {
-					return nil, c.Errf("Incorrectly formatted 'ingressClasses' parameter")
+					return nil, c.ArgErr()
+				}
+				zone, err := servedZone(c, gw, "zonePreferFamily", args[0])
+				if err != nil {
+					return nil, err
+				}
+				preference, err := parseFamilyPreference(c, "zonePreferFamily", args[1:])
+				if err != nil {
+					return nil, err
+				}
+				if gw.zonePreferFamily == nil {
+					gw.zonePreferFamily = make(map[string]familyPreference)
 				}
-				gw.resourceFilters.ingressClasses = args
+				gw.zonePreferFamily[zone] = preference
 
-			case "gatewayClasses":
+			case "cnameGatewayHostnames":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.cnameGatewayHostnames = true
+
+			case "zoneCNAMEGatewayHostnames":
+				// overrides cnameGatewayHostnames for one of the zones, e.g. `zoneCNAMEGatewayHostnames example.com off`
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[1] != "off") {
+					return nil, c.ArgErr()
+				}
+				zone, err := servedZone(c, gw, "zoneCNAMEGatewayHostnames", args[0])
+				if err != nil {
+					return nil, err
+				}
+				if gw.zoneCNAMEGatewayHostnames == nil {
+					gw.zoneCNAMEGatewayHostnames = make(map[string]bool)
+				}
+				gw.zoneCNAMEGatewayHostnames[zone] = len(args) == 1
+
+			case "minimalAny":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.minimalAny = true
+
+			case "refuseFiltered":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.refuseFiltered = true
+
+			case "fallthroughUnsynced":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.fallthroughUnsynced = true
+
+			case "allowNames", "denyNames":
+				option := c.Val()
 				args := c.RemainingArgs()
 				if len(args) == 0 {
-					return nil, c.Errf("Incorrectly formatted 'gatewayClasses' parameter")
+					return nil, c.ArgErr()
+				}
+				var patterns []string
+				for _, arg := range args {
+					pattern := strings.ToLower(stripClosingDot(arg))
+					if _, err := path.Match(pattern, ""); err != nil {
+						return nil, c.Errf("Incorrectly formatted '%s' pattern: %s", option, arg)
+					}
+					patterns = append(patterns, pattern)
+				}
+				if option == "allowNames" {
+					gw.allowNames = append(gw.allowNames, patterns...)
+				} else {
+					gw.denyNames = append(gw.denyNames, patterns...)
 				}
-				gw.resourceFilters.gatewayClasses = args
+
+			case "resyncPeriod":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				period, err := time.ParseDuration(args[0])
+				if err != nil || period < 0 {
+					return nil, c.Errf("Incorrectly formatted 'resyncPeriod' period: %s", args[0])
+				}
+				gw.resyncPeriod = period
+
+			case "deleteGrace":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				period, err := time.ParseDuration(args[0])
+				if err != nil || period <= 0 {
+					return nil, c.Errf("Incorrectly formatted 'deleteGrace' period: %s", args[0])
+				}
+				gw.deleteGracePeriod = period
+				if len(args) == 2 {
+					t, err := strconv.Atoi(args[1])
+					if err != nil {
+						return nil, err
+					}
+					if t < 0 || t > 3600 {
+						return nil, c.Errf("deleteGrace ttl must be in range [0, 3600]: %d", t)
+					}
+					gw.deleteGraceTTL = uint32(t)
+				}
+
+			case "statusGrace":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				period, err := time.ParseDuration(args[0])
+				if err != nil || period <= 0 {
+					return nil, c.Errf("Incorrectly formatted 'statusGrace' period: %s", args[0])
+				}
+				gw.statusGracePeriod = period
+
+			case "serveStale":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				t, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if t < 0 || t > 3600 {
+					return nil, c.Errf("serveStale ttl must be in range [0, 3600]: %d", t)
+				}
+				gw.serveStale = true
+				gw.serveStaleTTL = uint32(t)
+
+			case "trace":
+				// query names may be followed by `sample RATE` to also trace that share of all queries
+				args := c.RemainingArgs()
+				if i := slices.Index(args, "sample"); i >= 0 {
+					if i != len(args)-2 {
+						return nil, c.Errf("Incorrectly formatted 'trace' sample rate")
+					}
+					rate, err := strconv.ParseFloat(args[i+1], 64)
+					if err != nil || rate < 0 || rate > 1 {
+						return nil, c.Errf("trace sample rate must be in range [0, 1]: %s", args[i+1])
+					}
+					gw.traceSampleRate = rate
+					args = args[:i]
+				}
+				for _, name := range args {
+					gw.traceNames = append(gw.traceNames, dns.Fqdn(strings.ToLower(name)))
+				}
+				if len(gw.traceNames) == 0 && gw.traceSampleRate == 0 {
+					return nil, c.Errf("Incorrectly formatted 'trace' parameter")
+				}
+
+			case "answerCache":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				size, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if size <= 0 {
+					return nil, c.Errf("answerCache size must be positive: %d", size)
+				}
+				gw.answerCache = newAnswerCache(size)
+
+			case "debugIndex":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				gw.debugIndex = true
 
 			default:
 				return nil, c.Errf("Unknown property '%s'", c.Val())
 			}
 		}
 	}
+
+	// the precedence applies regardless of where resources are configured
+	if len(gw.resourcePrecedence) > 0 {
+		var names []string
+		for _, resource := range gw.Resources {
+			names = append(names, resource.name)
+		}
+		gw.updateResources(names)
+	}
+
+	// zone overrides apply on top of the filters of the block, wherever those are configured
+	for zone, options := range zoneFilterOptions {
+		filters := gw.resourceFilters
+		for _, option := range options {
+			option(&filters)
+		}
+		if gw.zoneFilters == nil {
+			gw.zoneFilters = make(map[string]*ResourceFilters)
+		}
+		gw.zoneFilters[zone] = &filters
+	}
+
+	// ClusterIP services aren't published without the type, so serviceClusterIPs
+	// would silently only apply to the other types
+	for _, zone := range gw.Zones {
+		if filters := gw.filtersOf(zone); filters.serviceClusterIPs && !slices.Contains(filters.serviceTypes, "ClusterIP") {
+			return nil, c.Errf("serviceClusterIPs of zone '%s' requires ClusterIP in serviceTypes, got %v", zone, filters.serviceTypes)
+		}
+	}
+
+	// alias zones are served next to the configured ones
+	gw.Zones = append(gw.Zones, slices.Sorted(maps.Keys(gw.zoneAliases))...)
+
+	// zone resources can only be picked from the watched resources
+	for zone, names := range zoneResources {
+		if gw.zoneResources == nil {
+			gw.zoneResources = make(map[string][]*resourceWithIndex)
+		}
+		for _, name := range names {
+			resource := gw.lookupResource(name)
+			if resource == nil {
+				return nil, c.Errf("Resource '%s' of zone '%s' is not watched", name, zone)
+			}
+			gw.zoneResources[zone] = append(gw.zoneResources[zone], resource)
+		}
+	}
+
 	return gw, nil
 }
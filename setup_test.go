@@ -1,41 +1,986 @@
 package gateway
 
 import (
+	"net/netip"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
 )
 
+// setupFields are the options of a Gateway compared by TestSetup
+var setupFields = []struct {
+	name  string
+	value func(gw *Gateway) any
+}{
+	{"zones", func(gw *Gateway) any { return gw.Zones }},
+	{"resources", func(gw *Gateway) any { return resourceNames(gw.Resources) }},
+	{"configured resources", func(gw *Gateway) any { return dereferenceStrings(gw.ConfiguredResources) }},
+	{"resourcePrecedence", func(gw *Gateway) any { return gw.resourcePrecedence }},
+	{"zoneResources", func(gw *Gateway) any {
+		var zones map[string][]string
+		for zone, resources := range gw.zoneResources {
+			if zones == nil {
+				zones = make(map[string][]string)
+			}
+			zones[zone] = resourceNames(resources)
+		}
+		return zones
+	}},
+	// the resolver of the block is compared above
+	{"zone filters", func(gw *Gateway) any {
+		var zones map[string]ResourceFilters
+		for zone, filters := range gw.zoneFilters {
+			if zones == nil {
+				zones = make(map[string]ResourceFilters)
+			}
+			zoneFilters := *filters
+			zoneFilters.resolver = nil
+			zones[zone] = zoneFilters
+		}
+		return zones
+	}},
+	{"ttl", func(gw *Gateway) any { return gw.ttlLow }},
+	{"upstreamTTLFloor", func(gw *Gateway) any { return gw.upstreamTTLFloor }},
+	{"negativeTTL", func(gw *Gateway) any { return gw.soaMinTTL }},
+	{"apex", func(gw *Gateway) any { return gw.apex }},
+	{"hostmaster", func(gw *Gateway) any { return gw.hostmaster }},
+	{"secondary", func(gw *Gateway) any { return gw.secondNS }},
+	{"nameservers", func(gw *Gateway) any { return gw.nameserverNames }},
+	{"disabled nameservers", func(gw *Gateway) any { return gw.disableNameservers }},
+	{"kubeconfig", func(gw *Gateway) any { return gw.configFile }},
+	{"kubeconfig contexts", func(gw *Gateway) any { return gw.configContexts }},
+	{"ingressClasses", func(gw *Gateway) any { return gw.resourceFilters.ingressClasses }},
+	{"gatewayClasses", func(gw *Gateway) any { return gw.resourceFilters.gatewayClasses }},
+	{"serviceTypes", func(gw *Gateway) any { return gw.resourceFilters.serviceTypes }},
+	{"acceptedRoutesOnly", func(gw *Gateway) any { return gw.resourceFilters.acceptedRoutesOnly }},
+	{"programmedGatewaysOnly", func(gw *Gateway) any { return gw.resourceFilters.programmedGatewaysOnly }},
+	{"requireReferenceGrants", func(gw *Gateway) any { return gw.resourceFilters.requireReferenceGrants }},
+	{"backendRefHostnames", func(gw *Gateway) any { return gw.resourceFilters.backendRefHostnames }},
+	{"serviceClusterIPs", func(gw *Gateway) any { return gw.resourceFilters.serviceClusterIPs }},
+	{"preferLoadBalancerIPs", func(gw *Gateway) any { return gw.resourceFilters.preferLoadBalancerIPs }},
+	{"mergeExternalIPs", func(gw *Gateway) any { return gw.resourceFilters.mergeExternalIPs }},
+	{"requireAnnotation", func(gw *Gateway) any { return gw.resourceFilters.requireHostnameAnnotation }},
+	{"indexLoadBalancerHostnames", func(gw *Gateway) any { return gw.resourceFilters.indexLoadBalancerHostnames }},
+	{"nodePortAddresses", func(gw *Gateway) any { return gw.resourceFilters.nodeAddressType }},
+	{"localTrafficPolicyAddresses", func(gw *Gateway) any { return gw.resourceFilters.localPolicyAddressType }},
+	{"hostnameConflicts", func(gw *Gateway) any { return gw.resourceFilters.hostnameConflicts }},
+	{"gatewayAddressAnnotation", func(gw *Gateway) any { return gw.resourceFilters.gatewayAddressAnnotation }},
+	{"readyIngressesOnly", func(gw *Gateway) any { return gw.resourceFilters.readyIngressesOnly }},
+	{"ingress ready annotation", func(gw *Gateway) any { return gw.resourceFilters.ingressReadyAnnotation }},
+	{"upstreamResolvers", func(gw *Gateway) any { return gw.upstreamResolvers }},
+	// each instance resolves hostnames with its own nameservers, those of
+	// resolv.conf unless configured
+	{"resolver nameservers", func(gw *Gateway) any {
+		if resolver, ok := gw.resourceFilters.resolver.(*upstreamResolver); ok {
+			return resolver.servers
+		}
+		return gw.resourceFilters.resolver
+	}},
+	{"fallthrough zones", func(gw *Gateway) any { return gw.Fall.Zones }},
+	{"fallthrough types", func(gw *Gateway) any { return gw.fallthroughTypes }},
+	{"zoneFallthrough", func(gw *Gateway) any { return gw.zoneFallthrough }},
+	{"fallthroughUnsynced", func(gw *Gateway) any { return gw.fallthroughUnsynced }},
+	{"family", func(gw *Gateway) any { return gw.family }},
+	{"zoneFamily", func(gw *Gateway) any { return gw.zoneFamily }},
+	{"preferFamily", func(gw *Gateway) any { return gw.preferFamily }},
+	{"preferFamily only", func(gw *Gateway) any { return gw.preferFamilyOnly }},
+	{"zonePreferFamily", func(gw *Gateway) any { return gw.zonePreferFamily }},
+	{"cnameGatewayHostnames", func(gw *Gateway) any { return gw.cnameGatewayHostnames }},
+	{"zoneCNAMEGatewayHostnames", func(gw *Gateway) any { return gw.zoneCNAMEGatewayHostnames }},
+	{"minimalAny", func(gw *Gateway) any { return gw.minimalAny }},
+	{"refuseFiltered", func(gw *Gateway) any { return gw.refuseFiltered }},
+	{"allowNames", func(gw *Gateway) any { return gw.allowNames }},
+	{"denyNames", func(gw *Gateway) any { return gw.denyNames }},
+	{"zoneAlias", func(gw *Gateway) any { return gw.zoneAliases }},
+	{"stripSubdomain", func(gw *Gateway) any { return gw.stripSubdomain }},
+	{"clientRegion", func(gw *Gateway) any { return gw.clientRegions }},
+	{"static", func(gw *Gateway) any { return gw.staticRecords }},
+	{"dname", func(gw *Gateway) any { return gw.dnameTargets }},
+	{"resyncPeriod", func(gw *Gateway) any { return gw.resyncPeriod }},
+	{"deleteGrace period", func(gw *Gateway) any { return gw.deleteGracePeriod }},
+	{"deleteGrace ttl", func(gw *Gateway) any { return gw.deleteGraceTTL }},
+	{"statusGrace", func(gw *Gateway) any { return gw.statusGracePeriod }},
+	{"serveStale", func(gw *Gateway) any { return gw.serveStale }},
+	{"serveStale ttl", func(gw *Gateway) any { return gw.serveStaleTTL }},
+	{"trace names", func(gw *Gateway) any { return gw.traceNames }},
+	{"trace sample rate", func(gw *Gateway) any { return gw.traceSampleRate }},
+	{"answerCache size", func(gw *Gateway) any {
+		if gw.answerCache == nil {
+			return 0
+		}
+		return gw.answerCache.size
+	}},
+	{"debugIndex", func(gw *Gateway) any { return gw.debugIndex }},
+}
+
+func resourceNames(resources []*resourceWithIndex) []string {
+	var names []string
+	for _, resource := range resources {
+		names = append(names, resource.name)
+	}
+	return names
+}
+
+// resourcesNamed returns the static resources of the given names, in order
+func resourcesNamed(names ...string) []*resourceWithIndex {
+	var resources []*resourceWithIndex
+	for _, name := range names {
+		for _, resource := range staticResources {
+			if resource.name == name {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	return resources
+}
+
 func TestSetup(t *testing.T) {
 	tests := []struct {
-		input         string
-		shouldErr     bool
-		expectedZone  string
-		expectedZones int
+		input     string
+		shouldErr bool
+		// changes the options of a Gateway serving example.org with the
+		// defaults to the expected ones
+		expected func(gw *Gateway)
 	}{
-		{`k8s_gateway`, false, "", 1},
-		{`k8s_gateway example.org`, false, "example.org.", 1},
-		{`k8s_gateway example.org sub.example.org`, false, "sub.example.org.", 2},
+		{`k8s_gateway`, false, func(gw *Gateway) { gw.Zones = []string{} }},
+		{`k8s_gateway example.org`, false, nil},
+		{`k8s_gateway example.org sub.example.org`, false, func(gw *Gateway) { gw.Zones = []string{"example.org.", "sub.example.org."} }},
+		{`k8s_gateway example.org {
+			unknownOption
+		}`, true, nil},
+
+		// resources
+		{`k8s_gateway example.org {
+			resources Ingress Service HTTPRoute
+		}`, false, func(gw *Gateway) {
+			gw.Resources = resourcesNamed("Ingress", "Service", "HTTPRoute")
+			gw.SetConfiguredResources([]string{"Ingress", "Service", "HTTPRoute"})
+		}},
+		{`k8s_gateway example.org {
+			resources
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			resources Ingres Service
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			resources Ingress service
+		}`, true, nil},
+
+		// resourcePrecedence
+		{`k8s_gateway example.org {
+			resources Ingress Service DNSEndpoint
+		}`, false, func(gw *Gateway) {
+			gw.Resources = resourcesNamed("Ingress", "Service", "DNSEndpoint")
+			gw.SetConfiguredResources([]string{"Ingress", "Service", "DNSEndpoint"})
+		}},
+		{`k8s_gateway example.org {
+			resources Ingress Service DNSEndpoint
+			resourcePrecedence Service
+		}`, false, func(gw *Gateway) {
+			gw.Resources = resourcesNamed("Service", "Ingress", "DNSEndpoint")
+			gw.SetConfiguredResources([]string{"Ingress", "Service", "DNSEndpoint"})
+			gw.resourcePrecedence = []string{"Service"}
+		}},
+		{`k8s_gateway example.org {
+			resourcePrecedence DNSEndpoint Service
+			resources Ingress Service DNSEndpoint
+		}`, false, func(gw *Gateway) {
+			gw.Resources = resourcesNamed("DNSEndpoint", "Service", "Ingress")
+			gw.SetConfiguredResources([]string{"Ingress", "Service", "DNSEndpoint"})
+			gw.resourcePrecedence = []string{"DNSEndpoint", "Service"}
+		}},
+		{`k8s_gateway example.org {
+			resourcePrecedence Service Ingress
+		}`, false, func(gw *Gateway) {
+			gw.Resources = resourcesNamed("Service", "Ingress", "HTTPRoute", "TLSRoute", "GRPCRoute", "DNSEndpoint", "Endpoints", "VirtualService")
+			gw.resourcePrecedence = []string{"Service", "Ingress"}
+		}},
+		{`k8s_gateway example.org {
+			resourcePrecedence
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			resourcePrecedence Service Pod
+		}`, true, nil},
+
+		// zoneResources
+		{`k8s_gateway example.org example.com {
+			zoneResources example.org Ingress
+			zoneResources EXAMPLE.com HTTPRoute GRPCRoute
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "example.com."}
+			gw.zoneResources = map[string][]*resourceWithIndex{
+				"example.org.": resourcesNamed("Ingress"),
+				"example.com.": resourcesNamed("HTTPRoute", "GRPCRoute"),
+			}
+		}},
+		{`k8s_gateway example.org {
+			resources Ingress
+			zoneResources example.org Ingress
+		}`, false, func(gw *Gateway) {
+			gw.Resources = resourcesNamed("Ingress")
+			gw.SetConfiguredResources([]string{"Ingress"})
+			gw.zoneResources = map[string][]*resourceWithIndex{"example.org.": resourcesNamed("Ingress")}
+		}},
+		{`k8s_gateway example.org {
+			resources Ingress
+			zoneResources example.org Service
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneResources example.net Ingress
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneResources example.org
+		}`, true, nil},
+
+		// flags
+		{`k8s_gateway example.org {
+			debugIndex
+			refuseFiltered
+			minimalAny
+			fallthroughUnsynced
+			acceptedRoutesOnly
+			programmedGatewaysOnly
+			serviceTypes LoadBalancer ClusterIP
+			serviceClusterIPs
+			requireReferenceGrants
+			backendRefHostnames
+			preferLoadBalancerIPs
+			requireAnnotation
+			indexLoadBalancerHostnames
+			mergeExternalIPs
+		}`, false, func(gw *Gateway) {
+			gw.debugIndex = true
+			gw.refuseFiltered = true
+			gw.minimalAny = true
+			gw.fallthroughUnsynced = true
+			gw.resourceFilters.acceptedRoutesOnly = true
+			gw.resourceFilters.programmedGatewaysOnly = true
+			gw.resourceFilters.serviceTypes = []string{"LoadBalancer", "ClusterIP"}
+			gw.resourceFilters.serviceClusterIPs = true
+			gw.resourceFilters.requireReferenceGrants = true
+			gw.resourceFilters.backendRefHostnames = true
+			gw.resourceFilters.preferLoadBalancerIPs = true
+			gw.resourceFilters.requireHostnameAnnotation = true
+			gw.resourceFilters.indexLoadBalancerHostnames = true
+			gw.resourceFilters.mergeExternalIPs = true
+		}},
+		{`k8s_gateway example.org {
+			debugIndex yes
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			refuseFiltered yes
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			minimalAny hinfo
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			fallthroughUnsynced yes
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			serviceClusterIPs yes
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			serviceClusterIPs
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			serviceClusterIPs
+			serviceTypes LoadBalancer
+		}`, true, nil},
+		{`k8s_gateway example.org internal.example.org {
+			serviceTypes LoadBalancer ClusterIP
+			zoneServiceClusterIPs Internal.example.org
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "internal.example.org."}
+			gw.resourceFilters.serviceTypes = []string{"LoadBalancer", "ClusterIP"}
+			internal := gw.resourceFilters
+			internal.serviceClusterIPs = true
+			gw.zoneFilters = map[string]*ResourceFilters{"internal.example.org.": &internal}
+		}},
+		{`k8s_gateway example.org internal.example.org {
+			zoneServiceClusterIPs internal.example.org off
+			serviceClusterIPs
+			serviceTypes LoadBalancer ClusterIP
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "internal.example.org."}
+			gw.resourceFilters.serviceTypes = []string{"LoadBalancer", "ClusterIP"}
+			gw.resourceFilters.serviceClusterIPs = true
+			internal := gw.resourceFilters
+			internal.serviceClusterIPs = false
+			gw.zoneFilters = map[string]*ResourceFilters{"internal.example.org.": &internal}
+		}},
+		// ClusterIP services aren't published in the zone
+		{`k8s_gateway example.org internal.example.org {
+			zoneServiceClusterIPs internal.example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneServiceClusterIPs
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneServiceClusterIPs example.com
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneServiceClusterIPs example.org on
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			requireReferenceGrants yes
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			backendRefHostnames yes
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			preferLoadBalancerIPs yes
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			requireAnnotation Ingress
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			indexLoadBalancerHostnames Service
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			mergeExternalIPs true
+		}`, true, nil},
+
+		// single values
+		{`k8s_gateway example.org {
+			upstreamTTLFloor 30
+			negativeTTL 10
+			statusGrace 1m
+			deleteGrace 30s 5
+			resyncPeriod 10m
+			serveStale 10
+			answerCache 1000
+			stripSubdomain SVC
+			gatewayAddressAnnotation cloud.example.com/static-ip
+			nodePortAddresses
+			localTrafficPolicyAddresses
+			hostnameConflicts first
+			readyIngressesOnly
+		}`, false, func(gw *Gateway) {
+			gw.upstreamTTLFloor = 30
+			gw.soaMinTTL = 10
+			gw.statusGracePeriod = time.Minute
+			gw.deleteGracePeriod = 30 * time.Second
+			gw.deleteGraceTTL = 5
+			gw.resyncPeriod = 10 * time.Minute
+			gw.serveStale = true
+			gw.serveStaleTTL = 10
+			gw.answerCache = newAnswerCache(1000)
+			gw.stripSubdomain = "svc"
+			gw.resourceFilters.gatewayAddressAnnotation = "cloud.example.com/static-ip"
+			gw.resourceFilters.nodeAddressType = "InternalIP"
+			gw.resourceFilters.localPolicyAddressType = "ExternalIP"
+			gw.resourceFilters.hostnameConflicts = "first"
+			gw.resourceFilters.readyIngressesOnly = true
+		}},
+		{`k8s_gateway example.org {
+			negativeTTL 0
+			deleteGrace 2m
+			resyncPeriod 0s
+			serveStale 0
+			stripSubdomain svc.cluster.
+			nodePortAddresses ExternalIP
+			localTrafficPolicyAddresses InternalIP
+			hostnameConflicts reject
+			readyIngressesOnly example.com/backends-ready
+		}`, false, func(gw *Gateway) {
+			gw.soaMinTTL = 0
+			gw.deleteGracePeriod = 2 * time.Minute
+			gw.serveStale = true
+			gw.stripSubdomain = "svc.cluster"
+			gw.resourceFilters.nodeAddressType = "ExternalIP"
+			gw.resourceFilters.localPolicyAddressType = "InternalIP"
+			gw.resourceFilters.hostnameConflicts = "reject"
+			gw.resourceFilters.readyIngressesOnly = true
+			gw.resourceFilters.ingressReadyAnnotation = "example.com/backends-ready"
+		}},
+		{`k8s_gateway example.org {
+			upstreamTTLFloor
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			upstreamTTLFloor 4000
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			negativeTTL
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			negativeTTL -1
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			negativeTTL 4000
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			statusGrace
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			statusGrace 60
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			statusGrace -1m
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			deleteGrace
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			deleteGrace 30
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			deleteGrace 30s 4000
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			resyncPeriod
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			resyncPeriod -1m
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			resyncPeriod often
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			serveStale
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			serveStale 3601
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			serveStale ten
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			answerCache
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			answerCache 0
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			answerCache many
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			stripSubdomain
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			stripSubdomain .
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			stripSubdomain svc internal
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			gatewayAddressAnnotation
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			gatewayAddressAnnotation a b
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			nodePortAddresses Hostname
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			nodePortAddresses InternalIP ExternalIP
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			localTrafficPolicyAddresses Hostname
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			localTrafficPolicyAddresses InternalIP ExternalIP
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			hostnameConflicts newest
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			hostnameConflicts
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			readyIngressesOnly a b
+		}`, true, nil},
+
+		// apex, hostmaster and secondary
+		{`k8s_gateway example.org {
+			apex exdns-1-k8s-gateway.kube-system
+			hostmaster admin
+			secondary exdns-2-k8s-gateway.kube-system
+		}`, false, func(gw *Gateway) {
+			gw.apex = "exdns-1-k8s-gateway.kube-system"
+			gw.hostmaster = "admin"
+			gw.secondNS = []string{"exdns-2-k8s-gateway.kube-system"}
+		}},
+		{`k8s_gateway example.org {
+			secondary exdns-2-k8s-gateway.kube-system exdns-3-k8s-gateway.kube-system
+		}`, false, func(gw *Gateway) {
+			gw.secondNS = []string{"exdns-2-k8s-gateway.kube-system", "exdns-3-k8s-gateway.kube-system"}
+		}},
+		{`k8s_gateway example.org {
+			apex
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			hostmaster
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			secondary
+		}`, true, nil},
+
+		// nameservers
+		{`k8s_gateway example.org {
+			nameservers none
+		}`, false, func(gw *Gateway) { gw.disableNameservers = true }},
+		{`k8s_gateway example.org {
+			nameservers NS1.example.net ns2.example.net.
+		}`, false, func(gw *Gateway) { gw.nameserverNames = []string{"ns1.example.net.", "ns2.example.net."} }},
+		{`k8s_gateway example.org {
+			nameservers
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			nameservers ns1..example.net
+		}`, true, nil},
+
+		// serviceTypes
+		{`k8s_gateway example.org {
+			serviceTypes LoadBalancer ClusterIP
+		}`, false, func(gw *Gateway) { gw.resourceFilters.serviceTypes = []string{"LoadBalancer", "ClusterIP"} }},
+		{`k8s_gateway example.org {
+			serviceTypes
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			serviceTypes ExternalName
+		}`, true, nil},
+
+		// ingressClasses and gatewayClasses
+		{`k8s_gateway example.org {
+			ingressClasses nginx internal
+			gatewayClasses istio
+		}`, false, func(gw *Gateway) {
+			gw.resourceFilters.ingressClasses = []string{"nginx", "internal"}
+			gw.resourceFilters.gatewayClasses = []string{"istio"}
+		}},
+		{`k8s_gateway example.org {
+			ingressClasses nginx,internal
+			gatewayClasses istio,cilium envoy
+		}`, false, func(gw *Gateway) {
+			gw.resourceFilters.ingressClasses = []string{"nginx", "internal"}
+			gw.resourceFilters.gatewayClasses = []string{"istio", "cilium", "envoy"}
+		}},
+		{`k8s_gateway example.org internal.example.org {
+			ingressClasses nginx
+			zoneIngressClasses Internal.example.org internal,nginx
+			zoneGatewayClasses internal.example.org cilium
+			zoneServiceTypes internal.example.org LoadBalancer ClusterIP
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "internal.example.org."}
+			gw.resourceFilters.ingressClasses = []string{"nginx"}
+			internal := gw.resourceFilters
+			internal.ingressClasses = []string{"internal", "nginx"}
+			internal.gatewayClasses = []string{"cilium"}
+			internal.serviceTypes = []string{"LoadBalancer", "ClusterIP"}
+			gw.zoneFilters = map[string]*ResourceFilters{"internal.example.org.": &internal}
+		}},
+		{`k8s_gateway example.org {
+			zoneIngressClasses example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneGatewayClasses example.com istio
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneIngressClasses example.org nginx,
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneServiceTypes example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneServiceTypes example.org ExternalName
+		}`, true, nil},
+		// the zone doesn't publish ClusterIP services
+		{`k8s_gateway example.org internal.example.org {
+			serviceTypes LoadBalancer ClusterIP
+			serviceClusterIPs
+			zoneServiceTypes example.org LoadBalancer
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			ingressClasses
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			gatewayClasses istio,
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			ingressClasses nginx,,internal
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			gatewayClasses Istio
+		}`, true, nil},
+
+		// fallthrough
+		{`k8s_gateway example.org {
+			fallthrough
+		}`, false, func(gw *Gateway) { gw.Fall.Zones = []string{"."} }},
+		{`k8s_gateway example.org {
+			fallthrough types TXT
+		}`, false, func(gw *Gateway) {
+			gw.Fall.Zones = []string{"."}
+			gw.fallthroughTypes = []uint16{dns.TypeTXT}
+		}},
+		{`k8s_gateway example.org {
+			fallthrough example.org types txt MX
+		}`, false, func(gw *Gateway) {
+			gw.Fall.Zones = []string{"example.org."}
+			gw.fallthroughTypes = []uint16{dns.TypeTXT, dns.TypeMX}
+		}},
+		{`k8s_gateway example.org {
+			fallthrough types
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			fallthrough types BOGUS
+		}`, true, nil},
+
+		// zoneFallthrough
+		{`k8s_gateway example.org internal.example.org {
+			fallthrough
+			zoneFallthrough Internal.example.org off
+			zoneFallthrough example.org types TXT
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "internal.example.org."}
+			gw.Fall.Zones = []string{"."}
+			gw.zoneFallthrough = map[string]zoneFall{
+				"internal.example.org.": {off: true},
+				"example.org.":          {types: []uint16{dns.TypeTXT}},
+			}
+		}},
+		{`k8s_gateway example.org {
+			zoneFallthrough example.org
+		}`, false, func(gw *Gateway) { gw.zoneFallthrough = map[string]zoneFall{"example.org.": {}} }},
+		{`k8s_gateway example.org {
+			zoneFallthrough
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneFallthrough example.com
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneFallthrough example.org on
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneFallthrough example.org types
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneFallthrough example.org types BOGUS
+		}`, true, nil},
+
+		// family and zoneFamily
+		{`k8s_gateway example.org {
+			family ipv4
+		}`, false, func(gw *Gateway) { gw.family = familyIPv4 }},
+		{`k8s_gateway example.org {
+			family ipv6
+		}`, false, func(gw *Gateway) { gw.family = familyIPv6 }},
+		{`k8s_gateway example.org internal.example.org {
+			family ipv6
+			zoneFamily Internal.example.org all
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "internal.example.org."}
+			gw.family = familyIPv6
+			gw.zoneFamily = map[string]string{"internal.example.org.": familyAll}
+		}},
+		{`k8s_gateway example.org {
+			family
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			family ipx
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneFamily example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneFamily example.com ipv4
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneFamily example.org ipx
+		}`, true, nil},
+
+		// preferFamily and zonePreferFamily
+		{`k8s_gateway example.org {
+			preferFamily ipv4
+		}`, false, func(gw *Gateway) { gw.preferFamily = familyIPv4 }},
+		{`k8s_gateway example.org {
+			preferFamily ipv6 only
+		}`, false, func(gw *Gateway) {
+			gw.preferFamily = familyIPv6
+			gw.preferFamilyOnly = true
+		}},
+		{`k8s_gateway example.org internal.example.org {
+			preferFamily ipv4
+			zonePreferFamily Internal.example.org ipv6 only
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "internal.example.org."}
+			gw.preferFamily = familyIPv4
+			gw.zonePreferFamily = map[string]familyPreference{"internal.example.org.": {family: familyIPv6, only: true}}
+		}},
+		{`k8s_gateway example.org {
+			preferFamily all
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			preferFamily ipv4 first
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			preferFamily
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zonePreferFamily example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zonePreferFamily example.com ipv4
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zonePreferFamily example.org ipv4 first
+		}`, true, nil},
+
+		// cnameGatewayHostnames and zoneCNAMEGatewayHostnames
+		{`k8s_gateway example.org {
+			cnameGatewayHostnames
+		}`, false, func(gw *Gateway) { gw.cnameGatewayHostnames = true }},
+		{`k8s_gateway example.org internal.example.org {
+			cnameGatewayHostnames
+			zoneCNAMEGatewayHostnames Internal.example.org off
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "internal.example.org."}
+			gw.cnameGatewayHostnames = true
+			gw.zoneCNAMEGatewayHostnames = map[string]bool{"internal.example.org.": false}
+		}},
+		{`k8s_gateway example.org internal.example.org {
+			zoneCNAMEGatewayHostnames example.org
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "internal.example.org."}
+			gw.zoneCNAMEGatewayHostnames = map[string]bool{"example.org.": true}
+		}},
+		{`k8s_gateway example.org {
+			cnameGatewayHostnames example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneCNAMEGatewayHostnames
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneCNAMEGatewayHostnames example.com
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneCNAMEGatewayHostnames example.org on
+		}`, true, nil},
+
+		// trace
+		{`k8s_gateway example.org {
+			trace App.example.org www.example.org.
+		}`, false, func(gw *Gateway) { gw.traceNames = []string{"app.example.org.", "www.example.org."} }},
+		{`k8s_gateway example.org {
+			trace app.example.org sample 0.5
+		}`, false, func(gw *Gateway) {
+			gw.traceNames = []string{"app.example.org."}
+			gw.traceSampleRate = 0.5
+		}},
+		{`k8s_gateway example.org {
+			trace sample 0.01
+		}`, false, func(gw *Gateway) { gw.traceSampleRate = 0.01 }},
+		{`k8s_gateway example.org {
+			trace
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			trace sample 2
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			trace sample
+		}`, true, nil},
+
+		// kubeconfig
+		{`k8s_gateway example.org {
+			kubeconfig /.kube/config
+		}`, false, func(gw *Gateway) {
+			gw.configFile = "/.kube/config"
+			gw.configContexts = []string{}
+		}},
+		{`k8s_gateway example.org {
+			kubeconfig /.kube/config east
+		}`, false, func(gw *Gateway) {
+			gw.configFile = "/.kube/config"
+			gw.configContexts = []string{"east"}
+		}},
+		{`k8s_gateway example.org {
+			kubeconfig /.kube/config east west
+		}`, false, func(gw *Gateway) {
+			gw.configFile = "/.kube/config"
+			gw.configContexts = []string{"east", "west"}
+		}},
+		{`k8s_gateway example.org {
+			kubeconfig /.kube/config east west east
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			kubeconfig
+		}`, true, nil},
+
+		// allowNames and denyNames
+		{`k8s_gateway example.org {
+			denyNames *.Admin.example.org. internal.example.org
+		}`, false, func(gw *Gateway) { gw.denyNames = []string{"*.admin.example.org", "internal.example.org"} }},
+		{`k8s_gateway example.org {
+			allowNames *.public.example.org
+			allowNames www.example.org
+			denyNames *.admin.public.example.org
+		}`, false, func(gw *Gateway) {
+			gw.allowNames = []string{"*.public.example.org", "www.example.org"}
+			gw.denyNames = []string{"*.admin.public.example.org"}
+		}},
+		{`k8s_gateway example.org {
+			allowNames
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			denyNames [a-.example.org
+		}`, true, nil},
+
+		// zoneAlias
+		{`k8s_gateway example.org {
+			zoneAlias Internal.example.org example.org
+			zoneAlias example.net example.org.
+		}`, false, func(gw *Gateway) {
+			gw.Zones = []string{"example.org.", "example.net.", "internal.example.org."}
+			gw.zoneAliases = map[string]string{
+				"internal.example.org.": "example.org.",
+				"example.net.":          "example.org.",
+			}
+		}},
+		{`k8s_gateway example.org {
+			zoneAlias internal.example.org example.com
+		}`, true, nil},
+		{`k8s_gateway example.org example.net {
+			zoneAlias example.net example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneAlias internal.example.org example.org
+			zoneAlias internal.example.org example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			zoneAlias internal.example.org
+		}`, true, nil},
+
+		// clientRegion
+		{`k8s_gateway example.org {
+			clientRegion eu-west 10.1.0.0/16 fd00:1::1/48
+			clientRegion us-east 10.2.0.0/16
+		}`, false, func(gw *Gateway) {
+			gw.clientRegions = []clientRegion{
+				{prefix: netip.MustParsePrefix("10.1.0.0/16"), region: "eu-west"},
+				{prefix: netip.MustParsePrefix("fd00:1::/48"), region: "eu-west"},
+				{prefix: netip.MustParsePrefix("10.2.0.0/16"), region: "us-east"},
+			}
+		}},
+		{`k8s_gateway example.org {
+			clientRegion eu-west
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			clientRegion eu-west 10.1.0.0
+		}`, true, nil},
+
+		// upstreamResolvers
+		{`k8s_gateway example.org {
+			upstreamResolvers 10.0.0.2 10.0.0.3:5353 fd00::2 [fd00::3]:5353
+		}`, false, func(gw *Gateway) {
+			gw.upstreamResolvers = []string{"10.0.0.2:53", "10.0.0.3:5353", "[fd00::2]:53", "[fd00::3]:5353"}
+			gw.resourceFilters.resolver = newUpstreamResolver(gw.upstreamResolvers)
+		}},
+		{`k8s_gateway example.org {
+			upstreamResolvers
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			upstreamResolvers dns.example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			upstreamResolvers 10.0.0.2:0
+		}`, true, nil},
+
+		// static
+		{`k8s_gateway example.org {
+			static www.example.org A 192.0.2.1 192.0.2.2
+			static WWW.example.org. AAAA 2001:db8::1
+			static example.org a 192.0.2.3
+		}`, false, func(gw *Gateway) {
+			gw.staticRecords = map[string][]netip.Addr{
+				"www.example.org": {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("2001:db8::1")},
+				"example.org":     {netip.MustParseAddr("192.0.2.3")},
+			}
+		}},
+		{`k8s_gateway example.org {
+			static www.example.org A
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			static www.example.com A 192.0.2.1
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			static www.example.org MX 192.0.2.1
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			static www.example.org A 2001:db8::1
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			static www.example.org AAAA 192.0.2.1
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			static www.example.org A www.example.net
+		}`, true, nil},
+
+		// dname
+		{`k8s_gateway example.org {
+			dname Old.example.org new.example.org
+			dname legacy.example.org. example.net
+		}`, false, func(gw *Gateway) {
+			gw.dnameTargets = map[string]string{
+				"old.example.org.":    "new.example.org.",
+				"legacy.example.org.": "example.net.",
+			}
+		}},
+		{`k8s_gateway example.org {
+			dname old.example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			dname old.example.com new.example.org
+		}`, true, nil},
+		// the target can't be below the owner
+		{`k8s_gateway example.org {
+			dname old.example.org new.old.example.org
+		}`, true, nil},
+		{`k8s_gateway example.org {
+			dname old.example.org new.example.org
+			dname old.example.org other.example.org
+		}`, true, nil},
 	}
 
 	for i, test := range tests {
 		c := caddy.NewTestController("dns", test.input)
 		gw, err := parse(c)
 
-		if test.shouldErr && err == nil {
-			t.Errorf("Test %d: Expected error but found %s for input %s", i, err, test.input)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: Expected error but found none for input %s", i, test.input)
+			}
+			continue
 		}
-
 		if err != nil {
-			if !test.shouldErr {
-				t.Errorf("Test %d: Expected no error but found one for input %s. Error was: %v", i, test.input, err)
-			}
+			t.Errorf("Test %d: Expected no error but found one for input %s. Error was: %v", i, test.input, err)
+			continue
 		}
 
-		if !test.shouldErr && test.expectedZone != "" {
-			if test.expectedZones != len(gw.Zones) {
-				t.Errorf("Test %d, expected zone %q for input %s, got: %q", i, test.expectedZone, test.input, gw.Zones[0])
+		expected := newGateway()
+		expected.Zones = []string{"example.org."}
+		if test.expected != nil {
+			test.expected(expected)
+		}
+		for _, field := range setupFields {
+			if want, got := field.value(expected), field.value(gw); !reflect.DeepEqual(want, got) {
+				t.Errorf("Test %d: Expected %s %v, got %v for input %s", i, field.name, want, got, test.input)
 			}
 		}
 	}
 }
+
+func TestSetupUnknownResource(t *testing.T) {
+	// the error lists the valid resources
+	for _, option := range []string{"resources", "resourcePrecedence"} {
+		_, err := parse(caddy.NewTestController("dns", `k8s_gateway example.org {
+			`+option+` Ingres
+		}`))
+		if err == nil || !strings.Contains(err.Error(), "VirtualService") {
+			t.Errorf("Expected an error of %s listing the supported resources, got %v", option, err)
+		}
+	}
+}